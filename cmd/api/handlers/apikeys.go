@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/marcosstupnicki/go-users/internal/apikeys"
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	"github.com/marcosstupnicki/go-users/internal/rbac"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+)
+
+const (
+	_ErrorMessageAPIKeyCreatedByAPIKey = "api keys can only be created by users"
+	_ErrorMessageAPIKeyAdminWithoutMFA = "api keys with the users:admin scope can only be created from a login with mfa"
+)
+
+type APIKeyService interface {
+	Create(ctx context.Context, key apikeys.APIKey) (apikeys.APIKey, string, error)
+	Get(ctx context.Context, id int) (apikeys.APIKey, error)
+	List(ctx context.Context) ([]apikeys.APIKey, error)
+	Revoke(ctx context.Context, id int) error
+}
+
+// APIKeyHandler manages the API keys of machine clients. Every endpoint requires the users:admin
+// permission.
+type APIKeyHandler struct {
+	Service    APIKeyService
+	Sessions   SessionGetter
+	Authorizer Authorizer
+}
+
+func NewAPIKeyHandler(service APIKeyService, sessions SessionGetter, authorizer Authorizer) APIKeyHandler {
+	return APIKeyHandler{
+		Service:    service,
+		Sessions:   sessions,
+		Authorizer: authorizer,
+	}
+}
+
+// Create creates an API key for the authenticated user. API keys can't create other keys, which
+// could outlive their own expiration or revocation. Keys with the users:admin scope can only be
+// created from a session that passed MFA, so they can't be used to get around it.
+func (h *APIKeyHandler) Create(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authorizer, rbac.PermissionUsersAdmin) {
+		return
+	}
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if principal.APIKeyID != 0 {
+		gowebapp.RespondWithError(w, http.StatusForbidden, _ErrorMessageAPIKeyCreatedByAPIKey)
+		return
+	}
+
+	var keyRequest apikeys.APIKeyRequest
+	err := json.NewDecoder(r.Body).Decode(&keyRequest)
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageCouldNotDecodeInput)
+		return
+	}
+
+	for _, scope := range keyRequest.Scopes {
+		if scope != rbac.PermissionUsersAdmin {
+			continue
+		}
+		verified, err := mfaVerified(r.Context(), h.Sessions, principal)
+		if err != nil {
+			gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+		if !verified {
+			gowebapp.RespondWithError(w, http.StatusForbidden, _ErrorMessageAPIKeyAdminWithoutMFA)
+			return
+		}
+		break
+	}
+
+	key, plain, err := h.Service.Create(r.Context(), apikeys.APIKey{
+		Name:      keyRequest.Name,
+		Scopes:    strings.Join(keyRequest.Scopes, ","),
+		CreatedBy: principal.UserID,
+		ExpiresAt: keyRequest.ExpiresAt,
+	})
+	if err != nil {
+		respondWithAPIKeyError(w, err)
+		return
+	}
+
+	// The key is only returned on creation, only the hash of its secret is stored.
+	response := buildAPIKeyResponse(key)
+	response.Key = plain
+	gowebapp.RespondWithJSON(w, http.StatusCreated, response)
+	return
+}
+
+func (h *APIKeyHandler) List(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authorizer, rbac.PermissionUsersAdmin) {
+		return
+	}
+
+	keys, err := h.Service.List(r.Context())
+	if err != nil {
+		respondWithAPIKeyError(w, err)
+		return
+	}
+
+	response := make([]apikeys.APIKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		response = append(response, buildAPIKeyResponse(key))
+	}
+	gowebapp.RespondWithJSON(w, http.StatusOK, response)
+	return
+}
+
+func (h *APIKeyHandler) Get(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authorizer, rbac.PermissionUsersAdmin) {
+		return
+	}
+
+	id, err := strconv.Atoi(gowebapp.URLParam(r, "id"))
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageInvalidIDParam)
+		return
+	}
+
+	key, err := h.Service.Get(r.Context(), id)
+	if err != nil {
+		respondWithAPIKeyError(w, err)
+		return
+	}
+
+	gowebapp.RespondWithJSON(w, http.StatusOK, buildAPIKeyResponse(key))
+	return
+}
+
+// Revoke revokes an API key. It's kept, and listed with its revocation time.
+func (h *APIKeyHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authorizer, rbac.PermissionUsersAdmin) {
+		return
+	}
+
+	id, err := strconv.Atoi(gowebapp.URLParam(r, "id"))
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageInvalidIDParam)
+		return
+	}
+
+	err = h.Service.Revoke(r.Context(), id)
+	if err != nil {
+		respondWithAPIKeyError(w, err)
+		return
+	}
+
+	gowebapp.RespondWithJSON(w, http.StatusNoContent, nil)
+	return
+}
+
+func respondWithAPIKeyError(w http.ResponseWriter, err error) {
+	switch err {
+	case apikeys.ErrAPIKeyNotFound:
+		gowebapp.RespondWithError(w, http.StatusNotFound, err.Error())
+	case apikeys.ErrInvalidName, apikeys.ErrInvalidScopes, apikeys.ErrInvalidExpiration:
+		gowebapp.RespondWithError(w, http.StatusBadRequest, err.Error())
+	default:
+		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+	}
+}
+
+func buildAPIKeyResponse(key apikeys.APIKey) apikeys.APIKeyResponse {
+	return apikeys.APIKeyResponse{
+		ID:         key.ID,
+		Name:       key.Name,
+		Prefix:     key.KeyPrefix(),
+		Scopes:     key.ScopeList(),
+		CreatedBy:  key.CreatedBy,
+		CreatedAt:  key.CreatedAt,
+		LastUsedAt: key.LastUsedAt,
+		ExpiresAt:  key.ExpiresAt,
+		RevokedAt:  key.RevokedAt,
+	}
+}
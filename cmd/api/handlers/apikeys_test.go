@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/marcosstupnicki/go-users/internal/apikeys"
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type APIKeyServiceMock struct {
+	mock.Mock
+}
+
+func (s *APIKeyServiceMock) Create(_ context.Context, key apikeys.APIKey) (apikeys.APIKey, string, error) {
+	args := s.Called(key)
+	return args.Get(0).(apikeys.APIKey), args.String(1), args.Error(2)
+}
+
+func (s *APIKeyServiceMock) Get(_ context.Context, _ int) (apikeys.APIKey, error) {
+	args := s.Called()
+	return args.Get(0).(apikeys.APIKey), args.Error(1)
+}
+
+func (s *APIKeyServiceMock) List(_ context.Context) ([]apikeys.APIKey, error) {
+	args := s.Called()
+	return args.Get(0).([]apikeys.APIKey), args.Error(1)
+}
+
+func (s *APIKeyServiceMock) Revoke(_ context.Context, _ int) error {
+	args := s.Called()
+	return args.Error(0)
+}
+
+func TestAPIKeyHandler_Create(t *testing.T) {
+	var tests = []struct {
+		name               string
+		principal          auth.Principal
+		service            *APIKeyServiceMock
+		authorizer         AuthorizerMock
+		request            string
+		expectedResponse   string
+		expectedStatusCode int
+	}{
+		{
+			name:      "Ok - Create key, key returned",
+			principal: auth.Principal{Subject: "user:7", UserID: 7},
+			service: func() *APIKeyServiceMock {
+				m := APIKeyServiceMock{}
+				m.On("Create", apikeys.APIKey{Name: "billing", Scopes: "users:read,users:write", CreatedBy: 7}).
+					Return(apikeys.APIKey{ID: 3, Name: "billing", Prefix: "0011223344556677", Scopes: "users:read,users:write", CreatedBy: 7, CreatedAt: 123456}, "gu_0011223344556677_some-secret", nil)
+				return &m
+			}(),
+			request:            `{"name":"billing","scopes":["users:read","users:write"]}`,
+			expectedResponse:   `{"id":3,"name":"billing","prefix":"gu_0011223344556677","scopes":["users:read","users:write"],"key":"gu_0011223344556677_some-secret","created_by":7,"created_at":123456}`,
+			expectedStatusCode: http.StatusCreated,
+		},
+		{
+			name:      "Fail - Invalid scopes",
+			principal: auth.Principal{Subject: "user:7", UserID: 7},
+			service: func() *APIKeyServiceMock {
+				m := APIKeyServiceMock{}
+				m.On("Create", mock.Anything).Return(apikeys.APIKey{}, "", apikeys.ErrInvalidScopes)
+				return &m
+			}(),
+			request:            `{"name":"billing","scopes":["users:everything"]}`,
+			expectedResponse:   `{"message":"invalid api key scopes. at least one scope is required, and scopes must be permissions"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:      "Ok - Create admin key from a login with MFA",
+			principal: auth.Principal{Subject: "user:5", UserID: 5, SessionID: 8},
+			service: func() *APIKeyServiceMock {
+				m := APIKeyServiceMock{}
+				m.On("Create", apikeys.APIKey{Name: "ops", Scopes: "users:admin", CreatedBy: 5}).
+					Return(apikeys.APIKey{ID: 4, Name: "ops", Prefix: "8899aabbccddeeff", Scopes: "users:admin", CreatedBy: 5, CreatedAt: 123456}, "gu_8899aabbccddeeff_some-secret", nil)
+				return &m
+			}(),
+			request:            `{"name":"ops","scopes":["users:admin"]}`,
+			expectedResponse:   `{"id":4,"name":"ops","prefix":"gu_8899aabbccddeeff","scopes":["users:admin"],"key":"gu_8899aabbccddeeff_some-secret","created_by":5,"created_at":123456}`,
+			expectedStatusCode: http.StatusCreated,
+		},
+		{
+			name:               "Fail - Admin key from a login without MFA",
+			principal:          auth.Principal{Subject: "user:5", UserID: 5, SessionID: 10},
+			request:            `{"name":"ops","scopes":["users:read","users:admin"]}`,
+			expectedResponse:   `{"message":"api keys with the users:admin scope can only be created from a login with mfa"}`,
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			name:               "Fail - Admin key without a session",
+			principal:          auth.Principal{Subject: "user:7", UserID: 7},
+			request:            `{"name":"ops","scopes":["users:admin"]}`,
+			expectedResponse:   `{"message":"api keys with the users:admin scope can only be created from a login with mfa"}`,
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			name:               "Fail - Admin key from a session of another user",
+			principal:          auth.Principal{Subject: "user:7", UserID: 7, SessionID: 8},
+			request:            `{"name":"ops","scopes":["users:admin"]}`,
+			expectedResponse:   `{"message":"api keys with the users:admin scope can only be created from a login with mfa"}`,
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			name:               "Fail - Created by an API key",
+			principal:          auth.Principal{Subject: "apikey:3", APIKeyID: 3, Scopes: []string{"users:admin"}},
+			request:            `{"name":"billing","scopes":["users:admin"]}`,
+			expectedResponse:   `{"message":"api keys can only be created by users"}`,
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			name:               "Fail - Not an admin",
+			principal:          auth.Principal{Subject: "user:8", UserID: 8},
+			authorizer:         AuthorizerMock{err: auth.ErrForbidden},
+			request:            `{"name":"billing","scopes":["users:read"]}`,
+			expectedResponse:   `{"message":"permission denied"}`,
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			name:               "Fail - Bad request",
+			principal:          auth.Principal{Subject: "user:7", UserID: 7},
+			request:            "request_invalid",
+			expectedResponse:   `{"message":"could not decode value from input"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := gowebapp.NewWebApp("local")
+			handler := NewAPIKeyHandler(tt.service, SessionServiceMock{}, tt.authorizer)
+			app.Post("/api-keys", handler.Create)
+
+			r := httptest.NewRequest(http.MethodPost, "/api-keys", bytes.NewReader([]byte(tt.request)))
+			r = r.WithContext(auth.WithPrincipal(r.Context(), tt.principal))
+
+			rr := httptest.NewRecorder()
+			app.Router.ServeHTTP(rr, r)
+
+			res := rr.Result()
+			resBody, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expectedStatusCode, res.StatusCode)
+			require.Equal(t, tt.expectedResponse, string(resBody))
+		})
+	}
+}
+
+func TestAPIKeyHandler_List(t *testing.T) {
+	service := APIKeyServiceMock{}
+	service.On("List").Return([]apikeys.APIKey{
+		{ID: 3, Name: "billing", Prefix: "0011223344556677", Scopes: "users:read", CreatedBy: 7, CreatedAt: 123456, LastUsedAt: 123999, RevokedAt: 124000},
+	}, nil)
+
+	app := gowebapp.NewWebApp("local")
+	handler := NewAPIKeyHandler(&service, SessionServiceMock{}, AuthorizerMock{})
+	app.Get("/api-keys", handler.List)
+
+	r := httptest.NewRequest(http.MethodGet, "/api-keys", nil)
+	rr := httptest.NewRecorder()
+	app.Router.ServeHTTP(rr, r)
+
+	res := rr.Result()
+	resBody, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, `[{"id":3,"name":"billing","prefix":"gu_0011223344556677","scopes":["users:read"],"created_by":7,"created_at":123456,"last_used_at":123999,"revoked_at":124000}]`, string(resBody))
+}
+
+func TestAPIKeyHandler_Revoke(t *testing.T) {
+	var tests = []struct {
+		name               string
+		service            *APIKeyServiceMock
+		id                 string
+		expectedStatusCode int
+	}{
+		{
+			name: "Ok",
+			service: func() *APIKeyServiceMock {
+				m := APIKeyServiceMock{}
+				m.On("Revoke").Return(nil)
+				return &m
+			}(),
+			id:                 "3",
+			expectedStatusCode: http.StatusNoContent,
+		},
+		{
+			name: "Fail - Key not found",
+			service: func() *APIKeyServiceMock {
+				m := APIKeyServiceMock{}
+				m.On("Revoke").Return(apikeys.ErrAPIKeyNotFound)
+				return &m
+			}(),
+			id:                 "9",
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "Fail - Invalid id",
+			id:                 "abc",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := gowebapp.NewWebApp("local")
+			handler := NewAPIKeyHandler(tt.service, SessionServiceMock{}, AuthorizerMock{})
+			app.Delete("/api-keys/{id}", handler.Revoke)
+
+			r := httptest.NewRequest(http.MethodDelete, "/api-keys/"+tt.id, nil)
+			rr := httptest.NewRecorder()
+			app.Router.ServeHTTP(rr, r)
+
+			require.Equal(t, tt.expectedStatusCode, rr.Result().StatusCode)
+		})
+	}
+}
@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	"github.com/marcosstupnicki/go-users/internal/sessions"
+	"github.com/marcosstupnicki/go-users/internal/users"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+)
+
+const _tokenTypeBearer = "Bearer"
+
+// _accountStatusCodes are the error codes of logins rejected because the account isn't active, so
+// clients can tell the user why.
+var _accountStatusCodes = map[error]string{
+	users.ErrAccountPending:   "account_pending",
+	users.ErrAccountSuspended: "account_suspended",
+	users.ErrAccountLocked:    "account_locked",
+}
+
+type Authenticator interface {
+	UserGetter
+	Authenticate(ctx context.Context, email string, password string) (users.User, error)
+	CheckLogin(ctx context.Context, id int) (users.User, error)
+	RecordLoginFailure(ctx context.Context, user users.User) error
+	CompleteLogin(ctx context.Context, user users.User) error
+}
+
+type RoleNamesLister interface {
+	UserRoleNames(ctx context.Context, userID int) ([]string, error)
+}
+
+type TokenIssuer interface {
+	Issue(userID int, sessionID int, roles []string) (string, time.Time, error)
+	IssueChallenge(userID int) (string, auth.Challenge, error)
+	ParseChallenge(token string) (auth.Challenge, error)
+	IssueBrowserSession(userID int, sessionID int) (string, time.Time, error)
+	ParseBrowserSession(token string) (auth.Principal, error)
+}
+
+type MFAVerifier interface {
+	Enabled(ctx context.Context, userID int) (bool, error)
+	StartChallenge(ctx context.Context, challengeID string, userID int, expiresAt time.Time) error
+	VerifyChallenge(ctx context.Context, challengeID string, userID int, code string) error
+}
+
+type SessionService interface {
+	Start(ctx context.Context, userID int, mfa bool) (sessions.Session, string, error)
+	Refresh(ctx context.Context, refreshToken string, clientID string) (sessions.Session, string, error)
+	Revoke(ctx context.Context, userID int, sessionID int) error
+}
+
+type AuthHandler struct {
+	Users    Authenticator
+	Roles    RoleNamesLister
+	Tokens   TokenIssuer
+	MFA      MFAVerifier
+	Sessions SessionService
+	// MFARequiredRoles are left out of the tokens of users not enrolled in MFA.
+	MFARequiredRoles []string
+}
+
+func NewAuthHandler(users Authenticator, roles RoleNamesLister, tokens TokenIssuer, mfa MFAVerifier, sessions SessionService, mfaRequiredRoles []string) AuthHandler {
+	return AuthHandler{
+		Users:            users,
+		Roles:            roles,
+		Tokens:           tokens,
+		MFA:              mfa,
+		Sessions:         sessions,
+		MFARequiredRoles: mfaRequiredRoles,
+	}
+}
+
+// Login exchanges an email and password for an access token carrying the user roles, and the
+// refresh token of a new session. Users enrolled in MFA get an MFA token instead, to exchange
+// with a code in LoginMFA, and their failed logins are only reset once that succeeds.
+func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
+	var loginRequest auth.LoginRequest
+	err := json.NewDecoder(r.Body).Decode(&loginRequest)
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageCouldNotDecodeInput)
+		return
+	}
+
+	user, mfaToken, expiresAt, err := h.steps().password(r.Context(), loginRequest.Email, loginRequest.Password)
+	if err != nil {
+		respondWithLoginError(w, err)
+		return
+	}
+	if mfaToken != "" {
+		gowebapp.RespondWithJSON(w, http.StatusOK, auth.MFAChallengeResponse{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+			ExpiresIn:   expiresIn(expiresAt),
+		})
+		return
+	}
+
+	h.startSession(w, r, user.ID, false)
+	return
+}
+
+// LoginMFA exchanges the MFA token returned by Login and a TOTP or recovery code for an access
+// token carrying the user roles, and the refresh token of a new session. An MFA token is used up
+// by the first valid code and only accepts a few wrong ones, each counted as a failed login
+// towards the account and client IP lockout.
+func (h *AuthHandler) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	var loginRequest auth.MFALoginRequest
+	err := json.NewDecoder(r.Body).Decode(&loginRequest)
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageCouldNotDecodeInput)
+		return
+	}
+
+	user, err := h.steps().secondFactor(r.Context(), loginRequest.MFAToken, loginRequest.Code)
+	if err != nil {
+		respondWithLoginError(w, err)
+		return
+	}
+
+	h.startSession(w, r, user.ID, true)
+	return
+}
+
+// Refresh exchanges a refresh token for a new access token, carrying the current user roles, and
+// the refresh token replacing it. Reusing a refresh token revokes its session.
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var refreshRequest sessions.RefreshRequest
+	err := json.NewDecoder(r.Body).Decode(&refreshRequest)
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageCouldNotDecodeInput)
+		return
+	}
+
+	session, refreshToken, err := h.Sessions.Refresh(r.Context(), refreshRequest.RefreshToken, "")
+	if err != nil {
+		if err == sessions.ErrInvalidRefreshToken || err == sessions.ErrRefreshTokenReused {
+			gowebapp.RespondWithError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	active, err := activeUser(r.Context(), h.Users, session.UserID)
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	if !active {
+		gowebapp.RespondWithError(w, http.StatusUnauthorized, sessions.ErrInvalidRefreshToken.Error())
+		return
+	}
+
+	h.respondWithToken(w, r, session, refreshToken)
+	return
+}
+
+// Logout revokes a session of the authenticated user, so its refresh token can't be used anymore.
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		gowebapp.RespondWithError(w, http.StatusUnauthorized, auth.ErrUnauthenticated.Error())
+		return
+	}
+
+	id, err := strconv.Atoi(gowebapp.URLParam(r, "id"))
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageInvalidIDParam)
+		return
+	}
+
+	err = h.Sessions.Revoke(r.Context(), principal.UserID, id)
+	if err != nil {
+		if err == sessions.ErrSessionNotFound {
+			gowebapp.RespondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	gowebapp.RespondWithJSON(w, http.StatusNoContent, nil)
+	return
+}
+
+func (h *AuthHandler) steps() loginSteps {
+	return loginSteps{users: h.Users, mfa: h.MFA, tokens: h.Tokens}
+}
+
+// startSession starts a session for the user with id userID and responds with its tokens.
+func (h *AuthHandler) startSession(w http.ResponseWriter, r *http.Request, userID int, mfaPassed bool) {
+	session, refreshToken, err := h.Sessions.Start(r.Context(), userID, mfaPassed)
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	h.respondWithToken(w, r, session, refreshToken)
+}
+
+// respondWithToken issues an access token for session, and responds with it and refreshToken.
+func (h *AuthHandler) respondWithToken(w http.ResponseWriter, r *http.Request, session sessions.Session, refreshToken string) {
+	token, expiresAt, enrollmentRequired, err := issueAccessToken(r.Context(), h.Roles, h.Tokens, h.MFARequiredRoles, session)
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	gowebapp.RespondWithJSON(w, http.StatusOK, auth.TokenResponse{
+		AccessToken:           token,
+		TokenType:             _tokenTypeBearer,
+		ExpiresIn:             expiresIn(expiresAt),
+		RefreshToken:          refreshToken,
+		SessionID:             session.ID,
+		MFAEnrollmentRequired: enrollmentRequired,
+	})
+}
+
+// respondWithLoginError responds with the error of a failed login step. Logins of accounts not
+// active carry the code of their status, so clients can tell the user why.
+func respondWithLoginError(w http.ResponseWriter, err error) {
+	status, expected := loginErrorStatus(err)
+	if !expected {
+		gowebapp.RespondWithError(w, status, http.StatusText(status))
+		return
+	}
+	if users.IsAccountStatusError(err) {
+		gowebapp.RespondWithJSON(w, status, auth.ErrorResponse{
+			Message: err.Error(),
+			Code:    _accountStatusCodes[err],
+		})
+		return
+	}
+
+	gowebapp.RespondWithError(w, status, err.Error())
+}
+
+// issueAccessToken issues an access token for session carrying the user roles. Unless the session
+// passed MFA, the roles in mfaRequiredRoles are left out, and it returns whether any was.
+func issueAccessToken(ctx context.Context, roles RoleNamesLister, tokens TokenIssuer, mfaRequiredRoles []string, session sessions.Session) (string, time.Time, bool, error) {
+	names, err := roles.UserRoleNames(ctx, session.UserID)
+	if err != nil {
+		return "", time.Time{}, false, err
+	}
+
+	var enrollmentRequired bool
+	if !session.MFA {
+		names, enrollmentRequired = withoutRoles(names, mfaRequiredRoles)
+	}
+
+	token, expiresAt, err := tokens.Issue(session.UserID, session.ID, names)
+	if err != nil {
+		return "", time.Time{}, false, err
+	}
+
+	return token, expiresAt, enrollmentRequired, nil
+}
+
+// withoutRoles returns roles without the ones in excluded, and whether any was removed.
+func withoutRoles(roles []string, excluded []string) ([]string, bool) {
+	kept := make([]string, 0, len(roles))
+	for _, role := range roles {
+		if !contains(excluded, role) {
+			kept = append(kept, role)
+		}
+	}
+
+	return kept, len(kept) != len(roles)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// expiresIn returns the number of seconds until expiresAt.
+func expiresIn(expiresAt time.Time) int64 {
+	return int64(time.Until(expiresAt).Round(time.Second).Seconds())
+}
@@ -0,0 +1,549 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/marcosstupnicki/go-users/internal/mfa"
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	"github.com/marcosstupnicki/go-users/internal/sessions"
+	"github.com/marcosstupnicki/go-users/internal/users"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// AuthenticatorMock records the ids of the users whose logins failed or completed.
+type AuthenticatorMock struct {
+	mock.Mock
+	failed    []int
+	completed []int
+}
+
+func (a *AuthenticatorMock) Authenticate(_ context.Context, email string, password string) (users.User, error) {
+	args := a.Called(email, password)
+	return args.Get(0).(users.User), args.Error(1)
+}
+
+func (a *AuthenticatorMock) Get(_ context.Context, id int) (users.User, error) {
+	args := a.Called(id)
+	return args.Get(0).(users.User), args.Error(1)
+}
+
+func (a *AuthenticatorMock) CheckLogin(_ context.Context, id int) (users.User, error) {
+	args := a.Called(id)
+	return args.Get(0).(users.User), args.Error(1)
+}
+
+func (a *AuthenticatorMock) RecordLoginFailure(_ context.Context, user users.User) error {
+	a.failed = append(a.failed, user.ID)
+	return nil
+}
+
+func (a *AuthenticatorMock) CompleteLogin(_ context.Context, user users.User) error {
+	a.completed = append(a.completed, user.ID)
+	return nil
+}
+
+type RoleNamesListerMock struct {
+	roles []string
+}
+
+func (l RoleNamesListerMock) UserRoleNames(_ context.Context, _ int) ([]string, error) {
+	return l.roles, nil
+}
+
+type TokenIssuerMock struct {
+	mock.Mock
+}
+
+func (i *TokenIssuerMock) Issue(userID int, sessionID int, roles []string) (string, time.Time, error) {
+	args := i.Called(userID, sessionID, roles)
+	return args.String(0), args.Get(1).(time.Time), args.Error(2)
+}
+
+func (i *TokenIssuerMock) IssueChallenge(userID int) (string, auth.Challenge, error) {
+	args := i.Called(userID)
+	return args.String(0), args.Get(1).(auth.Challenge), args.Error(2)
+}
+
+func (i *TokenIssuerMock) ParseChallenge(token string) (auth.Challenge, error) {
+	args := i.Called(token)
+	return args.Get(0).(auth.Challenge), args.Error(1)
+}
+
+func (i *TokenIssuerMock) IssueBrowserSession(userID int, sessionID int) (string, time.Time, error) {
+	args := i.Called(userID, sessionID)
+	return args.String(0), args.Get(1).(time.Time), args.Error(2)
+}
+
+func (i *TokenIssuerMock) ParseBrowserSession(token string) (auth.Principal, error) {
+	args := i.Called(token)
+	return args.Get(0).(auth.Principal), args.Error(1)
+}
+
+// SessionServiceMock starts sessions with id 9, and refreshes "some-refresh-token" of the session
+// with id 8 of the user with id 5, which passed MFA, and "client-refresh-token" of the same
+// session started for the OAuth client "some-client". The session with id 10 of the same user
+// didn't pass MFA.
+type SessionServiceMock struct{}
+
+func (SessionServiceMock) Get(_ context.Context, id int) (sessions.Session, error) {
+	if id == 10 {
+		return sessions.Session{ID: 10, UserID: 5, CreatedAt: 1700000000}, nil
+	}
+	if id != 8 {
+		return sessions.Session{}, sessions.ErrSessionNotFound
+	}
+	return sessions.Session{ID: 8, UserID: 5, MFA: true, CreatedAt: 1700000000}, nil
+}
+
+func (SessionServiceMock) Start(_ context.Context, userID int, mfa bool) (sessions.Session, string, error) {
+	return sessions.Session{ID: 9, UserID: userID, MFA: mfa}, "some-refresh-token", nil
+}
+
+func (SessionServiceMock) StartForClient(_ context.Context, userID int, mfa bool, clientID string) (sessions.Session, string, error) {
+	return sessions.Session{ID: 9, UserID: userID, MFA: mfa, ClientID: clientID}, "some-refresh-token", nil
+}
+
+func (SessionServiceMock) Refresh(_ context.Context, refreshToken string, clientID string) (sessions.Session, string, error) {
+	if refreshToken == "some-refresh-token" && clientID == "" {
+		return sessions.Session{ID: 8, UserID: 5}, "other-refresh-token", nil
+	}
+	if refreshToken == "client-refresh-token" && clientID == "some-client" {
+		return sessions.Session{ID: 8, UserID: 5, ClientID: clientID}, "other-refresh-token", nil
+	}
+	return sessions.Session{}, "", sessions.ErrInvalidRefreshToken
+}
+
+func (SessionServiceMock) Revoke(_ context.Context, userID int, sessionID int) error {
+	if userID != 5 || sessionID != 8 {
+		return sessions.ErrSessionNotFound
+	}
+	return nil
+}
+
+// MFAVerifierMock has MFA enabled for the users in codes, accepting their code for any challenge
+// but "used-challenge".
+type MFAVerifierMock struct {
+	codes map[int]string
+}
+
+func (v MFAVerifierMock) Enabled(_ context.Context, userID int) (bool, error) {
+	_, ok := v.codes[userID]
+	return ok, nil
+}
+
+func (v MFAVerifierMock) StartChallenge(_ context.Context, _ string, _ int, _ time.Time) error {
+	return nil
+}
+
+func (v MFAVerifierMock) VerifyChallenge(_ context.Context, challengeID string, userID int, code string) error {
+	if challengeID == "used-challenge" {
+		return mfa.ErrChallengeInvalid
+	}
+	expected, ok := v.codes[userID]
+	if !ok {
+		return mfa.ErrNotEnrolled
+	}
+	if code != expected {
+		return mfa.ErrInvalidCode
+	}
+	return nil
+}
+
+func TestAuthHandler_Login(t *testing.T) {
+	var tests = []struct {
+		name               string
+		users              *AuthenticatorMock
+		tokens             *TokenIssuerMock
+		mfaRequiredRoles   []string
+		request            string
+		expectedResponse   string
+		expectedStatusCode int
+	}{
+		{
+			name: "Ok - Token issued with the user roles",
+			users: func() *AuthenticatorMock {
+				m := AuthenticatorMock{}
+				m.On("Authenticate", "some@email.com", "some-password").Return(users.User{ID: 5}, nil)
+				return &m
+			}(),
+			tokens: func() *TokenIssuerMock {
+				m := TokenIssuerMock{}
+				m.On("Issue", 5, 9, []string{"admin"}).Return("some-token", time.Now().Add(15*time.Minute), nil)
+				return &m
+			}(),
+			request:            `{"email":"some@email.com","password":"some-password"}`,
+			expectedResponse:   `{"access_token":"some-token","token_type":"Bearer","expires_in":900,"refresh_token":"some-refresh-token","session_id":9}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "Ok - MFA required",
+			users: func() *AuthenticatorMock {
+				m := AuthenticatorMock{}
+				m.On("Authenticate", "other@email.com", "some-password").Return(users.User{ID: 6}, nil)
+				return &m
+			}(),
+			tokens: func() *TokenIssuerMock {
+				m := TokenIssuerMock{}
+				m.On("IssueChallenge", 6).Return("some-mfa-token", auth.Challenge{ID: "some-challenge", UserID: 6, ExpiresAt: time.Now().Add(5 * time.Minute)}, nil)
+				return &m
+			}(),
+			request:            `{"email":"other@email.com","password":"some-password"}`,
+			expectedResponse:   `{"mfa_required":true,"mfa_token":"some-mfa-token","expires_in":300}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "Ok - Roles requiring MFA left out until enrolled",
+			users: func() *AuthenticatorMock {
+				m := AuthenticatorMock{}
+				m.On("Authenticate", "some@email.com", "some-password").Return(users.User{ID: 5}, nil)
+				return &m
+			}(),
+			tokens: func() *TokenIssuerMock {
+				m := TokenIssuerMock{}
+				m.On("Issue", 5, 9, []string{"support"}).Return("some-token", time.Now().Add(15*time.Minute), nil)
+				return &m
+			}(),
+			mfaRequiredRoles:   []string{"admin"},
+			request:            `{"email":"some@email.com","password":"some-password"}`,
+			expectedResponse:   `{"access_token":"some-token","token_type":"Bearer","expires_in":900,"refresh_token":"some-refresh-token","session_id":9,"mfa_enrollment_required":true}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "Fail - Invalid credentials",
+			users: func() *AuthenticatorMock {
+				m := AuthenticatorMock{}
+				m.On("Authenticate", "some@email.com", "other-password").Return(users.User{}, users.ErrInvalidCredentials)
+				return &m
+			}(),
+			request:            `{"email":"some@email.com","password":"other-password"}`,
+			expectedResponse:   `{"message":"invalid email or password"}`,
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name: "Fail - Locked out",
+			users: func() *AuthenticatorMock {
+				m := AuthenticatorMock{}
+				m.On("Authenticate", "some@email.com", "some-password").Return(users.User{}, users.ErrLoginThrottled)
+				return &m
+			}(),
+			request:            `{"email":"some@email.com","password":"some-password"}`,
+			expectedResponse:   `{"message":"too many failed logins, try again later"}`,
+			expectedStatusCode: http.StatusTooManyRequests,
+		},
+		{
+			name: "Fail - Suspended account",
+			users: func() *AuthenticatorMock {
+				m := AuthenticatorMock{}
+				m.On("Authenticate", "some@email.com", "some-password").Return(users.User{}, users.ErrAccountSuspended)
+				return &m
+			}(),
+			request:            `{"email":"some@email.com","password":"some-password"}`,
+			expectedResponse:   `{"message":"account suspended","code":"account_suspended"}`,
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			name:               "Fail - Bad request",
+			request:            "request_invalid",
+			expectedResponse:   `{"message":"could not decode value from input"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := gowebapp.NewWebApp("local")
+			roles := RoleNamesListerMock{roles: []string{"admin"}}
+			if tt.mfaRequiredRoles != nil {
+				roles.roles = []string{"admin", "support"}
+			}
+			handler := NewAuthHandler(tt.users, roles, tt.tokens, MFAVerifierMock{codes: map[int]string{6: "123456"}}, SessionServiceMock{}, tt.mfaRequiredRoles)
+			app.Post("/auth/login", handler.Login)
+
+			r := httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewReader([]byte(tt.request)))
+
+			rr := httptest.NewRecorder()
+			app.Router.ServeHTTP(rr, r)
+
+			res := rr.Result()
+			resBody, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expectedStatusCode, res.StatusCode)
+			require.Equal(t, tt.expectedResponse, string(resBody))
+		})
+	}
+}
+
+func TestAuthHandler_LoginMFA(t *testing.T) {
+	challenge := auth.Challenge{ID: "some-challenge", UserID: 6, ExpiresAt: time.Now().Add(5 * time.Minute)}
+	used := challenge
+	used.ID = "used-challenge"
+
+	var tests = []struct {
+		name               string
+		users              *AuthenticatorMock
+		tokens             *TokenIssuerMock
+		request            string
+		expectedResponse   string
+		expectedStatusCode int
+		expectedFailed     []int
+		expectedCompleted  []int
+	}{
+		{
+			name: "Ok - Token issued with every role",
+			users: func() *AuthenticatorMock {
+				m := AuthenticatorMock{}
+				m.On("CheckLogin", 6).Return(users.User{ID: 6}, nil)
+				return &m
+			}(),
+			tokens: func() *TokenIssuerMock {
+				m := TokenIssuerMock{}
+				m.On("ParseChallenge", "some-mfa-token").Return(challenge, nil)
+				m.On("Issue", 6, 9, []string{"admin"}).Return("some-token", time.Now().Add(15*time.Minute), nil)
+				return &m
+			}(),
+			request:            `{"mfa_token":"some-mfa-token","code":"123456"}`,
+			expectedResponse:   `{"access_token":"some-token","token_type":"Bearer","expires_in":900,"refresh_token":"some-refresh-token","session_id":9}`,
+			expectedStatusCode: http.StatusOK,
+			expectedCompleted:  []int{6},
+		},
+		{
+			name: "Fail - Invalid code counted as a failed login",
+			users: func() *AuthenticatorMock {
+				m := AuthenticatorMock{}
+				m.On("CheckLogin", 6).Return(users.User{ID: 6}, nil)
+				return &m
+			}(),
+			tokens: func() *TokenIssuerMock {
+				m := TokenIssuerMock{}
+				m.On("ParseChallenge", "some-mfa-token").Return(challenge, nil)
+				return &m
+			}(),
+			request:            `{"mfa_token":"some-mfa-token","code":"654321"}`,
+			expectedResponse:   `{"message":"invalid mfa code"}`,
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedFailed:     []int{6},
+		},
+		{
+			name: "Fail - Challenge used or out of attempts",
+			users: func() *AuthenticatorMock {
+				m := AuthenticatorMock{}
+				m.On("CheckLogin", 6).Return(users.User{ID: 6}, nil)
+				return &m
+			}(),
+			tokens: func() *TokenIssuerMock {
+				m := TokenIssuerMock{}
+				m.On("ParseChallenge", "used-mfa-token").Return(used, nil)
+				return &m
+			}(),
+			request:            `{"mfa_token":"used-mfa-token","code":"123456"}`,
+			expectedResponse:   `{"message":"invalid mfa challenge"}`,
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name: "Fail - Locked out",
+			users: func() *AuthenticatorMock {
+				m := AuthenticatorMock{}
+				m.On("CheckLogin", 6).Return(users.User{}, users.ErrLoginThrottled)
+				return &m
+			}(),
+			tokens: func() *TokenIssuerMock {
+				m := TokenIssuerMock{}
+				m.On("ParseChallenge", "some-mfa-token").Return(challenge, nil)
+				return &m
+			}(),
+			request:            `{"mfa_token":"some-mfa-token","code":"123456"}`,
+			expectedResponse:   `{"message":"too many failed logins, try again later"}`,
+			expectedStatusCode: http.StatusTooManyRequests,
+		},
+		{
+			name: "Fail - Suspended account",
+			users: func() *AuthenticatorMock {
+				m := AuthenticatorMock{}
+				m.On("CheckLogin", 6).Return(users.User{}, users.ErrAccountSuspended)
+				return &m
+			}(),
+			tokens: func() *TokenIssuerMock {
+				m := TokenIssuerMock{}
+				m.On("ParseChallenge", "some-mfa-token").Return(challenge, nil)
+				return &m
+			}(),
+			request:            `{"mfa_token":"some-mfa-token","code":"123456"}`,
+			expectedResponse:   `{"message":"account suspended","code":"account_suspended"}`,
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			name:  "Fail - Invalid MFA token",
+			users: &AuthenticatorMock{},
+			tokens: func() *TokenIssuerMock {
+				m := TokenIssuerMock{}
+				m.On("ParseChallenge", "some-access-token").Return(auth.Challenge{}, auth.ErrInvalidToken)
+				return &m
+			}(),
+			request:            `{"mfa_token":"some-access-token","code":"123456"}`,
+			expectedResponse:   `{"message":"invalid token"}`,
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:               "Fail - Bad request",
+			users:              &AuthenticatorMock{},
+			request:            "request_invalid",
+			expectedResponse:   `{"message":"could not decode value from input"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := gowebapp.NewWebApp("local")
+			handler := NewAuthHandler(tt.users, RoleNamesListerMock{roles: []string{"admin"}}, tt.tokens, MFAVerifierMock{codes: map[int]string{6: "123456"}}, SessionServiceMock{}, []string{"admin"})
+			app.Post("/auth/login/mfa", handler.LoginMFA)
+
+			r := httptest.NewRequest(http.MethodPost, "/auth/login/mfa", bytes.NewReader([]byte(tt.request)))
+
+			rr := httptest.NewRecorder()
+			app.Router.ServeHTTP(rr, r)
+
+			res := rr.Result()
+			resBody, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expectedStatusCode, res.StatusCode)
+			require.Equal(t, tt.expectedResponse, string(resBody))
+			require.Equal(t, tt.expectedFailed, tt.users.failed)
+			require.Equal(t, tt.expectedCompleted, tt.users.completed)
+		})
+	}
+}
+
+func TestAuthHandler_Refresh(t *testing.T) {
+	var tests = []struct {
+		name               string
+		user               users.User
+		userErr            error
+		tokens             *TokenIssuerMock
+		request            string
+		expectedResponse   string
+		expectedStatusCode int
+	}{
+		{
+			name: "Ok - Token issued with the current user roles",
+			user: users.User{ID: 5, Status: users.StatusActive},
+			tokens: func() *TokenIssuerMock {
+				m := TokenIssuerMock{}
+				m.On("Issue", 5, 8, []string{"support"}).Return("some-token", time.Now().Add(15*time.Minute), nil)
+				return &m
+			}(),
+			request:            `{"refresh_token":"some-refresh-token"}`,
+			expectedResponse:   `{"access_token":"some-token","token_type":"Bearer","expires_in":900,"refresh_token":"other-refresh-token","session_id":8,"mfa_enrollment_required":true}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Fail - User suspended",
+			user:               users.User{ID: 5, Status: users.StatusSuspended},
+			request:            `{"refresh_token":"some-refresh-token"}`,
+			expectedResponse:   `{"message":"invalid or expired refresh token"}`,
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:               "Fail - User deleted",
+			userErr:            users.ErrUserNotFound,
+			request:            `{"refresh_token":"some-refresh-token"}`,
+			expectedResponse:   `{"message":"invalid or expired refresh token"}`,
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:               "Fail - Invalid refresh token",
+			request:            `{"refresh_token":"other-refresh-token"}`,
+			expectedResponse:   `{"message":"invalid or expired refresh token"}`,
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:               "Fail - Bad request",
+			request:            "request_invalid",
+			expectedResponse:   `{"message":"could not decode value from input"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := gowebapp.NewWebApp("local")
+			userService := AuthenticatorMock{}
+			userService.On("Get", 5).Return(tt.user, tt.userErr)
+			handler := NewAuthHandler(&userService, RoleNamesListerMock{roles: []string{"admin", "support"}}, tt.tokens, MFAVerifierMock{}, SessionServiceMock{}, []string{"admin"})
+			app.Post("/auth/refresh", handler.Refresh)
+
+			r := httptest.NewRequest(http.MethodPost, "/auth/refresh", bytes.NewReader([]byte(tt.request)))
+
+			rr := httptest.NewRecorder()
+			app.Router.ServeHTTP(rr, r)
+
+			res := rr.Result()
+			resBody, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expectedStatusCode, res.StatusCode)
+			require.Equal(t, tt.expectedResponse, string(resBody))
+		})
+	}
+}
+
+func TestAuthHandler_Logout(t *testing.T) {
+	var tests = []struct {
+		name               string
+		principal          *auth.Principal
+		id                 string
+		expectedStatusCode int
+	}{
+		{
+			name:               "Ok",
+			principal:          &auth.Principal{UserID: 5},
+			id:                 "8",
+			expectedStatusCode: http.StatusNoContent,
+		},
+		{
+			name:               "Fail - Session of another user",
+			principal:          &auth.Principal{UserID: 6},
+			id:                 "8",
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "Fail - Invalid id",
+			principal:          &auth.Principal{UserID: 5},
+			id:                 "abc",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Fail - Unauthenticated",
+			id:                 "8",
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := gowebapp.NewWebApp("local")
+			handler := NewAuthHandler(nil, RoleNamesListerMock{}, nil, MFAVerifierMock{}, SessionServiceMock{}, nil)
+			app.Delete("/auth/sessions/{id}", handler.Logout)
+
+			r := httptest.NewRequest(http.MethodDelete, "/auth/sessions/"+tt.id, nil)
+			if tt.principal != nil {
+				r = r.WithContext(auth.WithPrincipal(r.Context(), *tt.principal))
+			}
+
+			rr := httptest.NewRecorder()
+			app.Router.ServeHTTP(rr, r)
+
+			require.Equal(t, tt.expectedStatusCode, rr.Result().StatusCode)
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	"github.com/marcosstupnicki/go-users/internal/sessions"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+)
+
+type Authorizer interface {
+	// Authorize returns nil if the caller of ctx has permission, auth.ErrUnauthenticated if there
+	// is no caller and auth.ErrForbidden if it lacks the permission.
+	Authorize(ctx context.Context, permission string) error
+}
+
+// SessionGetter returns the login sessions of principals.
+type SessionGetter interface {
+	Get(ctx context.Context, id int) (sessions.Session, error)
+}
+
+// authorize responds with 401 or 403 and returns false unless the caller has permission.
+func authorize(w http.ResponseWriter, r *http.Request, authorizer Authorizer, permission string) bool {
+	err := authorizer.Authorize(r.Context(), permission)
+	switch err {
+	case nil:
+		return true
+	case auth.ErrUnauthenticated:
+		gowebapp.RespondWithError(w, http.StatusUnauthorized, err.Error())
+	case auth.ErrForbidden:
+		gowebapp.RespondWithError(w, http.StatusForbidden, err.Error())
+	default:
+		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+	}
+
+	return false
+}
+
+// authorizeSelfOr is authorize, except the user with id userID is always allowed.
+func authorizeSelfOr(w http.ResponseWriter, r *http.Request, authorizer Authorizer, permission string, userID int) bool {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if ok && principal.UserID == userID {
+		return true
+	}
+
+	return authorize(w, r, authorizer, permission)
+}
+
+// mfaVerified reports whether principal is logged in with an active session of its user that
+// passed MFA. API keys, which have no session, never are.
+func mfaVerified(ctx context.Context, getter SessionGetter, principal auth.Principal) (bool, error) {
+	if principal.SessionID == 0 {
+		return false, nil
+	}
+
+	session, err := getter.Get(ctx, principal.SessionID)
+	if err != nil {
+		if err == sessions.ErrSessionNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return session.UserID == principal.UserID && session.RevokedAt == 0 && session.MFA, nil
+}
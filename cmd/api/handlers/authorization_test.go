@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	"github.com/marcosstupnicki/go-users/internal/rbac"
+	"github.com/stretchr/testify/require"
+)
+
+// AuthorizerMock returns err on every Authorize call. The zero value allows everything.
+type AuthorizerMock struct {
+	err error
+}
+
+func (a AuthorizerMock) Authorize(_ context.Context, _ string) error {
+	return a.err
+}
+
+func TestAuthorizeSelfOr(t *testing.T) {
+	var tests = []struct {
+		name               string
+		authorizer         AuthorizerMock
+		principal          *auth.Principal
+		expectedAllowed    bool
+		expectedResponse   string
+		expectedStatusCode int
+	}{
+		{
+			name:            "Ok - Permission granted",
+			principal:       &auth.Principal{Subject: "user:7", UserID: 7},
+			expectedAllowed: true,
+		},
+		{
+			name:            "Ok - Self",
+			authorizer:      AuthorizerMock{err: auth.ErrForbidden},
+			principal:       &auth.Principal{Subject: "user:5", UserID: 5},
+			expectedAllowed: true,
+		},
+		{
+			name:               "Fail - Anonymous",
+			authorizer:         AuthorizerMock{err: auth.ErrUnauthenticated},
+			expectedResponse:   "{\"message\":\"authentication required\"}",
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:               "Fail - Forbidden",
+			authorizer:         AuthorizerMock{err: auth.ErrForbidden},
+			principal:          &auth.Principal{Subject: "user:7", UserID: 7},
+			expectedResponse:   "{\"message\":\"permission denied\"}",
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			name:               "Fail - Internal error",
+			authorizer:         AuthorizerMock{err: ErrInternalErr},
+			principal:          &auth.Principal{Subject: "user:7", UserID: 7},
+			expectedResponse:   "{\"message\":\"Internal Server Error\"}",
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/users/5", nil)
+			if tt.principal != nil {
+				r = r.WithContext(auth.WithPrincipal(r.Context(), *tt.principal))
+			}
+			rr := httptest.NewRecorder()
+
+			allowed := authorizeSelfOr(rr, r, tt.authorizer, rbac.PermissionUsersRead, 5)
+			require.Equal(t, tt.expectedAllowed, allowed)
+			if tt.expectedAllowed {
+				return
+			}
+
+			res := rr.Result()
+			resBody, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expectedStatusCode, res.StatusCode)
+			require.Equal(t, tt.expectedResponse, string(resBody))
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/marcosstupnicki/go-users/internal/rbac"
+	"github.com/marcosstupnicki/go-users/internal/users"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+)
+
+// _maxBatchOperations bounds the operations of a batch, whose creates and password updates each
+// hash a password.
+const _maxBatchOperations = 100
+
+const (
+	_ErrorMessageInvalidBatchMode = "invalid mode. mode must be atomic or best_effort"
+	_ErrorMessageInvalidBatchSize = "invalid operations. a batch has 1 to 100 operations"
+)
+
+// _batchPermissions maps the batch operation methods to the permission they require.
+var _batchPermissions = map[string]string{
+	users.BatchMethodCreate: rbac.PermissionUsersWrite,
+	users.BatchMethodUpdate: rbac.PermissionUsersWrite,
+	users.BatchMethodDelete: rbac.PermissionUsersDelete,
+}
+
+// _batchStatuses maps the batch operation methods to the status code of their success.
+var _batchStatuses = map[string]int{
+	users.BatchMethodCreate: http.StatusCreated,
+	users.BatchMethodUpdate: http.StatusOK,
+	users.BatchMethodDelete: http.StatusNoContent,
+}
+
+type BatchService interface {
+	Batch(ctx context.Context, operations []users.BatchOperation, atomic bool) ([]users.BatchResult, error)
+}
+
+// BatchHandler runs create, update and delete operations on users in a single request, for admin
+// tooling.
+type BatchHandler struct {
+	Service    BatchService
+	Authorizer Authorizer
+}
+
+func NewBatchHandler(service BatchService, authorizer Authorizer) BatchHandler {
+	return BatchHandler{
+		Service:    service,
+		Authorizer: authorizer,
+	}
+}
+
+// Batch runs the operations of the request in order, with the validation and events of their
+// endpoints, and responds with the result of each one. Creates and updates require the
+// users:write permission and deletes users:delete, for every user, even to update oneself.
+func (h *BatchHandler) Batch(w http.ResponseWriter, r *http.Request) {
+	var batchRequest users.BatchRequest
+	err := json.NewDecoder(r.Body).Decode(&batchRequest)
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageCouldNotDecodeInput)
+		return
+	}
+
+	if batchRequest.Mode != "" && batchRequest.Mode != users.BatchModeAtomic && batchRequest.Mode != users.BatchModeBestEffort {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageInvalidBatchMode)
+		return
+	}
+	if len(batchRequest.Operations) == 0 || len(batchRequest.Operations) > _maxBatchOperations {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageInvalidBatchSize)
+		return
+	}
+
+	// Every permission is checked before running any operation. Operations with an unknown
+	// method fail on their own.
+	authorized := map[string]bool{}
+	operations := make([]users.BatchOperation, 0, len(batchRequest.Operations))
+	for _, operation := range batchRequest.Operations {
+		permission, ok := _batchPermissions[operation.Method]
+		if ok && !authorized[permission] {
+			if !authorize(w, r, h.Authorizer, permission) {
+				return
+			}
+			authorized[permission] = true
+		}
+
+		operations = append(operations, users.BatchOperation{
+			Method: operation.Method,
+			ID:     operation.ID,
+			User:   buildUserFromUserRequest(operation.User),
+		})
+	}
+
+	results, err := h.Service.Batch(r.Context(), operations, batchRequest.Mode == users.BatchModeAtomic)
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	response := users.BatchResponse{Results: make([]users.BatchResultResponse, 0, len(results))}
+	for i, result := range results {
+		response.Results = append(response.Results, buildBatchResultResponse(operations[i].Method, result))
+	}
+	gowebapp.RespondWithJSON(w, http.StatusOK, response)
+	return
+}
+
+// buildBatchResultResponse returns the result of an operation with the status code its endpoint
+// would respond with.
+func buildBatchResultResponse(method string, result users.BatchResult) users.BatchResultResponse {
+	switch {
+	case result.Err == nil && method == users.BatchMethodDelete:
+		return users.BatchResultResponse{Status: _batchStatuses[method]}
+	case result.Err == nil:
+		user := buildUserResponseFromUser(result.User)
+		return users.BatchResultResponse{Status: _batchStatuses[method], User: &user}
+	case users.IsValidationError(result.Err):
+		return users.BatchResultResponse{Status: http.StatusBadRequest, Error: result.Err.Error()}
+	case result.Err == users.ErrUserNotFound:
+		return users.BatchResultResponse{Status: http.StatusNotFound, Error: _ErrorMessageUserNotFound}
+	case result.Err == users.ErrUserAlreadyExists:
+		return users.BatchResultResponse{Status: http.StatusConflict, Error: _ErrorMessageUserAlreadyExists}
+	case result.Err == users.ErrConcurrentUpdate:
+		return users.BatchResultResponse{Status: http.StatusConflict, Error: result.Err.Error()}
+	case result.Err == users.ErrBatchAborted:
+		return users.BatchResultResponse{Status: http.StatusFailedDependency, Error: result.Err.Error()}
+	default:
+		return users.BatchResultResponse{Status: http.StatusInternalServerError, Error: http.StatusText(http.StatusInternalServerError)}
+	}
+}
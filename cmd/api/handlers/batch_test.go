@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	"github.com/marcosstupnicki/go-users/internal/users"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type BatchServiceMock struct {
+	mock.Mock
+}
+
+func (s *BatchServiceMock) Batch(_ context.Context, operations []users.BatchOperation, atomic bool) ([]users.BatchResult, error) {
+	args := s.Called(operations, atomic)
+	return args.Get(0).([]users.BatchResult), args.Error(1)
+}
+
+func TestBatchHandler_Batch(t *testing.T) {
+	var tests = []struct {
+		name               string
+		service            *BatchServiceMock
+		authorizer         AuthorizerMock
+		request            string
+		expectedResponse   string
+		expectedStatusCode int
+	}{
+		{
+			name: "Ok - Best effort, result of each operation",
+			service: func() *BatchServiceMock {
+				m := BatchServiceMock{}
+				m.On("Batch", []users.BatchOperation{
+					{Method: users.BatchMethodCreate, User: users.User{Email: "some@email.com", Password: "some-password"}},
+					{Method: users.BatchMethodDelete, ID: 2},
+					{Method: users.BatchMethodUpdate, ID: 3, User: users.User{FirstName: "Some"}},
+					{Method: "upsert", ID: 4},
+				}, false).Return([]users.BatchResult{
+					{User: users.User{ID: 1, Email: "some@email.com", Status: users.StatusActive}},
+					{},
+					{Err: users.ErrUserNotFound},
+					{Err: users.ErrInvalidBatchMethod},
+				}, nil)
+				return &m
+			}(),
+			request: `{"operations":[` +
+				`{"method":"create","user":{"email":"some@email.com","password":"some-password"}},` +
+				`{"method":"delete","id":2},` +
+				`{"method":"update","id":3,"user":{"first_name":"Some"}},` +
+				`{"method":"upsert","id":4}]}`,
+			expectedResponse: `{"results":[` +
+				`{"status":201,"user":{"id":1,"email":"some@email.com","status":"active"}},` +
+				`{"status":204},` +
+				`{"status":404,"error":"user not found"},` +
+				`{"status":400,"error":"invalid method. method must be one of create, update or delete"}]}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "Ok - Atomic, aborted operations",
+			service: func() *BatchServiceMock {
+				m := BatchServiceMock{}
+				m.On("Batch", mock.Anything, true).Return([]users.BatchResult{
+					{Err: users.ErrBatchAborted},
+					{Err: users.ErrUserAlreadyExists},
+				}, nil)
+				return &m
+			}(),
+			request: `{"mode":"atomic","operations":[` +
+				`{"method":"delete","id":2},` +
+				`{"method":"create","user":{"email":"some@email.com","password":"some-password"}}]}`,
+			expectedResponse: `{"results":[` +
+				`{"status":424,"error":"operation not applied. another operation of the atomic batch failed"},` +
+				`{"status":409,"error":"user already exists"}]}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "Fail - Transaction failed",
+			service: func() *BatchServiceMock {
+				m := BatchServiceMock{}
+				m.On("Batch", mock.Anything, true).Return([]users.BatchResult(nil), errors.New("internal error"))
+				return &m
+			}(),
+			request:            `{"mode":"atomic","operations":[{"method":"delete","id":2}]}`,
+			expectedResponse:   `{"message":"Internal Server Error"}`,
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+		{
+			name:               "Fail - Invalid mode",
+			request:            `{"mode":"eventual","operations":[{"method":"delete","id":2}]}`,
+			expectedResponse:   `{"message":"invalid mode. mode must be atomic or best_effort"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Fail - Without operations",
+			request:            `{"operations":[]}`,
+			expectedResponse:   `{"message":"invalid operations. a batch has 1 to 100 operations"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Fail - Too many operations",
+			request:            `{"operations":[` + strings.Repeat(`{"method":"delete","id":2},`, 100) + `{"method":"delete","id":2}]}`,
+			expectedResponse:   `{"message":"invalid operations. a batch has 1 to 100 operations"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Fail - Not allowed",
+			authorizer:         AuthorizerMock{err: auth.ErrForbidden},
+			request:            `{"operations":[{"method":"delete","id":2}]}`,
+			expectedResponse:   `{"message":"permission denied"}`,
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			name:               "Fail - Bad request",
+			request:            "request_invalid",
+			expectedResponse:   `{"message":"could not decode value from input"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewBatchHandler(tt.service, tt.authorizer)
+
+			r := httptest.NewRequest(http.MethodPost, "/users:batch", strings.NewReader(tt.request))
+			rr := httptest.NewRecorder()
+			handler.Batch(rr, r)
+
+			res := rr.Result()
+			resBody, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expectedStatusCode, res.StatusCode)
+			require.Equal(t, tt.expectedResponse, string(resBody))
+		})
+	}
+}
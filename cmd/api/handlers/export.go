@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/marcosstupnicki/go-users/internal/rbac"
+	"github.com/marcosstupnicki/go-users/internal/users"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+)
+
+const _ErrorMessageUnsupportedExportType = "unsupported accept header. exports are text/csv or application/x-ndjson"
+
+// _exportFormats maps the media ranges accepted by export requests to the format of the export.
+// Requests accepting any type get NDJSON.
+var _exportFormats = map[string]string{
+	"text/csv":         users.ExportFormatCSV,
+	"text/*":           users.ExportFormatCSV,
+	_contentTypeNDJSON: users.ExportFormatNDJSON,
+	"application/*":    users.ExportFormatNDJSON,
+	"*/*":              users.ExportFormatNDJSON,
+}
+
+// _exportContentTypes are the content types of the export formats.
+var _exportContentTypes = map[string]string{
+	users.ExportFormatCSV:    "text/csv; charset=utf-8",
+	users.ExportFormatNDJSON: _contentTypeNDJSON,
+}
+
+type Exporter interface {
+	Export(ctx context.Context, filter users.ListFilter, fn func(user users.User) error) error
+}
+
+// ExportHandler dumps the users, without their password. It requires the users:read permission,
+// like listing them.
+type ExportHandler struct {
+	Service    Exporter
+	Authorizer Authorizer
+}
+
+func NewExportHandler(service Exporter, authorizer Authorizer) ExportHandler {
+	return ExportHandler{
+		Service:    service,
+		Authorizer: authorizer,
+	}
+}
+
+// Export streams the users, ordered by id, as CSV or NDJSON as the Accept header prefers. They're
+// filtered like List, by indexed metadata keys with metadata.<namespace>.<key>=<value> query
+// params, but not paginated. If the export fails once streaming started, the connection is closed
+// before the end of the response, so clients don't mistake it for a whole export.
+func (h *ExportHandler) Export(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authorizer, rbac.PermissionUsersRead) {
+		return
+	}
+
+	format, ok := exportFormat(r.Header.Get("Accept"))
+	if !ok {
+		gowebapp.RespondWithError(w, http.StatusNotAcceptable, _ErrorMessageUnsupportedExportType)
+		return
+	}
+
+	writer, err := users.NewExportWriter(w, format)
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	started := false
+	err = h.Service.Export(r.Context(), listFilter(r), func(user users.User) error {
+		if !started {
+			started = true
+			setExportHeaders(w, format)
+		}
+		return writer.Write(user)
+	})
+	if err != nil {
+		if started {
+			abortResponse(w)
+			return
+		}
+		if users.IsValidationError(err) {
+			gowebapp.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	// Exports without users only have the CSV header, if any.
+	if !started {
+		setExportHeaders(w, format)
+	}
+	err = writer.Flush()
+	if err != nil {
+		abortResponse(w)
+		return
+	}
+	return
+}
+
+// abortResponse closes the connection of a response that already started, so it ends without
+// the end of its chunked body and clients see it failed. Panicking with http.ErrAbortHandler
+// doesn't, since the recoverer middleware swallows it.
+func abortResponse(w http.ResponseWriter) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return
+	}
+	// Hijacking discards the buffered response, send what was written first.
+	flusher, ok := w.(http.Flusher)
+	if ok {
+		flusher.Flush()
+	}
+
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	buf.Flush()
+	conn.Close()
+}
+
+func setExportHeaders(w http.ResponseWriter, format string) {
+	w.Header().Set("Content-Type", _exportContentTypes[format])
+	w.Header().Set("Content-Disposition", `attachment; filename="users.`+format+`"`)
+}
+
+// exportFormat returns the export format preferred by the accept header, by quality and then
+// order. Requests without the header get NDJSON.
+func exportFormat(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return users.ExportFormatNDJSON, true
+	}
+
+	format := ""
+	quality := 0.0
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(mediaRange)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		rangeFormat, ok := _exportFormats[mediaType]
+		if ok && q > quality {
+			format = rangeFormat
+			quality = q
+		}
+	}
+
+	return format, format != ""
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	"github.com/marcosstupnicki/go-users/internal/users"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+// ExporterMock exports its users matching the filter on the "preferences.theme" key, then fails
+// with err if it's set.
+type ExporterMock struct {
+	users []users.User
+	err   error
+}
+
+func (e ExporterMock) Export(_ context.Context, filter users.ListFilter, fn func(user users.User) error) error {
+	for key := range filter.Metadata {
+		if key != "preferences.theme" {
+			return fmt.Errorf("%w: %s", users.ErrMetadataKeyNotIndexed, key)
+		}
+	}
+	for _, user := range e.users {
+		theme, ok := filter.Metadata["preferences.theme"]
+		if ok && user.Metadata["preferences"]["theme"] != theme {
+			continue
+		}
+		err := fn(user)
+		if err != nil {
+			return err
+		}
+	}
+	return e.err
+}
+
+func TestExportHandler_Export(t *testing.T) {
+	exported := []users.User{
+		{ID: 1, Email: "some@email.com", Status: users.StatusActive, Metadata: users.Metadata{"preferences": {"theme": "dark"}}, CreatedAt: 1651422724, UpdatedAt: 1651422724},
+		{ID: 2, Email: "other@email.com", Status: users.StatusActive, CreatedAt: 1651422725, UpdatedAt: 1651422725},
+	}
+
+	var tests = []struct {
+		name                string
+		exporter            ExporterMock
+		authorizer          AuthorizerMock
+		accept              string
+		query               string
+		expectedContentType string
+		expectedResponse    string
+		expectedStatusCode  int
+	}{
+		{
+			name:                "Ok - NDJSON by default",
+			exporter:            ExporterMock{users: exported},
+			expectedContentType: "application/x-ndjson",
+			expectedResponse: `{"id":1,"email":"some@email.com","status":"active","metadata":{"preferences":{"theme":"dark"}},"created_at":1651422724,"updated_at":1651422724}` + "\n" +
+				`{"id":2,"email":"other@email.com","status":"active","created_at":1651422725,"updated_at":1651422725}` + "\n",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:                "Ok - CSV preferred, filtered",
+			exporter:            ExporterMock{users: exported},
+			accept:              "application/x-ndjson;q=0.5, text/csv",
+			query:               "?metadata.preferences.theme=dark",
+			expectedContentType: "text/csv; charset=utf-8",
+			expectedResponse: "id,email,status,status_reason,status_changed_at,first_name,last_name,display_name,locale,timezone,phone,avatar_url,metadata,created_at,updated_at\n" +
+				`1,some@email.com,active,,,,,,,,,,"{""preferences"":{""theme"":""dark""}}",1651422724,1651422724` + "\n",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:                "Ok - Empty CSV",
+			accept:              "text/csv",
+			expectedContentType: "text/csv; charset=utf-8",
+			expectedResponse:    "id,email,status,status_reason,status_changed_at,first_name,last_name,display_name,locale,timezone,phone,avatar_url,metadata,created_at,updated_at\n",
+			expectedStatusCode:  http.StatusOK,
+		},
+		{
+			name:               "Fail - Filter by key not indexed",
+			exporter:           ExporterMock{users: exported},
+			query:              "?metadata.crm.owner=sales",
+			expectedResponse:   `{"message":"metadata key not indexed: crm.owner"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Fail - Unsupported accept header",
+			accept:             "application/xml",
+			expectedResponse:   `{"message":"unsupported accept header. exports are text/csv or application/x-ndjson"}`,
+			expectedStatusCode: http.StatusNotAcceptable,
+		},
+		{
+			name:               "Fail - Error before streaming",
+			exporter:           ExporterMock{err: errors.New("internal error")},
+			expectedResponse:   `{"message":"Internal Server Error"}`,
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+		{
+			name:               "Fail - Not allowed",
+			authorizer:         AuthorizerMock{err: auth.ErrForbidden},
+			expectedResponse:   `{"message":"permission denied"}`,
+			expectedStatusCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewExportHandler(tt.exporter, tt.authorizer)
+
+			r := httptest.NewRequest(http.MethodGet, "/users/export"+tt.query, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			rr := httptest.NewRecorder()
+			handler.Export(rr, r)
+
+			res := rr.Result()
+			resBody, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expectedStatusCode, res.StatusCode)
+			require.Equal(t, tt.expectedResponse, string(resBody))
+			if tt.expectedContentType != "" {
+				require.Equal(t, tt.expectedContentType, res.Header.Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestExportHandler_Export_Aborted(t *testing.T) {
+	handler := NewExportHandler(ExporterMock{
+		users: []users.User{{ID: 1, Email: "some@email.com"}},
+		err:   errors.New("connection lost"),
+	}, AuthorizerMock{})
+	app := gowebapp.NewWebApp("local")
+	app.Get("/users/export", handler.Export)
+	server := httptest.NewServer(app.Router)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/users/export")
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	// The users exported before the failure are received, but the response doesn't end cleanly.
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	resBody, err := ioutil.ReadAll(res.Body)
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+	require.Contains(t, string(resBody), `"email":"some@email.com"`)
+}
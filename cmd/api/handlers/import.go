@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/config"
+	"github.com/marcosstupnicki/go-users/internal/rbac"
+	"github.com/marcosstupnicki/go-users/internal/users"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+)
+
+const (
+	_ErrorMessageUnsupportedImportType = "unsupported content type. content type must be text/csv or application/x-ndjson"
+	_ErrorMessageImportLineTooLong     = "line too long"
+
+	_contentTypeNDJSON = "application/x-ndjson"
+)
+
+// _importFormats maps the content types of import requests to their format.
+var _importFormats = map[string]string{
+	"text/csv":         users.ImportFormatCSV,
+	_contentTypeNDJSON: users.ImportFormatNDJSON,
+}
+
+type Importer interface {
+	Import(ctx context.Context, reader users.ImportReader, batchSize int, report func(result users.ImportResult) error) (users.ImportSummary, error)
+}
+
+// ImportHandler bulk imports users. It requires the users:admin permission, since imported users
+// can have a password hash and an initial status.
+type ImportHandler struct {
+	Service    Importer
+	Authorizer Authorizer
+	BatchSize  int
+}
+
+func NewImportHandler(service Importer, authorizer Authorizer, cfg config.Import) ImportHandler {
+	return ImportHandler{
+		Service:    service,
+		Authorizer: authorizer,
+		BatchSize:  cfg.BatchSize,
+	}
+}
+
+// Import creates the users of a CSV or NDJSON request body, as its content type says, and streams
+// back an NDJSON report: the result of each row as it's imported, then the summary. The body is
+// read as it's imported, so imports aren't limited in size. Once the report started, errors
+// aborting the import are reported by the summary, with the users imported until then kept.
+func (h *ImportHandler) Import(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authorizer, rbac.PermissionUsersAdmin) {
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	format, ok := _importFormats[mediaType]
+	if err != nil || !ok {
+		gowebapp.RespondWithError(w, http.StatusUnsupportedMediaType, _ErrorMessageUnsupportedImportType)
+		return
+	}
+
+	reader, err := users.NewImportReader(r.Body, format)
+	if err != nil {
+		if err == users.ErrInvalidImportHeader {
+			gowebapp.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	w.Header().Set("Content-Type", _contentTypeNDJSON)
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+
+	summary, err := h.Service.Import(r.Context(), reader, h.BatchSize, func(result users.ImportResult) error {
+		err := encoder.Encode(result)
+		if err != nil {
+			return err
+		}
+		// Flushed once per row, so clients follow the import progress.
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		summary.Error = http.StatusText(http.StatusInternalServerError)
+		if err == bufio.ErrTooLong {
+			summary.Error = _ErrorMessageImportLineTooLong
+		}
+	}
+
+	encoder.Encode(summary)
+	return
+}
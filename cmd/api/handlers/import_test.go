@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	"github.com/marcosstupnicki/go-users/internal/platform/config"
+	"github.com/marcosstupnicki/go-users/internal/users"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+// ImporterMock reports every row read as created, then fails with err if it's set.
+type ImporterMock struct {
+	err error
+}
+
+func (i ImporterMock) Import(_ context.Context, reader users.ImportReader, _ int, report func(result users.ImportResult) error) (users.ImportSummary, error) {
+	summary := users.ImportSummary{Status: users.ImportStatusCompleted}
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return users.ImportSummary{Status: users.ImportStatusAborted}, err
+		}
+		summary.Created++
+		err = report(users.ImportResult{Row: row.Row, Email: row.User.Email, Status: users.ImportStatusCreated, ID: summary.Created})
+		if err != nil {
+			return users.ImportSummary{Status: users.ImportStatusAborted}, err
+		}
+	}
+	if i.err != nil {
+		summary.Status = users.ImportStatusAborted
+		return summary, i.err
+	}
+	return summary, nil
+}
+
+func TestImportHandler_Import(t *testing.T) {
+	var tests = []struct {
+		name               string
+		importer           ImporterMock
+		authorizer         AuthorizerMock
+		contentType        string
+		request            string
+		expectedResponse   string
+		expectedStatusCode int
+	}{
+		{
+			name:        "Ok - Import CSV",
+			contentType: "text/csv; charset=utf-8",
+			request:     "email,password\nsome@email.com,some-password\nother@email.com,some-password\n",
+			expectedResponse: `{"row":2,"email":"some@email.com","status":"created","id":1}` + "\n" +
+				`{"row":3,"email":"other@email.com","status":"created","id":2}` + "\n" +
+				`{"status":"completed","created":2,"failed":0}` + "\n",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:        "Ok - Import NDJSON",
+			contentType: "application/x-ndjson",
+			request:     `{"email":"some@email.com","password":"some-password"}`,
+			expectedResponse: `{"row":1,"email":"some@email.com","status":"created","id":1}` + "\n" +
+				`{"status":"completed","created":1,"failed":0}` + "\n",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:        "Ok - Aborted import",
+			importer:    ImporterMock{err: errors.New("internal error")},
+			contentType: "application/x-ndjson",
+			request:     `{"email":"some@email.com","password":"some-password"}`,
+			expectedResponse: `{"row":1,"email":"some@email.com","status":"created","id":1}` + "\n" +
+				`{"status":"aborted","created":1,"failed":0,"error":"Internal Server Error"}` + "\n",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Fail - Invalid header",
+			contentType:        "text/csv",
+			request:            "name,password\n",
+			expectedResponse:   `{"message":"invalid import header. columns must be user fields, including email, without repeats"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Fail - Unsupported content type",
+			contentType:        "application/json",
+			request:            `[{"email":"some@email.com"}]`,
+			expectedResponse:   `{"message":"unsupported content type. content type must be text/csv or application/x-ndjson"}`,
+			expectedStatusCode: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:               "Fail - Not an admin",
+			authorizer:         AuthorizerMock{err: auth.ErrForbidden},
+			contentType:        "text/csv",
+			request:            "email,password\n",
+			expectedResponse:   `{"message":"permission denied"}`,
+			expectedStatusCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := gowebapp.NewWebApp("local")
+			handler := NewImportHandler(tt.importer, tt.authorizer, config.Import{BatchSize: 100})
+			app.Post("/users/import", handler.Import)
+
+			r := httptest.NewRequest(http.MethodPost, "/users/import", strings.NewReader(tt.request))
+			r.Header.Set("Content-Type", tt.contentType)
+			rr := httptest.NewRecorder()
+			app.Router.ServeHTTP(rr, r)
+
+			res := rr.Result()
+			resBody, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expectedStatusCode, res.StatusCode)
+			require.Equal(t, tt.expectedResponse, string(resBody))
+		})
+	}
+}
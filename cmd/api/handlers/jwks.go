@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+)
+
+// _jwksCacheControl lets verifiers cache the key set for 5 minutes. Keys must be published for
+// longer than that before they sign tokens.
+const _jwksCacheControl = "public, max-age=300"
+
+type KeySetPublisher interface {
+	JWKS() auth.JWKS
+}
+
+// JWKSHandler publishes the public keys verifying access tokens, for other services.
+type JWKSHandler struct {
+	Keys KeySetPublisher
+}
+
+func NewJWKSHandler(keys KeySetPublisher) JWKSHandler {
+	return JWKSHandler{
+		Keys: keys,
+	}
+}
+
+func (h *JWKSHandler) Get(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", _jwksCacheControl)
+	gowebapp.RespondWithJSON(w, http.StatusOK, h.Keys.JWKS())
+	return
+}
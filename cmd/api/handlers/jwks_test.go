@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+type KeySetPublisherMock struct {
+	jwks auth.JWKS
+}
+
+func (p KeySetPublisherMock) JWKS() auth.JWKS {
+	return p.jwks
+}
+
+func TestJWKSHandler_Get(t *testing.T) {
+	app := gowebapp.NewWebApp("local")
+	handler := NewJWKSHandler(KeySetPublisherMock{jwks: auth.JWKS{Keys: []auth.JWK{
+		{KeyType: "RSA", Use: "sig", Algorithm: "RS256", KeyID: "2024-06", Modulus: "sXch", Exponent: "AQAB"},
+	}}})
+	app.Get("/.well-known/jwks.json", handler.Get)
+
+	r := httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+	rr := httptest.NewRecorder()
+	app.Router.ServeHTTP(rr, r)
+
+	res := rr.Result()
+	resBody, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "public, max-age=300", res.Header.Get("Cache-Control"))
+	require.Equal(t, `{"keys":[{"kty":"RSA","use":"sig","alg":"RS256","kid":"2024-06","n":"sXch","e":"AQAB"}]}`, string(resBody))
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/marcosstupnicki/go-users/internal/mfa"
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	"github.com/marcosstupnicki/go-users/internal/users"
+)
+
+// loginSteps are the password and second factor steps of a login, shared by POST /auth/login and
+// the login form of the OAuth authorization endpoint.
+type loginSteps struct {
+	users  Authenticator
+	mfa    MFAVerifier
+	tokens TokenIssuer
+}
+
+// password verifies the email and password of a user. Users enrolled in MFA get an MFA token, and
+// when it expires, to send with a code to secondFactor. For the others the login is completed.
+func (l loginSteps) password(ctx context.Context, email string, password string) (users.User, string, time.Time, error) {
+	user, err := l.users.Authenticate(ctx, email, password)
+	if err != nil {
+		return users.User{}, "", time.Time{}, err
+	}
+
+	enabled, err := l.mfa.Enabled(ctx, user.ID)
+	if err != nil {
+		return users.User{}, "", time.Time{}, err
+	}
+	if enabled {
+		token, challenge, err := l.tokens.IssueChallenge(user.ID)
+		if err != nil {
+			return users.User{}, "", time.Time{}, err
+		}
+		err = l.mfa.StartChallenge(ctx, challenge.ID, user.ID, challenge.ExpiresAt)
+		if err != nil {
+			return users.User{}, "", time.Time{}, err
+		}
+
+		return user, token, challenge.ExpiresAt, nil
+	}
+
+	err = l.users.CompleteLogin(ctx, user)
+	if err != nil {
+		return users.User{}, "", time.Time{}, err
+	}
+
+	return user, "", time.Time{}, nil
+}
+
+// secondFactor verifies a TOTP or recovery code for an MFA token returned by password, and
+// completes the login. Wrong codes count as failed logins, and fail with mfa.ErrInvalidCode.
+func (l loginSteps) secondFactor(ctx context.Context, mfaToken string, code string) (users.User, error) {
+	challenge, err := l.tokens.ParseChallenge(mfaToken)
+	if err != nil {
+		return users.User{}, err
+	}
+
+	user, err := l.users.CheckLogin(ctx, challenge.UserID)
+	if err != nil {
+		if err == users.ErrUserNotFound {
+			return users.User{}, auth.ErrInvalidToken
+		}
+		return users.User{}, err
+	}
+
+	err = l.mfa.VerifyChallenge(ctx, challenge.ID, user.ID, code)
+	if err != nil {
+		if err == mfa.ErrInvalidCode || err == mfa.ErrCodeAlreadyUsed || err == mfa.ErrNotEnrolled {
+			err = l.users.RecordLoginFailure(ctx, user)
+			if err != nil {
+				return users.User{}, err
+			}
+			return users.User{}, mfa.ErrInvalidCode
+		}
+		return users.User{}, err
+	}
+
+	err = l.users.CompleteLogin(ctx, user)
+	if err != nil {
+		return users.User{}, err
+	}
+
+	return user, nil
+}
+
+// loginErrorStatus returns the status code of a failed login step, and whether err is one of the
+// expected failures whose message can be shown to the user.
+func loginErrorStatus(err error) (int, bool) {
+	switch {
+	case err == users.ErrInvalidCredentials, err == auth.ErrInvalidToken, err == mfa.ErrChallengeInvalid,
+		err == mfa.ErrInvalidCode:
+		return http.StatusUnauthorized, true
+	case err == users.ErrLoginThrottled:
+		return http.StatusTooManyRequests, true
+	case users.IsAccountStatusError(err):
+		return http.StatusForbidden, true
+	default:
+		return http.StatusInternalServerError, false
+	}
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/marcosstupnicki/go-users/internal/mfa"
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	"github.com/marcosstupnicki/go-users/internal/users"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+)
+
+const _ErrorMessageMFADisableWithoutMFA = "mfa can only be disabled from a login with mfa"
+
+type MFAService interface {
+	Enroll(ctx context.Context, userID int, account string) (mfa.EnrollResponse, error)
+	Confirm(ctx context.Context, userID int, code string) ([]string, error)
+	Disable(ctx context.Context, userID int, code string) error
+}
+
+// MFAHandler manages the second factor of the authenticated user. There's no admin access: only
+// users can enroll or disable their own.
+type MFAHandler struct {
+	Service  MFAService
+	Users    UserGetter
+	Sessions SessionGetter
+}
+
+func NewMFAHandler(service MFAService, users UserGetter, sessions SessionGetter) MFAHandler {
+	return MFAHandler{
+		Service:  service,
+		Users:    users,
+		Sessions: sessions,
+	}
+}
+
+// Enroll starts the enrollment of the authenticated user, returning the secret to add to an
+// authenticator app.
+func (h *MFAHandler) Enroll(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		gowebapp.RespondWithError(w, http.StatusUnauthorized, auth.ErrUnauthenticated.Error())
+		return
+	}
+
+	user, err := h.Users.Get(r.Context(), principal.UserID)
+	if err != nil {
+		if err == users.ErrUserNotFound {
+			gowebapp.RespondWithError(w, http.StatusUnauthorized, auth.ErrUnauthenticated.Error())
+			return
+		}
+		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	enrollment, err := h.Service.Enroll(r.Context(), user.ID, user.Email)
+	if err != nil {
+		respondWithMFAError(w, err)
+		return
+	}
+
+	gowebapp.RespondWithJSON(w, http.StatusOK, enrollment)
+	return
+}
+
+// Confirm confirms the enrollment of the authenticated user with a code, returning its recovery
+// codes.
+func (h *MFAHandler) Confirm(w http.ResponseWriter, r *http.Request) {
+	principal, codeRequest, ok := decodeMFACodeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	recoveryCodes, err := h.Service.Confirm(r.Context(), principal.UserID, codeRequest.Code)
+	if err != nil {
+		respondWithMFAError(w, err)
+		return
+	}
+
+	gowebapp.RespondWithJSON(w, http.StatusOK, mfa.RecoveryCodesResponse{RecoveryCodes: recoveryCodes})
+	return
+}
+
+// Disable disables MFA for the authenticated user, given a TOTP or recovery code. It requires a
+// session that passed MFA, so a stolen password or access token of a session without it isn't
+// enough to guess codes.
+func (h *MFAHandler) Disable(w http.ResponseWriter, r *http.Request) {
+	principal, codeRequest, ok := decodeMFACodeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	verified, err := mfaVerified(r.Context(), h.Sessions, principal)
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	if !verified {
+		gowebapp.RespondWithError(w, http.StatusForbidden, _ErrorMessageMFADisableWithoutMFA)
+		return
+	}
+
+	err = h.Service.Disable(r.Context(), principal.UserID, codeRequest.Code)
+	if err != nil {
+		respondWithMFAError(w, err)
+		return
+	}
+
+	gowebapp.RespondWithJSON(w, http.StatusNoContent, nil)
+	return
+}
+
+// decodeMFACodeRequest returns the authenticated principal and the code request of r, or responds
+// with an error and returns false.
+func decodeMFACodeRequest(w http.ResponseWriter, r *http.Request) (auth.Principal, mfa.CodeRequest, bool) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		gowebapp.RespondWithError(w, http.StatusUnauthorized, auth.ErrUnauthenticated.Error())
+		return auth.Principal{}, mfa.CodeRequest{}, false
+	}
+
+	var codeRequest mfa.CodeRequest
+	err := json.NewDecoder(r.Body).Decode(&codeRequest)
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageCouldNotDecodeInput)
+		return auth.Principal{}, mfa.CodeRequest{}, false
+	}
+
+	return principal, codeRequest, true
+}
+
+func respondWithMFAError(w http.ResponseWriter, err error) {
+	switch err {
+	case mfa.ErrNotEnrolled:
+		gowebapp.RespondWithError(w, http.StatusNotFound, err.Error())
+	case mfa.ErrAlreadyEnrolled:
+		gowebapp.RespondWithError(w, http.StatusConflict, err.Error())
+	case mfa.ErrInvalidCode, mfa.ErrCodeAlreadyUsed:
+		gowebapp.RespondWithError(w, http.StatusBadRequest, mfa.ErrInvalidCode.Error())
+	default:
+		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+	}
+}
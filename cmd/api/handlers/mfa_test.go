@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/marcosstupnicki/go-users/internal/mfa"
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	"github.com/marcosstupnicki/go-users/internal/users"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MFAServiceMock struct {
+	mock.Mock
+}
+
+func (s *MFAServiceMock) Enroll(_ context.Context, userID int, account string) (mfa.EnrollResponse, error) {
+	args := s.Called(userID, account)
+	return args.Get(0).(mfa.EnrollResponse), args.Error(1)
+}
+
+func (s *MFAServiceMock) Confirm(_ context.Context, userID int, code string) ([]string, error) {
+	args := s.Called(userID, code)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (s *MFAServiceMock) Disable(_ context.Context, userID int, code string) error {
+	args := s.Called(userID, code)
+	return args.Error(0)
+}
+
+func TestMFAHandler_Enroll(t *testing.T) {
+	var tests = []struct {
+		name               string
+		principal          *auth.Principal
+		service            *MFAServiceMock
+		expectedResponse   string
+		expectedStatusCode int
+	}{
+		{
+			name:      "Ok",
+			principal: &auth.Principal{UserID: 5},
+			service: func() *MFAServiceMock {
+				m := MFAServiceMock{}
+				m.On("Enroll", 5, "some@email.com").Return(mfa.EnrollResponse{
+					Secret: "JBSWY3DPEHPK3PXP",
+					URI:    "otpauth://totp/go-users:some@email.com?issuer=go-users&secret=JBSWY3DPEHPK3PXP",
+				}, nil)
+				return &m
+			}(),
+			expectedResponse:   `{"secret":"JBSWY3DPEHPK3PXP","otpauth_uri":"otpauth://totp/go-users:some@email.com?issuer=go-users\u0026secret=JBSWY3DPEHPK3PXP"}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:      "Fail - Already enrolled",
+			principal: &auth.Principal{UserID: 5},
+			service: func() *MFAServiceMock {
+				m := MFAServiceMock{}
+				m.On("Enroll", 5, "some@email.com").Return(mfa.EnrollResponse{}, mfa.ErrAlreadyEnrolled)
+				return &m
+			}(),
+			expectedResponse:   `{"message":"mfa already enrolled"}`,
+			expectedStatusCode: http.StatusConflict,
+		},
+		{
+			name:               "Fail - Unauthenticated",
+			expectedResponse:   `{"message":"authentication required"}`,
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userGetter := &ServiceMock{}
+			userGetter.On("Get").Return(users.User{ID: 5, Email: "some@email.com"}, nil)
+
+			app := gowebapp.NewWebApp("local")
+			handler := NewMFAHandler(tt.service, userGetter, SessionServiceMock{})
+			app.Post("/auth/mfa/enroll", handler.Enroll)
+
+			r := httptest.NewRequest(http.MethodPost, "/auth/mfa/enroll", nil)
+			if tt.principal != nil {
+				r = r.WithContext(auth.WithPrincipal(r.Context(), *tt.principal))
+			}
+
+			rr := httptest.NewRecorder()
+			app.Router.ServeHTTP(rr, r)
+
+			res := rr.Result()
+			resBody, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expectedStatusCode, res.StatusCode)
+			require.Equal(t, tt.expectedResponse, string(resBody))
+		})
+	}
+}
+
+func TestMFAHandler_Confirm(t *testing.T) {
+	var tests = []struct {
+		name               string
+		service            *MFAServiceMock
+		request            string
+		expectedResponse   string
+		expectedStatusCode int
+	}{
+		{
+			name: "Ok",
+			service: func() *MFAServiceMock {
+				m := MFAServiceMock{}
+				m.On("Confirm", 5, "123456").Return([]string{"abcd-efgh-ijkl-mnop"}, nil)
+				return &m
+			}(),
+			request:            `{"code":"123456"}`,
+			expectedResponse:   `{"recovery_codes":["abcd-efgh-ijkl-mnop"]}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "Fail - Invalid code",
+			service: func() *MFAServiceMock {
+				m := MFAServiceMock{}
+				m.On("Confirm", 5, "654321").Return([]string(nil), mfa.ErrInvalidCode)
+				return &m
+			}(),
+			request:            `{"code":"654321"}`,
+			expectedResponse:   `{"message":"invalid mfa code"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name: "Fail - Not enrolled",
+			service: func() *MFAServiceMock {
+				m := MFAServiceMock{}
+				m.On("Confirm", 5, "123456").Return([]string(nil), mfa.ErrNotEnrolled)
+				return &m
+			}(),
+			request:            `{"code":"123456"}`,
+			expectedResponse:   `{"message":"mfa not enrolled"}`,
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "Fail - Bad request",
+			request:            "request_invalid",
+			expectedResponse:   `{"message":"could not decode value from input"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := gowebapp.NewWebApp("local")
+			handler := NewMFAHandler(tt.service, &ServiceMock{}, SessionServiceMock{})
+			app.Post("/auth/mfa/confirm", handler.Confirm)
+
+			r := httptest.NewRequest(http.MethodPost, "/auth/mfa/confirm", bytes.NewReader([]byte(tt.request)))
+			r = r.WithContext(auth.WithPrincipal(r.Context(), auth.Principal{UserID: 5}))
+
+			rr := httptest.NewRecorder()
+			app.Router.ServeHTTP(rr, r)
+
+			res := rr.Result()
+			resBody, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expectedStatusCode, res.StatusCode)
+			require.Equal(t, tt.expectedResponse, string(resBody))
+		})
+	}
+}
+
+func TestMFAHandler_Disable(t *testing.T) {
+	var tests = []struct {
+		name               string
+		principal          auth.Principal
+		service            *MFAServiceMock
+		expectedResponse   string
+		expectedStatusCode int
+	}{
+		{
+			name:      "Ok - Disabled from a login with MFA",
+			principal: auth.Principal{UserID: 5, SessionID: 8},
+			service: func() *MFAServiceMock {
+				m := MFAServiceMock{}
+				m.On("Disable", 5, "abcd-efgh-ijkl-mnop").Return(nil)
+				return &m
+			}(),
+			expectedStatusCode: http.StatusNoContent,
+		},
+		{
+			name:               "Fail - Login without MFA",
+			principal:          auth.Principal{UserID: 5, SessionID: 10},
+			service:            &MFAServiceMock{},
+			expectedResponse:   `{"message":"mfa can only be disabled from a login with mfa"}`,
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			name:               "Fail - Without a session",
+			principal:          auth.Principal{UserID: 5},
+			service:            &MFAServiceMock{},
+			expectedResponse:   `{"message":"mfa can only be disabled from a login with mfa"}`,
+			expectedStatusCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := gowebapp.NewWebApp("local")
+			handler := NewMFAHandler(tt.service, &ServiceMock{}, SessionServiceMock{})
+			app.Post("/auth/mfa/disable", handler.Disable)
+
+			r := httptest.NewRequest(http.MethodPost, "/auth/mfa/disable", bytes.NewReader([]byte(`{"code":"abcd-efgh-ijkl-mnop"}`)))
+			r = r.WithContext(auth.WithPrincipal(r.Context(), tt.principal))
+
+			rr := httptest.NewRecorder()
+			app.Router.ServeHTTP(rr, r)
+
+			res := rr.Result()
+			resBody, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expectedStatusCode, res.StatusCode)
+			require.Equal(t, tt.expectedResponse, string(resBody))
+			tt.service.AssertExpectations(t)
+		})
+	}
+}
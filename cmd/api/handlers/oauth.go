@@ -0,0 +1,403 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/marcosstupnicki/go-users/internal/oauth"
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	"github.com/marcosstupnicki/go-users/internal/sessions"
+	"github.com/marcosstupnicki/go-users/internal/users"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+)
+
+// _discoveryCacheControl lets clients cache the discovery document for 5 minutes, like the JWKS.
+const _discoveryCacheControl = "public, max-age=300"
+
+// _errServerError is the token endpoint error of unexpected failures.
+var _errServerError = &oauth.Error{Code: "server_error", Description: http.StatusText(http.StatusInternalServerError)}
+
+type OAuthService interface {
+	ValidateRedirect(ctx context.Context, clientID string, redirectURI string) error
+	Authorize(ctx context.Context, request oauth.AuthorizeRequest, session sessions.Session) (string, error)
+	Exchange(ctx context.Context, request oauth.TokenRequest) (oauth.AuthorizationCode, error)
+	AuthenticateClient(ctx context.Context, clientID string, secret string) (oauth.Client, error)
+	IDToken(code oauth.AuthorizationCode, user users.User) (string, error)
+	UserInfo(user users.User) oauth.UserInfo
+	Discovery() oauth.Discovery
+}
+
+type OAuthSessionService interface {
+	Get(ctx context.Context, id int) (sessions.Session, error)
+	Start(ctx context.Context, userID int, mfa bool) (sessions.Session, string, error)
+	StartForClient(ctx context.Context, userID int, mfa bool, clientID string) (sessions.Session, string, error)
+	Refresh(ctx context.Context, refreshToken string, clientID string) (sessions.Session, string, error)
+}
+
+// OAuthHandler serves the OAuth 2.0 authorization code flow with PKCE, and the OpenID Connect
+// userinfo and discovery endpoints. Tokens are the access and refresh tokens of login sessions,
+// so clients use them like the ones of POST /auth/login.
+type OAuthHandler struct {
+	Service  OAuthService
+	Sessions OAuthSessionService
+	Users    UserGetter
+	// Logins and MFA run the login form of the authorization endpoint.
+	Logins Authenticator
+	MFA    MFAVerifier
+	Roles  RoleNamesLister
+	Tokens TokenIssuer
+	// MFARequiredRoles are left out of the tokens of sessions that didn't pass MFA.
+	MFARequiredRoles []string
+}
+
+func NewOAuthHandler(service OAuthService, sessions OAuthSessionService, users UserGetter, logins Authenticator, mfa MFAVerifier, roles RoleNamesLister, tokens TokenIssuer, mfaRequiredRoles []string) OAuthHandler {
+	return OAuthHandler{
+		Service:          service,
+		Sessions:         sessions,
+		Users:            users,
+		Logins:           logins,
+		MFA:              mfa,
+		Roles:            roles,
+		Tokens:           tokens,
+		MFARequiredRoles: mfaRequiredRoles,
+	}
+}
+
+// Discovery serves the OpenID provider metadata.
+func (h *OAuthHandler) Discovery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", _discoveryCacheControl)
+	gowebapp.RespondWithJSON(w, http.StatusOK, h.Service.Discovery())
+	return
+}
+
+// Authorize issues an authorization code to the client for the user of the bearer token, or of
+// the login cookie set by AuthorizeLogin, and redirects to the client redirect URI with it. Users
+// not logged in get the login form, unless the request has prompt=none. Errors are redirected
+// too, as the OAuth error and state query params, except the ones of unknown clients or
+// unregistered redirect URIs.
+func (h *OAuthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
+	authorizeRequest := authorizeRequestFrom(r.URL.Query())
+	if !h.validateRedirect(w, r, authorizeRequest) {
+		return
+	}
+
+	session, ok, err := h.loginSession(r)
+	if err != nil {
+		redirectWithOAuthError(w, r, authorizeRequest, _errServerError)
+		return
+	}
+	if !ok {
+		if authorizeRequest.Prompt == "none" {
+			redirectWithOAuthError(w, r, authorizeRequest, oauth.ErrLoginRequired)
+			return
+		}
+		h.renderLoginForm(w, r, http.StatusOK, loginForm{Request: authorizeRequest})
+		return
+	}
+
+	h.authorize(w, r, authorizeRequest, session)
+	return
+}
+
+// Token exchanges an authorization code, or a refresh token, for tokens. The request is form
+// encoded, and clients authenticate with HTTP basic authentication or the client_id and
+// client_secret params.
+func (h *OAuthHandler) Token(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	err := r.ParseForm()
+	if err != nil {
+		respondWithOAuthError(w, &oauth.Error{Code: "invalid_request", Description: _ErrorMessageCouldNotDecodeInput})
+		return
+	}
+
+	tokenRequest := oauth.TokenRequest{
+		GrantType:    r.PostForm.Get("grant_type"),
+		ClientID:     r.PostForm.Get("client_id"),
+		ClientSecret: r.PostForm.Get("client_secret"),
+		Code:         r.PostForm.Get("code"),
+		RedirectURI:  r.PostForm.Get("redirect_uri"),
+		CodeVerifier: r.PostForm.Get("code_verifier"),
+		RefreshToken: r.PostForm.Get("refresh_token"),
+	}
+	if clientID, secret, ok := basicAuth(r); ok {
+		tokenRequest.ClientID = clientID
+		tokenRequest.ClientSecret = secret
+	}
+
+	switch tokenRequest.GrantType {
+	case oauth.GrantTypeAuthorizationCode:
+		h.exchangeCode(w, r, tokenRequest)
+	case oauth.GrantTypeRefreshToken:
+		h.refresh(w, r, tokenRequest)
+	default:
+		respondWithOAuthError(w, oauth.ErrUnsupportedGrantType)
+	}
+	return
+}
+
+// UserInfo returns the claims of the user of the bearer token.
+func (h *OAuthHandler) UserInfo(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		w.Header().Set("WWW-Authenticate", _tokenTypeBearer)
+		gowebapp.RespondWithError(w, http.StatusUnauthorized, auth.ErrUnauthenticated.Error())
+		return
+	}
+
+	user, err := h.Users.Get(r.Context(), principal.UserID)
+	if err != nil {
+		if err == users.ErrUserNotFound {
+			w.Header().Set("WWW-Authenticate", _tokenTypeBearer)
+			gowebapp.RespondWithError(w, http.StatusUnauthorized, auth.ErrUnauthenticated.Error())
+			return
+		}
+		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	gowebapp.RespondWithJSON(w, http.StatusOK, h.Service.UserInfo(user))
+	return
+}
+
+// exchangeCode redeems an authorization code, starting a session of the client for its user, and
+// responds with the session tokens and, for the openid scope, an ID token. Codes of users no
+// longer active are rejected.
+func (h *OAuthHandler) exchangeCode(w http.ResponseWriter, r *http.Request, tokenRequest oauth.TokenRequest) {
+	code, err := h.Service.Exchange(r.Context(), tokenRequest)
+	if err != nil {
+		respondWithOAuthError(w, err)
+		return
+	}
+
+	user, err := h.Users.Get(r.Context(), code.UserID)
+	if err != nil {
+		if err == users.ErrUserNotFound {
+			respondWithOAuthError(w, oauth.ErrInvalidGrant)
+			return
+		}
+		respondWithOAuthError(w, err)
+		return
+	}
+	if user.Status != users.StatusActive {
+		respondWithOAuthError(w, oauth.ErrInvalidGrant)
+		return
+	}
+
+	session, refreshToken, err := h.Sessions.StartForClient(r.Context(), user.ID, code.MFA, code.ClientID)
+	if err != nil {
+		respondWithOAuthError(w, err)
+		return
+	}
+
+	accessToken, expiresAt, _, err := issueAccessToken(r.Context(), h.Roles, h.Tokens, h.MFARequiredRoles, session)
+	if err != nil {
+		respondWithOAuthError(w, err)
+		return
+	}
+
+	var idToken string
+	if code.HasScope(oauth.ScopeOpenID) {
+		idToken, err = h.Service.IDToken(code, user)
+		if err != nil {
+			respondWithOAuthError(w, err)
+			return
+		}
+	}
+
+	gowebapp.RespondWithJSON(w, http.StatusOK, oauth.TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    _tokenTypeBearer,
+		ExpiresIn:    expiresIn(expiresAt),
+		RefreshToken: refreshToken,
+		IDToken:      idToken,
+		Scope:        code.Scope,
+	})
+}
+
+// refresh rotates the refresh token of a session, like POST /auth/refresh, for the authenticated
+// client the session was started for.
+func (h *OAuthHandler) refresh(w http.ResponseWriter, r *http.Request, tokenRequest oauth.TokenRequest) {
+	client, err := h.Service.AuthenticateClient(r.Context(), tokenRequest.ClientID, tokenRequest.ClientSecret)
+	if err != nil {
+		respondWithOAuthError(w, err)
+		return
+	}
+
+	session, refreshToken, err := h.Sessions.Refresh(r.Context(), tokenRequest.RefreshToken, client.ClientID)
+	if err != nil {
+		if err == sessions.ErrInvalidRefreshToken || err == sessions.ErrRefreshTokenReused {
+			respondWithOAuthError(w, &oauth.Error{Code: oauth.ErrInvalidGrant.Code, Description: err.Error()})
+			return
+		}
+		respondWithOAuthError(w, err)
+		return
+	}
+
+	active, err := activeUser(r.Context(), h.Users, session.UserID)
+	if err != nil {
+		respondWithOAuthError(w, err)
+		return
+	}
+	if !active {
+		respondWithOAuthError(w, &oauth.Error{Code: oauth.ErrInvalidGrant.Code, Description: sessions.ErrInvalidRefreshToken.Error()})
+		return
+	}
+
+	accessToken, expiresAt, _, err := issueAccessToken(r.Context(), h.Roles, h.Tokens, h.MFARequiredRoles, session)
+	if err != nil {
+		respondWithOAuthError(w, err)
+		return
+	}
+
+	gowebapp.RespondWithJSON(w, http.StatusOK, oauth.TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    _tokenTypeBearer,
+		ExpiresIn:    expiresIn(expiresAt),
+		RefreshToken: refreshToken,
+	})
+}
+
+// validateRedirect responds with an error, and returns false, if the client of authorizeRequest
+// is unknown or its redirect URI isn't registered. Those errors aren't redirected, since the
+// redirect URI can't be trusted.
+func (h *OAuthHandler) validateRedirect(w http.ResponseWriter, r *http.Request, authorizeRequest oauth.AuthorizeRequest) bool {
+	err := h.Service.ValidateRedirect(r.Context(), authorizeRequest.ClientID, authorizeRequest.RedirectURI)
+	if err != nil {
+		if oauthErr, ok := err.(*oauth.Error); ok {
+			gowebapp.RespondWithJSON(w, http.StatusBadRequest, oauth.ErrorResponse{
+				Error:            oauthErr.Code,
+				ErrorDescription: oauthErr.Description,
+			})
+			return false
+		}
+		respondWithOAuthError(w, err)
+		return false
+	}
+
+	return true
+}
+
+// authorize issues an authorization code for session and redirects to the client with it.
+func (h *OAuthHandler) authorize(w http.ResponseWriter, r *http.Request, authorizeRequest oauth.AuthorizeRequest, session sessions.Session) {
+	code, err := h.Service.Authorize(r.Context(), authorizeRequest, session)
+	if err != nil {
+		redirectWithOAuthError(w, r, authorizeRequest, err)
+		return
+	}
+
+	params := url.Values{"code": {code}}
+	if authorizeRequest.State != "" {
+		params.Set("state", authorizeRequest.State)
+	}
+	redirect(w, r, authorizeRequest.RedirectURI, params)
+}
+
+// authorizeRequestFrom returns the authorization request of the query, or form, params.
+func authorizeRequestFrom(params url.Values) oauth.AuthorizeRequest {
+	return oauth.AuthorizeRequest{
+		ResponseType:        params.Get("response_type"),
+		ClientID:            params.Get("client_id"),
+		RedirectURI:         params.Get("redirect_uri"),
+		Scope:               params.Get("scope"),
+		State:               params.Get("state"),
+		Nonce:               params.Get("nonce"),
+		CodeChallenge:       params.Get("code_challenge"),
+		CodeChallengeMethod: params.Get("code_challenge_method"),
+		Prompt:              params.Get("prompt"),
+	}
+}
+
+// respondWithOAuthError responds with err in the OAuth error format: 401 for client
+// authentication failures, 400 for other OAuth errors and 500 for anything else.
+func respondWithOAuthError(w http.ResponseWriter, err error) {
+	oauthErr, ok := err.(*oauth.Error)
+	if !ok {
+		oauthErr = _errServerError
+	}
+
+	status := http.StatusBadRequest
+	switch oauthErr {
+	case oauth.ErrInvalidClient:
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		status = http.StatusUnauthorized
+	case _errServerError:
+		status = http.StatusInternalServerError
+	}
+
+	gowebapp.RespondWithJSON(w, status, oauth.ErrorResponse{
+		Error:            oauthErr.Code,
+		ErrorDescription: oauthErr.Description,
+	})
+}
+
+// redirectWithOAuthError redirects the authorization request to the client with err as the
+// error query params.
+func redirectWithOAuthError(w http.ResponseWriter, r *http.Request, authorizeRequest oauth.AuthorizeRequest, err error) {
+	oauthErr, ok := err.(*oauth.Error)
+	if !ok {
+		oauthErr = _errServerError
+	}
+
+	params := url.Values{
+		"error":             {oauthErr.Code},
+		"error_description": {oauthErr.Description},
+	}
+	if authorizeRequest.State != "" {
+		params.Set("state", authorizeRequest.State)
+	}
+	redirect(w, r, authorizeRequest.RedirectURI, params)
+}
+
+// redirect redirects to redirectURI with params added to its query.
+func redirect(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	u.RawQuery = query.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// basicAuth returns the client credentials of the HTTP basic authentication of r, which are form
+// encoded (RFC 6749 section 2.3.1).
+func basicAuth(r *http.Request) (string, string, bool) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return "", "", false
+	}
+
+	clientID, err := url.QueryUnescape(username)
+	if err != nil {
+		return "", "", false
+	}
+	secret, err := url.QueryUnescape(password)
+	if err != nil {
+		return "", "", false
+	}
+
+	return clientID, secret, true
+}
+
+// activeUser reports whether the user with id exists and is active, so tokens aren't issued to
+// deleted or suspended users while the revocation of their sessions is pending.
+func activeUser(ctx context.Context, getter UserGetter, id int) (bool, error) {
+	user, err := getter.Get(ctx, id)
+	if err != nil {
+		if err == users.ErrUserNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return user.Status == users.StatusActive, nil
+}
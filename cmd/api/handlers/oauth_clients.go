@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/marcosstupnicki/go-users/internal/oauth"
+	"github.com/marcosstupnicki/go-users/internal/rbac"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+)
+
+type OAuthClientService interface {
+	Create(ctx context.Context, client oauth.Client) (oauth.Client, string, error)
+	Get(ctx context.Context, id int) (oauth.Client, error)
+	List(ctx context.Context) ([]oauth.Client, error)
+	Delete(ctx context.Context, id int) error
+}
+
+// OAuthClientHandler manages the registry of OAuth clients. Every endpoint requires the
+// users:admin permission.
+type OAuthClientHandler struct {
+	Service    OAuthClientService
+	Authorizer Authorizer
+}
+
+func NewOAuthClientHandler(service OAuthClientService, authorizer Authorizer) OAuthClientHandler {
+	return OAuthClientHandler{
+		Service:    service,
+		Authorizer: authorizer,
+	}
+}
+
+func (h *OAuthClientHandler) Create(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authorizer, rbac.PermissionUsersAdmin) {
+		return
+	}
+
+	var clientRequest oauth.ClientRequest
+	err := json.NewDecoder(r.Body).Decode(&clientRequest)
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageCouldNotDecodeInput)
+		return
+	}
+
+	client, secret, err := h.Service.Create(r.Context(), oauth.Client{
+		Name:         clientRequest.Name,
+		RedirectURIs: strings.Join(clientRequest.RedirectURIs, " "),
+		Public:       clientRequest.Public,
+	})
+	if err != nil {
+		respondWithOAuthClientError(w, err)
+		return
+	}
+
+	// The secret is only returned on creation, only its hash is stored.
+	response := buildClientResponse(client)
+	response.ClientSecret = secret
+	gowebapp.RespondWithJSON(w, http.StatusCreated, response)
+	return
+}
+
+func (h *OAuthClientHandler) List(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authorizer, rbac.PermissionUsersAdmin) {
+		return
+	}
+
+	clients, err := h.Service.List(r.Context())
+	if err != nil {
+		respondWithOAuthClientError(w, err)
+		return
+	}
+
+	response := make([]oauth.ClientResponse, 0, len(clients))
+	for _, client := range clients {
+		response = append(response, buildClientResponse(client))
+	}
+	gowebapp.RespondWithJSON(w, http.StatusOK, response)
+	return
+}
+
+func (h *OAuthClientHandler) Get(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authorizer, rbac.PermissionUsersAdmin) {
+		return
+	}
+
+	id, err := strconv.Atoi(gowebapp.URLParam(r, "id"))
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageInvalidIDParam)
+		return
+	}
+
+	client, err := h.Service.Get(r.Context(), id)
+	if err != nil {
+		respondWithOAuthClientError(w, err)
+		return
+	}
+
+	gowebapp.RespondWithJSON(w, http.StatusOK, buildClientResponse(client))
+	return
+}
+
+func (h *OAuthClientHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authorizer, rbac.PermissionUsersAdmin) {
+		return
+	}
+
+	id, err := strconv.Atoi(gowebapp.URLParam(r, "id"))
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageInvalidIDParam)
+		return
+	}
+
+	err = h.Service.Delete(r.Context(), id)
+	if err != nil {
+		respondWithOAuthClientError(w, err)
+		return
+	}
+
+	gowebapp.RespondWithJSON(w, http.StatusNoContent, nil)
+	return
+}
+
+func respondWithOAuthClientError(w http.ResponseWriter, err error) {
+	switch err {
+	case oauth.ErrClientNotFound:
+		gowebapp.RespondWithError(w, http.StatusNotFound, err.Error())
+	case oauth.ErrInvalidClientName, oauth.ErrInvalidRedirectURIs:
+		gowebapp.RespondWithError(w, http.StatusBadRequest, err.Error())
+	default:
+		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+	}
+}
+
+func buildClientResponse(client oauth.Client) oauth.ClientResponse {
+	return oauth.ClientResponse{
+		ID:           client.ID,
+		ClientID:     client.ClientID,
+		Name:         client.Name,
+		RedirectURIs: client.RedirectURIList(),
+		Public:       client.Public,
+		CreatedAt:    client.CreatedAt,
+	}
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/marcosstupnicki/go-users/internal/oauth"
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type OAuthClientServiceMock struct {
+	mock.Mock
+}
+
+func (s *OAuthClientServiceMock) Create(_ context.Context, client oauth.Client) (oauth.Client, string, error) {
+	args := s.Called(client)
+	return args.Get(0).(oauth.Client), args.String(1), args.Error(2)
+}
+
+func (s *OAuthClientServiceMock) Get(_ context.Context, _ int) (oauth.Client, error) {
+	args := s.Called()
+	return args.Get(0).(oauth.Client), args.Error(1)
+}
+
+func (s *OAuthClientServiceMock) List(_ context.Context) ([]oauth.Client, error) {
+	args := s.Called()
+	return args.Get(0).([]oauth.Client), args.Error(1)
+}
+
+func (s *OAuthClientServiceMock) Delete(_ context.Context, _ int) error {
+	args := s.Called()
+	return args.Error(0)
+}
+
+func TestOAuthClientHandler_Create(t *testing.T) {
+	var tests = []struct {
+		name               string
+		service            *OAuthClientServiceMock
+		authorizer         AuthorizerMock
+		request            string
+		expectedResponse   string
+		expectedStatusCode int
+	}{
+		{
+			name: "Ok - Create client, secret returned",
+			service: func() *OAuthClientServiceMock {
+				m := OAuthClientServiceMock{}
+				m.On("Create", oauth.Client{Name: "Web", RedirectURIs: "https://app.example.com/cb http://localhost:3000/cb"}).
+					Return(oauth.Client{ID: 1, ClientID: "some-client", Name: "Web", RedirectURIs: "https://app.example.com/cb http://localhost:3000/cb"}, "some-secret", nil)
+				return &m
+			}(),
+			request:            `{"name":"Web","redirect_uris":["https://app.example.com/cb","http://localhost:3000/cb"]}`,
+			expectedResponse:   `{"id":1,"client_id":"some-client","name":"Web","redirect_uris":["https://app.example.com/cb","http://localhost:3000/cb"],"public":false,"client_secret":"some-secret","created_at":0}`,
+			expectedStatusCode: http.StatusCreated,
+		},
+		{
+			name: "Fail - Invalid redirect uris",
+			service: func() *OAuthClientServiceMock {
+				m := OAuthClientServiceMock{}
+				m.On("Create", mock.Anything).Return(oauth.Client{}, "", oauth.ErrInvalidRedirectURIs)
+				return &m
+			}(),
+			request:            `{"name":"Web","redirect_uris":["http://app.example.com/cb"]}`,
+			expectedResponse:   `{"message":"invalid redirect uris. at least one absolute uri without fragment is required, and http is only allowed for localhost"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Fail - Bad request",
+			request:            "request_invalid",
+			expectedResponse:   `{"message":"could not decode value from input"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Fail - Not an admin",
+			authorizer:         AuthorizerMock{err: auth.ErrForbidden},
+			request:            `{"name":"Web","redirect_uris":["https://app.example.com/cb"]}`,
+			expectedResponse:   `{"message":"permission denied"}`,
+			expectedStatusCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := gowebapp.NewWebApp("local")
+			handler := NewOAuthClientHandler(tt.service, tt.authorizer)
+			app.Post("/oauth/clients", handler.Create)
+
+			r := httptest.NewRequest(http.MethodPost, "/oauth/clients", bytes.NewReader([]byte(tt.request)))
+
+			rr := httptest.NewRecorder()
+			app.Router.ServeHTTP(rr, r)
+
+			res := rr.Result()
+			resBody, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expectedStatusCode, res.StatusCode)
+			require.Equal(t, tt.expectedResponse, string(resBody))
+		})
+	}
+}
+
+func TestOAuthClientHandler_List(t *testing.T) {
+	service := OAuthClientServiceMock{}
+	service.On("List").Return([]oauth.Client{
+		{ID: 2, ClientID: "public", Name: "Mobile", RedirectURIs: "com.example.app:/cb", Public: true, CreatedAt: 123456},
+	}, nil)
+
+	app := gowebapp.NewWebApp("local")
+	handler := NewOAuthClientHandler(&service, AuthorizerMock{})
+	app.Get("/oauth/clients", handler.List)
+
+	r := httptest.NewRequest(http.MethodGet, "/oauth/clients", nil)
+	rr := httptest.NewRecorder()
+	app.Router.ServeHTTP(rr, r)
+
+	res := rr.Result()
+	resBody, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, `[{"id":2,"client_id":"public","name":"Mobile","redirect_uris":["com.example.app:/cb"],"public":true,"created_at":123456}]`, string(resBody))
+}
+
+func TestOAuthClientHandler_Delete(t *testing.T) {
+	var tests = []struct {
+		name               string
+		service            *OAuthClientServiceMock
+		id                 string
+		expectedStatusCode int
+	}{
+		{
+			name: "Ok",
+			service: func() *OAuthClientServiceMock {
+				m := OAuthClientServiceMock{}
+				m.On("Delete").Return(nil)
+				return &m
+			}(),
+			id:                 "1",
+			expectedStatusCode: http.StatusNoContent,
+		},
+		{
+			name: "Fail - Client not found",
+			service: func() *OAuthClientServiceMock {
+				m := OAuthClientServiceMock{}
+				m.On("Delete").Return(oauth.ErrClientNotFound)
+				return &m
+			}(),
+			id:                 "9",
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "Fail - Invalid id",
+			id:                 "abc",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := gowebapp.NewWebApp("local")
+			handler := NewOAuthClientHandler(tt.service, AuthorizerMock{})
+			app.Delete("/oauth/clients/{id}", handler.Delete)
+
+			r := httptest.NewRequest(http.MethodDelete, "/oauth/clients/"+tt.id, nil)
+			rr := httptest.NewRecorder()
+			app.Router.ServeHTTP(rr, r)
+
+			require.Equal(t, tt.expectedStatusCode, rr.Result().StatusCode)
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"html/template"
+	"net/http"
+
+	"github.com/marcosstupnicki/go-users/internal/mfa"
+	"github.com/marcosstupnicki/go-users/internal/oauth"
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	"github.com/marcosstupnicki/go-users/internal/sessions"
+	"github.com/marcosstupnicki/go-users/internal/users"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+)
+
+const (
+	// _loginCookieName is the cookie keeping browsers logged in to the authorization endpoint.
+	_loginCookieName = "go_users_session"
+	// _csrfCookieName is the cookie the CSRF token of the login form must match.
+	_csrfCookieName = "go_users_csrf"
+	// _oauthCookiePath scopes the cookies to the OAuth endpoints.
+	_oauthCookiePath = "/oauth"
+	// _csrfTokenSize is the size in bytes of the random CSRF tokens.
+	_csrfTokenSize = 32
+
+	// _loginFormPolicy only lets the login form post to this service, and not be framed.
+	_loginFormPolicy = "default-src 'none'; form-action 'self'; frame-ancestors 'none'"
+
+	_errLoginFormExpired = "the login expired, please try again"
+)
+
+// _loginFormTemplate is the login form of the authorization endpoint. It posts the authorization
+// request back with the credentials, so the flow continues once the user is logged in.
+var _loginFormTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Log in</title>
+</head>
+<body>
+<form method="post">
+{{if .Error}}<p role="alert">{{.Error}}</p>
+{{end}}{{range .Params}}<input type="hidden" name="{{.Name}}" value="{{.Value}}">
+{{end}}<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+{{if .MFAToken}}<input type="hidden" name="mfa_token" value="{{.MFAToken}}">
+<label>Authentication or recovery code <input name="code" autocomplete="one-time-code" required autofocus></label>
+{{else}}<label>Email <input type="email" name="email" value="{{.Email}}" autocomplete="username" required autofocus></label>
+<label>Password <input type="password" name="password" autocomplete="current-password" required></label>
+{{end}}<button type="submit">Log in</button>
+</form>
+</body>
+</html>
+`))
+
+// loginForm is the state of the login form: the authorization request it continues, the email
+// and MFA token of the previous step, if any, and the error of the last attempt.
+type loginForm struct {
+	Request  oauth.AuthorizeRequest
+	Email    string
+	MFAToken string
+	Error    string
+}
+
+type formParam struct {
+	Name  string
+	Value string
+}
+
+// AuthorizeLogin handles the login form shown by Authorize: the password and, for users enrolled
+// in MFA, the code steps. Once logged in it starts a session, sets the login cookie keeping the
+// browser logged in to the authorization endpoint for Auth.BrowserSessionTTL, and continues the
+// authorization request.
+func (h *OAuthHandler) AuthorizeLogin(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageCouldNotDecodeInput)
+		return
+	}
+
+	authorizeRequest := authorizeRequestFrom(r.PostForm)
+	if !h.validateRedirect(w, r, authorizeRequest) {
+		return
+	}
+
+	form := loginForm{Request: authorizeRequest, Email: r.PostForm.Get("email")}
+	if !validCSRFToken(r) {
+		form.Error = _errLoginFormExpired
+		h.renderLoginForm(w, r, http.StatusForbidden, form)
+		return
+	}
+
+	steps := loginSteps{users: h.Logins, mfa: h.MFA, tokens: h.Tokens}
+	var user users.User
+	mfaToken := r.PostForm.Get("mfa_token")
+	mfaPassed := mfaToken != ""
+	if mfaPassed {
+		user, err = steps.secondFactor(r.Context(), mfaToken, r.PostForm.Get("code"))
+		if err == mfa.ErrInvalidCode {
+			// The MFA token may accept a few more codes.
+			form.MFAToken = mfaToken
+		}
+	} else {
+		user, mfaToken, _, err = steps.password(r.Context(), form.Email, r.PostForm.Get("password"))
+		if err == nil && mfaToken != "" {
+			form.MFAToken = mfaToken
+			h.renderLoginForm(w, r, http.StatusOK, form)
+			return
+		}
+	}
+	if err != nil {
+		status, expected := loginErrorStatus(err)
+		if !expected {
+			redirectWithOAuthError(w, r, authorizeRequest, _errServerError)
+			return
+		}
+		form.Error = err.Error()
+		if err == mfa.ErrChallengeInvalid || err == auth.ErrInvalidToken {
+			form.Error = _errLoginFormExpired
+		}
+		h.renderLoginForm(w, r, status, form)
+		return
+	}
+
+	session, _, err := h.Sessions.Start(r.Context(), user.ID, mfaPassed)
+	if err != nil {
+		redirectWithOAuthError(w, r, authorizeRequest, _errServerError)
+		return
+	}
+	token, expiresAt, err := h.Tokens.IssueBrowserSession(user.ID, session.ID)
+	if err != nil {
+		redirectWithOAuthError(w, r, authorizeRequest, _errServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     _loginCookieName,
+		Value:    token,
+		Path:     _oauthCookiePath,
+		Expires:  expiresAt,
+		Secure:   true,
+		HttpOnly: true,
+		// Lax, so the cookie is sent when clients redirect browsers to the authorization endpoint.
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	h.authorize(w, r, authorizeRequest, session)
+	return
+}
+
+// loginSession returns the session of the bearer token of r or, for browsers, of its login cookie,
+// and whether there's one still active.
+func (h *OAuthHandler) loginSession(r *http.Request) (sessions.Session, bool, error) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		cookie, err := r.Cookie(_loginCookieName)
+		if err != nil {
+			return sessions.Session{}, false, nil
+		}
+		principal, err = h.Tokens.ParseBrowserSession(cookie.Value)
+		if err != nil {
+			return sessions.Session{}, false, nil
+		}
+	}
+
+	session, err := h.Sessions.Get(r.Context(), principal.SessionID)
+	if err != nil {
+		if err == sessions.ErrSessionNotFound {
+			return sessions.Session{}, false, nil
+		}
+		return sessions.Session{}, false, err
+	}
+	if session.UserID != principal.UserID || session.RevokedAt != 0 {
+		return sessions.Session{}, false, nil
+	}
+
+	return session, true, nil
+}
+
+// renderLoginForm responds with form and status, with the CSRF token of the browser.
+func (h *OAuthHandler) renderLoginForm(w http.ResponseWriter, r *http.Request, status int, form loginForm) {
+	csrfToken, err := csrfToken(w, r)
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	var body bytes.Buffer
+	err = _loginFormTemplate.Execute(&body, struct {
+		loginForm
+		Params    []formParam
+		CSRFToken string
+	}{
+		loginForm: form,
+		Params:    authorizeParams(form.Request),
+		CSRFToken: csrfToken,
+	})
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Security-Policy", _loginFormPolicy)
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+	_, _ = w.Write(body.Bytes())
+}
+
+// csrfToken returns the CSRF token of the CSRF cookie of r, or a new one set on w. The login form
+// posts it back, and AuthorizeLogin only accepts forms matching the cookie, so other sites can't
+// log browsers in to an account of their choosing.
+func csrfToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	cookie, err := r.Cookie(_csrfCookieName)
+	if err == nil && cookie.Value != "" {
+		return cookie.Value, nil
+	}
+
+	raw := make([]byte, _csrfTokenSize)
+	_, err = rand.Read(raw)
+	if err != nil {
+		return "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	http.SetCookie(w, &http.Cookie{
+		Name:     _csrfCookieName,
+		Value:    token,
+		Path:     _oauthCookiePath,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	return token, nil
+}
+
+// validCSRFToken reports whether the CSRF token of the form of r matches its CSRF cookie.
+func validCSRFToken(r *http.Request) bool {
+	cookie, err := r.Cookie(_csrfCookieName)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.PostForm.Get("csrf_token"))) == 1
+}
+
+// authorizeParams returns the params of authorizeRequest set, for the hidden fields of the form.
+func authorizeParams(authorizeRequest oauth.AuthorizeRequest) []formParam {
+	all := []formParam{
+		{Name: "response_type", Value: authorizeRequest.ResponseType},
+		{Name: "client_id", Value: authorizeRequest.ClientID},
+		{Name: "redirect_uri", Value: authorizeRequest.RedirectURI},
+		{Name: "scope", Value: authorizeRequest.Scope},
+		{Name: "state", Value: authorizeRequest.State},
+		{Name: "nonce", Value: authorizeRequest.Nonce},
+		{Name: "code_challenge", Value: authorizeRequest.CodeChallenge},
+		{Name: "code_challenge_method", Value: authorizeRequest.CodeChallengeMethod},
+	}
+
+	params := make([]formParam, 0, len(all))
+	for _, param := range all {
+		if param.Value != "" {
+			params = append(params, param)
+		}
+	}
+
+	return params
+}
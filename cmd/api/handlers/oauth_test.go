@@ -0,0 +1,558 @@
+package handlers
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcosstupnicki/go-users/internal/oauth"
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	"github.com/marcosstupnicki/go-users/internal/sessions"
+	"github.com/marcosstupnicki/go-users/internal/users"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// OAuthServiceMock knows the client "some-client", with redirect URI https://app.example.com/cb
+// and secret "some-secret".
+type OAuthServiceMock struct {
+	mock.Mock
+}
+
+func (s *OAuthServiceMock) ValidateRedirect(_ context.Context, clientID string, redirectURI string) error {
+	if clientID != "some-client" {
+		return oauth.ErrInvalidClient
+	}
+	if redirectURI != "https://app.example.com/cb" {
+		return oauth.ErrInvalidRedirectURI
+	}
+	return nil
+}
+
+func (s *OAuthServiceMock) Authorize(_ context.Context, request oauth.AuthorizeRequest, session sessions.Session) (string, error) {
+	args := s.Called(request.Scope, session.ID)
+	return args.String(0), args.Error(1)
+}
+
+func (s *OAuthServiceMock) Exchange(_ context.Context, request oauth.TokenRequest) (oauth.AuthorizationCode, error) {
+	args := s.Called(request)
+	return args.Get(0).(oauth.AuthorizationCode), args.Error(1)
+}
+
+func (s *OAuthServiceMock) AuthenticateClient(_ context.Context, clientID string, secret string) (oauth.Client, error) {
+	if clientID != "some-client" || secret != "some-secret" {
+		return oauth.Client{}, oauth.ErrInvalidClient
+	}
+	return oauth.Client{ID: 1, ClientID: clientID}, nil
+}
+
+func (s *OAuthServiceMock) IDToken(code oauth.AuthorizationCode, user users.User) (string, error) {
+	return "some-id-token", nil
+}
+
+func (s *OAuthServiceMock) UserInfo(user users.User) oauth.UserInfo {
+	return oauth.UserInfo{Subject: "5", Claims: oauth.Claims{Email: user.Email}}
+}
+
+func (s *OAuthServiceMock) Discovery() oauth.Discovery {
+	return oauth.Discovery{Issuer: "https://id.example.com"}
+}
+
+func TestOAuthHandler_Discovery(t *testing.T) {
+	app := gowebapp.NewWebApp("local")
+	handler := NewOAuthHandler(&OAuthServiceMock{}, SessionServiceMock{}, nil, nil, nil, nil, nil, nil)
+	app.Get("/.well-known/openid-configuration", handler.Discovery)
+
+	r := httptest.NewRequest(http.MethodGet, "/.well-known/openid-configuration", nil)
+	rr := httptest.NewRecorder()
+	app.Router.ServeHTTP(rr, r)
+
+	res := rr.Result()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "public, max-age=300", res.Header.Get("Cache-Control"))
+}
+
+func TestOAuthHandler_Authorize(t *testing.T) {
+	var tests = []struct {
+		name               string
+		query              string
+		principal          *auth.Principal
+		cookie             string
+		service            *OAuthServiceMock
+		expectedLocation   string
+		expectedResponse   string
+		expectedStatusCode int
+	}{
+		{
+			name:      "Ok - Code redirected with the state",
+			query:     "client_id=some-client&redirect_uri=https%3A%2F%2Fapp.example.com%2Fcb&scope=openid&state=some-state",
+			principal: &auth.Principal{UserID: 5, SessionID: 8},
+			service: func() *OAuthServiceMock {
+				m := OAuthServiceMock{}
+				m.On("Authorize", "openid", 8).Return("some-code", nil)
+				return &m
+			}(),
+			expectedLocation:   "https://app.example.com/cb?code=some-code&state=some-state",
+			expectedStatusCode: http.StatusFound,
+		},
+		{
+			name:      "Fail - Authorization error redirected",
+			query:     "client_id=some-client&redirect_uri=https%3A%2F%2Fapp.example.com%2Fcb&scope=admin&state=some-state",
+			principal: &auth.Principal{UserID: 5, SessionID: 8},
+			service: func() *OAuthServiceMock {
+				m := OAuthServiceMock{}
+				m.On("Authorize", "admin", 8).Return("", oauth.ErrInvalidScope)
+				return &m
+			}(),
+			expectedLocation:   "https://app.example.com/cb?error=invalid_scope&error_description=unknown+scope&state=some-state",
+			expectedStatusCode: http.StatusFound,
+		},
+		{
+			name:   "Ok - Code for the session of the login cookie",
+			query:  "client_id=some-client&redirect_uri=https%3A%2F%2Fapp.example.com%2Fcb&scope=openid",
+			cookie: "some-browser-session",
+			service: func() *OAuthServiceMock {
+				m := OAuthServiceMock{}
+				m.On("Authorize", "openid", 8).Return("some-code", nil)
+				return &m
+			}(),
+			expectedLocation:   "https://app.example.com/cb?code=some-code",
+			expectedStatusCode: http.StatusFound,
+		},
+		{
+			name:               "Ok - Login form for unauthenticated users",
+			query:              "client_id=some-client&redirect_uri=https%3A%2F%2Fapp.example.com%2Fcb",
+			service:            &OAuthServiceMock{},
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Ok - Login form for a login cookie of an ended session",
+			query:              "client_id=some-client&redirect_uri=https%3A%2F%2Fapp.example.com%2Fcb",
+			cookie:             "other-browser-session",
+			service:            &OAuthServiceMock{},
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Fail - Unauthenticated user without prompt",
+			query:              "client_id=some-client&redirect_uri=https%3A%2F%2Fapp.example.com%2Fcb&prompt=none",
+			service:            &OAuthServiceMock{},
+			expectedLocation:   "https://app.example.com/cb?error=login_required&error_description=the+user+is+not+logged+in",
+			expectedStatusCode: http.StatusFound,
+		},
+		{
+			name:               "Fail - Unregistered redirect uri not redirected",
+			query:              "client_id=some-client&redirect_uri=https%3A%2F%2Fevil.example.com%2Fcb",
+			principal:          &auth.Principal{UserID: 5, SessionID: 8},
+			service:            &OAuthServiceMock{},
+			expectedResponse:   `{"error":"invalid_request","error_description":"redirect_uri is not registered for the client"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Fail - Unknown client not redirected",
+			query:              "client_id=other-client&redirect_uri=https%3A%2F%2Fapp.example.com%2Fcb",
+			service:            &OAuthServiceMock{},
+			expectedResponse:   `{"error":"invalid_client","error_description":"unknown client or invalid client credentials"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := gowebapp.NewWebApp("local")
+			tokens := TokenIssuerMock{}
+			tokens.On("ParseBrowserSession", "some-browser-session").Return(auth.Principal{UserID: 5, SessionID: 8}, nil)
+			tokens.On("ParseBrowserSession", "other-browser-session").Return(auth.Principal{UserID: 5, SessionID: 7}, nil)
+			handler := NewOAuthHandler(tt.service, SessionServiceMock{}, nil, nil, nil, nil, &tokens, nil)
+			app.Get("/oauth/authorize", handler.Authorize)
+
+			r := httptest.NewRequest(http.MethodGet, "/oauth/authorize?response_type=code&"+tt.query, nil)
+			if tt.principal != nil {
+				r = r.WithContext(auth.WithPrincipal(r.Context(), *tt.principal))
+			}
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: "go_users_session", Value: tt.cookie})
+			}
+
+			rr := httptest.NewRecorder()
+			app.Router.ServeHTTP(rr, r)
+
+			res := rr.Result()
+			require.Equal(t, tt.expectedStatusCode, res.StatusCode)
+			require.Equal(t, tt.expectedLocation, res.Header.Get("Location"))
+			if tt.expectedStatusCode == http.StatusOK {
+				resBody, err := ioutil.ReadAll(res.Body)
+				require.NoError(t, err)
+				require.Equal(t, "text/html; charset=utf-8", res.Header.Get("Content-Type"))
+				require.Contains(t, string(resBody), `<input type="hidden" name="client_id" value="some-client">`)
+				require.Contains(t, string(resBody), `name="password"`)
+			}
+			if tt.expectedResponse != "" {
+				resBody, err := ioutil.ReadAll(res.Body)
+				require.NoError(t, err)
+				require.Equal(t, tt.expectedResponse, string(resBody))
+			}
+		})
+	}
+}
+
+func TestOAuthHandler_AuthorizeLogin(t *testing.T) {
+	authorizeParams := "response_type=code&client_id=some-client&redirect_uri=https%3A%2F%2Fapp.example.com%2Fcb&scope=openid&state=some-state"
+	challenge := auth.Challenge{ID: "some-challenge", UserID: 6, ExpiresAt: time.Now().Add(5 * time.Minute)}
+
+	var tests = []struct {
+		name               string
+		form               string
+		csrfCookie         string
+		users              *AuthenticatorMock
+		tokens             *TokenIssuerMock
+		service            *OAuthServiceMock
+		expectedLocation   string
+		expectedCookie     string
+		expectedBody       string
+		expectedStatusCode int
+	}{
+		{
+			name:       "Ok - Logged in with the password",
+			form:       authorizeParams + "&csrf_token=some-csrf&email=some%40email.com&password=some-password",
+			csrfCookie: "some-csrf",
+			users: func() *AuthenticatorMock {
+				m := AuthenticatorMock{}
+				m.On("Authenticate", "some@email.com", "some-password").Return(users.User{ID: 5}, nil)
+				return &m
+			}(),
+			tokens: func() *TokenIssuerMock {
+				m := TokenIssuerMock{}
+				m.On("IssueBrowserSession", 5, 9).Return("some-browser-session", time.Now().Add(8*time.Hour), nil)
+				return &m
+			}(),
+			service: func() *OAuthServiceMock {
+				m := OAuthServiceMock{}
+				m.On("Authorize", "openid", 9).Return("some-code", nil)
+				return &m
+			}(),
+			expectedLocation:   "https://app.example.com/cb?code=some-code&state=some-state",
+			expectedCookie:     "some-browser-session",
+			expectedStatusCode: http.StatusFound,
+		},
+		{
+			name:       "Ok - Code asked for users enrolled in MFA",
+			form:       authorizeParams + "&csrf_token=some-csrf&email=other%40email.com&password=some-password",
+			csrfCookie: "some-csrf",
+			users: func() *AuthenticatorMock {
+				m := AuthenticatorMock{}
+				m.On("Authenticate", "other@email.com", "some-password").Return(users.User{ID: 6}, nil)
+				return &m
+			}(),
+			tokens: func() *TokenIssuerMock {
+				m := TokenIssuerMock{}
+				m.On("IssueChallenge", 6).Return("some-mfa-token", challenge, nil)
+				return &m
+			}(),
+			service:            &OAuthServiceMock{},
+			expectedBody:       `<input type="hidden" name="mfa_token" value="some-mfa-token">`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:       "Ok - Logged in with the MFA code",
+			form:       authorizeParams + "&csrf_token=some-csrf&mfa_token=some-mfa-token&code=123456",
+			csrfCookie: "some-csrf",
+			users: func() *AuthenticatorMock {
+				m := AuthenticatorMock{}
+				m.On("CheckLogin", 6).Return(users.User{ID: 6}, nil)
+				return &m
+			}(),
+			tokens: func() *TokenIssuerMock {
+				m := TokenIssuerMock{}
+				m.On("ParseChallenge", "some-mfa-token").Return(challenge, nil)
+				m.On("IssueBrowserSession", 6, 9).Return("some-browser-session", time.Now().Add(8*time.Hour), nil)
+				return &m
+			}(),
+			service: func() *OAuthServiceMock {
+				m := OAuthServiceMock{}
+				m.On("Authorize", "openid", 9).Return("some-code", nil)
+				return &m
+			}(),
+			expectedLocation:   "https://app.example.com/cb?code=some-code&state=some-state",
+			expectedCookie:     "some-browser-session",
+			expectedStatusCode: http.StatusFound,
+		},
+		{
+			name:       "Fail - Wrong MFA code",
+			form:       authorizeParams + "&csrf_token=some-csrf&mfa_token=some-mfa-token&code=654321",
+			csrfCookie: "some-csrf",
+			users: func() *AuthenticatorMock {
+				m := AuthenticatorMock{}
+				m.On("CheckLogin", 6).Return(users.User{ID: 6}, nil)
+				return &m
+			}(),
+			tokens: func() *TokenIssuerMock {
+				m := TokenIssuerMock{}
+				m.On("ParseChallenge", "some-mfa-token").Return(challenge, nil)
+				return &m
+			}(),
+			service:            &OAuthServiceMock{},
+			expectedBody:       `<p role="alert">invalid mfa code</p>`,
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "Fail - Wrong password",
+			form:       authorizeParams + "&csrf_token=some-csrf&email=some%40email.com&password=other-password",
+			csrfCookie: "some-csrf",
+			users: func() *AuthenticatorMock {
+				m := AuthenticatorMock{}
+				m.On("Authenticate", "some@email.com", "other-password").Return(users.User{}, users.ErrInvalidCredentials)
+				return &m
+			}(),
+			service:            &OAuthServiceMock{},
+			expectedBody:       `<p role="alert">invalid email or password</p>`,
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:               "Fail - CSRF token not matching the cookie",
+			form:               authorizeParams + "&csrf_token=other-csrf&email=some%40email.com&password=some-password",
+			csrfCookie:         "some-csrf",
+			users:              &AuthenticatorMock{},
+			service:            &OAuthServiceMock{},
+			expectedBody:       `<p role="alert">the login expired, please try again</p>`,
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			name:               "Fail - Unregistered redirect uri",
+			form:               "client_id=some-client&redirect_uri=https%3A%2F%2Fevil.example.com%2Fcb&csrf_token=some-csrf",
+			csrfCookie:         "some-csrf",
+			users:              &AuthenticatorMock{},
+			service:            &OAuthServiceMock{},
+			expectedBody:       `{"error":"invalid_request","error_description":"redirect_uri is not registered for the client"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := gowebapp.NewWebApp("local")
+			handler := NewOAuthHandler(tt.service, SessionServiceMock{}, nil, tt.users, MFAVerifierMock{codes: map[int]string{6: "123456"}}, nil, tt.tokens, nil)
+			app.Post("/oauth/authorize", handler.AuthorizeLogin)
+
+			r := httptest.NewRequest(http.MethodPost, "/oauth/authorize", strings.NewReader(tt.form))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.AddCookie(&http.Cookie{Name: "go_users_csrf", Value: tt.csrfCookie})
+
+			rr := httptest.NewRecorder()
+			app.Router.ServeHTTP(rr, r)
+
+			res := rr.Result()
+			resBody, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expectedStatusCode, res.StatusCode)
+			require.Equal(t, tt.expectedLocation, res.Header.Get("Location"))
+			require.Contains(t, string(resBody), tt.expectedBody)
+			var cookie string
+			for _, c := range res.Cookies() {
+				if c.Name == "go_users_session" {
+					cookie = c.Value
+					require.True(t, c.HttpOnly)
+					require.True(t, c.Secure)
+				}
+			}
+			require.Equal(t, tt.expectedCookie, cookie)
+		})
+	}
+}
+
+func TestOAuthHandler_Token(t *testing.T) {
+	var tests = []struct {
+		name               string
+		form               string
+		basicAuth          bool
+		userStatus         string
+		userErr            error
+		service            *OAuthServiceMock
+		tokens             *TokenIssuerMock
+		expectedResponse   string
+		expectedStatusCode int
+	}{
+		{
+			name:      "Ok - Authorization code exchanged",
+			form:      "grant_type=authorization_code&code=some-code&redirect_uri=https%3A%2F%2Fapp.example.com%2Fcb&code_verifier=some-verifier",
+			basicAuth: true,
+			service: func() *OAuthServiceMock {
+				m := OAuthServiceMock{}
+				m.On("Exchange", oauth.TokenRequest{
+					GrantType:    oauth.GrantTypeAuthorizationCode,
+					ClientID:     "some-client",
+					ClientSecret: "some-secret",
+					Code:         "some-code",
+					RedirectURI:  "https://app.example.com/cb",
+					CodeVerifier: "some-verifier",
+				}).Return(oauth.AuthorizationCode{ClientID: "some-client", UserID: 5, Scope: "openid email", MFA: true}, nil)
+				return &m
+			}(),
+			tokens: func() *TokenIssuerMock {
+				m := TokenIssuerMock{}
+				m.On("Issue", 5, 9, []string{"admin"}).Return("some-token", time.Now().Add(15*time.Minute), nil)
+				return &m
+			}(),
+			expectedResponse:   `{"access_token":"some-token","token_type":"Bearer","expires_in":900,"refresh_token":"some-refresh-token","id_token":"some-id-token","scope":"openid email"}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:       "Fail - Authorization code of a user no longer active",
+			form:       "grant_type=authorization_code&client_id=some-client&client_secret=some-secret&code=some-code",
+			userStatus: users.StatusSuspended,
+			service: func() *OAuthServiceMock {
+				m := OAuthServiceMock{}
+				m.On("Exchange", mock.Anything).Return(oauth.AuthorizationCode{ClientID: "some-client", UserID: 5, Scope: "openid"}, nil)
+				return &m
+			}(),
+			expectedResponse:   `{"error":"invalid_grant","error_description":"invalid, expired or used authorization code"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name: "Fail - Invalid authorization code",
+			form: "grant_type=authorization_code&client_id=some-client&client_secret=some-secret&code=other-code",
+			service: func() *OAuthServiceMock {
+				m := OAuthServiceMock{}
+				m.On("Exchange", mock.Anything).Return(oauth.AuthorizationCode{}, oauth.ErrInvalidGrant)
+				return &m
+			}(),
+			expectedResponse:   `{"error":"invalid_grant","error_description":"invalid, expired or used authorization code"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name: "Ok - Refresh token rotated",
+			form: "grant_type=refresh_token&client_id=some-client&client_secret=some-secret&refresh_token=client-refresh-token",
+			tokens: func() *TokenIssuerMock {
+				m := TokenIssuerMock{}
+				m.On("Issue", 5, 8, []string{}).Return("some-token", time.Now().Add(15*time.Minute), nil)
+				return &m
+			}(),
+			expectedResponse:   `{"access_token":"some-token","token_type":"Bearer","expires_in":900,"refresh_token":"other-refresh-token"}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Fail - Refresh token of a user no longer active",
+			form:               "grant_type=refresh_token&client_id=some-client&client_secret=some-secret&refresh_token=client-refresh-token",
+			userStatus:         users.StatusLocked,
+			expectedResponse:   `{"error":"invalid_grant","error_description":"invalid or expired refresh token"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Fail - Refresh token of a deleted user",
+			form:               "grant_type=refresh_token&client_id=some-client&client_secret=some-secret&refresh_token=client-refresh-token",
+			userErr:            users.ErrUserNotFound,
+			expectedResponse:   `{"error":"invalid_grant","error_description":"invalid or expired refresh token"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Fail - Invalid refresh token",
+			form:               "grant_type=refresh_token&client_id=some-client&client_secret=some-secret&refresh_token=other-refresh-token",
+			expectedResponse:   `{"error":"invalid_grant","error_description":"invalid or expired refresh token"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Fail - Refresh token of another client",
+			form:               "grant_type=refresh_token&client_id=some-client&client_secret=some-secret&refresh_token=some-refresh-token",
+			expectedResponse:   `{"error":"invalid_grant","error_description":"invalid or expired refresh token"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Fail - Invalid client credentials",
+			form:               "grant_type=refresh_token&client_id=some-client&client_secret=other-secret&refresh_token=some-refresh-token",
+			expectedResponse:   `{"error":"invalid_client","error_description":"unknown client or invalid client credentials"}`,
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:               "Fail - Unsupported grant type",
+			form:               "grant_type=password&username=some@email.com&password=some-password",
+			expectedResponse:   `{"error":"unsupported_grant_type","error_description":"only the authorization_code and refresh_token grant types are supported"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := gowebapp.NewWebApp("local")
+			service := tt.service
+			if service == nil {
+				service = &OAuthServiceMock{}
+			}
+			status := tt.userStatus
+			if status == "" {
+				status = users.StatusActive
+			}
+			userService := ServiceMock{}
+			userService.On("Get").Return(users.User{ID: 5, Status: status}, tt.userErr)
+			handler := NewOAuthHandler(service, SessionServiceMock{}, &userService, nil, nil, RoleNamesListerMock{roles: []string{"admin"}}, tt.tokens, []string{"admin"})
+			app.Post("/oauth/token", handler.Token)
+
+			r := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(tt.form))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.basicAuth {
+				r.SetBasicAuth("some-client", "some-secret")
+			}
+
+			rr := httptest.NewRecorder()
+			app.Router.ServeHTTP(rr, r)
+
+			res := rr.Result()
+			resBody, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expectedStatusCode, res.StatusCode)
+			require.Equal(t, "no-store", res.Header.Get("Cache-Control"))
+			require.Equal(t, tt.expectedResponse, string(resBody))
+		})
+	}
+}
+
+func TestOAuthHandler_UserInfo(t *testing.T) {
+	var tests = []struct {
+		name               string
+		principal          *auth.Principal
+		expectedResponse   string
+		expectedStatusCode int
+	}{
+		{
+			name:               "Ok",
+			principal:          &auth.Principal{UserID: 5, SessionID: 8},
+			expectedResponse:   `{"sub":"5","email":"some@email.com"}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Fail - Unauthenticated",
+			expectedResponse:   `{"message":"authentication required"}`,
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := gowebapp.NewWebApp("local")
+			userService := ServiceMock{}
+			userService.On("Get").Return(users.User{ID: 5, Email: "some@email.com"}, nil)
+			handler := NewOAuthHandler(&OAuthServiceMock{}, SessionServiceMock{}, &userService, nil, nil, nil, nil, nil)
+			app.Get("/oauth/userinfo", handler.UserInfo)
+
+			r := httptest.NewRequest(http.MethodGet, "/oauth/userinfo", nil)
+			if tt.principal != nil {
+				r = r.WithContext(auth.WithPrincipal(r.Context(), *tt.principal))
+			}
+
+			rr := httptest.NewRecorder()
+			app.Router.ServeHTTP(rr, r)
+
+			res := rr.Result()
+			resBody, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expectedStatusCode, res.StatusCode)
+			require.Equal(t, tt.expectedResponse, string(resBody))
+		})
+	}
+}
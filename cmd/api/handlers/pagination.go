@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+const _ErrorMessageInvalidPagination = "invalid pagination params. limit and offset must be non negative integers."
+
+var errInvalidPagination = errors.New("invalid pagination params")
+
+// paginationParams reads the limit and offset query params, capping limit at maxLimit.
+func paginationParams(r *http.Request, defaultLimit int, maxLimit int) (int, int, error) {
+	limit, offset := defaultLimit, 0
+
+	var err error
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return 0, 0, errInvalidPagination
+		}
+	}
+	if value := r.URL.Query().Get("offset"); value != "" {
+		offset, err = strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return 0, 0, errInvalidPagination
+		}
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	return limit, offset, nil
+}
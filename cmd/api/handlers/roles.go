@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/marcosstupnicki/go-users/internal/rbac"
+	"github.com/marcosstupnicki/go-users/internal/users"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+)
+
+const _ErrorMessageRoleNotFound = "role not found"
+
+type RoleService interface {
+	CreateRole(ctx context.Context, role rbac.Role) (rbac.Role, error)
+	GetRole(ctx context.Context, id int) (rbac.Role, error)
+	ListRoles(ctx context.Context) ([]rbac.Role, error)
+	UpdateRole(ctx context.Context, id int, role rbac.Role) (rbac.Role, error)
+	DeleteRole(ctx context.Context, id int) error
+	UserRoles(ctx context.Context, userID int) ([]rbac.Role, error)
+	AssignRole(ctx context.Context, userID int, roleName string) error
+	UnassignRole(ctx context.Context, userID int, roleName string) error
+}
+
+type UserGetter interface {
+	Get(ctx context.Context, id int) (users.User, error)
+}
+
+// RoleHandler manages roles and their assignment to users. Every endpoint requires the users:admin
+// permission.
+type RoleHandler struct {
+	Service    RoleService
+	Users      UserGetter
+	Authorizer Authorizer
+}
+
+func NewRoleHandler(service RoleService, users UserGetter, authorizer Authorizer) RoleHandler {
+	return RoleHandler{
+		Service:    service,
+		Users:      users,
+		Authorizer: authorizer,
+	}
+}
+
+func (h *RoleHandler) Create(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authorizer, rbac.PermissionUsersAdmin) {
+		return
+	}
+
+	var roleRequest rbac.RoleRequest
+	err := json.NewDecoder(r.Body).Decode(&roleRequest)
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageCouldNotDecodeInput)
+		return
+	}
+
+	role, err := h.Service.CreateRole(r.Context(), buildRoleFromRequest(roleRequest))
+	if err != nil {
+		respondWithRoleError(w, err)
+		return
+	}
+
+	gowebapp.RespondWithJSON(w, http.StatusCreated, buildRoleResponse(role))
+	return
+}
+
+func (h *RoleHandler) List(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authorizer, rbac.PermissionUsersAdmin) {
+		return
+	}
+
+	roles, err := h.Service.ListRoles(r.Context())
+	if err != nil {
+		respondWithRoleError(w, err)
+		return
+	}
+
+	gowebapp.RespondWithJSON(w, http.StatusOK, buildRoleResponses(roles))
+	return
+}
+
+func (h *RoleHandler) Get(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authorizer, rbac.PermissionUsersAdmin) {
+		return
+	}
+
+	id, err := strconv.Atoi(gowebapp.URLParam(r, "id"))
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageInvalidIDParam)
+		return
+	}
+
+	role, err := h.Service.GetRole(r.Context(), id)
+	if err != nil {
+		respondWithRoleError(w, err)
+		return
+	}
+
+	gowebapp.RespondWithJSON(w, http.StatusOK, buildRoleResponse(role))
+	return
+}
+
+// Update replaces the description and permissions of a role. Its name can't be changed, since
+// issued tokens refer to roles by name.
+func (h *RoleHandler) Update(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authorizer, rbac.PermissionUsersAdmin) {
+		return
+	}
+
+	id, err := strconv.Atoi(gowebapp.URLParam(r, "id"))
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageInvalidIDParam)
+		return
+	}
+
+	var roleRequest rbac.RoleRequest
+	err = json.NewDecoder(r.Body).Decode(&roleRequest)
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageCouldNotDecodeInput)
+		return
+	}
+
+	role, err := h.Service.UpdateRole(r.Context(), id, buildRoleFromRequest(roleRequest))
+	if err != nil {
+		respondWithRoleError(w, err)
+		return
+	}
+
+	gowebapp.RespondWithJSON(w, http.StatusOK, buildRoleResponse(role))
+	return
+}
+
+func (h *RoleHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authorizer, rbac.PermissionUsersAdmin) {
+		return
+	}
+
+	id, err := strconv.Atoi(gowebapp.URLParam(r, "id"))
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageInvalidIDParam)
+		return
+	}
+
+	err = h.Service.DeleteRole(r.Context(), id)
+	if err != nil {
+		respondWithRoleError(w, err)
+		return
+	}
+
+	gowebapp.RespondWithJSON(w, http.StatusNoContent, nil)
+	return
+}
+
+func (h *RoleHandler) UserRoles(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authorizer, rbac.PermissionUsersAdmin) {
+		return
+	}
+
+	userID, ok := h.userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	roles, err := h.Service.UserRoles(r.Context(), userID)
+	if err != nil {
+		respondWithRoleError(w, err)
+		return
+	}
+
+	gowebapp.RespondWithJSON(w, http.StatusOK, buildRoleResponses(roles))
+	return
+}
+
+func (h *RoleHandler) Assign(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authorizer, rbac.PermissionUsersAdmin) {
+		return
+	}
+
+	userID, ok := h.userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var assignRequest rbac.AssignRoleRequest
+	err := json.NewDecoder(r.Body).Decode(&assignRequest)
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageCouldNotDecodeInput)
+		return
+	}
+
+	err = h.Service.AssignRole(r.Context(), userID, assignRequest.Role)
+	if err != nil {
+		respondWithRoleError(w, err)
+		return
+	}
+
+	gowebapp.RespondWithJSON(w, http.StatusNoContent, nil)
+	return
+}
+
+func (h *RoleHandler) Unassign(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authorizer, rbac.PermissionUsersAdmin) {
+		return
+	}
+
+	userID, ok := h.userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.Service.UnassignRole(r.Context(), userID, gowebapp.URLParam(r, "role"))
+	if err != nil {
+		respondWithRoleError(w, err)
+		return
+	}
+
+	gowebapp.RespondWithJSON(w, http.StatusNoContent, nil)
+	return
+}
+
+// userIDParam parses the id URL param and checks the user exists, responding with an error if not.
+func (h *RoleHandler) userIDParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, err := strconv.Atoi(gowebapp.URLParam(r, "id"))
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageInvalidIDParam)
+		return 0, false
+	}
+
+	_, err = h.Users.Get(r.Context(), userID)
+	if err != nil {
+		if err == users.ErrUserNotFound {
+			gowebapp.RespondWithError(w, http.StatusNotFound, _ErrorMessageUserNotFound)
+			return 0, false
+		}
+		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return 0, false
+	}
+
+	return userID, true
+}
+
+func respondWithRoleError(w http.ResponseWriter, err error) {
+	switch err {
+	case rbac.ErrRoleNotFound:
+		gowebapp.RespondWithError(w, http.StatusNotFound, _ErrorMessageRoleNotFound)
+	case rbac.ErrRoleAlreadyExists:
+		gowebapp.RespondWithError(w, http.StatusConflict, err.Error())
+	case rbac.ErrInvalidRoleName, rbac.ErrUnknownPermission:
+		gowebapp.RespondWithError(w, http.StatusBadRequest, err.Error())
+	default:
+		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+	}
+}
+
+func buildRoleFromRequest(request rbac.RoleRequest) rbac.Role {
+	permissions := make([]rbac.Permission, 0, len(request.Permissions))
+	for _, name := range request.Permissions {
+		permissions = append(permissions, rbac.Permission{Name: name})
+	}
+
+	return rbac.Role{
+		Name:        request.Name,
+		Description: request.Description,
+		Permissions: permissions,
+	}
+}
+
+func buildRoleResponse(role rbac.Role) rbac.RoleResponse {
+	return rbac.RoleResponse{
+		ID:          role.ID,
+		Name:        role.Name,
+		Description: role.Description,
+		Permissions: role.PermissionNames(),
+		CreatedAt:   role.CreatedAt,
+		UpdatedAt:   role.UpdatedAt,
+	}
+}
+
+func buildRoleResponses(roles []rbac.Role) []rbac.RoleResponse {
+	response := make([]rbac.RoleResponse, 0, len(roles))
+	for _, role := range roles {
+		response = append(response, buildRoleResponse(role))
+	}
+
+	return response
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	"github.com/marcosstupnicki/go-users/internal/rbac"
+	"github.com/marcosstupnicki/go-users/internal/users"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type RoleServiceMock struct {
+	mock.Mock
+}
+
+func (s *RoleServiceMock) CreateRole(_ context.Context, role rbac.Role) (rbac.Role, error) {
+	args := s.Called(role)
+	return args.Get(0).(rbac.Role), args.Error(1)
+}
+
+func (s *RoleServiceMock) GetRole(_ context.Context, _ int) (rbac.Role, error) {
+	args := s.Called()
+	return args.Get(0).(rbac.Role), args.Error(1)
+}
+
+func (s *RoleServiceMock) ListRoles(_ context.Context) ([]rbac.Role, error) {
+	args := s.Called()
+	return args.Get(0).([]rbac.Role), args.Error(1)
+}
+
+func (s *RoleServiceMock) UpdateRole(_ context.Context, _ int, role rbac.Role) (rbac.Role, error) {
+	args := s.Called(role)
+	return args.Get(0).(rbac.Role), args.Error(1)
+}
+
+func (s *RoleServiceMock) DeleteRole(_ context.Context, _ int) error {
+	args := s.Called()
+	return args.Error(0)
+}
+
+func (s *RoleServiceMock) UserRoles(_ context.Context, _ int) ([]rbac.Role, error) {
+	args := s.Called()
+	return args.Get(0).([]rbac.Role), args.Error(1)
+}
+
+func (s *RoleServiceMock) AssignRole(_ context.Context, userID int, roleName string) error {
+	args := s.Called(userID, roleName)
+	return args.Error(0)
+}
+
+func (s *RoleServiceMock) UnassignRole(_ context.Context, userID int, roleName string) error {
+	args := s.Called(userID, roleName)
+	return args.Error(0)
+}
+
+func TestRoleHandler_Create(t *testing.T) {
+	role := rbac.Role{
+		ID:          2,
+		Name:        "support",
+		Permissions: []rbac.Permission{{ID: 1, Name: rbac.PermissionUsersRead}},
+	}
+
+	var tests = []struct {
+		name               string
+		service            *RoleServiceMock
+		authorizer         AuthorizerMock
+		request            string
+		expectedResponse   string
+		expectedStatusCode int
+	}{
+		{
+			name: "Ok - Create role",
+			service: func() *RoleServiceMock {
+				m := RoleServiceMock{}
+				m.On("CreateRole", rbac.Role{Name: "support", Permissions: []rbac.Permission{{Name: rbac.PermissionUsersRead}}}).
+					Return(role, nil)
+				return &m
+			}(),
+			request:            `{"name":"support","permissions":["users:read"]}`,
+			expectedResponse:   `{"id":2,"name":"support","permissions":["users:read"],"created_at":0,"updated_at":0}`,
+			expectedStatusCode: http.StatusCreated,
+		},
+		{
+			name: "Fail - Role already exists",
+			service: func() *RoleServiceMock {
+				m := RoleServiceMock{}
+				m.On("CreateRole", mock.Anything).Return(rbac.Role{}, rbac.ErrRoleAlreadyExists)
+				return &m
+			}(),
+			request:            `{"name":"support"}`,
+			expectedResponse:   `{"message":"role already exists"}`,
+			expectedStatusCode: http.StatusConflict,
+		},
+		{
+			name: "Fail - Unknown permission",
+			service: func() *RoleServiceMock {
+				m := RoleServiceMock{}
+				m.On("CreateRole", mock.Anything).Return(rbac.Role{}, rbac.ErrUnknownPermission)
+				return &m
+			}(),
+			request:            `{"name":"support","permissions":["users:fly"]}`,
+			expectedResponse:   `{"message":"unknown permission"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Fail - Forbidden",
+			authorizer:         AuthorizerMock{err: auth.ErrForbidden},
+			request:            `{"name":"support"}`,
+			expectedResponse:   `{"message":"permission denied"}`,
+			expectedStatusCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := gowebapp.NewWebApp("local")
+			handler := NewRoleHandler(tt.service, &ServiceMock{}, tt.authorizer)
+			app.Post("/roles", handler.Create)
+
+			r := httptest.NewRequest(http.MethodPost, "/roles", bytes.NewReader([]byte(tt.request)))
+
+			rr := httptest.NewRecorder()
+			app.Router.ServeHTTP(rr, r)
+
+			res := rr.Result()
+			resBody, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expectedStatusCode, res.StatusCode)
+			require.Equal(t, tt.expectedResponse, string(resBody))
+		})
+	}
+}
+
+func TestRoleHandler_Assign(t *testing.T) {
+	var tests = []struct {
+		name               string
+		service            *RoleServiceMock
+		users              *ServiceMock
+		request            string
+		expectedResponse   string
+		expectedStatusCode int
+	}{
+		{
+			name: "Ok - Assign role",
+			service: func() *RoleServiceMock {
+				m := RoleServiceMock{}
+				m.On("AssignRole", 5, "support").Return(nil)
+				return &m
+			}(),
+			users: func() *ServiceMock {
+				m := ServiceMock{}
+				m.On("Get", mock.Anything).Return(users.User{ID: 5}, nil)
+				return &m
+			}(),
+			request:            `{"role":"support"}`,
+			expectedStatusCode: http.StatusNoContent,
+		},
+		{
+			name: "Fail - User not found",
+			users: func() *ServiceMock {
+				m := ServiceMock{}
+				m.On("Get", mock.Anything).Return(users.User{}, users.ErrUserNotFound)
+				return &m
+			}(),
+			request:            `{"role":"support"}`,
+			expectedResponse:   `{"message":"user not found"}`,
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name: "Fail - Role not found",
+			service: func() *RoleServiceMock {
+				m := RoleServiceMock{}
+				m.On("AssignRole", 5, "pilot").Return(rbac.ErrRoleNotFound)
+				return &m
+			}(),
+			users: func() *ServiceMock {
+				m := ServiceMock{}
+				m.On("Get", mock.Anything).Return(users.User{ID: 5}, nil)
+				return &m
+			}(),
+			request:            `{"role":"pilot"}`,
+			expectedResponse:   `{"message":"role not found"}`,
+			expectedStatusCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := gowebapp.NewWebApp("local")
+			handler := NewRoleHandler(tt.service, tt.users, AuthorizerMock{})
+			app.Post("/users/{id}/roles", handler.Assign)
+
+			r := httptest.NewRequest(http.MethodPost, "/users/5/roles", bytes.NewReader([]byte(tt.request)))
+
+			rr := httptest.NewRecorder()
+			app.Router.ServeHTTP(rr, r)
+
+			res := rr.Result()
+			resBody, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expectedStatusCode, res.StatusCode)
+			require.Equal(t, tt.expectedResponse, string(resBody))
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	"github.com/marcosstupnicki/go-users/internal/rbac"
+	"github.com/marcosstupnicki/go-users/internal/sessions"
+	"github.com/marcosstupnicki/go-users/internal/users"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+)
+
+type SessionLister interface {
+	List(ctx context.Context, userID int) ([]sessions.Session, error)
+	Revoke(ctx context.Context, userID int, sessionID int) error
+	RevokeAll(ctx context.Context, userID int) error
+}
+
+// SessionHandler lists and revokes the login sessions of a user. Users can manage their own,
+// others require the users:admin permission.
+type SessionHandler struct {
+	Service    SessionLister
+	Users      UserGetter
+	Authorizer Authorizer
+}
+
+func NewSessionHandler(service SessionLister, users UserGetter, authorizer Authorizer) SessionHandler {
+	return SessionHandler{
+		Service:    service,
+		Users:      users,
+		Authorizer: authorizer,
+	}
+}
+
+// List returns the active sessions of a user, flagging the one of the request token.
+func (h *SessionHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	userSessions, err := h.Service.List(r.Context(), userID)
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	gowebapp.RespondWithJSON(w, http.StatusOK, buildSessionResponses(userSessions, principal.SessionID))
+	return
+}
+
+// RevokeAll revokes every session of a user, including the one of the request token.
+func (h *SessionHandler) RevokeAll(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.Service.RevokeAll(r.Context(), userID)
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	gowebapp.RespondWithJSON(w, http.StatusNoContent, nil)
+	return
+}
+
+// Revoke revokes one session of a user.
+func (h *SessionHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	sessionID, err := strconv.Atoi(gowebapp.URLParam(r, "session"))
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageInvalidIDParam)
+		return
+	}
+
+	err = h.Service.Revoke(r.Context(), userID, sessionID)
+	if err != nil {
+		if err == sessions.ErrSessionNotFound {
+			gowebapp.RespondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	gowebapp.RespondWithJSON(w, http.StatusNoContent, nil)
+	return
+}
+
+// userIDParam parses the id URL param, authorizes the caller on that user and checks it exists,
+// responding with an error if not.
+func (h *SessionHandler) userIDParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, err := strconv.Atoi(gowebapp.URLParam(r, "id"))
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageInvalidIDParam)
+		return 0, false
+	}
+
+	if !authorizeSelfOr(w, r, h.Authorizer, rbac.PermissionUsersAdmin, userID) {
+		return 0, false
+	}
+
+	_, err = h.Users.Get(r.Context(), userID)
+	if err != nil {
+		if err == users.ErrUserNotFound {
+			gowebapp.RespondWithError(w, http.StatusNotFound, _ErrorMessageUserNotFound)
+			return 0, false
+		}
+		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return 0, false
+	}
+
+	return userID, true
+}
+
+func buildSessionResponses(userSessions []sessions.Session, currentID int) []sessions.SessionResponse {
+	response := make([]sessions.SessionResponse, 0, len(userSessions))
+	for _, session := range userSessions {
+		response = append(response, sessions.SessionResponse{
+			ID:         session.ID,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			Current:    session.ID == currentID,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+		})
+	}
+
+	return response
+}
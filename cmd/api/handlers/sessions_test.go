@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	"github.com/marcosstupnicki/go-users/internal/sessions"
+	"github.com/marcosstupnicki/go-users/internal/users"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type SessionListerMock struct {
+	mock.Mock
+}
+
+func (s *SessionListerMock) List(_ context.Context, userID int) ([]sessions.Session, error) {
+	args := s.Called(userID)
+	return args.Get(0).([]sessions.Session), args.Error(1)
+}
+
+func (s *SessionListerMock) Revoke(_ context.Context, userID int, sessionID int) error {
+	args := s.Called(userID, sessionID)
+	return args.Error(0)
+}
+
+func (s *SessionListerMock) RevokeAll(_ context.Context, userID int) error {
+	args := s.Called(userID)
+	return args.Error(0)
+}
+
+func TestSessionHandler_List(t *testing.T) {
+	var tests = []struct {
+		name               string
+		principal          auth.Principal
+		authorizer         AuthorizerMock
+		users              *ServiceMock
+		service            *SessionListerMock
+		expectedResponse   string
+		expectedStatusCode int
+	}{
+		{
+			name:      "Ok - Own sessions",
+			principal: auth.Principal{UserID: 5, SessionID: 8},
+			users: func() *ServiceMock {
+				m := ServiceMock{}
+				m.On("Get").Return(users.User{ID: 5}, nil)
+				return &m
+			}(),
+			service: func() *SessionListerMock {
+				m := SessionListerMock{}
+				m.On("List", 5).Return([]sessions.Session{
+					{ID: 8, UserID: 5, UserAgent: "some-agent/1.0", IP: "203.0.113.9", CreatedAt: 1700000000, LastSeenAt: 1700000600},
+					{ID: 7, UserID: 5, CreatedAt: 1690000000, LastSeenAt: 1690000000},
+				}, nil)
+				return &m
+			}(),
+			authorizer:         AuthorizerMock{err: auth.ErrForbidden},
+			expectedResponse:   `[{"id":8,"user_agent":"some-agent/1.0","ip":"203.0.113.9","current":true,"created_at":1700000000,"last_seen_at":1700000600},{"id":7,"current":false,"created_at":1690000000,"last_seen_at":1690000000}]`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Fail - Sessions of another user",
+			principal:          auth.Principal{UserID: 6, SessionID: 9},
+			authorizer:         AuthorizerMock{err: auth.ErrForbidden},
+			expectedResponse:   `{"message":"permission denied"}`,
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			name:      "Fail - User not found",
+			principal: auth.Principal{UserID: 1, SessionID: 9},
+			users: func() *ServiceMock {
+				m := ServiceMock{}
+				m.On("Get").Return(users.User{}, users.ErrUserNotFound)
+				return &m
+			}(),
+			expectedResponse:   `{"message":"user not found"}`,
+			expectedStatusCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := gowebapp.NewWebApp("local")
+			handler := NewSessionHandler(tt.service, tt.users, tt.authorizer)
+			app.Get("/users/{id}/sessions", handler.List)
+
+			r := httptest.NewRequest(http.MethodGet, "/users/5/sessions", nil)
+			r = r.WithContext(auth.WithPrincipal(r.Context(), tt.principal))
+
+			rr := httptest.NewRecorder()
+			app.Router.ServeHTTP(rr, r)
+
+			res := rr.Result()
+			resBody, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expectedStatusCode, res.StatusCode)
+			require.Equal(t, tt.expectedResponse, string(resBody))
+		})
+	}
+}
+
+func TestSessionHandler_Revoke(t *testing.T) {
+	var tests = []struct {
+		name               string
+		path               string
+		service            *SessionListerMock
+		expectedStatusCode int
+	}{
+		{
+			name: "Ok - All",
+			path: "/users/5/sessions",
+			service: func() *SessionListerMock {
+				m := SessionListerMock{}
+				m.On("RevokeAll", 5).Return(nil)
+				return &m
+			}(),
+			expectedStatusCode: http.StatusNoContent,
+		},
+		{
+			name: "Ok - One",
+			path: "/users/5/sessions/8",
+			service: func() *SessionListerMock {
+				m := SessionListerMock{}
+				m.On("Revoke", 5, 8).Return(nil)
+				return &m
+			}(),
+			expectedStatusCode: http.StatusNoContent,
+		},
+		{
+			name: "Fail - Session not found",
+			path: "/users/5/sessions/9",
+			service: func() *SessionListerMock {
+				m := SessionListerMock{}
+				m.On("Revoke", 5, 9).Return(sessions.ErrSessionNotFound)
+				return &m
+			}(),
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "Fail - Invalid session id",
+			path:               "/users/5/sessions/abc",
+			service:            &SessionListerMock{},
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userGetter := &ServiceMock{}
+			userGetter.On("Get").Return(users.User{ID: 5}, nil)
+
+			app := gowebapp.NewWebApp("local")
+			handler := NewSessionHandler(tt.service, userGetter, AuthorizerMock{})
+			app.Delete("/users/{id}/sessions", handler.RevokeAll)
+			app.Delete("/users/{id}/sessions/{session}", handler.Revoke)
+
+			r := httptest.NewRequest(http.MethodDelete, tt.path, nil)
+
+			rr := httptest.NewRecorder()
+			app.Router.ServeHTTP(rr, r)
+
+			require.Equal(t, tt.expectedStatusCode, rr.Result().StatusCode)
+			tt.service.AssertExpectations(t)
+		})
+	}
+}
@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"github.com/marcosstupnicki/go-users/internal/rbac"
 	"github.com/marcosstupnicki/go-users/internal/users"
 	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
 )
@@ -13,22 +16,40 @@ const (
 	_ErrorMessageInvalidIDParam      = "invalid param ID. ID must be a integer."
 	_ErrorMessageCouldNotDecodeInput = "could not decode value from input"
 	_ErrorMessageUserNotFound        = "user not found"
+	_ErrorMessageUserAlreadyExists   = "user already exists"
+
+	_defaultAuditLimit = 50
+	_maxAuditLimit     = 200
+	_defaultListLimit  = 50
+	_maxListLimit      = 200
+	// Searches are ranked, clients rarely page far.
+	_defaultSearchLimit = 20
+	_maxSearchLimit     = 100
+
+	// _metadataFilterPrefix prefixes the query params filtering users by a metadata key.
+	_metadataFilterPrefix = "metadata."
 )
 
 type Service interface {
-	Create(user users.User) (users.User, error)
-	Get(id int) (users.User, error)
-	Update(id int, user users.User) (users.User, error)
-	Delete(id int) error
+	Create(ctx context.Context, user users.User) (users.User, error)
+	Get(ctx context.Context, id int) (users.User, error)
+	Update(ctx context.Context, id int, user users.User) (users.User, error)
+	Delete(ctx context.Context, id int) error
+	List(ctx context.Context, filter users.ListFilter, limit int, offset int) ([]users.User, error)
+	Search(ctx context.Context, query string, limit int, offset int) ([]users.User, error)
+	AuditLog(ctx context.Context, id int, limit int, offset int) ([]users.AuditEntry, error)
+	SetStatus(ctx context.Context, id int, status string, reason string) (users.User, error)
 }
 
 type UserHandler struct {
-	Service Service
+	Service    Service
+	Authorizer Authorizer
 }
 
-func NewHandler(service Service) UserHandler {
+func NewHandler(service Service, authorizer Authorizer) UserHandler {
 	return UserHandler{
-		Service: service,
+		Service:    service,
+		Authorizer: authorizer,
 	}
 }
 
@@ -42,8 +63,16 @@ func (h *UserHandler) Create(w http.ResponseWriter, r *http.Request) {
 
 	user := buildUserFromUserRequest(userRequest)
 
-	user, err = h.Service.Create(user)
+	user, err = h.Service.Create(r.Context(), user)
 	if err != nil {
+		if users.IsValidationError(err) {
+			gowebapp.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err == users.ErrUserAlreadyExists {
+			gowebapp.RespondWithError(w, http.StatusConflict, _ErrorMessageUserAlreadyExists)
+			return
+		}
 		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 		return
 	}
@@ -62,7 +91,11 @@ func (h *UserHandler) Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := h.Service.Get(id)
+	if !authorizeSelfOr(w, r, h.Authorizer, rbac.PermissionUsersRead, id) {
+		return
+	}
+
+	user, err := h.Service.Get(r.Context(), id)
 	if err != nil {
 		if err == users.ErrUserNotFound {
 			gowebapp.RespondWithError(w, http.StatusNotFound, _ErrorMessageUserNotFound)
@@ -87,6 +120,10 @@ func (h *UserHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !authorizeSelfOr(w, r, h.Authorizer, rbac.PermissionUsersWrite, id) {
+		return
+	}
+
 	var userRequest users.UserRequest
 	err = json.NewDecoder(r.Body).Decode(&userRequest)
 	if err != nil {
@@ -96,12 +133,24 @@ func (h *UserHandler) Update(w http.ResponseWriter, r *http.Request) {
 
 	user := buildUserFromUserRequest(userRequest)
 
-	user, err = h.Service.Update(id, user)
+	user, err = h.Service.Update(r.Context(), id, user)
 	if err != nil {
+		if users.IsValidationError(err) {
+			gowebapp.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		if err == users.ErrUserNotFound {
 			gowebapp.RespondWithError(w, http.StatusNotFound, _ErrorMessageUserNotFound)
 			return
 		}
+		if err == users.ErrUserAlreadyExists {
+			gowebapp.RespondWithError(w, http.StatusConflict, _ErrorMessageUserAlreadyExists)
+			return
+		}
+		if err == users.ErrConcurrentUpdate {
+			gowebapp.RespondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
 		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 		return
 	}
@@ -121,7 +170,11 @@ func (h *UserHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.Service.Delete(id)
+	if !authorize(w, r, h.Authorizer, rbac.PermissionUsersDelete) {
+		return
+	}
+
+	err = h.Service.Delete(r.Context(), id)
 	if err != nil {
 		if err == users.ErrUserNotFound {
 			gowebapp.RespondWithError(w, http.StatusNotFound, _ErrorMessageUserNotFound)
@@ -135,16 +188,198 @@ func (h *UserHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+// List returns the users, ordered by id. Users can be filtered by indexed metadata keys with
+// metadata.<namespace>.<key>=<value> query params, and paginated with the limit and offset ones.
+func (h *UserHandler) List(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authorizer, rbac.PermissionUsersRead) {
+		return
+	}
+
+	limit, offset, err := paginationParams(r, _defaultListLimit, _maxListLimit)
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageInvalidPagination)
+		return
+	}
+
+	result, err := h.Service.List(r.Context(), listFilter(r), limit, offset)
+	if err != nil {
+		if users.IsValidationError(err) {
+			gowebapp.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	response := make([]users.UserResponse, 0, len(result))
+	for _, user := range result {
+		response = append(response, buildUserResponseFromUser(user))
+	}
+	gowebapp.RespondWithJSON(w, http.StatusOK, response)
+	return
+}
+
+// Search finds users by partial email or names in the q query param, most relevant first, for
+// support staff. It requires the users:read permission, like List.
+func (h *UserHandler) Search(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authorizer, rbac.PermissionUsersRead) {
+		return
+	}
+
+	limit, offset, err := paginationParams(r, _defaultSearchLimit, _maxSearchLimit)
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageInvalidPagination)
+		return
+	}
+
+	result, err := h.Service.Search(r.Context(), r.URL.Query().Get("q"), limit, offset)
+	if err != nil {
+		if users.IsValidationError(err) {
+			gowebapp.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	response := make([]users.UserResponse, 0, len(result))
+	for _, user := range result {
+		response = append(response, buildUserResponseFromUser(user))
+	}
+	gowebapp.RespondWithJSON(w, http.StatusOK, response)
+	return
+}
+
+// listFilter returns the filter of the metadata.<namespace>.<key>=<value> query params of r.
+func listFilter(r *http.Request) users.ListFilter {
+	filter := users.ListFilter{Metadata: map[string]string{}}
+	for param, values := range r.URL.Query() {
+		if strings.HasPrefix(param, _metadataFilterPrefix) {
+			filter.Metadata[strings.TrimPrefix(param, _metadataFilterPrefix)] = values[0]
+		}
+	}
+
+	return filter
+}
+
+// SetStatus transitions the account status of a user, e.g. to suspend it. The reason is required.
+func (h *UserHandler) SetStatus(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authorizer, rbac.PermissionUsersAdmin) {
+		return
+	}
+
+	id, err := strconv.Atoi(gowebapp.URLParam(r, "id"))
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageInvalidIDParam)
+		return
+	}
+
+	var statusRequest users.StatusRequest
+	err = json.NewDecoder(r.Body).Decode(&statusRequest)
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageCouldNotDecodeInput)
+		return
+	}
+
+	user, err := h.Service.SetStatus(r.Context(), id, statusRequest.Status, statusRequest.Reason)
+	if err != nil {
+		if users.IsValidationError(err) {
+			gowebapp.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err == users.ErrUserNotFound {
+			gowebapp.RespondWithError(w, http.StatusNotFound, _ErrorMessageUserNotFound)
+			return
+		}
+		if err == users.ErrInvalidStatusTransition || err == users.ErrConcurrentUpdate {
+			gowebapp.RespondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
+		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	gowebapp.RespondWithJSON(w, http.StatusOK, buildUserResponseFromUser(user))
+	return
+}
+
+// AuditLog returns the audit log of a user, newest first. Paginated with the limit and offset
+// query params.
+func (h *UserHandler) AuditLog(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authorizer, rbac.PermissionUsersAdmin) {
+		return
+	}
+
+	id, err := strconv.Atoi(gowebapp.URLParam(r, "id"))
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageInvalidIDParam)
+		return
+	}
+
+	limit, offset, err := paginationParams(r, _defaultAuditLimit, _maxAuditLimit)
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageInvalidPagination)
+		return
+	}
+
+	entries, err := h.Service.AuditLog(r.Context(), id, limit, offset)
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	response := make([]users.AuditEntryResponse, 0, len(entries))
+	for _, entry := range entries {
+		response = append(response, buildAuditEntryResponse(entry))
+	}
+	gowebapp.RespondWithJSON(w, http.StatusOK, response)
+	return
+}
+
 func buildUserResponseFromUser(user users.User) users.UserResponse {
 	return users.UserResponse{
-		ID:    user.ID,
-		Email: user.Email,
+		ID:              user.ID,
+		Email:           user.Email,
+		Status:          user.Status,
+		StatusReason:    user.StatusReason,
+		StatusChangedAt: user.StatusChangedAt,
+		FailedLogins:    user.LoginFailures.FailedLogins,
+		LockedUntil:     user.LoginFailures.LockedUntil,
+		FirstName:       user.FirstName,
+		LastName:        user.LastName,
+		DisplayName:     user.DisplayName,
+		Locale:          user.Locale,
+		Timezone:        user.Timezone,
+		Phone:           user.Phone,
+		AvatarURL:       user.AvatarURL,
+		Metadata:        user.Metadata,
 	}
 }
 
 func buildUserFromUserRequest(user users.UserRequest) users.User {
 	return users.User{
-		Email:    user.Email,
-		Password: user.Password,
+		Email:       user.Email,
+		Password:    user.Password,
+		FirstName:   user.FirstName,
+		LastName:    user.LastName,
+		DisplayName: user.DisplayName,
+		Locale:      user.Locale,
+		Timezone:    user.Timezone,
+		Phone:       user.Phone,
+		AvatarURL:   user.AvatarURL,
+		Metadata:    user.Metadata,
+	}
+}
+
+func buildAuditEntryResponse(entry users.AuditEntry) users.AuditEntryResponse {
+	return users.AuditEntryResponse{
+		ID:        entry.ID,
+		UserID:    entry.UserID,
+		Actor:     entry.Actor,
+		Action:    entry.Action,
+		Changes:   json.RawMessage(entry.Changes),
+		RequestID: entry.RequestID,
+		IP:        entry.IP,
+		CreatedAt: entry.CreatedAt,
 	}
 }
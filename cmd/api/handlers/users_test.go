@@ -2,14 +2,17 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
 	"testing"
 
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
 	"github.com/marcosstupnicki/go-users/internal/users"
 	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
 	"github.com/stretchr/testify/mock"
@@ -20,26 +23,46 @@ type ServiceMock struct {
 	mock.Mock
 }
 
-func (s *ServiceMock) Create(_ users.User) (users.User, error) {
+func (s *ServiceMock) Create(_ context.Context, _ users.User) (users.User, error) {
 	args := s.Called()
 	return args.Get(0).(users.User), args.Error(1)
 }
 
-func (s *ServiceMock) Get(_ int) (users.User, error) {
+func (s *ServiceMock) Get(_ context.Context, _ int) (users.User, error) {
 	args := s.Called()
 	return args.Get(0).(users.User), args.Error(1)
 }
 
-func (s *ServiceMock) Update(_ int, _ users.User) (users.User, error) {
+func (s *ServiceMock) Update(_ context.Context, _ int, _ users.User) (users.User, error) {
 	args := s.Called()
 	return args.Get(0).(users.User), args.Error(1)
 }
 
-func (s *ServiceMock) Delete(_ int) error {
+func (s *ServiceMock) Delete(_ context.Context, _ int) error {
 	args := s.Called()
 	return args.Error(0)
 }
 
+func (s *ServiceMock) List(_ context.Context, filter users.ListFilter, limit int, offset int) ([]users.User, error) {
+	args := s.Called(filter, limit, offset)
+	return args.Get(0).([]users.User), args.Error(1)
+}
+
+func (s *ServiceMock) Search(_ context.Context, query string, limit int, offset int) ([]users.User, error) {
+	args := s.Called(query, limit, offset)
+	return args.Get(0).([]users.User), args.Error(1)
+}
+
+func (s *ServiceMock) AuditLog(_ context.Context, _ int, limit int, offset int) ([]users.AuditEntry, error) {
+	args := s.Called(limit, offset)
+	return args.Get(0).([]users.AuditEntry), args.Error(1)
+}
+
+func (s *ServiceMock) SetStatus(_ context.Context, _ int, status string, reason string) (users.User, error) {
+	args := s.Called(status, reason)
+	return args.Get(0).(users.User), args.Error(1)
+}
+
 var ErrInternalErr = errors.New("internal error")
 
 func TestUserHandler_Create(t *testing.T) {
@@ -90,12 +113,23 @@ func TestUserHandler_Create(t *testing.T) {
 			expectedResponse:   "{\"message\":\"Internal Server Error\"}",
 			expectedStatusCode: http.StatusInternalServerError,
 		},
+		{
+			name: "Fail - User already exists",
+			service: func() *ServiceMock {
+				m := ServiceMock{}
+				m.On("Create", mock.Anything).Return(users.User{}, users.ErrUserAlreadyExists)
+				return &m
+			}(),
+			request:            bytes.NewReader(requestOk),
+			expectedResponse:   "{\"message\":\"user already exists\"}",
+			expectedStatusCode: http.StatusConflict,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			app := gowebapp.NewWebApp("local")
-			handler := NewHandler(tt.service)
+			handler := NewHandler(tt.service, AuthorizerMock{})
 			app.Post("/users", handler.Create)
 
 			r := httptest.NewRequest(http.MethodPost, "/users", tt.request)
@@ -165,7 +199,7 @@ func TestUserHandler_Get(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			app := gowebapp.NewWebApp("local")
-			handler := NewHandler(tt.service)
+			handler := NewHandler(tt.service, AuthorizerMock{})
 			app.Get("/users/{id}", handler.Get)
 
 			r := httptest.NewRequest(http.MethodGet, "/users/"+strconv.Itoa(tt.id), nil)
@@ -216,6 +250,34 @@ func TestUserHandler_Update(t *testing.T) {
 			expectedResponse:   "{\"id\":5,\"email\":\"dummy@email.com\"}",
 			expectedStatusCode: http.StatusOK,
 		},
+		{
+			name: "Ok - Update profile success",
+			service: func() *ServiceMock {
+				m := ServiceMock{}
+				profile := user
+				profile.DisplayName = "Dummy"
+				profile.Locale = "en-US"
+				profile.Timezone = "America/Argentina/Buenos_Aires"
+				m.On("Update", mock.Anything).Return(profile, nil)
+				return &m
+			}(),
+			id:                 5,
+			request:            bytes.NewReader([]byte(`{"display_name":"Dummy","locale":"en-US","timezone":"America/Argentina/Buenos_Aires"}`)),
+			expectedResponse:   "{\"id\":5,\"email\":\"dummy@email.com\",\"display_name\":\"Dummy\",\"locale\":\"en-US\",\"timezone\":\"America/Argentina/Buenos_Aires\"}",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "Fail - Invalid profile field",
+			service: func() *ServiceMock {
+				m := ServiceMock{}
+				m.On("Update", mock.Anything).Return(users.User{}, users.ErrInvalidPhone)
+				return &m
+			}(),
+			id:                 5,
+			request:            bytes.NewReader([]byte(`{"phone":"1234"}`)),
+			expectedResponse:   "{\"message\":\"invalid phone. phone must be in E.164 format, e.g. +5491123456789\"}",
+			expectedStatusCode: http.StatusBadRequest,
+		},
 		{
 			name:               "Fail - Bad request",
 			id:                 5,
@@ -247,12 +309,36 @@ func TestUserHandler_Update(t *testing.T) {
 			expectedResponse:   "{\"message\":\"Internal Server Error\"}",
 			expectedStatusCode: http.StatusInternalServerError,
 		},
+		{
+			name: "Fail - User already exists",
+			service: func() *ServiceMock {
+				m := ServiceMock{}
+				m.On("Update", mock.Anything).Return(users.User{}, users.ErrUserAlreadyExists)
+				return &m
+			}(),
+			id:                 5,
+			request:            bytes.NewReader(requestOk),
+			expectedResponse:   "{\"message\":\"user already exists\"}",
+			expectedStatusCode: http.StatusConflict,
+		},
+		{
+			name: "Fail - Updated concurrently",
+			service: func() *ServiceMock {
+				m := ServiceMock{}
+				m.On("Update", mock.Anything).Return(users.User{}, users.ErrConcurrentUpdate)
+				return &m
+			}(),
+			id:                 5,
+			request:            bytes.NewReader(requestOk),
+			expectedResponse:   "{\"message\":\"user was modified concurrently\"}",
+			expectedStatusCode: http.StatusConflict,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			app := gowebapp.NewWebApp("local")
-			handler := NewHandler(tt.service)
+			handler := NewHandler(tt.service, AuthorizerMock{})
 			app.Put("/users/{id}", handler.Update)
 
 			r := httptest.NewRequest(http.MethodPut, "/users/"+strconv.Itoa(tt.id), tt.request)
@@ -315,7 +401,7 @@ func TestUserHandler_Delete(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			app := gowebapp.NewWebApp("local")
-			handler := NewHandler(tt.service)
+			handler := NewHandler(tt.service, AuthorizerMock{})
 			app.Delete("/users/{id}", handler.Delete)
 
 			r := httptest.NewRequest(http.MethodDelete, "/users/"+strconv.Itoa(tt.id), nil)
@@ -332,3 +418,329 @@ func TestUserHandler_Delete(t *testing.T) {
 		})
 	}
 }
+
+func TestUserHandler_AuditLog(t *testing.T) {
+	entry := users.AuditEntry{
+		ID:        3,
+		UserID:    5,
+		Actor:     "user:9",
+		Action:    users.AuditActionUpdate,
+		Changes:   `{"email":{"before":"dummy@email.com","after":"other@email.com"}}`,
+		RequestID: "host/abc-000001",
+		IP:        "203.0.113.9",
+		CreatedAt: 1651422724,
+	}
+
+	var tests = []struct {
+		name               string
+		service            *ServiceMock
+		query              string
+		expectedResponse   string
+		expectedStatusCode int
+	}{
+		{
+			name: "Ok - Default pagination",
+			service: func() *ServiceMock {
+				m := ServiceMock{}
+				m.On("AuditLog", 50, 0).Return([]users.AuditEntry{entry}, nil)
+				return &m
+			}(),
+			expectedResponse:   `[{"id":3,"user_id":5,"actor":"user:9","action":"update","changes":{"email":{"before":"dummy@email.com","after":"other@email.com"}},"request_id":"host/abc-000001","ip":"203.0.113.9","created_at":1651422724}]`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "Ok - Empty log",
+			service: func() *ServiceMock {
+				m := ServiceMock{}
+				m.On("AuditLog", 10, 20).Return([]users.AuditEntry{}, nil)
+				return &m
+			}(),
+			query:              "?limit=10&offset=20",
+			expectedResponse:   `[]`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Fail - Invalid pagination",
+			query:              "?offset=abc",
+			expectedResponse:   `{"message":"invalid pagination params. limit and offset must be non negative integers."}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name: "Fail - Internal error in user service",
+			service: func() *ServiceMock {
+				m := ServiceMock{}
+				m.On("AuditLog", 50, 0).Return([]users.AuditEntry{}, ErrInternalErr)
+				return &m
+			}(),
+			expectedResponse:   `{"message":"Internal Server Error"}`,
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := gowebapp.NewWebApp("local")
+			handler := NewHandler(tt.service, AuthorizerMock{})
+			app.Get("/users/{id}/audit", handler.AuditLog)
+
+			r := httptest.NewRequest(http.MethodGet, "/users/5/audit"+tt.query, nil)
+
+			rr := httptest.NewRecorder()
+			app.Router.ServeHTTP(rr, r)
+
+			res := rr.Result()
+			resBody, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expectedStatusCode, res.StatusCode)
+			require.Equal(t, tt.expectedResponse, string(resBody))
+		})
+	}
+}
+
+func TestUserHandler_List(t *testing.T) {
+	user := users.User{
+		ID:       5,
+		Email:    "dummy@email.com",
+		Password: "dummypassword",
+		Metadata: users.Metadata{"preferences": {"theme": "dark"}},
+	}
+
+	var tests = []struct {
+		name               string
+		service            *ServiceMock
+		query              string
+		expectedResponse   string
+		expectedStatusCode int
+	}{
+		{
+			name: "Ok - List users",
+			service: func() *ServiceMock {
+				m := ServiceMock{}
+				m.On("List", users.ListFilter{Metadata: map[string]string{}}, 50, 0).Return([]users.User{user}, nil)
+				return &m
+			}(),
+			expectedResponse:   `[{"id":5,"email":"dummy@email.com","metadata":{"preferences":{"theme":"dark"}}}]`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "Ok - Filter by metadata",
+			service: func() *ServiceMock {
+				m := ServiceMock{}
+				m.On("List", users.ListFilter{Metadata: map[string]string{"preferences.theme": "dark"}}, 10, 0).Return([]users.User{user}, nil)
+				return &m
+			}(),
+			query:              "?metadata.preferences.theme=dark&limit=10",
+			expectedResponse:   `[{"id":5,"email":"dummy@email.com","metadata":{"preferences":{"theme":"dark"}}}]`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "Fail - Metadata key not indexed",
+			service: func() *ServiceMock {
+				m := ServiceMock{}
+				m.On("List", mock.Anything, 50, 0).Return([]users.User{}, fmt.Errorf("%w %q", users.ErrMetadataKeyNotIndexed, "preferences.newsletter"))
+				return &m
+			}(),
+			query:              "?metadata.preferences.newsletter=true",
+			expectedResponse:   `{"message":"metadata key not indexed \"preferences.newsletter\""}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name: "Fail - Internal error in user service",
+			service: func() *ServiceMock {
+				m := ServiceMock{}
+				m.On("List", mock.Anything, 50, 0).Return([]users.User{}, ErrInternalErr)
+				return &m
+			}(),
+			expectedResponse:   `{"message":"Internal Server Error"}`,
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := gowebapp.NewWebApp("local")
+			handler := NewHandler(tt.service, AuthorizerMock{})
+			app.Get("/users", handler.List)
+
+			r := httptest.NewRequest(http.MethodGet, "/users"+tt.query, nil)
+
+			rr := httptest.NewRecorder()
+			app.Router.ServeHTTP(rr, r)
+
+			res := rr.Result()
+			resBody, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expectedStatusCode, res.StatusCode)
+			require.Equal(t, tt.expectedResponse, string(resBody))
+		})
+	}
+}
+
+func TestUserHandler_Search(t *testing.T) {
+	user := users.User{
+		ID:        5,
+		Email:     "john@email.com",
+		Password:  "dummypassword",
+		FirstName: "John",
+	}
+
+	var tests = []struct {
+		name               string
+		service            *ServiceMock
+		authorizer         AuthorizerMock
+		query              string
+		expectedResponse   string
+		expectedStatusCode int
+	}{
+		{
+			name: "Ok - Search users",
+			service: func() *ServiceMock {
+				m := ServiceMock{}
+				m.On("Search", "john", 20, 0).Return([]users.User{user}, nil)
+				return &m
+			}(),
+			query:              "?q=john",
+			expectedResponse:   `[{"id":5,"email":"john@email.com","first_name":"John"}]`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "Ok - Paginated, without results",
+			service: func() *ServiceMock {
+				m := ServiceMock{}
+				m.On("Search", "john doe", 10, 20).Return([]users.User{}, nil)
+				return &m
+			}(),
+			query:              "?q=john+doe&limit=10&offset=20",
+			expectedResponse:   `[]`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "Fail - Invalid query",
+			service: func() *ServiceMock {
+				m := ServiceMock{}
+				m.On("Search", "", 20, 0).Return([]users.User{}, users.ErrInvalidSearchQuery)
+				return &m
+			}(),
+			expectedResponse:   `{"message":"invalid search query. q must have letters or digits and be at most 100 characters"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Fail - Invalid pagination",
+			query:              "?q=john&limit=-1",
+			expectedResponse:   `{"message":"invalid pagination params. limit and offset must be non negative integers."}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Fail - Not allowed",
+			authorizer:         AuthorizerMock{err: auth.ErrForbidden},
+			query:              "?q=john",
+			expectedResponse:   `{"message":"permission denied"}`,
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			name: "Fail - Internal error in user service",
+			service: func() *ServiceMock {
+				m := ServiceMock{}
+				m.On("Search", "john", 20, 0).Return([]users.User{}, ErrInternalErr)
+				return &m
+			}(),
+			query:              "?q=john",
+			expectedResponse:   `{"message":"Internal Server Error"}`,
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := gowebapp.NewWebApp("local")
+			handler := NewHandler(tt.service, tt.authorizer)
+			app.Get("/users/search", handler.Search)
+
+			r := httptest.NewRequest(http.MethodGet, "/users/search"+tt.query, nil)
+
+			rr := httptest.NewRecorder()
+			app.Router.ServeHTTP(rr, r)
+
+			res := rr.Result()
+			resBody, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expectedStatusCode, res.StatusCode)
+			require.Equal(t, tt.expectedResponse, string(resBody))
+		})
+	}
+}
+
+func TestUserHandler_SetStatus(t *testing.T) {
+	user := users.User{
+		ID:              5,
+		Email:           "dummy@email.com",
+		Status:          users.StatusSuspended,
+		StatusReason:    "spam",
+		StatusChangedAt: 1651422724,
+	}
+
+	var tests = []struct {
+		name               string
+		service            *ServiceMock
+		request            string
+		expectedResponse   string
+		expectedStatusCode int
+	}{
+		{
+			name: "Ok - Suspend user",
+			service: func() *ServiceMock {
+				m := ServiceMock{}
+				m.On("SetStatus", users.StatusSuspended, "spam").Return(user, nil)
+				return &m
+			}(),
+			request:            `{"status":"suspended","reason":"spam"}`,
+			expectedResponse:   `{"id":5,"email":"dummy@email.com","status":"suspended","status_reason":"spam","status_changed_at":1651422724}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "Fail - Invalid transition",
+			service: func() *ServiceMock {
+				m := ServiceMock{}
+				m.On("SetStatus", users.StatusPending, "spam").Return(users.User{}, users.ErrInvalidStatusTransition)
+				return &m
+			}(),
+			request:            `{"status":"pending","reason":"spam"}`,
+			expectedResponse:   `{"message":"invalid status transition"}`,
+			expectedStatusCode: http.StatusConflict,
+		},
+		{
+			name: "Fail - Missing reason",
+			service: func() *ServiceMock {
+				m := ServiceMock{}
+				m.On("SetStatus", users.StatusSuspended, "").Return(users.User{}, users.ErrInvalidStatusReason)
+				return &m
+			}(),
+			request:            `{"status":"suspended"}`,
+			expectedResponse:   `{"message":"invalid reason. reason is required, up to 255 characters"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := gowebapp.NewWebApp("local")
+			handler := NewHandler(tt.service, AuthorizerMock{})
+			app.Put("/users/{id}/status", handler.SetStatus)
+
+			r := httptest.NewRequest(http.MethodPut, "/users/5/status", bytes.NewReader([]byte(tt.request)))
+
+			rr := httptest.NewRecorder()
+			app.Router.ServeHTTP(rr, r)
+
+			res := rr.Result()
+			resBody, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expectedStatusCode, res.StatusCode)
+			require.Equal(t, tt.expectedResponse, string(resBody))
+		})
+	}
+}
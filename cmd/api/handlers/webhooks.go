@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/marcosstupnicki/go-users/internal/rbac"
+	"github.com/marcosstupnicki/go-users/internal/webhooks"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+)
+
+const (
+	_ErrorMessageSubscriptionNotFound = "webhook subscription not found"
+
+	_defaultDeliveriesLimit = 50
+	_maxDeliveriesLimit     = 200
+)
+
+type WebhookService interface {
+	Create(ctx context.Context, subscription webhooks.Subscription) (webhooks.Subscription, error)
+	Get(ctx context.Context, id int) (webhooks.Subscription, error)
+	List(ctx context.Context) ([]webhooks.Subscription, error)
+	Update(ctx context.Context, id int, subscription webhooks.Subscription) (webhooks.Subscription, error)
+	Delete(ctx context.Context, id int) error
+	Deliveries(ctx context.Context, subscriptionID int, limit int, offset int) ([]webhooks.Delivery, error)
+}
+
+// WebhookHandler manages the webhook subscriptions. Every endpoint requires the users:admin
+// permission.
+type WebhookHandler struct {
+	Service    WebhookService
+	Authorizer Authorizer
+}
+
+func NewWebhookHandler(service WebhookService, authorizer Authorizer) WebhookHandler {
+	return WebhookHandler{
+		Service:    service,
+		Authorizer: authorizer,
+	}
+}
+
+func (h *WebhookHandler) Create(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authorizer, rbac.PermissionUsersAdmin) {
+		return
+	}
+
+	var subscriptionRequest webhooks.SubscriptionRequest
+	err := json.NewDecoder(r.Body).Decode(&subscriptionRequest)
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageCouldNotDecodeInput)
+		return
+	}
+
+	subscription, err := h.Service.Create(r.Context(), buildSubscriptionFromRequest(subscriptionRequest))
+	if err != nil {
+		respondWithWebhookError(w, err)
+		return
+	}
+
+	// The secret is only returned on creation, receivers need it to verify signatures.
+	response := buildSubscriptionResponse(subscription)
+	response.Secret = subscription.Secret
+	gowebapp.RespondWithJSON(w, http.StatusCreated, response)
+	return
+}
+
+func (h *WebhookHandler) List(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authorizer, rbac.PermissionUsersAdmin) {
+		return
+	}
+
+	subscriptions, err := h.Service.List(r.Context())
+	if err != nil {
+		respondWithWebhookError(w, err)
+		return
+	}
+
+	response := make([]webhooks.SubscriptionResponse, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		response = append(response, buildSubscriptionResponse(subscription))
+	}
+	gowebapp.RespondWithJSON(w, http.StatusOK, response)
+	return
+}
+
+func (h *WebhookHandler) Get(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authorizer, rbac.PermissionUsersAdmin) {
+		return
+	}
+
+	id, err := strconv.Atoi(gowebapp.URLParam(r, "id"))
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageInvalidIDParam)
+		return
+	}
+
+	subscription, err := h.Service.Get(r.Context(), id)
+	if err != nil {
+		respondWithWebhookError(w, err)
+		return
+	}
+
+	gowebapp.RespondWithJSON(w, http.StatusOK, buildSubscriptionResponse(subscription))
+	return
+}
+
+func (h *WebhookHandler) Update(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authorizer, rbac.PermissionUsersAdmin) {
+		return
+	}
+
+	id, err := strconv.Atoi(gowebapp.URLParam(r, "id"))
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageInvalidIDParam)
+		return
+	}
+
+	var subscriptionRequest webhooks.SubscriptionRequest
+	err = json.NewDecoder(r.Body).Decode(&subscriptionRequest)
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageCouldNotDecodeInput)
+		return
+	}
+
+	subscription, err := h.Service.Update(r.Context(), id, buildSubscriptionFromRequest(subscriptionRequest))
+	if err != nil {
+		respondWithWebhookError(w, err)
+		return
+	}
+
+	gowebapp.RespondWithJSON(w, http.StatusOK, buildSubscriptionResponse(subscription))
+	return
+}
+
+func (h *WebhookHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authorizer, rbac.PermissionUsersAdmin) {
+		return
+	}
+
+	id, err := strconv.Atoi(gowebapp.URLParam(r, "id"))
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageInvalidIDParam)
+		return
+	}
+
+	err = h.Service.Delete(r.Context(), id)
+	if err != nil {
+		respondWithWebhookError(w, err)
+		return
+	}
+
+	gowebapp.RespondWithJSON(w, http.StatusNoContent, nil)
+	return
+}
+
+// Deliveries returns the delivery log of a subscription, newest first. Paginated with the limit
+// and offset query params.
+func (h *WebhookHandler) Deliveries(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authorizer, rbac.PermissionUsersAdmin) {
+		return
+	}
+
+	id, err := strconv.Atoi(gowebapp.URLParam(r, "id"))
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageInvalidIDParam)
+		return
+	}
+
+	limit, offset, err := paginationParams(r, _defaultDeliveriesLimit, _maxDeliveriesLimit)
+	if err != nil {
+		gowebapp.RespondWithError(w, http.StatusBadRequest, _ErrorMessageInvalidPagination)
+		return
+	}
+
+	deliveries, err := h.Service.Deliveries(r.Context(), id, limit, offset)
+	if err != nil {
+		respondWithWebhookError(w, err)
+		return
+	}
+
+	response := make([]webhooks.DeliveryResponse, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		response = append(response, buildDeliveryResponse(delivery))
+	}
+	gowebapp.RespondWithJSON(w, http.StatusOK, response)
+	return
+}
+
+func respondWithWebhookError(w http.ResponseWriter, err error) {
+	switch err {
+	case webhooks.ErrSubscriptionNotFound:
+		gowebapp.RespondWithError(w, http.StatusNotFound, _ErrorMessageSubscriptionNotFound)
+	case webhooks.ErrInvalidURL, webhooks.ErrForbiddenAddress, webhooks.ErrUnknownEvent:
+		gowebapp.RespondWithError(w, http.StatusBadRequest, err.Error())
+	default:
+		gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+	}
+}
+
+func buildSubscriptionFromRequest(request webhooks.SubscriptionRequest) webhooks.Subscription {
+	active := true
+	if request.Active != nil {
+		active = *request.Active
+	}
+
+	return webhooks.Subscription{
+		URL:    request.URL,
+		Events: strings.Join(request.Events, ","),
+		Active: active,
+	}
+}
+
+func buildSubscriptionResponse(subscription webhooks.Subscription) webhooks.SubscriptionResponse {
+	return webhooks.SubscriptionResponse{
+		ID:        subscription.ID,
+		URL:       subscription.URL,
+		Events:    subscription.EventList(),
+		Active:    subscription.Active,
+		CreatedAt: subscription.CreatedAt,
+		UpdatedAt: subscription.UpdatedAt,
+	}
+}
+
+func buildDeliveryResponse(delivery webhooks.Delivery) webhooks.DeliveryResponse {
+	return webhooks.DeliveryResponse{
+		ID:             delivery.ID,
+		EventID:        delivery.EventID,
+		EventName:      delivery.EventName,
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		LastStatusCode: delivery.LastStatusCode,
+		LastError:      delivery.LastError,
+		NextAttemptAt:  delivery.NextAttemptAt,
+		CreatedAt:      delivery.CreatedAt,
+		UpdatedAt:      delivery.UpdatedAt,
+	}
+}
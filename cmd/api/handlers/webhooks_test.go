@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/marcosstupnicki/go-users/internal/webhooks"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type WebhookServiceMock struct {
+	mock.Mock
+}
+
+func (s *WebhookServiceMock) Create(_ context.Context, subscription webhooks.Subscription) (webhooks.Subscription, error) {
+	args := s.Called(subscription)
+	return args.Get(0).(webhooks.Subscription), args.Error(1)
+}
+
+func (s *WebhookServiceMock) Get(_ context.Context, _ int) (webhooks.Subscription, error) {
+	args := s.Called()
+	return args.Get(0).(webhooks.Subscription), args.Error(1)
+}
+
+func (s *WebhookServiceMock) List(_ context.Context) ([]webhooks.Subscription, error) {
+	args := s.Called()
+	return args.Get(0).([]webhooks.Subscription), args.Error(1)
+}
+
+func (s *WebhookServiceMock) Update(_ context.Context, _ int, subscription webhooks.Subscription) (webhooks.Subscription, error) {
+	args := s.Called(subscription)
+	return args.Get(0).(webhooks.Subscription), args.Error(1)
+}
+
+func (s *WebhookServiceMock) Delete(_ context.Context, _ int) error {
+	args := s.Called()
+	return args.Error(0)
+}
+
+func (s *WebhookServiceMock) Deliveries(_ context.Context, _ int, limit int, offset int) ([]webhooks.Delivery, error) {
+	args := s.Called(limit, offset)
+	return args.Get(0).([]webhooks.Delivery), args.Error(1)
+}
+
+func TestWebhookHandler_Create(t *testing.T) {
+	subscription := webhooks.Subscription{
+		ID:     3,
+		URL:    "https://partner.com/hooks",
+		Secret: "some-secret",
+		Events: "user.created,user.deleted",
+		Active: true,
+	}
+
+	var tests = []struct {
+		name               string
+		service            *WebhookServiceMock
+		request            string
+		expectedResponse   string
+		expectedStatusCode int
+	}{
+		{
+			name: "Ok - Create subscription, secret returned",
+			service: func() *WebhookServiceMock {
+				m := WebhookServiceMock{}
+				m.On("Create", webhooks.Subscription{URL: "https://partner.com/hooks", Events: "user.created,user.deleted", Active: true}).
+					Return(subscription, nil)
+				return &m
+			}(),
+			request:            `{"url":"https://partner.com/hooks","events":["user.created","user.deleted"]}`,
+			expectedResponse:   `{"id":3,"url":"https://partner.com/hooks","events":["user.created","user.deleted"],"active":true,"secret":"some-secret","created_at":0,"updated_at":0}`,
+			expectedStatusCode: http.StatusCreated,
+		},
+		{
+			name:               "Fail - Bad request",
+			request:            "request_invalid",
+			expectedResponse:   `{"message":"could not decode value from input"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name: "Fail - Invalid URL",
+			service: func() *WebhookServiceMock {
+				m := WebhookServiceMock{}
+				m.On("Create", mock.Anything).Return(webhooks.Subscription{}, webhooks.ErrInvalidURL)
+				return &m
+			}(),
+			request:            `{"url":"partner.com"}`,
+			expectedResponse:   `{"message":"invalid webhook url. url must be an absolute http or https url"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name: "Fail - Internal error in webhook service",
+			service: func() *WebhookServiceMock {
+				m := WebhookServiceMock{}
+				m.On("Create", mock.Anything).Return(webhooks.Subscription{}, ErrInternalErr)
+				return &m
+			}(),
+			request:            `{"url":"https://partner.com/hooks"}`,
+			expectedResponse:   `{"message":"Internal Server Error"}`,
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := gowebapp.NewWebApp("local")
+			handler := NewWebhookHandler(tt.service, AuthorizerMock{})
+			app.Post("/webhooks", handler.Create)
+
+			r := httptest.NewRequest(http.MethodPost, "/webhooks", bytes.NewReader([]byte(tt.request)))
+
+			rr := httptest.NewRecorder()
+			app.Router.ServeHTTP(rr, r)
+
+			res := rr.Result()
+			resBody, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expectedStatusCode, res.StatusCode)
+			require.Equal(t, tt.expectedResponse, string(resBody))
+		})
+	}
+}
+
+func TestWebhookHandler_Get(t *testing.T) {
+	var tests = []struct {
+		name               string
+		service            *WebhookServiceMock
+		id                 string
+		expectedResponse   string
+		expectedStatusCode int
+	}{
+		{
+			name: "Ok - Get subscription, secret not returned",
+			service: func() *WebhookServiceMock {
+				m := WebhookServiceMock{}
+				m.On("Get").Return(webhooks.Subscription{ID: 3, URL: "https://partner.com/hooks", Secret: "some-secret", Active: true}, nil)
+				return &m
+			}(),
+			id:                 "3",
+			expectedResponse:   `{"id":3,"url":"https://partner.com/hooks","events":[],"active":true,"created_at":0,"updated_at":0}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Fail - Invalid id",
+			id:                 "abc",
+			expectedResponse:   `{"message":"invalid param ID. ID must be a integer."}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name: "Fail - Subscription not found",
+			service: func() *WebhookServiceMock {
+				m := WebhookServiceMock{}
+				m.On("Get").Return(webhooks.Subscription{}, webhooks.ErrSubscriptionNotFound)
+				return &m
+			}(),
+			id:                 "3",
+			expectedResponse:   `{"message":"webhook subscription not found"}`,
+			expectedStatusCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := gowebapp.NewWebApp("local")
+			handler := NewWebhookHandler(tt.service, AuthorizerMock{})
+			app.Get("/webhooks/{id}", handler.Get)
+
+			r := httptest.NewRequest(http.MethodGet, "/webhooks/"+tt.id, nil)
+
+			rr := httptest.NewRecorder()
+			app.Router.ServeHTTP(rr, r)
+
+			res := rr.Result()
+			resBody, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expectedStatusCode, res.StatusCode)
+			require.Equal(t, tt.expectedResponse, string(resBody))
+		})
+	}
+}
+
+func TestWebhookHandler_Deliveries(t *testing.T) {
+	var tests = []struct {
+		name               string
+		service            *WebhookServiceMock
+		query              string
+		expectedResponse   string
+		expectedStatusCode int
+	}{
+		{
+			name: "Ok - Default pagination",
+			service: func() *WebhookServiceMock {
+				m := WebhookServiceMock{}
+				m.On("Deliveries", 50, 0).Return([]webhooks.Delivery{
+					{ID: 7, EventID: "a", EventName: "user.created", Status: webhooks.DeliveryStatusDead, Attempts: 8, LastStatusCode: 500, LastError: "responded with status code 500"},
+				}, nil)
+				return &m
+			}(),
+			expectedResponse:   `[{"id":7,"event_id":"a","event_name":"user.created","status":"dead","attempts":8,"last_status_code":500,"last_error":"responded with status code 500","created_at":0,"updated_at":0}]`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "Ok - Limit capped",
+			service: func() *WebhookServiceMock {
+				m := WebhookServiceMock{}
+				m.On("Deliveries", 200, 20).Return([]webhooks.Delivery{}, nil)
+				return &m
+			}(),
+			query:              "?limit=1000&offset=20",
+			expectedResponse:   `[]`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Fail - Invalid pagination",
+			query:              "?limit=-1",
+			expectedResponse:   `{"message":"invalid pagination params. limit and offset must be non negative integers."}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name: "Fail - Subscription not found",
+			service: func() *WebhookServiceMock {
+				m := WebhookServiceMock{}
+				m.On("Deliveries", 50, 0).Return([]webhooks.Delivery{}, webhooks.ErrSubscriptionNotFound)
+				return &m
+			}(),
+			expectedResponse:   `{"message":"webhook subscription not found"}`,
+			expectedStatusCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := gowebapp.NewWebApp("local")
+			handler := NewWebhookHandler(tt.service, AuthorizerMock{})
+			app.Get("/webhooks/{id}/deliveries", handler.Deliveries)
+
+			r := httptest.NewRequest(http.MethodGet, "/webhooks/3/deliveries"+tt.query, nil)
+
+			rr := httptest.NewRecorder()
+			app.Router.ServeHTTP(rr, r)
+
+			res := rr.Result()
+			resBody, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expectedStatusCode, res.StatusCode)
+			require.Equal(t, tt.expectedResponse, string(resBody))
+		})
+	}
+}
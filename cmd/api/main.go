@@ -1,21 +1,46 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/marcosstupnicki/go-users/cmd/api/handlers"
+	"github.com/marcosstupnicki/go-users/internal/apikeys"
+	"github.com/marcosstupnicki/go-users/internal/mfa"
+	"github.com/marcosstupnicki/go-users/internal/oauth"
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
 	"github.com/marcosstupnicki/go-users/internal/platform/config"
+	"github.com/marcosstupnicki/go-users/internal/platform/health"
+	"github.com/marcosstupnicki/go-users/internal/platform/idempotency"
+	"github.com/marcosstupnicki/go-users/internal/platform/metrics"
+	"github.com/marcosstupnicki/go-users/internal/platform/ratelimit"
+	"github.com/marcosstupnicki/go-users/internal/platform/requestmeta"
+	"github.com/marcosstupnicki/go-users/internal/platform/server"
+	"github.com/marcosstupnicki/go-users/internal/platform/tracing"
+	"github.com/marcosstupnicki/go-users/internal/rbac"
+	"github.com/marcosstupnicki/go-users/internal/sessions"
 	"github.com/marcosstupnicki/go-users/internal/users"
+	"github.com/marcosstupnicki/go-users/internal/webhooks"
 	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
 
 	"os"
 )
 
 const (
-	ExitCodeFailToCreateWebApplication = iota
+	// Exit codes start at 1 so a clean shutdown is the only way to exit with 0.
+	ExitCodeFailToCreateWebApplication = iota + 1
 	ExitCodeFailToRunWebApplication
 	ExitCodeFailReadConfigs
 	ExitCodeFailCreateUserService
+	ExitCodeFailRegisterMetrics
+	ExitCodeFailInitTracing
+	ExitCodeFailGracefulShutdown
+	ExitCodeFailCreateEventSinks
 )
 
 func main() {
@@ -27,31 +52,308 @@ func main() {
 		os.Exit(ExitCodeFailReadConfigs)
 	}
 
+	shutdownTracing, err := tracing.Init(cfg.Tracing)
+	if err != nil {
+		fmt.Print("error initializing tracing", err)
+		os.Exit(ExitCodeFailInitTracing)
+	}
+
 	repo, err := users.NewMySQL(cfg.Database)
 	if err != nil {
 		os.Exit(ExitCodeFailCreateUserService)
 	}
 
-	service := users.NewService(repo)
+	sqlDB, err := repo.DB.DB()
+	if err != nil {
+		os.Exit(ExitCodeFailCreateUserService)
+	}
+
+	err = metrics.RegisterDBStats(sqlDB, cfg.Database.Name)
+	if err != nil {
+		os.Exit(ExitCodeFailRegisterMetrics)
+	}
+
+	bus := users.NewBus()
+	if cfg.Events.WebhookURL != "" {
+		sink := users.NewWebhookSink(cfg.Events.WebhookURL, &http.Client{Timeout: cfg.Events.WebhookTimeout})
+		bus.Subscribe(sink.Publish)
+	}
+	if cfg.Events.FilePath != "" {
+		eventsFile, err := os.OpenFile(cfg.Events.FilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			fmt.Print("error opening events file", err)
+			os.Exit(ExitCodeFailCreateEventSinks)
+		}
+		defer eventsFile.Close()
+		bus.Subscribe(users.NewFileSink(eventsFile).Publish)
+	}
+
+	metadataSchemas, err := users.NewMetadataSchemas(cfg.Metadata)
+	if err != nil {
+		fmt.Print("error compiling metadata schemas", err)
+		os.Exit(ExitCodeFailCreateUserService)
+	}
+
+	if cfg.Accounts.InitialStatus != users.StatusActive && cfg.Accounts.InitialStatus != users.StatusPending {
+		fmt.Print("error creating users service: invalid initial status ", cfg.Accounts.InitialStatus)
+		os.Exit(ExitCodeFailCreateUserService)
+	}
+
+	// Sessions are revoked by the users service itself on status changes, so access tokens stop
+	// working before the status change event is relayed.
+	sessionService := sessions.NewService(sessions.NewMySQL(repo.DB), cfg.Auth.RefreshTokenTTL)
+
+	service := users.NewService(repo,
+		users.WithSessionRevoker(sessionService),
+		users.WithMetadataSchemas(metadataSchemas),
+		users.WithInitialStatus(cfg.Accounts.InitialStatus),
+		users.WithLockoutPolicy(users.LockoutPolicy{
+			MaxFailures:   cfg.Lockout.MaxFailures,
+			IPMaxFailures: cfg.Lockout.IPMaxFailures,
+			BaseDuration:  cfg.Lockout.BaseDuration,
+			MaxDuration:   cfg.Lockout.MaxDuration,
+			ResetAfter:    cfg.Lockout.ResetAfter,
+		}),
+	)
+
+	webhooksRepo := webhooks.NewMySQL(repo.DB)
+	webhookAddresses := webhooks.AddressPolicy{
+		Resolver:     net.DefaultResolver,
+		AllowPrivate: cfg.Webhooks.AllowPrivateNetworks,
+	}
+	webhookService := webhooks.NewService(webhooksRepo, webhookAddresses)
+	bus.Subscribe(webhookService.Enqueue)
+
+	rbacService := rbac.NewService(rbac.NewMySQL(repo.DB))
+	bus.Subscribe(rbacService.HandleUserDeleted, users.EventNameUserDeleted)
+
+	mfaService, err := mfa.NewService(mfa.NewMySQL(repo.DB), cfg.MFA)
+	if err != nil {
+		fmt.Print("error creating mfa service", err)
+		os.Exit(ExitCodeFailToCreateWebApplication)
+	}
+	bus.Subscribe(mfaService.HandleUserDeleted, users.EventNameUserDeleted)
+
+	bus.Subscribe(sessionService.HandleUserDeleted, users.EventNameUserDeleted)
+	bus.Subscribe(sessionService.HandleStatusChanged, users.EventNameStatusChanged)
+
+	keys, err := auth.LoadKeySet(cfg.Auth)
+	if err != nil {
+		fmt.Print("error loading signing keys", err)
+		os.Exit(ExitCodeFailToCreateWebApplication)
+	}
+	tokens := auth.NewTokens(cfg.Auth, keys)
+
+	oauthService, err := oauth.NewService(oauth.NewMySQL(repo.DB), keys, cfg.OAuth)
+	if err != nil {
+		fmt.Print("error creating oauth service", err)
+		os.Exit(ExitCodeFailToCreateWebApplication)
+	}
+	bus.Subscribe(oauthService.HandleUserDeleted, users.EventNameUserDeleted)
+
+	apiKeyService := apikeys.NewService(apikeys.NewMySQL(repo.DB))
+	bus.Subscribe(apiKeyService.HandleUserDeleted, users.EventNameUserDeleted)
+	bus.Subscribe(apiKeyService.HandleStatusChanged, users.EventNameStatusChanged)
 
-	initRoutes(app, service)
+	limiter, err := ratelimit.NewLimiter(ratelimit.NewMemoryStore(time.Minute), cfg.RateLimit)
 	if err != nil {
+		fmt.Print("error creating rate limiter", err)
 		os.Exit(ExitCodeFailToCreateWebApplication)
 	}
 
-	err = app.Run()
+	replayer := idempotency.NewReplayer(idempotency.NewMySQLStore(repo.DB), cfg.Idempotency)
+
+	collector, err := requestmeta.NewCollector(cfg.Server.TrustedProxies)
+	if err != nil {
+		fmt.Print("error parsing trusted proxies", err)
+		os.Exit(ExitCodeFailToCreateWebApplication)
+	}
+
+	initRoutes(app, cfg, repo, service, webhookService, rbacService, mfaService, sessionService, oauthService, apiKeyService, keys, tokens, collector, limiter, replayer)
+	if err != nil {
+		os.Exit(ExitCodeFailToCreateWebApplication)
+	}
+
+	listener, err := net.Listen("tcp", cfg.Server.Address)
 	if err != nil {
 		fmt.Print("error booting application", err)
 		os.Exit(ExitCodeFailToRunWebApplication)
 	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	// The relay publishes the events stored by the users service to the bus.
+	relay := users.NewOutboxRelay(
+		repo,
+		bus,
+		users.RelayPolicy{
+			MaxAttempts:    cfg.Events.RelayMaxAttempts,
+			InitialBackoff: cfg.Events.RelayInitialBackoff,
+			MaxBackoff:     cfg.Events.RelayMaxBackoff,
+			Lease:          cfg.Events.RelayLease,
+		},
+		cfg.Events.RelayInterval,
+		cfg.Events.RelayBatchSize,
+	)
+	relayDone := make(chan struct{})
+	go func() {
+		relay.Run(ctx)
+		close(relayDone)
+	}()
+
+	dispatcher := webhooks.NewDispatcher(
+		webhooksRepo,
+		webhooks.NewClient(cfg.Webhooks.Timeout, webhookAddresses),
+		webhooks.RetryPolicy{
+			MaxAttempts:    cfg.Webhooks.MaxAttempts,
+			InitialBackoff: cfg.Webhooks.InitialBackoff,
+			MaxBackoff:     cfg.Webhooks.MaxBackoff,
+			Lease:          cfg.Webhooks.Lease,
+		},
+		cfg.Webhooks.DispatchInterval,
+		cfg.Webhooks.DispatchBatchSize,
+	)
+	dispatcherDone := make(chan struct{})
+	go func() {
+		dispatcher.Run(ctx)
+		close(dispatcherDone)
+	}()
+
+	replayerDone := make(chan struct{})
+	go func() {
+		replayer.Run(ctx)
+		close(replayerDone)
+	}()
+
+	srv := &http.Server{Handler: requestmeta.PeerAddress(app.Router)}
+	err = server.Serve(ctx, srv, listener, cfg.Server.ShutdownTimeout)
+	if err != nil && ctx.Err() == nil {
+		fmt.Print("error running application", err)
+		os.Exit(ExitCodeFailToRunWebApplication)
+	}
+	clean := err == nil
+	if err != nil {
+		fmt.Print("error draining in-flight requests", err)
+	}
+
+	// Wait for the workers to finish their batch, then flush buffered spans and release the
+	// database connections before exiting.
+	<-relayDone
+	<-dispatcherDone
+	<-replayerDone
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	err = shutdownTracing(flushCtx)
+	if err != nil {
+		fmt.Print("error flushing telemetry", err)
+		clean = false
+	}
+
+	err = repo.Close()
+	if err != nil {
+		fmt.Print("error closing database", err)
+		clean = false
+	}
+
+	if !clean {
+		os.Exit(ExitCodeFailGracefulShutdown)
+	}
 }
 
-func initRoutes(app *gowebapp.WebApp, service users.Service) {
-	userHandler := handlers.NewHandler(service)
+func initRoutes(app *gowebapp.WebApp, cfg config.Config, repo users.MySQL, service users.Service, webhookService webhooks.Service, rbacService rbac.Service, mfaService mfa.Service, sessionService sessions.Service, oauthService oauth.Service, apiKeyService apikeys.Service, keys auth.KeySet, tokens auth.Tokens, collector requestmeta.Collector, limiter ratelimit.Limiter, replayer idempotency.Replayer) {
+	userHandler := handlers.NewHandler(service, rbacService)
+	webhookHandler := handlers.NewWebhookHandler(webhookService, rbacService)
+	roleHandler := handlers.NewRoleHandler(rbacService, service, rbacService)
+	authHandler := handlers.NewAuthHandler(service, rbacService, tokens, mfaService, sessionService, cfg.MFA.RequiredRoles)
+	mfaHandler := handlers.NewMFAHandler(mfaService, service, sessionService)
+	sessionHandler := handlers.NewSessionHandler(sessionService, service, rbacService)
+	jwksHandler := handlers.NewJWKSHandler(keys)
+	oauthHandler := handlers.NewOAuthHandler(oauthService, sessionService, service, service, mfaService, rbacService, tokens, cfg.MFA.RequiredRoles)
+	oauthClientHandler := handlers.NewOAuthClientHandler(oauthService, rbacService)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyService, sessionService, rbacService)
+	importHandler := handlers.NewImportHandler(service, rbacService, cfg.Import)
+	exportHandler := handlers.NewExportHandler(service, rbacService)
+	batchHandler := handlers.NewBatchHandler(service, rbacService)
+	instrument := instrumenter(collector.Middleware, auth.Middleware(tokens, sessionService, apiKeyService), limiter.Middleware)
+
+	app.Get("/metrics", metrics.Handler().ServeHTTP)
+	app.Get("/healthz", health.Liveness)
+	app.Get("/readyz", health.NewReadinessHandler(cfg.Health.ReadinessTimeout,
+		health.Check{Name: "database", Check: repo.Ping},
+		health.Check{Name: "migrations", Check: repo.CheckSchemaVersion},
+	))
+
+	app.Get("/.well-known/jwks.json", instrument(jwksHandler.Get))
+	app.Get("/.well-known/openid-configuration", instrument(oauthHandler.Discovery))
+	app.Get("/oauth/authorize", instrument(oauthHandler.Authorize))
+	app.Post("/oauth/authorize", instrument(oauthHandler.AuthorizeLogin))
+	app.Post("/oauth/token", instrument(oauthHandler.Token))
+	app.Get("/oauth/userinfo", instrument(oauthHandler.UserInfo))
+	app.Post("/oauth/userinfo", instrument(oauthHandler.UserInfo))
+	app.Post("/auth/login", instrument(authHandler.Login))
+	app.Post("/auth/login/mfa", instrument(authHandler.LoginMFA))
+	app.Post("/auth/refresh", instrument(authHandler.Refresh))
+	app.Delete("/auth/sessions/{id}", instrument(authHandler.Logout))
+	app.Post("/auth/mfa/enroll", instrument(mfaHandler.Enroll))
+	app.Post("/auth/mfa/confirm", instrument(mfaHandler.Confirm))
+	app.Post("/auth/mfa/disable", instrument(mfaHandler.Disable))
+
+	app.Post("/users:batch", instrument(batchHandler.Batch))
 
 	userGroup := app.Group("/users")
-	userGroup.Post("", userHandler.Create)
-	userGroup.Get("/{id}", userHandler.Get)
-	userGroup.Put("/{id}", userHandler.Update)
-	userGroup.Delete("/{id}", userHandler.Delete)
+	userGroup.Post("", instrument(replayer.Middleware(userHandler.Create)))
+	userGroup.Get("", instrument(userHandler.List))
+	userGroup.Post("/import", instrument(importHandler.Import))
+	userGroup.Get("/export", instrument(exportHandler.Export))
+	userGroup.Get("/search", instrument(userHandler.Search))
+	userGroup.Get("/{id}", instrument(userHandler.Get))
+	userGroup.Put("/{id}", instrument(userHandler.Update))
+	userGroup.Delete("/{id}", instrument(userHandler.Delete))
+	userGroup.Get("/{id}/audit", instrument(userHandler.AuditLog))
+	userGroup.Put("/{id}/status", instrument(userHandler.SetStatus))
+	userGroup.Get("/{id}/roles", instrument(roleHandler.UserRoles))
+	userGroup.Post("/{id}/roles", instrument(roleHandler.Assign))
+	userGroup.Delete("/{id}/roles/{role}", instrument(roleHandler.Unassign))
+	userGroup.Get("/{id}/sessions", instrument(sessionHandler.List))
+	userGroup.Delete("/{id}/sessions", instrument(sessionHandler.RevokeAll))
+	userGroup.Delete("/{id}/sessions/{session}", instrument(sessionHandler.Revoke))
+
+	roleGroup := app.Group("/roles")
+	roleGroup.Post("", instrument(roleHandler.Create))
+	roleGroup.Get("", instrument(roleHandler.List))
+	roleGroup.Get("/{id}", instrument(roleHandler.Get))
+	roleGroup.Put("/{id}", instrument(roleHandler.Update))
+	roleGroup.Delete("/{id}", instrument(roleHandler.Delete))
+
+	webhookGroup := app.Group("/webhooks")
+	webhookGroup.Post("", instrument(webhookHandler.Create))
+	webhookGroup.Get("", instrument(webhookHandler.List))
+	webhookGroup.Get("/{id}", instrument(webhookHandler.Get))
+	webhookGroup.Put("/{id}", instrument(webhookHandler.Update))
+	webhookGroup.Delete("/{id}", instrument(webhookHandler.Delete))
+	webhookGroup.Get("/{id}/deliveries", instrument(webhookHandler.Deliveries))
+
+	oauthClientGroup := app.Group("/oauth/clients")
+	oauthClientGroup.Post("", instrument(oauthClientHandler.Create))
+	oauthClientGroup.Get("", instrument(oauthClientHandler.List))
+	oauthClientGroup.Get("/{id}", instrument(oauthClientHandler.Get))
+	oauthClientGroup.Delete("/{id}", instrument(oauthClientHandler.Delete))
+
+	apiKeyGroup := app.Group("/api-keys")
+	apiKeyGroup.Post("", instrument(apiKeyHandler.Create))
+	apiKeyGroup.Get("", instrument(apiKeyHandler.List))
+	apiKeyGroup.Get("/{id}", instrument(apiKeyHandler.Get))
+	apiKeyGroup.Delete("/{id}", instrument(apiKeyHandler.Revoke))
+}
+
+// instrumenter returns a function wrapping handlers with tracing, metrics, request metadata,
+// authenticate and rate limit middlewares. Rate limiting runs last, since it's keyed by the
+// client IP and the authenticated user.
+func instrumenter(collect func(http.HandlerFunc) http.HandlerFunc, authenticate func(http.HandlerFunc) http.HandlerFunc, limit func(http.HandlerFunc) http.HandlerFunc) func(http.HandlerFunc) http.HandlerFunc {
+	return func(handler http.HandlerFunc) http.HandlerFunc {
+		return tracing.InstrumentHandler(metrics.InstrumentHandler(collect(authenticate(limit(handler)))))
+	}
 }
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/config"
+	"github.com/marcosstupnicki/go-users/internal/users"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+)
+
+const (
+	ExitCodeFailInvalidFlags = iota + 1
+	ExitCodeFailReadConfigs
+	ExitCodeFailCreateUserService
+	ExitCodeFailOpenFile
+	ExitCodeFailImport
+	// ExitCodeRowsFailed is returned by imports that completed with failed rows.
+	ExitCodeRowsFailed
+)
+
+// import creates the users of a CSV or NDJSON file, like POST /users/import, writing the NDJSON
+// report of its rows and its summary. Their UserCreated events are stored in the outbox, and
+// published by the API.
+func main() {
+	file := flag.String("file", "", "path of the CSV or NDJSON file to import, - for stdin")
+	format := flag.String("format", "", "csv or ndjson, by default from the file extension")
+	batchSize := flag.Int("batch-size", 0, "users imported per transaction, by default config.Import.BatchSize")
+	reportPath := flag.String("report", "", "path of the report file to create, by default stdout")
+	flag.Parse()
+
+	if *format == "" {
+		*format = strings.TrimPrefix(filepath.Ext(*file), ".")
+	}
+	if *file == "" || (*format != users.ImportFormatCSV && *format != users.ImportFormatNDJSON) {
+		fmt.Println("-file is required, and -format must be csv or ndjson")
+		os.Exit(ExitCodeFailInvalidFlags)
+	}
+
+	cfg, err := config.GetConfigFromScope(gowebapp.Scope{Environment: "local"})
+	if err != nil {
+		fmt.Println("error reading configs", err)
+		os.Exit(ExitCodeFailReadConfigs)
+	}
+	if *batchSize <= 0 {
+		*batchSize = cfg.Import.BatchSize
+	}
+
+	repo, err := users.NewMySQL(cfg.Database)
+	if err != nil {
+		fmt.Println("error connecting to the database", err)
+		os.Exit(ExitCodeFailCreateUserService)
+	}
+	defer repo.Close()
+
+	metadataSchemas, err := users.NewMetadataSchemas(cfg.Metadata)
+	if err != nil {
+		fmt.Println("error compiling metadata schemas", err)
+		os.Exit(ExitCodeFailCreateUserService)
+	}
+	service := users.NewService(repo,
+		users.WithMetadataSchemas(metadataSchemas),
+		users.WithInitialStatus(cfg.Accounts.InitialStatus),
+	)
+
+	input := io.Reader(os.Stdin)
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			fmt.Println("error opening file", err)
+			os.Exit(ExitCodeFailOpenFile)
+		}
+		defer f.Close()
+		input = f
+	}
+
+	output := os.Stdout
+	if *reportPath != "" {
+		output, err = os.OpenFile(*reportPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			fmt.Println("error creating report", err)
+			os.Exit(ExitCodeFailOpenFile)
+		}
+		defer output.Close()
+	}
+	report := bufio.NewWriter(output)
+	defer report.Flush()
+	encoder := json.NewEncoder(report)
+
+	reader, err := users.NewImportReader(bufio.NewReader(input), *format)
+	if err != nil {
+		fmt.Println("error reading file", err)
+		os.Exit(ExitCodeFailImport)
+	}
+
+	// Interrupting aborts the import, rolling back the batch in progress.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	summary, err := service.Import(ctx, reader, *batchSize, func(result users.ImportResult) error {
+		return encoder.Encode(result)
+	})
+	if err != nil {
+		summary.Error = err.Error()
+	}
+	encoder.Encode(summary)
+
+	fmt.Fprintf(os.Stderr, "import %s: %d created, %d failed\n", summary.Status, summary.Created, summary.Failed)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error importing users", err)
+		exit(report, ExitCodeFailImport)
+	}
+	if summary.Failed > 0 {
+		exit(report, ExitCodeRowsFailed)
+	}
+}
+
+// exit flushes the report before exiting with code, since deferred calls don't run on os.Exit.
+func exit(report *bufio.Writer, code int) {
+	report.Flush()
+	os.Exit(code)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+)
+
+const (
+	ExitCodeFailInvalidFlags = iota + 1
+	ExitCodeFailGenerateKey
+	ExitCodeFailWriteKey
+)
+
+// keygen generates an RSA key to sign access tokens, listed in config.Auth.SigningKeys. The public
+// key file can replace the private one once the key is rotated out.
+func main() {
+	out := flag.String("out", "", "path of the PEM private key file to create")
+	publicOut := flag.String("public-out", "", "path of the PEM public key file to create, optional")
+	bits := flag.Int("bits", 2048, "size of the key in bits")
+	flag.Parse()
+
+	if *out == "" || *bits < 2048 {
+		fmt.Println("-out is required and -bits must be at least 2048")
+		os.Exit(ExitCodeFailInvalidFlags)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, *bits)
+	if err != nil {
+		fmt.Println("error generating key", err)
+		os.Exit(ExitCodeFailGenerateKey)
+	}
+
+	private, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		fmt.Println("error encoding private key", err)
+		os.Exit(ExitCodeFailGenerateKey)
+	}
+	err = writePEM(*out, "PRIVATE KEY", private, 0600)
+	if err != nil {
+		fmt.Println("error writing private key", err)
+		os.Exit(ExitCodeFailWriteKey)
+	}
+
+	if *publicOut == "" {
+		return
+	}
+
+	public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		fmt.Println("error encoding public key", err)
+		os.Exit(ExitCodeFailGenerateKey)
+	}
+	err = writePEM(*publicOut, "PUBLIC KEY", public, 0644)
+	if err != nil {
+		fmt.Println("error writing public key", err)
+		os.Exit(ExitCodeFailWriteKey)
+	}
+}
+
+// writePEM creates the file at path with the PEM block of blockType and bytes. It fails if the file
+// exists, so keys are never overwritten.
+func writePEM(path string, blockType string, bytes []byte, perm os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+
+	err = pem.Encode(file, &pem.Block{Type: blockType, Bytes: bytes})
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
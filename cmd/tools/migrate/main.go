@@ -1,10 +1,19 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"os"
 
+	"github.com/marcosstupnicki/go-users/internal/apikeys"
+	"github.com/marcosstupnicki/go-users/internal/mfa"
+	"github.com/marcosstupnicki/go-users/internal/oauth"
 	"github.com/marcosstupnicki/go-users/internal/platform/config"
+	"github.com/marcosstupnicki/go-users/internal/platform/idempotency"
+	"github.com/marcosstupnicki/go-users/internal/rbac"
+	"github.com/marcosstupnicki/go-users/internal/sessions"
 	"github.com/marcosstupnicki/go-users/internal/users"
+	"github.com/marcosstupnicki/go-users/internal/webhooks"
 	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
 )
 
@@ -12,17 +21,66 @@ const (
 	ExitCodeFailToConnectLocalDB = iota
 	ExitCodeFailToMigrateModel
 	ExitCodeFailCreateRepository
+	ExitCodeFailGrantAdmin
 )
 
 func main() {
+	// Bootstrapping needs an admin to assign roles through the API.
+	grantAdmin := flag.Int("grant-admin", 0, "id of a user to assign the admin role to, after migrating")
+	flag.Parse()
+
 	cfg, err := config.GetConfigFromScope(gowebapp.Scope{Environment: "local"})
 	repo, err := users.NewMySQL(cfg.Database)
 	if err != nil {
 		os.Exit(ExitCodeFailCreateRepository)
 	}
 
+	err = webhooks.NewMySQL(repo.DB).AutoMigrate()
+	if err != nil {
+		os.Exit(ExitCodeFailToMigrateModel)
+	}
+
+	rbacRepo := rbac.NewMySQL(repo.DB)
+	err = rbacRepo.AutoMigrate()
+	if err != nil {
+		os.Exit(ExitCodeFailToMigrateModel)
+	}
+
+	err = mfa.NewMySQL(repo.DB).AutoMigrate()
+	if err != nil {
+		os.Exit(ExitCodeFailToMigrateModel)
+	}
+
+	err = sessions.NewMySQL(repo.DB).AutoMigrate()
+	if err != nil {
+		os.Exit(ExitCodeFailToMigrateModel)
+	}
+
+	err = oauth.NewMySQL(repo.DB).AutoMigrate()
+	if err != nil {
+		os.Exit(ExitCodeFailToMigrateModel)
+	}
+
+	err = apikeys.NewMySQL(repo.DB).AutoMigrate()
+	if err != nil {
+		os.Exit(ExitCodeFailToMigrateModel)
+	}
+
+	err = idempotency.NewMySQLStore(repo.DB).AutoMigrate()
+	if err != nil {
+		os.Exit(ExitCodeFailToMigrateModel)
+	}
+
+	// Migrated last, since it records the schema version once every table is up to date.
 	err = repo.AutoMigrate()
 	if err != nil {
 		os.Exit(ExitCodeFailToMigrateModel)
 	}
+
+	if *grantAdmin != 0 {
+		err = rbacRepo.AssignRole(context.Background(), *grantAdmin, rbac.RoleAdmin)
+		if err != nil {
+			os.Exit(ExitCodeFailGrantAdmin)
+		}
+	}
 }
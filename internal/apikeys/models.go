@@ -0,0 +1,60 @@
+package apikeys
+
+import "strings"
+
+type APIKeyRequest struct {
+	Name string `json:"name"`
+	// Scopes are the RBAC permissions granted to the key.
+	Scopes []string `json:"scopes"`
+	// ExpiresAt is when the key stops working, as a unix timestamp. Zero never expires.
+	ExpiresAt int64 `json:"expires_at"`
+}
+
+type APIKeyResponse struct {
+	ID     int      `json:"id"`
+	Name   string   `json:"name"`
+	Prefix string   `json:"prefix"`
+	Scopes []string `json:"scopes"`
+	// Key is only returned on creation.
+	Key        string `json:"key,omitempty"`
+	CreatedBy  int    `json:"created_by"`
+	CreatedAt  int64  `json:"created_at"`
+	LastUsedAt int64  `json:"last_used_at,omitempty"`
+	ExpiresAt  int64  `json:"expires_at,omitempty"`
+	RevokedAt  int64  `json:"revoked_at,omitempty"`
+}
+
+// APIKey is the credential of a machine client. Keys are "gu_<prefix>_<secret>": the prefix
+// identifies the key and is stored as is, only the SHA-256 hash of the secret is stored. Scopes
+// holds the granted permissions separated by commas.
+type APIKey struct {
+	ID         int    `gorm:"column:id;primaryKey"`
+	Name       string `gorm:"column:name;size:100"`
+	Prefix     string `gorm:"column:prefix;size:16;uniqueIndex"`
+	SecretHash string `gorm:"column:secret_hash;size:64"`
+	Scopes     string `gorm:"column:scopes;size:255"`
+	// CreatedBy is the id of the user who created the key.
+	CreatedBy  int   `gorm:"column:created_by"`
+	CreatedAt  int64 `gorm:"column:created_at"`
+	LastUsedAt int64 `gorm:"column:last_used_at"`
+	ExpiresAt  int64 `gorm:"column:expires_at"`
+	RevokedAt  int64 `gorm:"column:revoked_at"`
+}
+
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+// ScopeList returns the permissions granted to the key.
+func (k APIKey) ScopeList() []string {
+	if k.Scopes == "" {
+		return []string{}
+	}
+
+	return strings.Split(k.Scopes, ",")
+}
+
+// KeyPrefix returns the start of the key, "gu_<prefix>", to recognize it without its secret.
+func (k APIKey) KeyPrefix() string {
+	return _keyPrefix + k.Prefix
+}
@@ -0,0 +1,88 @@
+package apikeys
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+type MySQL struct {
+	DB *gorm.DB
+}
+
+// NewMySQL returns the API keys repository over an existing connection, usually the one opened
+// by users.NewMySQL.
+func NewMySQL(db *gorm.DB) MySQL {
+	return MySQL{
+		DB: db,
+	}
+}
+
+func (repository MySQL) CreateAPIKey(ctx context.Context, key APIKey) (APIKey, error) {
+	tx := repository.DB.WithContext(ctx).Create(&key)
+	if tx.Error != nil {
+		return APIKey{}, tx.Error
+	}
+
+	return key, nil
+}
+
+func (repository MySQL) GetAPIKey(ctx context.Context, id int) (APIKey, error) {
+	key := APIKey{ID: id}
+	tx := repository.DB.WithContext(ctx).Limit(1).Find(&key)
+	if tx.Error != nil {
+		return APIKey{}, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return APIKey{}, ErrAPIKeyNotFound
+	}
+
+	return key, nil
+}
+
+func (repository MySQL) GetAPIKeyByPrefix(ctx context.Context, prefix string) (APIKey, error) {
+	var key APIKey
+	tx := repository.DB.WithContext(ctx).Where("prefix = ?", prefix).Limit(1).Find(&key)
+	if tx.Error != nil {
+		return APIKey{}, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return APIKey{}, ErrAPIKeyNotFound
+	}
+
+	return key, nil
+}
+
+func (repository MySQL) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	var keys []APIKey
+	tx := repository.DB.WithContext(ctx).Order("id").Find(&keys)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey marks the key with id as revoked at now. Revoking a revoked key keeps the first
+// revocation time.
+func (repository MySQL) RevokeAPIKey(ctx context.Context, id int, now int64) error {
+	return repository.DB.WithContext(ctx).Model(&APIKey{}).
+		Where("id = ? AND revoked_at = 0", id).
+		UpdateColumn("revoked_at", now).Error
+}
+
+// RevokeUserAPIKeys marks every key created by the user with userID, not revoked yet, as revoked
+// at now.
+func (repository MySQL) RevokeUserAPIKeys(ctx context.Context, userID int, now int64) error {
+	return repository.DB.WithContext(ctx).Model(&APIKey{}).
+		Where("created_by = ? AND revoked_at = 0", userID).
+		UpdateColumn("revoked_at", now).Error
+}
+
+func (repository MySQL) TouchAPIKey(ctx context.Context, id int, now int64) error {
+	return repository.DB.WithContext(ctx).Model(&APIKey{}).Where("id = ?", id).UpdateColumn("last_used_at", now).Error
+}
+
+func (repository MySQL) AutoMigrate() error {
+	return repository.DB.AutoMigrate(&APIKey{})
+}
@@ -0,0 +1,41 @@
+package apikeys
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestMySQL_GetAPIKeyByPrefix(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	query := regexp.QuoteMeta("SELECT * FROM `api_keys` WHERE prefix = ? LIMIT 1")
+	mock.ExpectQuery(query).
+		WithArgs("0011223344556677").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "prefix", "scopes"}).
+			AddRow(3, "billing", "0011223344556677", "users:read"))
+	mock.ExpectQuery(query).
+		WithArgs("0011223344556677").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	gormDB, err := gorm.Open(
+		mysql.New(mysql.Config{
+			Conn:                      db,
+			SkipInitializeWithVersion: true}),
+		&gorm.Config{})
+	require.NoError(t, err)
+
+	repo := NewMySQL(gormDB)
+	key, err := repo.GetAPIKeyByPrefix(context.Background(), "0011223344556677")
+	require.NoError(t, err)
+	require.Equal(t, APIKey{ID: 3, Name: "billing", Prefix: "0011223344556677", Scopes: "users:read"}, key)
+
+	_, err = repo.GetAPIKeyByPrefix(context.Background(), "0011223344556677")
+	require.Equal(t, ErrAPIKeyNotFound, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,231 @@
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	"github.com/marcosstupnicki/go-users/internal/rbac"
+	"github.com/marcosstupnicki/go-users/internal/users"
+)
+
+const (
+	// _keyPrefix starts every key, so leaked keys are easy to recognize by secret scanners.
+	_keyPrefix = "gu_"
+	// _prefixSize and _secretSize are the sizes in bytes of the random key prefixes and secrets.
+	_prefixSize = 8
+	_secretSize = 32
+	// _maxNameLength is the size of the name column.
+	_maxNameLength = 100
+	// _lastUsedResolution is how often the last used time of a key in use is updated, so
+	// requests don't all write to the database.
+	_lastUsedResolution = time.Minute
+
+	_subjectPrefix = "apikey:"
+)
+
+var (
+	// ErrAPIKeyNotFound api key not found error
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	// ErrInvalidName api key name empty or too long error
+	ErrInvalidName = errors.New("invalid api key name. name is required and must be at most 100 characters")
+	// ErrInvalidScopes api key without scopes, or with unknown ones, error
+	ErrInvalidScopes = errors.New("invalid api key scopes. at least one scope is required, and scopes must be permissions")
+	// ErrInvalidExpiration api key expiring in the past error
+	ErrInvalidExpiration = errors.New("invalid api key expiration. expires_at must be in the future")
+)
+
+type Repository interface {
+	CreateAPIKey(ctx context.Context, key APIKey) (APIKey, error)
+	GetAPIKey(ctx context.Context, id int) (APIKey, error)
+	GetAPIKeyByPrefix(ctx context.Context, prefix string) (APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, id int, now int64) error
+	RevokeUserAPIKeys(ctx context.Context, userID int, now int64) error
+	TouchAPIKey(ctx context.Context, id int, now int64) error
+}
+
+// Service manages the API keys of machine clients, which are granted RBAC permissions as scopes
+// instead of roles.
+type Service struct {
+	repository Repository
+	now        func() time.Time
+}
+
+func NewService(repository Repository) Service {
+	return Service{
+		repository: repository,
+		now:        time.Now,
+	}
+}
+
+// Create stores a new key and returns it with the key itself, the only time it's available.
+func (s Service) Create(ctx context.Context, key APIKey) (APIKey, string, error) {
+	err := s.validate(key)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+
+	key.Prefix, err = generateToken(_prefixSize)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	secret, err := generateToken(_secretSize)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+
+	key.SecretHash = hashSecret(secret)
+	key.CreatedAt = s.now().Unix()
+	key.LastUsedAt = 0
+	key.RevokedAt = 0
+	key, err = s.repository.CreateAPIKey(ctx, key)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+
+	return key, key.KeyPrefix() + "_" + secret, nil
+}
+
+func (s Service) Get(ctx context.Context, id int) (APIKey, error) {
+	return s.repository.GetAPIKey(ctx, id)
+}
+
+func (s Service) List(ctx context.Context) ([]APIKey, error) {
+	return s.repository.ListAPIKeys(ctx)
+}
+
+// Revoke revokes a key: requests with it are rejected from then on. Revoked keys are kept, listed
+// with their revocation time.
+func (s Service) Revoke(ctx context.Context, id int) error {
+	_, err := s.repository.GetAPIKey(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return s.repository.RevokeAPIKey(ctx, id, s.now().Unix())
+}
+
+// HandleUserDeleted revokes every key created by a deleted user. It's meant to be subscribed to
+// the users events bus for users.EventNameUserDeleted.
+func (s Service) HandleUserDeleted(ctx context.Context, event users.Event) error {
+	return s.repository.RevokeUserAPIKeys(ctx, event.Metadata().UserID, s.now().Unix())
+}
+
+// HandleStatusChanged revokes every key created by a user whose status left active, so suspended
+// or locked users can't keep acting through their keys. It's meant to be subscribed to the users
+// events bus for users.EventNameStatusChanged.
+func (s Service) HandleStatusChanged(ctx context.Context, event users.Event) error {
+	changed, ok := event.(users.StatusChanged)
+	if !ok || changed.To == users.StatusActive {
+		return nil
+	}
+
+	return s.repository.RevokeUserAPIKeys(ctx, changed.UserID, s.now().Unix())
+}
+
+// AuthenticateAPIKey returns the principal of key, granted its scopes, or auth.ErrInvalidAPIKey
+// if it's unknown, revoked or expired. It records when the key was last used.
+func (s Service) AuthenticateAPIKey(ctx context.Context, key string) (auth.Principal, error) {
+	prefix, secret, ok := parseKey(key)
+	if !ok {
+		return auth.Principal{}, auth.ErrInvalidAPIKey
+	}
+
+	stored, err := s.repository.GetAPIKeyByPrefix(ctx, prefix)
+	if err != nil {
+		if err == ErrAPIKeyNotFound {
+			return auth.Principal{}, auth.ErrInvalidAPIKey
+		}
+		return auth.Principal{}, err
+	}
+
+	now := s.now()
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(stored.SecretHash)) != 1 ||
+		stored.RevokedAt != 0 ||
+		(stored.ExpiresAt != 0 && stored.ExpiresAt <= now.Unix()) {
+		return auth.Principal{}, auth.ErrInvalidAPIKey
+	}
+
+	if now.Sub(time.Unix(stored.LastUsedAt, 0)) >= _lastUsedResolution {
+		err = s.repository.TouchAPIKey(ctx, stored.ID, now.Unix())
+		if err != nil {
+			return auth.Principal{}, err
+		}
+	}
+
+	return auth.Principal{
+		Subject:  _subjectPrefix + strconv.Itoa(stored.ID),
+		APIKeyID: stored.ID,
+		Scopes:   stored.ScopeList(),
+	}, nil
+}
+
+func (s Service) validate(key APIKey) error {
+	name := strings.TrimSpace(key.Name)
+	if name == "" || len(name) > _maxNameLength {
+		return ErrInvalidName
+	}
+
+	scopes := key.ScopeList()
+	if len(scopes) == 0 {
+		return ErrInvalidScopes
+	}
+	for _, scope := range scopes {
+		if !contains(rbac.Permissions, scope) {
+			return ErrInvalidScopes
+		}
+	}
+
+	if key.ExpiresAt != 0 && key.ExpiresAt <= s.now().Unix() {
+		return ErrInvalidExpiration
+	}
+
+	return nil
+}
+
+// parseKey returns the prefix and secret of a "gu_<prefix>_<secret>" key.
+func parseKey(key string) (string, string, bool) {
+	if !strings.HasPrefix(key, _keyPrefix) {
+		return "", "", false
+	}
+
+	parts := strings.Split(strings.TrimPrefix(key, _keyPrefix), "_")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+
+	return parts[0], parts[1], true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func generateToken(size int) (string, error) {
+	b := make([]byte, size)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
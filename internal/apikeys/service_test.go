@@ -0,0 +1,255 @@
+package apikeys
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	"github.com/marcosstupnicki/go-users/internal/users"
+	"github.com/stretchr/testify/require"
+)
+
+// RepositoryMock is an in memory Repository.
+type RepositoryMock struct {
+	keys []APIKey
+}
+
+func (r *RepositoryMock) CreateAPIKey(_ context.Context, key APIKey) (APIKey, error) {
+	key.ID = len(r.keys) + 1
+	r.keys = append(r.keys, key)
+	return key, nil
+}
+
+func (r *RepositoryMock) GetAPIKey(_ context.Context, id int) (APIKey, error) {
+	for _, key := range r.keys {
+		if key.ID == id {
+			return key, nil
+		}
+	}
+	return APIKey{}, ErrAPIKeyNotFound
+}
+
+func (r *RepositoryMock) GetAPIKeyByPrefix(_ context.Context, prefix string) (APIKey, error) {
+	for _, key := range r.keys {
+		if key.Prefix == prefix {
+			return key, nil
+		}
+	}
+	return APIKey{}, ErrAPIKeyNotFound
+}
+
+func (r *RepositoryMock) ListAPIKeys(_ context.Context) ([]APIKey, error) {
+	return r.keys, nil
+}
+
+func (r *RepositoryMock) RevokeAPIKey(_ context.Context, id int, now int64) error {
+	for i, key := range r.keys {
+		if key.ID == id && key.RevokedAt == 0 {
+			r.keys[i].RevokedAt = now
+		}
+	}
+	return nil
+}
+
+func (r *RepositoryMock) RevokeUserAPIKeys(_ context.Context, userID int, now int64) error {
+	for i, key := range r.keys {
+		if key.CreatedBy == userID && key.RevokedAt == 0 {
+			r.keys[i].RevokedAt = now
+		}
+	}
+	return nil
+}
+
+func (r *RepositoryMock) TouchAPIKey(_ context.Context, id int, now int64) error {
+	for i, key := range r.keys {
+		if key.ID == id {
+			r.keys[i].LastUsedAt = now
+		}
+	}
+	return nil
+}
+
+func newTestService(repository Repository, now time.Time) Service {
+	s := NewService(repository)
+	s.now = func() time.Time { return now }
+	return s
+}
+
+func TestService_Create(t *testing.T) {
+	var tests = []struct {
+		name string
+		key  APIKey
+		err  error
+	}{
+		{
+			name: "Success",
+			key:  APIKey{Name: "billing", Scopes: "users:read,users:write", CreatedBy: 7, ExpiresAt: 200000},
+		},
+		{
+			name: "Error - name required",
+			key:  APIKey{Name: " ", Scopes: "users:read"},
+			err:  ErrInvalidName,
+		},
+		{
+			name: "Error - scope required",
+			key:  APIKey{Name: "billing"},
+			err:  ErrInvalidScopes,
+		},
+		{
+			name: "Error - unknown scope",
+			key:  APIKey{Name: "billing", Scopes: "users:read,users:everything"},
+			err:  ErrInvalidScopes,
+		},
+		{
+			name: "Error - expired",
+			key:  APIKey{Name: "billing", Scopes: "users:read", ExpiresAt: 123456},
+			err:  ErrInvalidExpiration,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := &RepositoryMock{}
+			s := newTestService(repository, time.Unix(123456, 0))
+
+			key, plain, err := s.Create(context.Background(), tt.key)
+			require.Equal(t, tt.err, err)
+			if tt.err != nil {
+				require.Empty(t, repository.keys)
+				return
+			}
+			require.Equal(t, 1, key.ID)
+			require.Equal(t, int64(123456), key.CreatedAt)
+			require.True(t, strings.HasPrefix(plain, "gu_"+key.Prefix+"_"))
+			require.NotContains(t, key.SecretHash, strings.TrimPrefix(plain, "gu_"+key.Prefix+"_"))
+		})
+	}
+}
+
+func TestService_AuthenticateAPIKey(t *testing.T) {
+	repository := &RepositoryMock{}
+	now := time.Unix(123456, 0)
+	s := newTestService(repository, now)
+	key, plain, err := s.Create(context.Background(), APIKey{Name: "billing", Scopes: "users:read", ExpiresAt: 200000})
+	require.NoError(t, err)
+	_, revoked, err := s.Create(context.Background(), APIKey{Name: "old", Scopes: "users:read"})
+	require.NoError(t, err)
+	require.NoError(t, s.Revoke(context.Background(), 2))
+
+	var tests = []struct {
+		name              string
+		key               string
+		elapsed           time.Duration
+		expectedPrincipal auth.Principal
+		err               error
+	}{
+		{
+			name:              "Success",
+			key:               plain,
+			expectedPrincipal: auth.Principal{Subject: "apikey:1", APIKeyID: 1, Scopes: []string{"users:read"}},
+		},
+		{
+			name: "Error - wrong secret",
+			key:  "gu_" + key.Prefix + "_" + strings.Repeat("0", 64),
+			err:  auth.ErrInvalidAPIKey,
+		},
+		{
+			name: "Error - unknown prefix",
+			key:  "gu_0000000000000000_" + strings.Repeat("0", 64),
+			err:  auth.ErrInvalidAPIKey,
+		},
+		{
+			name: "Error - malformed",
+			key:  "some-key",
+			err:  auth.ErrInvalidAPIKey,
+		},
+		{
+			name: "Error - revoked",
+			key:  revoked,
+			err:  auth.ErrInvalidAPIKey,
+		},
+		{
+			name:    "Error - expired",
+			key:     plain,
+			elapsed: 200000 * time.Second,
+			err:     auth.ErrInvalidAPIKey,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.now = func() time.Time { return now.Add(tt.elapsed) }
+
+			principal, err := s.AuthenticateAPIKey(context.Background(), tt.key)
+			require.Equal(t, tt.err, err)
+			require.Equal(t, tt.expectedPrincipal, principal)
+		})
+	}
+	require.Equal(t, int64(123456), repository.keys[0].LastUsedAt)
+}
+
+func TestService_Revoke(t *testing.T) {
+	repository := &RepositoryMock{}
+	s := newTestService(repository, time.Unix(123456, 0))
+	_, _, err := s.Create(context.Background(), APIKey{Name: "billing", Scopes: "users:read"})
+	require.NoError(t, err)
+
+	err = s.Revoke(context.Background(), 1)
+	require.NoError(t, err)
+	require.Equal(t, int64(123456), repository.keys[0].RevokedAt)
+
+	err = s.Revoke(context.Background(), 2)
+	require.Equal(t, ErrAPIKeyNotFound, err)
+}
+
+func TestService_HandleUserDeleted(t *testing.T) {
+	repository := &RepositoryMock{}
+	s := newTestService(repository, time.Unix(123456, 0))
+	_, key, err := s.Create(context.Background(), APIKey{Name: "billing", Scopes: "users:read", CreatedBy: 5})
+	require.NoError(t, err)
+	_, other, err := s.Create(context.Background(), APIKey{Name: "reports", Scopes: "users:read", CreatedBy: 6})
+	require.NoError(t, err)
+
+	err = s.HandleUserDeleted(context.Background(), users.UserDeleted{EventMetadata: users.EventMetadata{UserID: 5}})
+	require.NoError(t, err)
+
+	_, err = s.AuthenticateAPIKey(context.Background(), key)
+	require.Equal(t, auth.ErrInvalidAPIKey, err)
+	_, err = s.AuthenticateAPIKey(context.Background(), other)
+	require.NoError(t, err)
+}
+
+func TestService_HandleStatusChanged(t *testing.T) {
+	var tests = []struct {
+		name          string
+		event         users.Event
+		expectedError error
+	}{
+		{
+			name:          "Ok - Suspended",
+			event:         users.StatusChanged{EventMetadata: users.EventMetadata{UserID: 5}, From: users.StatusActive, To: users.StatusSuspended},
+			expectedError: auth.ErrInvalidAPIKey,
+		},
+		{
+			name:  "Ok - Activated",
+			event: users.StatusChanged{EventMetadata: users.EventMetadata{UserID: 5}, From: users.StatusPending, To: users.StatusActive},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := &RepositoryMock{}
+			s := newTestService(repository, time.Unix(123456, 0))
+			_, key, err := s.Create(context.Background(), APIKey{Name: "billing", Scopes: "users:read", CreatedBy: 5})
+			require.NoError(t, err)
+
+			err = s.HandleStatusChanged(context.Background(), tt.event)
+			require.NoError(t, err)
+
+			_, err = s.AuthenticateAPIKey(context.Background(), key)
+			require.Equal(t, tt.expectedError, err)
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package mfa
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+)
+
+// ErrInvalidEncryptionKey encryption key not hex encoded 32 bytes error
+var ErrInvalidEncryptionKey = errors.New("invalid mfa encryption key. key must be 32 hex encoded bytes")
+
+// secretCipher encrypts TOTP secrets at rest with AES-256-GCM. Ciphertexts are prefixed with their
+// random nonce.
+type secretCipher struct {
+	aead cipher.AEAD
+}
+
+func newSecretCipher(hexKey string) (secretCipher, error) {
+	key, err := hex.DecodeString(hexKey)
+	if err != nil || len(key) != 32 {
+		return secretCipher{}, ErrInvalidEncryptionKey
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return secretCipher{}, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return secretCipher{}, err
+	}
+
+	return secretCipher{aead: aead}, nil
+}
+
+func (c secretCipher) encrypt(plaintext string) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.aead.Seal(nonce, nonce, []byte(plaintext), nil), nil
+}
+
+func (c secretCipher) decrypt(ciphertext []byte) (string, error) {
+	size := c.aead.NonceSize()
+	if len(ciphertext) < size {
+		return "", errors.New("mfa secret ciphertext too short")
+	}
+
+	plaintext, err := c.aead.Open(nil, ciphertext[:size], ciphertext[size:], nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
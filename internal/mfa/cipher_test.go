@@ -0,0 +1,45 @@
+package mfa
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const _testEncryptionKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+func TestSecretCipher(t *testing.T) {
+	cipher, err := newSecretCipher(_testEncryptionKey)
+	require.NoError(t, err)
+
+	ciphertext, err := cipher.encrypt("JBSWY3DPEHPK3PXP")
+	require.NoError(t, err)
+	require.NotContains(t, string(ciphertext), "JBSWY3DPEHPK3PXP")
+
+	plaintext, err := cipher.decrypt(ciphertext)
+	require.NoError(t, err)
+	require.Equal(t, "JBSWY3DPEHPK3PXP", plaintext)
+
+	ciphertext[len(ciphertext)-1] ^= 1
+	_, err = cipher.decrypt(ciphertext)
+	require.Error(t, err)
+}
+
+func TestNewSecretCipher(t *testing.T) {
+	var tests = []struct {
+		name          string
+		key           string
+		expectedError error
+	}{
+		{name: "Ok", key: _testEncryptionKey},
+		{name: "Fail - Not hex", key: "not-a-hex-key", expectedError: ErrInvalidEncryptionKey},
+		{name: "Fail - Too short", key: "000102030405060708090a0b0c0d0e0f", expectedError: ErrInvalidEncryptionKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newSecretCipher(tt.key)
+			require.Equal(t, tt.expectedError, err)
+		})
+	}
+}
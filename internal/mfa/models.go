@@ -0,0 +1,64 @@
+package mfa
+
+type CodeRequest struct {
+	Code string `json:"code"`
+}
+
+type EnrollResponse struct {
+	// Secret is the base32 encoded TOTP secret, for authenticator apps that can't scan URI.
+	Secret string `json:"secret"`
+	// URI is the otpauth:// key URI, usually shown as a QR code.
+	URI string `json:"otpauth_uri"`
+}
+
+type RecoveryCodesResponse struct {
+	// RecoveryCodes can each be used once instead of a TOTP code. They're only shown once.
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// Enrollment is the TOTP secret of a user. It's pending until confirmed with a code, proving the
+// user added it to an authenticator app, and only confirmed enrollments are required on login.
+type Enrollment struct {
+	UserID      int    `gorm:"column:user_id;primaryKey;autoIncrement:false"`
+	Secret      []byte `gorm:"column:secret;size:128"`
+	Confirmed   bool   `gorm:"column:confirmed"`
+	ConfirmedAt int64  `gorm:"column:confirmed_at"`
+	// LastUsedStep is the time step of the last accepted code, so codes can't be replayed within
+	// their validity window.
+	LastUsedStep int64 `gorm:"column:last_used_step"`
+	CreatedAt    int64 `gorm:"column:created_at"`
+}
+
+func (Enrollment) TableName() string {
+	return "mfa_enrollments"
+}
+
+// RecoveryCode is a single use code replacing a TOTP code, for users who lost their authenticator.
+// Only the SHA-256 hash of the code is stored.
+type RecoveryCode struct {
+	ID        int    `gorm:"column:id;primaryKey"`
+	UserID    int    `gorm:"column:user_id;index"`
+	CodeHash  string `gorm:"column:code_hash;size:64"`
+	UsedAt    int64  `gorm:"column:used_at"`
+	CreatedAt int64  `gorm:"column:created_at"`
+}
+
+func (RecoveryCode) TableName() string {
+	return "mfa_recovery_codes"
+}
+
+// Challenge is the second step of a login of a user enrolled in MFA, started when the password is
+// verified. It counts the codes sent for it, so it can't be used to guess codes without limit,
+// and it's used up by the first valid one.
+type Challenge struct {
+	ID        string `gorm:"column:id;primaryKey;size:32"`
+	UserID    int    `gorm:"column:user_id;index"`
+	Attempts  int    `gorm:"column:attempts"`
+	UsedAt    int64  `gorm:"column:used_at"`
+	ExpiresAt int64  `gorm:"column:expires_at"`
+	CreatedAt int64  `gorm:"column:created_at"`
+}
+
+func (Challenge) TableName() string {
+	return "mfa_challenges"
+}
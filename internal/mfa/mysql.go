@@ -0,0 +1,151 @@
+package mfa
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type MySQL struct {
+	DB *gorm.DB
+}
+
+// NewMySQL returns the MFA repository over an existing connection, usually the one opened by
+// users.NewMySQL.
+func NewMySQL(db *gorm.DB) MySQL {
+	return MySQL{
+		DB: db,
+	}
+}
+
+func (repository MySQL) GetEnrollment(ctx context.Context, userID int) (Enrollment, error) {
+	var enrollment Enrollment
+	err := repository.DB.WithContext(ctx).Where("user_id = ?", userID).Take(&enrollment).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return Enrollment{}, ErrNotEnrolled
+		}
+		return Enrollment{}, err
+	}
+
+	return enrollment, nil
+}
+
+// SaveEnrollment creates enrollment, replacing the pending enrollment of the user if any.
+func (repository MySQL) SaveEnrollment(ctx context.Context, enrollment Enrollment) error {
+	return repository.DB.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&enrollment).Error
+}
+
+func (repository MySQL) ConfirmEnrollment(ctx context.Context, enrollment Enrollment, codes []RecoveryCode) error {
+	return repository.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Enrollment{}).Where("user_id = ?", enrollment.UserID).UpdateColumns(map[string]interface{}{
+			"confirmed":      true,
+			"confirmed_at":   enrollment.ConfirmedAt,
+			"last_used_step": enrollment.LastUsedStep,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("user_id = ?", enrollment.UserID).Delete(&RecoveryCode{}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(&codes).Error
+	})
+}
+
+// UseStep records step as the last used by the user, unless a code of that or a later step was
+// already used, which returns ErrCodeAlreadyUsed. The check and the update are a single statement,
+// so concurrent logins can't both use the same code.
+func (repository MySQL) UseStep(ctx context.Context, userID int, step int64) error {
+	result := repository.DB.WithContext(ctx).Model(&Enrollment{}).
+		Where("user_id = ? AND last_used_step < ?", userID, step).
+		UpdateColumn("last_used_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrCodeAlreadyUsed
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks the unused recovery code of the user with codeHash as used, or returns
+// ErrInvalidCode if there's none.
+func (repository MySQL) UseRecoveryCode(ctx context.Context, userID int, codeHash string) error {
+	result := repository.DB.WithContext(ctx).Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at = 0", userID, codeHash).
+		UpdateColumn("used_at", time.Now().Unix())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidCode
+	}
+
+	return nil
+}
+
+// DeleteEnrollment deletes the enrollment and recovery codes of the user.
+func (repository MySQL) DeleteEnrollment(ctx context.Context, userID int) error {
+	return repository.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Where("user_id = ?", userID).Delete(&Enrollment{}).Error
+	})
+}
+
+func (repository MySQL) CreateChallenge(ctx context.Context, challenge Challenge) error {
+	return repository.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("user_id = ? AND expires_at <= ?", challenge.UserID, challenge.CreatedAt).
+			Delete(&Challenge{}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(&challenge).Error
+	})
+}
+
+// TakeChallengeAttempt checks and counts the attempt in a single statement, so concurrent requests
+// can't send more codes than maxAttempts.
+func (repository MySQL) TakeChallengeAttempt(ctx context.Context, id string, userID int, maxAttempts int, now int64) error {
+	result := repository.DB.WithContext(ctx).Model(&Challenge{}).
+		Where("id = ? AND user_id = ? AND used_at = 0 AND expires_at > ? AND attempts < ?", id, userID, now, maxAttempts).
+		UpdateColumn("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrChallengeInvalid
+	}
+
+	return nil
+}
+
+func (repository MySQL) UseChallenge(ctx context.Context, id string, now int64) error {
+	result := repository.DB.WithContext(ctx).Model(&Challenge{}).
+		Where("id = ? AND used_at = 0", id).
+		UpdateColumn("used_at", now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrChallengeInvalid
+	}
+
+	return nil
+}
+
+func (repository MySQL) AutoMigrate() error {
+	return repository.DB.AutoMigrate(&Enrollment{}, &RecoveryCode{}, &Challenge{})
+}
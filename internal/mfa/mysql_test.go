@@ -0,0 +1,74 @@
+package mfa
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestMySQL_UseStep(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	query := regexp.QuoteMeta("UPDATE `mfa_enrollments` SET `last_used_step`=? WHERE user_id = ? AND last_used_step < ?")
+	mock.ExpectBegin()
+	mock.ExpectExec(query).
+		WithArgs(37037037, 5, 37037037).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(query).
+		WithArgs(37037037, 5, 37037037).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	gormDB, err := gorm.Open(
+		mysql.New(mysql.Config{
+			Conn:                      db,
+			SkipInitializeWithVersion: true}),
+		&gorm.Config{})
+	require.NoError(t, err)
+
+	repo := NewMySQL(gormDB)
+	err = repo.UseStep(context.Background(), 5, 37037037)
+	require.NoError(t, err)
+
+	err = repo.UseStep(context.Background(), 5, 37037037)
+	require.Equal(t, ErrCodeAlreadyUsed, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMySQL_TakeChallengeAttempt(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	query := regexp.QuoteMeta("UPDATE `mfa_challenges` SET `attempts`=attempts + 1 WHERE id = ? AND user_id = ? AND used_at = 0 AND expires_at > ? AND attempts < ?")
+	mock.ExpectBegin()
+	mock.ExpectExec(query).
+		WithArgs("some-id", 5, 1111111111, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(query).
+		WithArgs("some-id", 5, 1111111111, 5).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	gormDB, err := gorm.Open(
+		mysql.New(mysql.Config{
+			Conn:                      db,
+			SkipInitializeWithVersion: true}),
+		&gorm.Config{})
+	require.NoError(t, err)
+
+	repo := NewMySQL(gormDB)
+	err = repo.TakeChallengeAttempt(context.Background(), "some-id", 5, 5, 1111111111)
+	require.NoError(t, err)
+
+	err = repo.TakeChallengeAttempt(context.Background(), "some-id", 5, 5, 1111111111)
+	require.Equal(t, ErrChallengeInvalid, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,276 @@
+package mfa
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/config"
+	"github.com/marcosstupnicki/go-users/internal/users"
+)
+
+const (
+	// _recoveryCodes is how many recovery codes are generated on each confirmation.
+	_recoveryCodes = 10
+	// _recoveryCodeSize is the size in bytes of each recovery code, 16 base32 characters.
+	_recoveryCodeSize = 10
+)
+
+var (
+	// ErrNotEnrolled user without a confirmed MFA enrollment error
+	ErrNotEnrolled = errors.New("mfa not enrolled")
+	// ErrAlreadyEnrolled user with a confirmed MFA enrollment error
+	ErrAlreadyEnrolled = errors.New("mfa already enrolled")
+	// ErrInvalidCode TOTP or recovery code not matching error
+	ErrInvalidCode = errors.New("invalid mfa code")
+	// ErrCodeAlreadyUsed TOTP code already used error
+	ErrCodeAlreadyUsed = errors.New("mfa code already used")
+	// ErrChallengeInvalid MFA challenge unknown, expired, used or out of attempts error
+	ErrChallengeInvalid = errors.New("invalid mfa challenge")
+)
+
+type Repository interface {
+	// GetEnrollment returns the enrollment of the user, pending or confirmed, or ErrNotEnrolled.
+	GetEnrollment(ctx context.Context, userID int) (Enrollment, error)
+	SaveEnrollment(ctx context.Context, enrollment Enrollment) error
+	// ConfirmEnrollment confirms enrollment, replacing the recovery codes of the user with codes.
+	ConfirmEnrollment(ctx context.Context, enrollment Enrollment, codes []RecoveryCode) error
+	UseStep(ctx context.Context, userID int, step int64) error
+	UseRecoveryCode(ctx context.Context, userID int, codeHash string) error
+	DeleteEnrollment(ctx context.Context, userID int) error
+	// CreateChallenge stores challenge, deleting the expired challenges of its user.
+	CreateChallenge(ctx context.Context, challenge Challenge) error
+	// TakeChallengeAttempt counts an attempt of the challenge with id of the user, or returns
+	// ErrChallengeInvalid if it's unknown, used, expired at now or has maxAttempts already.
+	TakeChallengeAttempt(ctx context.Context, id string, userID int, maxAttempts int, now int64) error
+	// UseChallenge marks the challenge with id as used, or returns ErrChallengeInvalid if it was
+	// already.
+	UseChallenge(ctx context.Context, id string, now int64) error
+}
+
+// Service manages TOTP (RFC 6238) second factors. Users enroll a secret, confirm it with a code,
+// and from then on are asked for a code, or one of their recovery codes, on login.
+type Service struct {
+	repository Repository
+	cipher     secretCipher
+	issuer     string
+	// maxAttempts is how many codes can be sent for a login challenge.
+	maxAttempts int
+	now         func() time.Time
+}
+
+func NewService(repository Repository, cfg config.MFA) (Service, error) {
+	cipher, err := newSecretCipher(cfg.EncryptionKey)
+	if err != nil {
+		return Service{}, err
+	}
+
+	return Service{
+		repository:  repository,
+		cipher:      cipher,
+		issuer:      cfg.Issuer,
+		maxAttempts: cfg.ChallengeMaxAttempts,
+		now:         time.Now,
+	}, nil
+}
+
+// Enroll generates a new TOTP secret for the user, identified as account in authenticator apps.
+// It replaces any pending enrollment, and must be confirmed before it's required on login.
+func (s Service) Enroll(ctx context.Context, userID int, account string) (EnrollResponse, error) {
+	current, err := s.repository.GetEnrollment(ctx, userID)
+	if err != nil && err != ErrNotEnrolled {
+		return EnrollResponse{}, err
+	}
+	if err == nil && current.Confirmed {
+		return EnrollResponse{}, ErrAlreadyEnrolled
+	}
+
+	secret, err := generateSecret()
+	if err != nil {
+		return EnrollResponse{}, err
+	}
+	encrypted, err := s.cipher.encrypt(secret)
+	if err != nil {
+		return EnrollResponse{}, err
+	}
+
+	err = s.repository.SaveEnrollment(ctx, Enrollment{
+		UserID:    userID,
+		Secret:    encrypted,
+		CreatedAt: s.now().Unix(),
+	})
+	if err != nil {
+		return EnrollResponse{}, err
+	}
+
+	return EnrollResponse{
+		Secret: secret,
+		URI:    keyURI(s.issuer, account, secret),
+	}, nil
+}
+
+// Confirm confirms the pending enrollment of the user with a code of its secret, and returns its
+// recovery codes. They're stored hashed, so this is the only time they're available.
+func (s Service) Confirm(ctx context.Context, userID int, code string) ([]string, error) {
+	enrollment, err := s.repository.GetEnrollment(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if enrollment.Confirmed {
+		return nil, ErrAlreadyEnrolled
+	}
+
+	step, err := s.validate(enrollment, code)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, codes, err := s.generateRecoveryCodes(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	enrollment.ConfirmedAt = s.now().Unix()
+	enrollment.LastUsedStep = step
+	err = s.repository.ConfirmEnrollment(ctx, enrollment, codes)
+	if err != nil {
+		return nil, err
+	}
+
+	return plain, nil
+}
+
+// Enabled returns whether the user has a confirmed enrollment, and so must send a code on login.
+func (s Service) Enabled(ctx context.Context, userID int) (bool, error) {
+	enrollment, err := s.repository.GetEnrollment(ctx, userID)
+	if err != nil {
+		if err == ErrNotEnrolled {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return enrollment.Confirmed, nil
+}
+
+// Verify returns nil if code is a TOTP code of the user not used before, or one of its unused
+// recovery codes, which is then used up.
+func (s Service) Verify(ctx context.Context, userID int, code string) error {
+	enrollment, err := s.repository.GetEnrollment(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !enrollment.Confirmed {
+		return ErrNotEnrolled
+	}
+
+	if len(code) != _totpDigits {
+		return s.repository.UseRecoveryCode(ctx, userID, hashRecoveryCode(code))
+	}
+
+	step, err := s.validate(enrollment, code)
+	if err != nil {
+		return err
+	}
+
+	return s.repository.UseStep(ctx, userID, step)
+}
+
+// StartChallenge records the login challenge with id challengeID of the user, valid until
+// expiresAt, so the codes sent for it can be counted.
+func (s Service) StartChallenge(ctx context.Context, challengeID string, userID int, expiresAt time.Time) error {
+	return s.repository.CreateChallenge(ctx, Challenge{
+		ID:        challengeID,
+		UserID:    userID,
+		ExpiresAt: expiresAt.Unix(),
+		CreatedAt: s.now().Unix(),
+	})
+}
+
+// VerifyChallenge verifies code as Verify for the login challenge with id challengeID of the user.
+// Every code sent takes one of the attempts of the challenge, and a valid one uses it up: once
+// it's used, expired or out of attempts, ErrChallengeInvalid is returned without checking code.
+func (s Service) VerifyChallenge(ctx context.Context, challengeID string, userID int, code string) error {
+	err := s.repository.TakeChallengeAttempt(ctx, challengeID, userID, s.maxAttempts, s.now().Unix())
+	if err != nil {
+		return err
+	}
+
+	err = s.Verify(ctx, userID, code)
+	if err != nil {
+		return err
+	}
+
+	return s.repository.UseChallenge(ctx, challengeID, s.now().Unix())
+}
+
+// Disable deletes the enrollment and recovery codes of the user, after verifying code as Verify.
+func (s Service) Disable(ctx context.Context, userID int, code string) error {
+	err := s.Verify(ctx, userID, code)
+	if err != nil {
+		return err
+	}
+
+	return s.repository.DeleteEnrollment(ctx, userID)
+}
+
+// HandleUserDeleted deletes the enrollment of a deleted user. It's meant to be subscribed to the
+// users events bus for users.EventNameUserDeleted.
+func (s Service) HandleUserDeleted(ctx context.Context, event users.Event) error {
+	return s.repository.DeleteEnrollment(ctx, event.Metadata().UserID)
+}
+
+// validate returns the step of code if it's a TOTP code of the enrollment secret.
+func (s Service) validate(enrollment Enrollment, code string) (int64, error) {
+	secret, err := s.cipher.decrypt(enrollment.Secret)
+	if err != nil {
+		return 0, err
+	}
+
+	step, ok, err := validate(secret, code, s.now())
+	if err != nil {
+		return 0, err
+	}
+	if !ok || step <= enrollment.LastUsedStep {
+		return 0, ErrInvalidCode
+	}
+
+	return step, nil
+}
+
+// generateRecoveryCodes returns new recovery codes for the user, formatted for display and hashed
+// for storage.
+func (s Service) generateRecoveryCodes(userID int) ([]string, []RecoveryCode, error) {
+	plain := make([]string, 0, _recoveryCodes)
+	codes := make([]RecoveryCode, 0, _recoveryCodes)
+	for i := 0; i < _recoveryCodes; i++ {
+		raw := make([]byte, _recoveryCodeSize)
+		_, err := rand.Read(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		encoded := strings.ToLower(_base32.EncodeToString(raw))
+		code := encoded[0:4] + "-" + encoded[4:8] + "-" + encoded[8:12] + "-" + encoded[12:16]
+		plain = append(plain, code)
+		codes = append(codes, RecoveryCode{
+			UserID:    userID,
+			CodeHash:  hashRecoveryCode(code),
+			CreatedAt: s.now().Unix(),
+		})
+	}
+
+	return plain, codes, nil
+}
+
+// hashRecoveryCode returns the hex SHA-256 hash of code, ignoring case, spaces and dashes. Codes
+// are random, so a fast unsalted hash is enough to make them useless if the table leaks.
+func hashRecoveryCode(code string) string {
+	normalized := strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,318 @@
+package mfa
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/config"
+	"github.com/stretchr/testify/require"
+)
+
+// RepositoryMock is an in memory Repository.
+type RepositoryMock struct {
+	enrollments map[int]Enrollment
+	codes       map[int][]RecoveryCode
+	challenges  map[string]Challenge
+}
+
+func NewRepositoryMock() *RepositoryMock {
+	return &RepositoryMock{
+		enrollments: map[int]Enrollment{},
+		codes:       map[int][]RecoveryCode{},
+		challenges:  map[string]Challenge{},
+	}
+}
+
+func (r *RepositoryMock) GetEnrollment(_ context.Context, userID int) (Enrollment, error) {
+	enrollment, ok := r.enrollments[userID]
+	if !ok {
+		return Enrollment{}, ErrNotEnrolled
+	}
+	return enrollment, nil
+}
+
+func (r *RepositoryMock) SaveEnrollment(_ context.Context, enrollment Enrollment) error {
+	r.enrollments[enrollment.UserID] = enrollment
+	return nil
+}
+
+func (r *RepositoryMock) ConfirmEnrollment(_ context.Context, enrollment Enrollment, codes []RecoveryCode) error {
+	enrollment.Confirmed = true
+	r.enrollments[enrollment.UserID] = enrollment
+	r.codes[enrollment.UserID] = codes
+	return nil
+}
+
+func (r *RepositoryMock) UseStep(_ context.Context, userID int, step int64) error {
+	enrollment := r.enrollments[userID]
+	if enrollment.LastUsedStep >= step {
+		return ErrCodeAlreadyUsed
+	}
+	enrollment.LastUsedStep = step
+	r.enrollments[userID] = enrollment
+	return nil
+}
+
+func (r *RepositoryMock) UseRecoveryCode(_ context.Context, userID int, codeHash string) error {
+	for i, code := range r.codes[userID] {
+		if code.CodeHash == codeHash && code.UsedAt == 0 {
+			r.codes[userID][i].UsedAt = 1
+			return nil
+		}
+	}
+	return ErrInvalidCode
+}
+
+func (r *RepositoryMock) DeleteEnrollment(_ context.Context, userID int) error {
+	delete(r.enrollments, userID)
+	delete(r.codes, userID)
+	return nil
+}
+
+func (r *RepositoryMock) CreateChallenge(_ context.Context, challenge Challenge) error {
+	r.challenges[challenge.ID] = challenge
+	return nil
+}
+
+func (r *RepositoryMock) TakeChallengeAttempt(_ context.Context, id string, userID int, maxAttempts int, now int64) error {
+	challenge, ok := r.challenges[id]
+	if !ok || challenge.UserID != userID || challenge.UsedAt != 0 || challenge.ExpiresAt <= now ||
+		challenge.Attempts >= maxAttempts {
+		return ErrChallengeInvalid
+	}
+	challenge.Attempts++
+	r.challenges[id] = challenge
+	return nil
+}
+
+func (r *RepositoryMock) UseChallenge(_ context.Context, id string, now int64) error {
+	challenge := r.challenges[id]
+	if challenge.UsedAt != 0 {
+		return ErrChallengeInvalid
+	}
+	challenge.UsedAt = now
+	r.challenges[id] = challenge
+	return nil
+}
+
+func newTestService(t *testing.T, repository Repository, now time.Time) Service {
+	s, err := NewService(repository, config.MFA{
+		EncryptionKey:        _testEncryptionKey,
+		Issuer:               "go-users",
+		ChallengeMaxAttempts: 3,
+	})
+	require.NoError(t, err)
+	s.now = func() time.Time { return now }
+	return s
+}
+
+// enrollConfirmed enrolls and confirms the user with id userID, returning its secret and recovery
+// codes.
+func enrollConfirmed(t *testing.T, s Service, userID int) (string, []string) {
+	enrollment, err := s.Enroll(context.Background(), userID, "some@email.com")
+	require.NoError(t, err)
+	current, err := code(enrollment.Secret, step(s.now())-1)
+	require.NoError(t, err)
+	recoveryCodes, err := s.Confirm(context.Background(), userID, current)
+	require.NoError(t, err)
+	return enrollment.Secret, recoveryCodes
+}
+
+func TestService_Enroll(t *testing.T) {
+	repository := NewRepositoryMock()
+	s := newTestService(t, repository, time.Unix(1111111111, 0))
+
+	enrollment, err := s.Enroll(context.Background(), 5, "some@email.com")
+	require.NoError(t, err)
+	uri, err := url.Parse(enrollment.URI)
+	require.NoError(t, err)
+	require.Equal(t, enrollment.Secret, uri.Query().Get("secret"))
+	require.NotContains(t, string(repository.enrollments[5].Secret), enrollment.Secret)
+
+	enabled, err := s.Enabled(context.Background(), 5)
+	require.NoError(t, err)
+	require.False(t, enabled)
+
+	_, err = s.Confirm(context.Background(), 5, "000000")
+	require.Equal(t, ErrInvalidCode, err)
+
+	current, err := code(enrollment.Secret, step(s.now()))
+	require.NoError(t, err)
+	recoveryCodes, err := s.Confirm(context.Background(), 5, current)
+	require.NoError(t, err)
+	require.Len(t, recoveryCodes, _recoveryCodes)
+	require.NotEqual(t, recoveryCodes[0], repository.codes[5][0].CodeHash)
+
+	enabled, err = s.Enabled(context.Background(), 5)
+	require.NoError(t, err)
+	require.True(t, enabled)
+
+	_, err = s.Enroll(context.Background(), 5, "some@email.com")
+	require.Equal(t, ErrAlreadyEnrolled, err)
+}
+
+func TestService_Verify(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	s := newTestService(t, NewRepositoryMock(), now)
+	secret, recoveryCodes := enrollConfirmed(t, s, 5)
+	current, err := code(secret, step(now))
+	require.NoError(t, err)
+
+	var tests = []struct {
+		name          string
+		userID        int
+		code          string
+		expectedError error
+	}{
+		{
+			name:   "Ok - TOTP code",
+			userID: 5,
+			code:   current,
+		},
+		{
+			name:          "Fail - TOTP code replayed",
+			userID:        5,
+			code:          current,
+			expectedError: ErrInvalidCode,
+		},
+		{
+			name:   "Ok - Recovery code ignoring case and dashes",
+			userID: 5,
+			code:   strings.ToUpper(recoveryCodes[0][:4] + recoveryCodes[0][5:9] + " " + recoveryCodes[0][10:]),
+		},
+		{
+			name:          "Fail - Recovery code already used",
+			userID:        5,
+			code:          recoveryCodes[0],
+			expectedError: ErrInvalidCode,
+		},
+		{
+			name:          "Fail - Wrong code",
+			userID:        5,
+			code:          "not-a-code",
+			expectedError: ErrInvalidCode,
+		},
+		{
+			name:          "Fail - Not enrolled",
+			userID:        6,
+			code:          current,
+			expectedError: ErrNotEnrolled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.Verify(context.Background(), tt.userID, tt.code)
+			require.Equal(t, tt.expectedError, err)
+		})
+	}
+}
+
+func TestService_VerifyChallenge(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	expiresAt := now.Add(5 * time.Minute)
+	s := newTestService(t, NewRepositoryMock(), now)
+	secret, _ := enrollConfirmed(t, s, 5)
+	current, err := code(secret, step(now))
+	require.NoError(t, err)
+
+	var tests = []struct {
+		name          string
+		challenges    []Challenge
+		challengeID   string
+		userID        int
+		codes         []string
+		expectedError error
+	}{
+		{
+			name:        "Ok",
+			challenges:  []Challenge{{ID: "a", UserID: 5, ExpiresAt: expiresAt.Unix()}},
+			challengeID: "a",
+			userID:      5,
+			codes:       []string{"000000", current},
+		},
+		{
+			name:          "Fail - Wrong code",
+			challenges:    []Challenge{{ID: "b", UserID: 5, ExpiresAt: expiresAt.Unix()}},
+			challengeID:   "b",
+			userID:        5,
+			codes:         []string{"000000"},
+			expectedError: ErrInvalidCode,
+		},
+		{
+			name:          "Fail - Out of attempts",
+			challenges:    []Challenge{{ID: "c", UserID: 5, ExpiresAt: expiresAt.Unix()}},
+			challengeID:   "c",
+			userID:        5,
+			codes:         []string{"000000", "000000", "000000", current},
+			expectedError: ErrChallengeInvalid,
+		},
+		{
+			name:          "Fail - Already used",
+			challenges:    []Challenge{{ID: "d", UserID: 5, ExpiresAt: expiresAt.Unix(), UsedAt: now.Unix()}},
+			challengeID:   "d",
+			userID:        5,
+			codes:         []string{current},
+			expectedError: ErrChallengeInvalid,
+		},
+		{
+			name:          "Fail - Expired",
+			challenges:    []Challenge{{ID: "e", UserID: 5, ExpiresAt: now.Unix()}},
+			challengeID:   "e",
+			userID:        5,
+			codes:         []string{current},
+			expectedError: ErrChallengeInvalid,
+		},
+		{
+			name:          "Fail - Another user",
+			challenges:    []Challenge{{ID: "f", UserID: 6, ExpiresAt: expiresAt.Unix()}},
+			challengeID:   "f",
+			userID:        5,
+			codes:         []string{current},
+			expectedError: ErrChallengeInvalid,
+		},
+		{
+			name:          "Fail - Unknown",
+			challengeID:   "g",
+			userID:        5,
+			codes:         []string{current},
+			expectedError: ErrChallengeInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, challenge := range tt.challenges {
+				err := s.StartChallenge(context.Background(), challenge.ID, challenge.UserID, time.Unix(challenge.ExpiresAt, 0))
+				require.NoError(t, err)
+				if challenge.UsedAt != 0 {
+					err = s.repository.UseChallenge(context.Background(), challenge.ID, challenge.UsedAt)
+					require.NoError(t, err)
+				}
+			}
+
+			var err error
+			for _, code := range tt.codes {
+				err = s.VerifyChallenge(context.Background(), tt.challengeID, tt.userID, code)
+			}
+			require.Equal(t, tt.expectedError, err)
+		})
+	}
+}
+
+func TestService_Disable(t *testing.T) {
+	repository := NewRepositoryMock()
+	s := newTestService(t, repository, time.Unix(1111111111, 0))
+	_, recoveryCodes := enrollConfirmed(t, s, 5)
+
+	err := s.Disable(context.Background(), 5, "not-a-code")
+	require.Equal(t, ErrInvalidCode, err)
+
+	err = s.Disable(context.Background(), 5, recoveryCodes[1])
+	require.NoError(t, err)
+	require.Empty(t, repository.enrollments)
+	require.Empty(t, repository.codes)
+}
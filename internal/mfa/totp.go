@@ -0,0 +1,94 @@
+package mfa
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	// _totpPeriod, _totpDigits and the SHA-1 algorithm are the RFC 6238 defaults, the only
+	// parameters every authenticator app supports.
+	_totpPeriod = 30 * time.Second
+	_totpDigits = 6
+	// _totpSkew is how many periods before and after the current one are accepted, for clocks
+	// drifting apart and codes typed near the end of their period.
+	_totpSkew = 1
+	// _secretSize is the size in bytes of the generated secrets, the HMAC-SHA1 output size
+	// recommended by RFC 4226.
+	_secretSize = 20
+)
+
+var _base32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateSecret returns a random TOTP secret, base32 encoded as expected by authenticator apps.
+func generateSecret() (string, error) {
+	secret := make([]byte, _secretSize)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return _base32.EncodeToString(secret), nil
+}
+
+// keyURI returns the otpauth:// URI authenticator apps enroll secret with, usually shown as a QR
+// code.
+func keyURI(issuer string, account string, secret string) string {
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", issuer)
+	values.Set("algorithm", "SHA1")
+	values.Set("digits", fmt.Sprint(_totpDigits))
+	values.Set("period", fmt.Sprint(int(_totpPeriod.Seconds())))
+
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + values.Encode()
+}
+
+// step returns the TOTP time step of t.
+func step(t time.Time) int64 {
+	return t.Unix() / int64(_totpPeriod.Seconds())
+}
+
+// code returns the TOTP code of the base32 secret for step, as defined by RFC 4226.
+func code(secret string, step int64) (string, error) {
+	key, err := _base32.DecodeString(secret)
+	if err != nil {
+		return "", err
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", _totpDigits, value%1000000), nil
+}
+
+// validate returns the step the code of secret was generated for, within the allowed skew of now,
+// or false if it doesn't match any.
+func validate(secret string, candidate string, now time.Time) (int64, bool, error) {
+	current := step(now)
+	for s := current - _totpSkew; s <= current+_totpSkew; s++ {
+		expected, err := code(secret, s)
+		if err != nil {
+			return 0, false, err
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(candidate)) == 1 {
+			return s, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
@@ -0,0 +1,72 @@
+package mfa
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// _rfc6238Secret is the SHA-1 secret of the RFC 6238 test vectors.
+var _rfc6238Secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	// RFC 6238 appendix B vectors, truncated to 6 digits.
+	var tests = []struct {
+		name         string
+		time         time.Time
+		expectedCode string
+	}{
+		{name: "Ok - 59", time: time.Unix(59, 0), expectedCode: "287082"},
+		{name: "Ok - 1111111109", time: time.Unix(1111111109, 0), expectedCode: "081804"},
+		{name: "Ok - 1111111111", time: time.Unix(1111111111, 0), expectedCode: "050471"},
+		{name: "Ok - 1234567890", time: time.Unix(1234567890, 0), expectedCode: "005924"},
+		{name: "Ok - 2000000000", time: time.Unix(2000000000, 0), expectedCode: "279037"},
+		{name: "Ok - 20000000000", time: time.Unix(20000000000, 0), expectedCode: "353130"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := code(_rfc6238Secret, step(tt.time))
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedCode, code)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	var tests = []struct {
+		name         string
+		code         string
+		expectedStep int64
+		expectedOk   bool
+	}{
+		{name: "Ok - Current step", code: "050471", expectedStep: step(now), expectedOk: true},
+		{name: "Ok - Previous step", code: "081804", expectedStep: step(now) - 1, expectedOk: true},
+		{name: "Fail - Wrong code", code: "123456"},
+		{name: "Fail - Too old", code: "287082"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok, err := validate(_rfc6238Secret, tt.code, now)
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedOk, ok)
+			require.Equal(t, tt.expectedStep, step)
+		})
+	}
+}
+
+func TestKeyURI(t *testing.T) {
+	uri, err := url.Parse(keyURI("go-users", "some@email.com", "JBSWY3DPEHPK3PXP"))
+	require.NoError(t, err)
+	require.Equal(t, "otpauth", uri.Scheme)
+	require.Equal(t, "totp", uri.Host)
+	require.Equal(t, "/go-users:some@email.com", uri.Path)
+	require.Equal(t, "JBSWY3DPEHPK3PXP", uri.Query().Get("secret"))
+	require.Equal(t, "go-users", uri.Query().Get("issuer"))
+}
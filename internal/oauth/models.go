@@ -0,0 +1,185 @@
+package oauth
+
+import "strings"
+
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+
+	ResponseTypeCode = "code"
+
+	GrantTypeAuthorizationCode = "authorization_code"
+	GrantTypeRefreshToken      = "refresh_token"
+
+	// CodeChallengeMethodS256 is the only PKCE method supported: plain challenges would leak the
+	// verifier with the authorization request.
+	CodeChallengeMethodS256 = "S256"
+)
+
+// Scopes are every scope clients can request.
+var Scopes = []string{ScopeOpenID, ScopeProfile, ScopeEmail}
+
+type ClientRequest struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	// Public clients, like single page and mobile apps, can't keep a secret. They authenticate
+	// with PKCE only.
+	Public bool `json:"public"`
+}
+
+type ClientResponse struct {
+	ID           int      `json:"id"`
+	ClientID     string   `json:"client_id"`
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Public       bool     `json:"public"`
+	ClientSecret string   `json:"client_secret,omitempty"`
+	CreatedAt    int64    `json:"created_at"`
+}
+
+// AuthorizeRequest is the query of an authorization request (RFC 6749 section 4.1.1), with its
+// PKCE challenge (RFC 7636).
+type AuthorizeRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	// Prompt "none" redirects with login_required instead of showing the login form to users not
+	// logged in.
+	Prompt string
+}
+
+// TokenRequest is the form of a token request. The client credentials are taken from the
+// Authorization header when sent with HTTP basic authentication.
+type TokenRequest struct {
+	GrantType    string
+	ClientID     string
+	ClientSecret string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	RefreshToken string
+}
+
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	// ExpiresIn is the number of seconds the access token is valid for.
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// ErrorResponse is an error response of the token endpoint (RFC 6749 section 5.2).
+type ErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// Discovery is the OpenID provider metadata served on /.well-known/openid-configuration.
+type Discovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// Claims are the standard claims about the user, released with the profile and email scopes.
+type Claims struct {
+	Name        string `json:"name,omitempty"`
+	GivenName   string `json:"given_name,omitempty"`
+	FamilyName  string `json:"family_name,omitempty"`
+	Picture     string `json:"picture,omitempty"`
+	Locale      string `json:"locale,omitempty"`
+	Zoneinfo    string `json:"zoneinfo,omitempty"`
+	PhoneNumber string `json:"phone_number,omitempty"`
+	Email       string `json:"email,omitempty"`
+}
+
+// UserInfo is the response of the userinfo endpoint. Subject is the user id, as in ID tokens.
+type UserInfo struct {
+	Subject string `json:"sub"`
+	Claims
+}
+
+// Client is an application registered to log users in with the authorization code flow.
+// RedirectURIs holds the registered redirect URIs separated by spaces, which can't be part of a
+// URI. Only the SHA-256 hash of the secret of confidential clients is stored.
+type Client struct {
+	ID           int    `gorm:"column:id;primaryKey"`
+	ClientID     string `gorm:"column:client_id;size:32;uniqueIndex"`
+	Name         string `gorm:"column:name;size:100"`
+	RedirectURIs string `gorm:"column:redirect_uris;size:4096"`
+	Public       bool   `gorm:"column:public"`
+	SecretHash   string `gorm:"column:secret_hash;size:64"`
+	CreatedAt    int64  `gorm:"column:created_at"`
+}
+
+func (Client) TableName() string {
+	return "oauth_clients"
+}
+
+// RedirectURIList returns the registered redirect URIs.
+func (c Client) RedirectURIList() []string {
+	if c.RedirectURIs == "" {
+		return []string{}
+	}
+
+	return strings.Fields(c.RedirectURIs)
+}
+
+// AuthorizationCode is a single use code issued to a client for a user, to exchange for tokens.
+// Only the SHA-256 hash of the code is stored.
+type AuthorizationCode struct {
+	ID          int    `gorm:"column:id;primaryKey"`
+	CodeHash    string `gorm:"column:code_hash;size:64;uniqueIndex"`
+	ClientID    string `gorm:"column:client_id;size:32"`
+	UserID      int    `gorm:"column:user_id;index"`
+	RedirectURI string `gorm:"column:redirect_uri;size:2048"`
+	Scope       string `gorm:"column:scope;size:255"`
+	Nonce       string `gorm:"column:nonce;size:255"`
+	// CodeChallenge is the S256 PKCE challenge the code verifier must match.
+	CodeChallenge string `gorm:"column:code_challenge;size:128"`
+	// MFA and AuthTime are whether the session of the user passed a second factor, and when it
+	// was started.
+	MFA       bool  `gorm:"column:mfa"`
+	AuthTime  int64 `gorm:"column:auth_time"`
+	ExpiresAt int64 `gorm:"column:expires_at"`
+	UsedAt    int64 `gorm:"column:used_at"`
+	CreatedAt int64 `gorm:"column:created_at"`
+}
+
+func (AuthorizationCode) TableName() string {
+	return "oauth_authorization_codes"
+}
+
+// ScopeList returns the scopes the code was issued for.
+func (c AuthorizationCode) ScopeList() []string {
+	return strings.Fields(c.Scope)
+}
+
+// HasScope returns whether the code was issued for scope.
+func (c AuthorizationCode) HasScope(scope string) bool {
+	for _, s := range c.ScopeList() {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,119 @@
+package oauth
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+type MySQL struct {
+	DB *gorm.DB
+}
+
+// NewMySQL returns the OAuth repository over an existing connection, usually the one opened by
+// users.NewMySQL.
+func NewMySQL(db *gorm.DB) MySQL {
+	return MySQL{
+		DB: db,
+	}
+}
+
+func (repository MySQL) CreateClient(ctx context.Context, client Client) (Client, error) {
+	tx := repository.DB.WithContext(ctx).Create(&client)
+	if tx.Error != nil {
+		return Client{}, tx.Error
+	}
+
+	return client, nil
+}
+
+func (repository MySQL) GetClient(ctx context.Context, id int) (Client, error) {
+	client := Client{ID: id}
+	tx := repository.DB.WithContext(ctx).Limit(1).Find(&client)
+	if tx.Error != nil {
+		return Client{}, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return Client{}, ErrClientNotFound
+	}
+
+	return client, nil
+}
+
+func (repository MySQL) GetClientByClientID(ctx context.Context, clientID string) (Client, error) {
+	var client Client
+	tx := repository.DB.WithContext(ctx).Where("client_id = ?", clientID).Limit(1).Find(&client)
+	if tx.Error != nil {
+		return Client{}, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return Client{}, ErrClientNotFound
+	}
+
+	return client, nil
+}
+
+func (repository MySQL) ListClients(ctx context.Context) ([]Client, error) {
+	var clients []Client
+	tx := repository.DB.WithContext(ctx).Order("id").Find(&clients)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return clients, nil
+}
+
+// DeleteClient deletes the client with id and its authorization codes.
+func (repository MySQL) DeleteClient(ctx context.Context, id int) error {
+	return repository.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var client Client
+		result := tx.Where("id = ?", id).Limit(1).Find(&client)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrClientNotFound
+		}
+
+		err := tx.Where("client_id = ?", client.ClientID).Delete(&AuthorizationCode{}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Delete(&client).Error
+	})
+}
+
+func (repository MySQL) CreateAuthorizationCode(ctx context.Context, code AuthorizationCode) error {
+	return repository.DB.WithContext(ctx).Create(&code).Error
+}
+
+// UseAuthorizationCode marks the code with codeHash as used at now and returns it. If it doesn't
+// exist or was already used, e.g. by a concurrent exchange, it returns ErrInvalidGrant.
+func (repository MySQL) UseAuthorizationCode(ctx context.Context, codeHash string, now int64) (AuthorizationCode, error) {
+	var code AuthorizationCode
+	err := repository.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&AuthorizationCode{}).Where("code_hash = ? AND used_at = 0", codeHash).UpdateColumn("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidGrant
+		}
+
+		return tx.Where("code_hash = ?", codeHash).Take(&code).Error
+	})
+	if err != nil {
+		return AuthorizationCode{}, err
+	}
+
+	return code, nil
+}
+
+func (repository MySQL) DeleteUserAuthorizationCodes(ctx context.Context, userID int) error {
+	return repository.DB.WithContext(ctx).Where("user_id = ?", userID).Delete(&AuthorizationCode{}).Error
+}
+
+func (repository MySQL) AutoMigrate() error {
+	return repository.DB.AutoMigrate(&Client{}, &AuthorizationCode{})
+}
@@ -0,0 +1,48 @@
+package oauth
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestMySQL_UseAuthorizationCode(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	update := regexp.QuoteMeta("UPDATE `oauth_authorization_codes` SET `used_at`=? WHERE code_hash = ? AND used_at = 0")
+	mock.ExpectBegin()
+	mock.ExpectExec(update).
+		WithArgs(123456, "some-hash").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth_authorization_codes` WHERE code_hash = ? LIMIT 1")).
+		WithArgs("some-hash").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "code_hash", "client_id", "user_id", "used_at"}).
+			AddRow(1, "some-hash", "public", 5, 123456))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(update).
+		WithArgs(123456, "some-hash").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	gormDB, err := gorm.Open(
+		mysql.New(mysql.Config{
+			Conn:                      db,
+			SkipInitializeWithVersion: true}),
+		&gorm.Config{})
+	require.NoError(t, err)
+
+	repo := NewMySQL(gormDB)
+	code, err := repo.UseAuthorizationCode(context.Background(), "some-hash", 123456)
+	require.NoError(t, err)
+	require.Equal(t, AuthorizationCode{ID: 1, CodeHash: "some-hash", ClientID: "public", UserID: 5, UsedAt: 123456}, code)
+
+	_, err = repo.UseAuthorizationCode(context.Background(), "some-hash", 123456)
+	require.Equal(t, ErrInvalidGrant, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,488 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/marcosstupnicki/go-users/internal/platform/config"
+	"github.com/marcosstupnicki/go-users/internal/sessions"
+	"github.com/marcosstupnicki/go-users/internal/users"
+)
+
+const (
+	// _clientIDSize, _clientSecretSize and _codeSize are the sizes in bytes of the random client
+	// ids, client secrets and authorization codes.
+	_clientIDSize     = 16
+	_clientSecretSize = 32
+	_codeSize         = 32
+	// _maxNameLength, _maxRedirectURIsLength and _maxNonceLength are the sizes of their columns.
+	_maxNameLength         = 100
+	_maxRedirectURIsLength = 4096
+	_maxNonceLength        = 255
+	// _minVerifierLength and _maxVerifierLength bound PKCE code verifiers (RFC 7636 section 4.1).
+	_minVerifierLength = 43
+	_maxVerifierLength = 128
+)
+
+// Error is an error returned to clients with an error code of RFC 6749 section 4.1.2.1 or 5.2.
+type Error struct {
+	Code        string
+	Description string
+}
+
+func (e *Error) Error() string {
+	return e.Description
+}
+
+var (
+	// ErrClientNotFound oauth client not found error
+	ErrClientNotFound = errors.New("oauth client not found")
+	// ErrInvalidClientName client name empty or too long error
+	ErrInvalidClientName = errors.New("invalid client name. name is required and must be at most 100 characters")
+	// ErrInvalidRedirectURIs client registered without valid redirect URIs error
+	ErrInvalidRedirectURIs = errors.New("invalid redirect uris. at least one absolute uri without fragment is required, and http is only allowed for localhost")
+	// ErrInvalidIssuer configured issuer not an absolute http(s) URL error
+	ErrInvalidIssuer = errors.New("invalid oauth issuer. issuer must be an absolute http or https url without query or fragment")
+
+	// ErrInvalidClient unknown client or wrong client secret error
+	ErrInvalidClient = &Error{Code: "invalid_client", Description: "unknown client or invalid client credentials"}
+	// ErrInvalidRedirectURI redirect URI not registered for the client error
+	ErrInvalidRedirectURI = &Error{Code: "invalid_request", Description: "redirect_uri is not registered for the client"}
+	// ErrUnsupportedResponseType response type other than code error
+	ErrUnsupportedResponseType = &Error{Code: "unsupported_response_type", Description: "only the code response type is supported"}
+	// ErrInvalidScope unknown scope requested error
+	ErrInvalidScope = &Error{Code: "invalid_scope", Description: "unknown scope"}
+	// ErrInvalidNonce nonce too long error
+	ErrInvalidNonce = &Error{Code: "invalid_request", Description: "nonce must be at most 255 characters"}
+	// ErrCodeChallengeRequired authorization request without a S256 PKCE challenge error
+	ErrCodeChallengeRequired = &Error{Code: "invalid_request", Description: "a S256 code_challenge is required"}
+	// ErrLoginRequired authorization request of an unauthenticated user error
+	ErrLoginRequired = &Error{Code: "login_required", Description: "the user is not logged in"}
+	// ErrInvalidGrant authorization code unknown, expired, used, issued to another client or not
+	// matching the redirect URI or code verifier error
+	ErrInvalidGrant = &Error{Code: "invalid_grant", Description: "invalid, expired or used authorization code"}
+	// ErrUnsupportedGrantType grant type other than authorization_code or refresh_token error
+	ErrUnsupportedGrantType = &Error{Code: "unsupported_grant_type", Description: "only the authorization_code and refresh_token grant types are supported"}
+)
+
+type Repository interface {
+	CreateClient(ctx context.Context, client Client) (Client, error)
+	GetClient(ctx context.Context, id int) (Client, error)
+	GetClientByClientID(ctx context.Context, clientID string) (Client, error)
+	ListClients(ctx context.Context) ([]Client, error)
+	DeleteClient(ctx context.Context, id int) error
+	CreateAuthorizationCode(ctx context.Context, code AuthorizationCode) error
+	// UseAuthorizationCode marks the code with codeHash as used at now and returns it, or
+	// ErrInvalidGrant if it doesn't exist or was already used.
+	UseAuthorizationCode(ctx context.Context, codeHash string, now int64) (AuthorizationCode, error)
+	DeleteUserAuthorizationCodes(ctx context.Context, userID int) error
+}
+
+// Signer signs ID tokens with the keys published on the JWKS endpoint.
+type Signer interface {
+	Sign(claims jwt.Claims) (string, error)
+}
+
+// idTokenClaims are the claims of OpenID Connect ID tokens.
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce    string `json:"nonce,omitempty"`
+	AuthTime int64  `json:"auth_time"`
+	// AMR are the authentication methods of the session: the password, and the second factor if
+	// it passed one.
+	AMR []string `json:"amr"`
+	Claims
+}
+
+// Service is the OAuth 2.0 authorization server and OpenID Connect provider of first-party
+// clients: it manages the client registry, and issues authorization codes and ID tokens. Access
+// and refresh tokens are the ones of the login sessions.
+type Service struct {
+	repository Repository
+	signer     Signer
+	issuer     string
+	codeTTL    time.Duration
+	idTokenTTL time.Duration
+	now        func() time.Time
+}
+
+func NewService(repository Repository, signer Signer, cfg config.OAuth) (Service, error) {
+	issuer, err := url.Parse(cfg.Issuer)
+	if err != nil || (issuer.Scheme != "http" && issuer.Scheme != "https") || issuer.Host == "" || issuer.RawQuery != "" || issuer.Fragment != "" {
+		return Service{}, ErrInvalidIssuer
+	}
+
+	return Service{
+		repository: repository,
+		signer:     signer,
+		issuer:     strings.TrimSuffix(cfg.Issuer, "/"),
+		codeTTL:    cfg.AuthorizationCodeTTL,
+		idTokenTTL: cfg.IDTokenTTL,
+		now:        time.Now,
+	}, nil
+}
+
+// Create registers a client, generating its client id and, for confidential clients, its
+// secret. The secret is returned, it's the only time it's available.
+func (s Service) Create(ctx context.Context, client Client) (Client, string, error) {
+	err := validateClient(client)
+	if err != nil {
+		return Client{}, "", err
+	}
+
+	client.ClientID, err = generateToken(_clientIDSize)
+	if err != nil {
+		return Client{}, "", err
+	}
+
+	var secret string
+	if !client.Public {
+		secret, err = generateToken(_clientSecretSize)
+		if err != nil {
+			return Client{}, "", err
+		}
+		client.SecretHash = hashToken(secret)
+	}
+
+	client.CreatedAt = s.now().Unix()
+	client, err = s.repository.CreateClient(ctx, client)
+	if err != nil {
+		return Client{}, "", err
+	}
+
+	return client, secret, nil
+}
+
+func (s Service) Get(ctx context.Context, id int) (Client, error) {
+	return s.repository.GetClient(ctx, id)
+}
+
+func (s Service) List(ctx context.Context) ([]Client, error) {
+	return s.repository.ListClients(ctx)
+}
+
+// Delete deletes a client and its pending authorization codes. Tokens already issued to it are
+// valid until their session is revoked.
+func (s Service) Delete(ctx context.Context, id int) error {
+	return s.repository.DeleteClient(ctx, id)
+}
+
+// ValidateRedirect returns ErrInvalidClient or ErrInvalidRedirectURI unless redirectURI is
+// registered for the client with clientID. Authorization errors must not be redirected to
+// unregistered URIs, so this is checked before anything else.
+func (s Service) ValidateRedirect(ctx context.Context, clientID string, redirectURI string) error {
+	_, err := s.client(ctx, clientID, redirectURI)
+	return err
+}
+
+// Authorize issues an authorization code of the client of request to the user of session, and
+// returns it.
+func (s Service) Authorize(ctx context.Context, request AuthorizeRequest, session sessions.Session) (string, error) {
+	client, err := s.client(ctx, request.ClientID, request.RedirectURI)
+	if err != nil {
+		return "", err
+	}
+
+	if request.ResponseType != ResponseTypeCode {
+		return "", ErrUnsupportedResponseType
+	}
+
+	scope, err := normalizeScope(request.Scope)
+	if err != nil {
+		return "", err
+	}
+
+	if len(request.Nonce) > _maxNonceLength {
+		return "", ErrInvalidNonce
+	}
+
+	if request.CodeChallengeMethod != CodeChallengeMethodS256 || !validChallenge(request.CodeChallenge) {
+		return "", ErrCodeChallengeRequired
+	}
+
+	plain, err := generateToken(_codeSize)
+	if err != nil {
+		return "", err
+	}
+
+	now := s.now()
+	err = s.repository.CreateAuthorizationCode(ctx, AuthorizationCode{
+		CodeHash:      hashToken(plain),
+		ClientID:      client.ClientID,
+		UserID:        session.UserID,
+		RedirectURI:   request.RedirectURI,
+		Scope:         scope,
+		Nonce:         request.Nonce,
+		CodeChallenge: request.CodeChallenge,
+		MFA:           session.MFA,
+		AuthTime:      session.CreatedAt,
+		ExpiresAt:     now.Add(s.codeTTL).Unix(),
+		CreatedAt:     now.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return plain, nil
+}
+
+// Exchange authenticates the client of request and redeems its authorization code, returning
+// it. Codes can only be redeemed once, even when the exchange fails, and only with the redirect
+// URI and the PKCE code verifier they were issued for.
+func (s Service) Exchange(ctx context.Context, request TokenRequest) (AuthorizationCode, error) {
+	client, err := s.AuthenticateClient(ctx, request.ClientID, request.ClientSecret)
+	if err != nil {
+		return AuthorizationCode{}, err
+	}
+
+	if request.Code == "" {
+		return AuthorizationCode{}, ErrInvalidGrant
+	}
+
+	code, err := s.repository.UseAuthorizationCode(ctx, hashToken(request.Code), s.now().Unix())
+	if err != nil {
+		return AuthorizationCode{}, err
+	}
+
+	if code.ClientID != client.ClientID || code.ExpiresAt <= s.now().Unix() || code.RedirectURI != request.RedirectURI {
+		return AuthorizationCode{}, ErrInvalidGrant
+	}
+
+	if !verifyChallenge(code.CodeChallenge, request.CodeVerifier) {
+		return AuthorizationCode{}, ErrInvalidGrant
+	}
+
+	return code, nil
+}
+
+// AuthenticateClient returns the client with clientID. Confidential clients must send their
+// secret, public clients are only identified.
+func (s Service) AuthenticateClient(ctx context.Context, clientID string, secret string) (Client, error) {
+	client, err := s.repository.GetClientByClientID(ctx, clientID)
+	if err != nil {
+		if err == ErrClientNotFound {
+			return Client{}, ErrInvalidClient
+		}
+		return Client{}, err
+	}
+
+	if !client.Public && subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return Client{}, ErrInvalidClient
+	}
+
+	return client, nil
+}
+
+// IDToken returns the ID token of the user code was issued to, with the claims of its scopes.
+func (s Service) IDToken(code AuthorizationCode, user users.User) (string, error) {
+	now := s.now()
+	amr := []string{"pwd"}
+	if code.MFA {
+		amr = append(amr, "otp")
+	}
+
+	return s.signer.Sign(idTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.issuer,
+			Subject:   strconv.Itoa(user.ID),
+			Audience:  jwt.ClaimStrings{code.ClientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.idTokenTTL)),
+		},
+		Nonce:    code.Nonce,
+		AuthTime: code.AuthTime,
+		AMR:      amr,
+		Claims:   buildClaims(user, code.ScopeList()),
+	})
+}
+
+// UserInfo returns the claims of the user for the userinfo endpoint. Clients are first-party, so
+// every claim is returned whatever scopes the access token was issued for.
+func (s Service) UserInfo(user users.User) UserInfo {
+	return UserInfo{
+		Subject: strconv.Itoa(user.ID),
+		Claims:  buildClaims(user, Scopes),
+	}
+}
+
+// Discovery returns the OpenID provider metadata.
+func (s Service) Discovery() Discovery {
+	return Discovery{
+		Issuer:                            s.issuer,
+		AuthorizationEndpoint:             s.issuer + "/oauth/authorize",
+		TokenEndpoint:                     s.issuer + "/oauth/token",
+		UserInfoEndpoint:                  s.issuer + "/oauth/userinfo",
+		JWKSURI:                           s.issuer + "/.well-known/jwks.json",
+		ScopesSupported:                   Scopes,
+		ResponseTypesSupported:            []string{ResponseTypeCode},
+		GrantTypesSupported:               []string{GrantTypeAuthorizationCode, GrantTypeRefreshToken},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{"RS256"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{CodeChallengeMethodS256},
+		ClaimsSupported: []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "amr",
+			"name", "given_name", "family_name", "picture", "locale", "zoneinfo", "phone_number", "email"},
+	}
+}
+
+// HandleUserDeleted deletes the pending authorization codes of a deleted user. It's meant to be
+// subscribed to the users events bus for users.EventNameUserDeleted.
+func (s Service) HandleUserDeleted(ctx context.Context, event users.Event) error {
+	return s.repository.DeleteUserAuthorizationCodes(ctx, event.Metadata().UserID)
+}
+
+// client returns the client with clientID if redirectURI is registered for it.
+func (s Service) client(ctx context.Context, clientID string, redirectURI string) (Client, error) {
+	client, err := s.repository.GetClientByClientID(ctx, clientID)
+	if err != nil {
+		if err == ErrClientNotFound {
+			return Client{}, ErrInvalidClient
+		}
+		return Client{}, err
+	}
+
+	for _, registered := range client.RedirectURIList() {
+		if registered == redirectURI {
+			return client, nil
+		}
+	}
+
+	return Client{}, ErrInvalidRedirectURI
+}
+
+func validateClient(client Client) error {
+	name := strings.TrimSpace(client.Name)
+	if name == "" || len(name) > _maxNameLength {
+		return ErrInvalidClientName
+	}
+
+	redirectURIs := client.RedirectURIList()
+	if len(redirectURIs) == 0 || len(client.RedirectURIs) > _maxRedirectURIsLength {
+		return ErrInvalidRedirectURIs
+	}
+
+	for _, redirectURI := range redirectURIs {
+		if !validRedirectURI(redirectURI) {
+			return ErrInvalidRedirectURIs
+		}
+	}
+
+	return nil
+}
+
+// validRedirectURI returns whether uri can be registered as a redirect URI: https, http on the
+// loopback interface, or the private-use scheme of a native app (RFC 8252 section 7).
+func validRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || u.Fragment != "" {
+		return false
+	}
+
+	switch u.Scheme {
+	case "https":
+		return u.Host != ""
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	default:
+		// Private-use schemes are reverse domain names, like com.example.app.
+		return strings.Contains(u.Scheme, ".")
+	}
+}
+
+// normalizeScope returns the space separated scopes of scope, without duplicates, or
+// ErrInvalidScope if any is unknown.
+func normalizeScope(scope string) (string, error) {
+	var scopes []string
+	for _, requested := range strings.Fields(scope) {
+		known := false
+		for _, s := range Scopes {
+			if s == requested {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return "", ErrInvalidScope
+		}
+
+		duplicated := false
+		for _, s := range scopes {
+			if s == requested {
+				duplicated = true
+				break
+			}
+		}
+		if !duplicated {
+			scopes = append(scopes, requested)
+		}
+	}
+
+	return strings.Join(scopes, " "), nil
+}
+
+// validChallenge returns whether challenge is a base64url encoded SHA-256 hash.
+func validChallenge(challenge string) bool {
+	b, err := base64.RawURLEncoding.DecodeString(challenge)
+	return err == nil && len(b) == sha256.Size
+}
+
+// verifyChallenge returns whether verifier is the PKCE code verifier of the S256 challenge.
+func verifyChallenge(challenge string, verifier string) bool {
+	if len(verifier) < _minVerifierLength || len(verifier) > _maxVerifierLength {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+// buildClaims returns the claims of user released for scopes.
+func buildClaims(user users.User, scopes []string) Claims {
+	var claims Claims
+	for _, scope := range scopes {
+		switch scope {
+		case ScopeProfile:
+			claims.Name = user.DisplayName
+			if claims.Name == "" {
+				claims.Name = strings.TrimSpace(user.FirstName + " " + user.LastName)
+			}
+			claims.GivenName = user.FirstName
+			claims.FamilyName = user.LastName
+			claims.Picture = user.AvatarURL
+			claims.Locale = user.Locale
+			claims.Zoneinfo = user.Timezone
+			claims.PhoneNumber = user.Phone
+		case ScopeEmail:
+			claims.Email = user.Email
+		}
+	}
+
+	return claims
+}
+
+func generateToken(size int) (string, error) {
+	b := make([]byte, size)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
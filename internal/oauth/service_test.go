@@ -0,0 +1,492 @@
+package oauth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/marcosstupnicki/go-users/internal/platform/config"
+	"github.com/marcosstupnicki/go-users/internal/sessions"
+	"github.com/marcosstupnicki/go-users/internal/users"
+	"github.com/stretchr/testify/require"
+)
+
+// The PKCE example of RFC 7636 appendix B.
+const (
+	_testVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	_testChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+// RepositoryMock is an in memory Repository.
+type RepositoryMock struct {
+	clients []Client
+	codes   map[string]AuthorizationCode
+}
+
+func NewRepositoryMock(clients ...Client) *RepositoryMock {
+	return &RepositoryMock{
+		clients: clients,
+		codes:   map[string]AuthorizationCode{},
+	}
+}
+
+func (r *RepositoryMock) CreateClient(_ context.Context, client Client) (Client, error) {
+	client.ID = len(r.clients) + 1
+	r.clients = append(r.clients, client)
+	return client, nil
+}
+
+func (r *RepositoryMock) GetClient(_ context.Context, id int) (Client, error) {
+	for _, client := range r.clients {
+		if client.ID == id {
+			return client, nil
+		}
+	}
+	return Client{}, ErrClientNotFound
+}
+
+func (r *RepositoryMock) GetClientByClientID(_ context.Context, clientID string) (Client, error) {
+	for _, client := range r.clients {
+		if client.ClientID == clientID {
+			return client, nil
+		}
+	}
+	return Client{}, ErrClientNotFound
+}
+
+func (r *RepositoryMock) ListClients(_ context.Context) ([]Client, error) {
+	return r.clients, nil
+}
+
+func (r *RepositoryMock) DeleteClient(_ context.Context, id int) error {
+	for i, client := range r.clients {
+		if client.ID == id {
+			r.clients = append(r.clients[:i], r.clients[i+1:]...)
+			return nil
+		}
+	}
+	return ErrClientNotFound
+}
+
+func (r *RepositoryMock) CreateAuthorizationCode(_ context.Context, code AuthorizationCode) error {
+	r.codes[code.CodeHash] = code
+	return nil
+}
+
+func (r *RepositoryMock) UseAuthorizationCode(_ context.Context, codeHash string, now int64) (AuthorizationCode, error) {
+	code, ok := r.codes[codeHash]
+	if !ok || code.UsedAt != 0 {
+		return AuthorizationCode{}, ErrInvalidGrant
+	}
+	code.UsedAt = now
+	r.codes[codeHash] = code
+	return code, nil
+}
+
+func (r *RepositoryMock) DeleteUserAuthorizationCodes(_ context.Context, userID int) error {
+	for hash, code := range r.codes {
+		if code.UserID == userID {
+			delete(r.codes, hash)
+		}
+	}
+	return nil
+}
+
+// SignerMock keeps the claims it signs.
+type SignerMock struct {
+	claims jwt.Claims
+}
+
+func (s *SignerMock) Sign(claims jwt.Claims) (string, error) {
+	s.claims = claims
+	return "some-id-token", nil
+}
+
+var (
+	_testConfidentialClient = Client{ID: 1, ClientID: "confidential", Name: "Web", RedirectURIs: "https://app.example.com/callback", SecretHash: hashToken("some-secret")}
+	_testPublicClient       = Client{ID: 2, ClientID: "public", Name: "Mobile", RedirectURIs: "com.example.app:/callback http://127.0.0.1/callback", Public: true}
+)
+
+func newTestService(t *testing.T, repository Repository, signer Signer, now time.Time) Service {
+	s, err := NewService(repository, signer, config.OAuth{
+		Issuer:               "https://id.example.com/",
+		AuthorizationCodeTTL: time.Minute,
+		IDTokenTTL:           15 * time.Minute,
+	})
+	require.NoError(t, err)
+	s.now = func() time.Time { return now }
+	return s
+}
+
+func newTestAuthorizeRequest(clientID string, redirectURI string) AuthorizeRequest {
+	return AuthorizeRequest{
+		ResponseType:        ResponseTypeCode,
+		ClientID:            clientID,
+		RedirectURI:         redirectURI,
+		Scope:               "openid email openid",
+		State:               "some-state",
+		Nonce:               "some-nonce",
+		CodeChallenge:       _testChallenge,
+		CodeChallengeMethod: CodeChallengeMethodS256,
+	}
+}
+
+func TestNewService(t *testing.T) {
+	var tests = []struct {
+		name   string
+		issuer string
+		err    error
+	}{
+		{name: "Success - https issuer", issuer: "https://id.example.com"},
+		{name: "Success - http issuer with path", issuer: "http://localhost:8080/auth"},
+		{name: "Error - relative issuer", issuer: "id.example.com", err: ErrInvalidIssuer},
+		{name: "Error - issuer with query", issuer: "https://id.example.com?tenant=1", err: ErrInvalidIssuer},
+		{name: "Error - empty issuer", issuer: "", err: ErrInvalidIssuer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewService(NewRepositoryMock(), &SignerMock{}, config.OAuth{Issuer: tt.issuer})
+			require.Equal(t, tt.err, err)
+		})
+	}
+}
+
+func TestService_Create(t *testing.T) {
+	var tests = []struct {
+		name   string
+		client Client
+		secret bool
+		err    error
+	}{
+		{
+			name:   "Success - confidential client gets a secret",
+			client: Client{Name: "Web", RedirectURIs: "https://app.example.com/callback"},
+			secret: true,
+		},
+		{
+			name:   "Success - public native client",
+			client: Client{Name: "Mobile", RedirectURIs: "com.example.app:/callback http://localhost:8000/callback", Public: true},
+		},
+		{
+			name:   "Error - name required",
+			client: Client{Name: " ", RedirectURIs: "https://app.example.com/callback"},
+			err:    ErrInvalidClientName,
+		},
+		{
+			name:   "Error - redirect uri required",
+			client: Client{Name: "Web"},
+			err:    ErrInvalidRedirectURIs,
+		},
+		{
+			name:   "Error - http redirect uri not on localhost",
+			client: Client{Name: "Web", RedirectURIs: "http://app.example.com/callback"},
+			err:    ErrInvalidRedirectURIs,
+		},
+		{
+			name:   "Error - redirect uri with fragment",
+			client: Client{Name: "Web", RedirectURIs: "https://app.example.com/callback#token"},
+			err:    ErrInvalidRedirectURIs,
+		},
+		{
+			name:   "Error - relative redirect uri",
+			client: Client{Name: "Web", RedirectURIs: "/callback"},
+			err:    ErrInvalidRedirectURIs,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := NewRepositoryMock()
+			s := newTestService(t, repository, &SignerMock{}, time.Unix(123456, 0))
+
+			client, secret, err := s.Create(context.Background(), tt.client)
+			require.Equal(t, tt.err, err)
+			if tt.err != nil {
+				return
+			}
+			require.Len(t, client.ClientID, 2*_clientIDSize)
+			require.Equal(t, int64(123456), client.CreatedAt)
+			if !tt.secret {
+				require.Empty(t, secret)
+				require.Empty(t, client.SecretHash)
+				return
+			}
+			require.Len(t, secret, 2*_clientSecretSize)
+			require.Equal(t, hashToken(secret), repository.clients[0].SecretHash)
+		})
+	}
+}
+
+func TestService_Authorize(t *testing.T) {
+	var tests = []struct {
+		name    string
+		request func() AuthorizeRequest
+		err     error
+	}{
+		{
+			name: "Success",
+			request: func() AuthorizeRequest {
+				return newTestAuthorizeRequest("confidential", "https://app.example.com/callback")
+			},
+		},
+		{
+			name: "Error - unknown client",
+			request: func() AuthorizeRequest {
+				return newTestAuthorizeRequest("unknown", "https://app.example.com/callback")
+			},
+			err: ErrInvalidClient,
+		},
+		{
+			name: "Error - unregistered redirect uri",
+			request: func() AuthorizeRequest {
+				return newTestAuthorizeRequest("confidential", "https://evil.example.com/callback")
+			},
+			err: ErrInvalidRedirectURI,
+		},
+		{
+			name: "Error - token response type",
+			request: func() AuthorizeRequest {
+				request := newTestAuthorizeRequest("public", "com.example.app:/callback")
+				request.ResponseType = "token"
+				return request
+			},
+			err: ErrUnsupportedResponseType,
+		},
+		{
+			name: "Error - unknown scope",
+			request: func() AuthorizeRequest {
+				request := newTestAuthorizeRequest("public", "com.example.app:/callback")
+				request.Scope = "openid admin"
+				return request
+			},
+			err: ErrInvalidScope,
+		},
+		{
+			name: "Error - plain code challenge",
+			request: func() AuthorizeRequest {
+				request := newTestAuthorizeRequest("public", "com.example.app:/callback")
+				request.CodeChallenge = _testVerifier
+				request.CodeChallengeMethod = "plain"
+				return request
+			},
+			err: ErrCodeChallengeRequired,
+		},
+		{
+			name: "Error - code challenge required",
+			request: func() AuthorizeRequest {
+				request := newTestAuthorizeRequest("public", "com.example.app:/callback")
+				request.CodeChallenge = ""
+				return request
+			},
+			err: ErrCodeChallengeRequired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := NewRepositoryMock(_testConfidentialClient, _testPublicClient)
+			s := newTestService(t, repository, &SignerMock{}, time.Unix(123456, 0))
+
+			code, err := s.Authorize(context.Background(), tt.request(), sessions.Session{ID: 9, UserID: 5, MFA: true, CreatedAt: 123000})
+			require.Equal(t, tt.err, err)
+			if tt.err != nil {
+				require.Empty(t, repository.codes)
+				return
+			}
+
+			stored, ok := repository.codes[hashToken(code)]
+			require.True(t, ok)
+			require.Equal(t, AuthorizationCode{
+				CodeHash:      hashToken(code),
+				ClientID:      "confidential",
+				UserID:        5,
+				RedirectURI:   "https://app.example.com/callback",
+				Scope:         "openid email",
+				Nonce:         "some-nonce",
+				CodeChallenge: _testChallenge,
+				MFA:           true,
+				AuthTime:      123000,
+				ExpiresAt:     123516,
+				CreatedAt:     123456,
+			}, stored)
+		})
+	}
+}
+
+func TestService_Exchange(t *testing.T) {
+	var tests = []struct {
+		name    string
+		request func(code string) TokenRequest
+		elapsed time.Duration
+		err     error
+	}{
+		{
+			name: "Success",
+			request: func(code string) TokenRequest {
+				return TokenRequest{ClientID: "public", Code: code, RedirectURI: "com.example.app:/callback", CodeVerifier: _testVerifier}
+			},
+		},
+		{
+			name: "Error - wrong code verifier",
+			request: func(code string) TokenRequest {
+				return TokenRequest{ClientID: "public", Code: code, RedirectURI: "com.example.app:/callback", CodeVerifier: _testVerifier[1:] + "A"}
+			},
+			err: ErrInvalidGrant,
+		},
+		{
+			name: "Error - code verifier required",
+			request: func(code string) TokenRequest {
+				return TokenRequest{ClientID: "public", Code: code, RedirectURI: "com.example.app:/callback"}
+			},
+			err: ErrInvalidGrant,
+		},
+		{
+			name: "Error - different redirect uri",
+			request: func(code string) TokenRequest {
+				return TokenRequest{ClientID: "public", Code: code, RedirectURI: "http://127.0.0.1/callback", CodeVerifier: _testVerifier}
+			},
+			err: ErrInvalidGrant,
+		},
+		{
+			name: "Error - code of another client",
+			request: func(code string) TokenRequest {
+				return TokenRequest{ClientID: "confidential", ClientSecret: "some-secret", Code: code, RedirectURI: "com.example.app:/callback", CodeVerifier: _testVerifier}
+			},
+			err: ErrInvalidGrant,
+		},
+		{
+			name: "Error - expired code",
+			request: func(code string) TokenRequest {
+				return TokenRequest{ClientID: "public", Code: code, RedirectURI: "com.example.app:/callback", CodeVerifier: _testVerifier}
+			},
+			elapsed: time.Minute,
+			err:     ErrInvalidGrant,
+		},
+		{
+			name: "Error - unknown code",
+			request: func(code string) TokenRequest {
+				return TokenRequest{ClientID: "public", Code: "unknown", RedirectURI: "com.example.app:/callback", CodeVerifier: _testVerifier}
+			},
+			err: ErrInvalidGrant,
+		},
+		{
+			name: "Error - unknown client",
+			request: func(code string) TokenRequest {
+				return TokenRequest{ClientID: "unknown", Code: code, RedirectURI: "com.example.app:/callback", CodeVerifier: _testVerifier}
+			},
+			err: ErrInvalidClient,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := NewRepositoryMock(_testConfidentialClient, _testPublicClient)
+			now := time.Unix(123456, 0)
+			s := newTestService(t, repository, &SignerMock{}, now)
+			code, err := s.Authorize(context.Background(), newTestAuthorizeRequest("public", "com.example.app:/callback"), sessions.Session{ID: 9, UserID: 5})
+			require.NoError(t, err)
+
+			s.now = func() time.Time { return now.Add(tt.elapsed) }
+			exchanged, err := s.Exchange(context.Background(), tt.request(code))
+			require.Equal(t, tt.err, err)
+			if tt.err != nil {
+				return
+			}
+			require.Equal(t, 5, exchanged.UserID)
+			require.Equal(t, "openid email", exchanged.Scope)
+
+			_, err = s.Exchange(context.Background(), tt.request(code))
+			require.Equal(t, ErrInvalidGrant, err)
+		})
+	}
+}
+
+func TestService_AuthenticateClient(t *testing.T) {
+	var tests = []struct {
+		name     string
+		clientID string
+		secret   string
+		err      error
+	}{
+		{name: "Success - confidential client", clientID: "confidential", secret: "some-secret"},
+		{name: "Success - public client without secret", clientID: "public"},
+		{name: "Error - wrong secret", clientID: "confidential", secret: "other-secret", err: ErrInvalidClient},
+		{name: "Error - missing secret", clientID: "confidential", err: ErrInvalidClient},
+		{name: "Error - unknown client", clientID: "unknown", err: ErrInvalidClient},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, NewRepositoryMock(_testConfidentialClient, _testPublicClient), &SignerMock{}, time.Unix(123456, 0))
+
+			client, err := s.AuthenticateClient(context.Background(), tt.clientID, tt.secret)
+			require.Equal(t, tt.err, err)
+			if tt.err == nil {
+				require.Equal(t, tt.clientID, client.ClientID)
+			}
+		})
+	}
+}
+
+func TestService_IDToken(t *testing.T) {
+	signer := &SignerMock{}
+	s := newTestService(t, NewRepositoryMock(), signer, time.Unix(123456, 0))
+	user := users.User{ID: 5, Email: "some@email.com", FirstName: "Some", LastName: "User"}
+
+	token, err := s.IDToken(AuthorizationCode{ClientID: "public", Scope: "openid email", Nonce: "some-nonce", MFA: true, AuthTime: 123000}, user)
+	require.NoError(t, err)
+	require.Equal(t, "some-id-token", token)
+	require.Equal(t, idTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "https://id.example.com",
+			Subject:   "5",
+			Audience:  jwt.ClaimStrings{"public"},
+			IssuedAt:  jwt.NewNumericDate(time.Unix(123456, 0)),
+			ExpiresAt: jwt.NewNumericDate(time.Unix(124356, 0)),
+		},
+		Nonce:    "some-nonce",
+		AuthTime: 123000,
+		AMR:      []string{"pwd", "otp"},
+		Claims:   Claims{Email: "some@email.com"},
+	}, signer.claims)
+}
+
+func TestService_UserInfo(t *testing.T) {
+	s := newTestService(t, NewRepositoryMock(), &SignerMock{}, time.Unix(123456, 0))
+
+	userInfo := s.UserInfo(users.User{ID: 5, Email: "some@email.com", FirstName: "Some", LastName: "User", Locale: "es-AR"})
+	require.Equal(t, UserInfo{
+		Subject: "5",
+		Claims: Claims{
+			Name:       "Some User",
+			GivenName:  "Some",
+			FamilyName: "User",
+			Locale:     "es-AR",
+			Email:      "some@email.com",
+		},
+	}, userInfo)
+}
+
+func TestService_Discovery(t *testing.T) {
+	s := newTestService(t, NewRepositoryMock(), &SignerMock{}, time.Unix(123456, 0))
+
+	discovery := s.Discovery()
+	require.Equal(t, "https://id.example.com", discovery.Issuer)
+	require.Equal(t, "https://id.example.com/oauth/authorize", discovery.AuthorizationEndpoint)
+	require.Equal(t, "https://id.example.com/oauth/token", discovery.TokenEndpoint)
+	require.Equal(t, "https://id.example.com/.well-known/jwks.json", discovery.JWKSURI)
+	require.Equal(t, []string{CodeChallengeMethodS256}, discovery.CodeChallengeMethodsSupported)
+}
+
+func TestService_HandleUserDeleted(t *testing.T) {
+	repository := NewRepositoryMock(_testPublicClient)
+	s := newTestService(t, repository, &SignerMock{}, time.Unix(123456, 0))
+	_, err := s.Authorize(context.Background(), newTestAuthorizeRequest("public", "com.example.app:/callback"), sessions.Session{ID: 9, UserID: 5})
+	require.NoError(t, err)
+
+	err = s.HandleUserDeleted(context.Background(), users.UserDeleted{EventMetadata: users.EventMetadata{UserID: 5}})
+	require.NoError(t, err)
+	require.Empty(t, repository.codes)
+}
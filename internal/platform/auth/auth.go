@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrUnauthenticated request without valid credentials error
+	ErrUnauthenticated = errors.New("authentication required")
+	// ErrForbidden principal without the required permission error
+	ErrForbidden = errors.New("permission denied")
+	// ErrSessionRevoked token of a revoked or expired session error
+	ErrSessionRevoked = errors.New("session revoked")
+	// ErrInvalidAPIKey API key malformed, unknown, revoked or expired error
+	ErrInvalidAPIKey = errors.New("invalid api key")
+)
+
+// AnonymousActor is the actor of requests without an authenticated principal.
+const AnonymousActor = "anonymous"
+
+// Principal is the authenticated caller of a request: a user, or a machine client with an API
+// key.
+type Principal struct {
+	// Subject identifies the caller in audit logs, e.g. "user:7" or "apikey:3".
+	Subject string
+	// UserID is the id of the authenticated user. Zero for API keys.
+	UserID int
+	// SessionID is the id of the login session the token was issued for.
+	SessionID int
+	// Roles are the names of the roles assigned to the user when the token was issued.
+	Roles []string
+	// APIKeyID is the id of the API key of machine clients.
+	APIKeyID int
+	// Scopes are the permissions granted to the API key, instead of roles.
+	Scopes []string
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying principal.
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal carried by ctx, if any.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+// Actor returns the subject of the principal carried by ctx, or AnonymousActor.
+func Actor(ctx context.Context) string {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok || principal.Subject == "" {
+		return AnonymousActor
+	}
+
+	return principal.Subject
+}
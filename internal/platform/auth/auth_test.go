@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestActor(t *testing.T) {
+	var tests = []struct {
+		name          string
+		ctx           context.Context
+		expectedActor string
+	}{
+		{
+			name:          "Ok - Authenticated",
+			ctx:           WithPrincipal(context.Background(), Principal{Subject: "user:7"}),
+			expectedActor: "user:7",
+		},
+		{
+			name:          "Ok - Anonymous",
+			ctx:           context.Background(),
+			expectedActor: AnonymousActor,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expectedActor, Actor(tt.ctx))
+		})
+	}
+}
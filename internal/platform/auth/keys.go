@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"math/big"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/marcosstupnicki/go-users/internal/platform/config"
+)
+
+const (
+	// _ephemeralKeyID is the id of the key generated when no active key is configured.
+	_ephemeralKeyID = "ephemeral"
+	// _keyBits is the size of the generated RSA keys.
+	_keyBits = 2048
+)
+
+var (
+	// ErrInvalidSigningKey signing key file not a PEM encoded RSA key error
+	ErrInvalidSigningKey = errors.New("invalid signing key. key files must be PEM encoded RSA keys")
+	// ErrActiveKeyNotFound active key id not in the signing keys error
+	ErrActiveKeyNotFound = errors.New("active signing key not found in signing keys")
+	// ErrActiveKeyNotPrivate active key file without the private key error
+	ErrActiveKeyNotPrivate = errors.New("active signing key must be a private key")
+	// ErrDuplicateKeyID signing keys with the same id error
+	ErrDuplicateKeyID = errors.New("duplicate signing key id")
+)
+
+// Key is an RSA key of a KeySet. Private is only needed to sign.
+type Key struct {
+	ID      string
+	Private *rsa.PrivateKey
+	Public  *rsa.PublicKey
+}
+
+// KeySet holds the keys of the access tokens: the active one signs new tokens, and every key
+// verifies them, so tokens signed before a rotation stay valid.
+type KeySet struct {
+	active Key
+	keys   []Key
+}
+
+// NewKeySet returns a KeySet signing with active, and also verifying with others.
+func NewKeySet(active Key, others ...Key) (KeySet, error) {
+	if active.Private == nil {
+		return KeySet{}, ErrActiveKeyNotPrivate
+	}
+	active.Public = &active.Private.PublicKey
+
+	keys := append([]Key{active}, others...)
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if seen[key.ID] {
+			return KeySet{}, ErrDuplicateKeyID
+		}
+		seen[key.ID] = true
+	}
+
+	return KeySet{
+		active: active,
+		keys:   keys,
+	}, nil
+}
+
+// LoadKeySet loads the signing keys of cfg from their PEM files. Without an active key id, it
+// signs with a key generated on the fly, so tokens don't survive restarts.
+func LoadKeySet(cfg config.Auth) (KeySet, error) {
+	if cfg.ActiveKeyID == "" {
+		return GenerateKeySet()
+	}
+
+	var active Key
+	var others []Key
+	for _, signingKey := range cfg.SigningKeys {
+		key, err := loadKey(signingKey)
+		if err != nil {
+			return KeySet{}, err
+		}
+		if key.ID == cfg.ActiveKeyID && active.ID == "" {
+			active = key
+			continue
+		}
+		others = append(others, key)
+	}
+	if active.ID == "" {
+		return KeySet{}, ErrActiveKeyNotFound
+	}
+
+	return NewKeySet(active, others...)
+}
+
+// GenerateKeySet returns a KeySet with a single random key.
+func GenerateKeySet() (KeySet, error) {
+	private, err := rsa.GenerateKey(rand.Reader, _keyBits)
+	if err != nil {
+		return KeySet{}, err
+	}
+
+	return NewKeySet(Key{ID: _ephemeralKeyID, Private: private})
+}
+
+// JWKS returns the public keys of the set as a JSON Web Key Set (RFC 7517).
+func (k KeySet) JWKS() JWKS {
+	keys := make([]JWK, 0, len(k.keys))
+	for _, key := range k.keys {
+		keys = append(keys, JWK{
+			KeyType:   "RSA",
+			Use:       "sig",
+			Algorithm: jwt.SigningMethodRS256.Alg(),
+			KeyID:     key.ID,
+			Modulus:   base64.RawURLEncoding.EncodeToString(key.Public.N.Bytes()),
+			Exponent:  base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.Public.E)).Bytes()),
+		})
+	}
+
+	return JWKS{Keys: keys}
+}
+
+// Sign returns claims as a JWT signed with RS256 by the active key, with its id in the kid header.
+func (k KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = k.active.ID
+	return token.SignedString(k.active.Private)
+}
+
+// publicKey returns the public key with id, if it's in the set.
+func (k KeySet) publicKey(id string) (*rsa.PublicKey, bool) {
+	for _, key := range k.keys {
+		if key.ID == id {
+			return key.Public, true
+		}
+	}
+
+	return nil, false
+}
+
+// loadKey reads the private or public key of signingKey from its PEM file.
+func loadKey(signingKey config.SigningKey) (Key, error) {
+	data, err := ioutil.ReadFile(signingKey.File)
+	if err != nil {
+		return Key{}, err
+	}
+
+	private, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+	if err == nil {
+		return Key{ID: signingKey.ID, Private: private, Public: &private.PublicKey}, nil
+	}
+
+	public, err := jwt.ParseRSAPublicKeyFromPEM(data)
+	if err != nil {
+		return Key{}, ErrInvalidSigningKey
+	}
+
+	return Key{ID: signingKey.ID, Public: public}, nil
+}
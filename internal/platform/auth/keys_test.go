@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/config"
+	"github.com/stretchr/testify/require"
+)
+
+// writeKeyFiles writes the private and public PEM files of key in dir, and returns their paths.
+func writeKeyFiles(t *testing.T, dir string, key Key) (string, string) {
+	private, err := x509.MarshalPKCS8PrivateKey(key.Private)
+	require.NoError(t, err)
+	public, err := x509.MarshalPKIXPublicKey(key.Public)
+	require.NoError(t, err)
+
+	privateFile := filepath.Join(dir, key.ID+".pem")
+	require.NoError(t, ioutil.WriteFile(privateFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: private}), 0600))
+	publicFile := filepath.Join(dir, key.ID+".pub.pem")
+	require.NoError(t, ioutil.WriteFile(publicFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}), 0644))
+
+	return privateFile, publicFile
+}
+
+func TestLoadKeySet(t *testing.T) {
+	dir := t.TempDir()
+	current := newTestKey(t, "2024-06")
+	previous := newTestKey(t, "2024-01")
+	currentPrivate, currentPublic := writeKeyFiles(t, dir, current)
+	_, previousPublic := writeKeyFiles(t, dir, previous)
+	invalid := filepath.Join(dir, "invalid.pem")
+	require.NoError(t, ioutil.WriteFile(invalid, []byte("not a key"), 0600))
+
+	var tests = []struct {
+		name           string
+		cfg            config.Auth
+		expectedKeyIDs []string
+		expectedError  error
+	}{
+		{
+			name: "Ok - Active and previous keys",
+			cfg: config.Auth{
+				SigningKeys: []config.SigningKey{
+					{ID: "2024-01", File: previousPublic},
+					{ID: "2024-06", File: currentPrivate},
+				},
+				ActiveKeyID: "2024-06",
+			},
+			expectedKeyIDs: []string{"2024-06", "2024-01"},
+		},
+		{
+			name:           "Ok - Ephemeral key",
+			cfg:            config.Auth{},
+			expectedKeyIDs: []string{"ephemeral"},
+		},
+		{
+			name: "Fail - Active key not found",
+			cfg: config.Auth{
+				SigningKeys: []config.SigningKey{{ID: "2024-01", File: previousPublic}},
+				ActiveKeyID: "2024-06",
+			},
+			expectedError: ErrActiveKeyNotFound,
+		},
+		{
+			name: "Fail - Active key without the private key",
+			cfg: config.Auth{
+				SigningKeys: []config.SigningKey{{ID: "2024-06", File: currentPublic}},
+				ActiveKeyID: "2024-06",
+			},
+			expectedError: ErrActiveKeyNotPrivate,
+		},
+		{
+			name: "Fail - Duplicate key id",
+			cfg: config.Auth{
+				SigningKeys: []config.SigningKey{
+					{ID: "2024-06", File: currentPrivate},
+					{ID: "2024-06", File: previousPublic},
+				},
+				ActiveKeyID: "2024-06",
+			},
+			expectedError: ErrDuplicateKeyID,
+		},
+		{
+			name: "Fail - Invalid key file",
+			cfg: config.Auth{
+				SigningKeys: []config.SigningKey{{ID: "2024-06", File: invalid}},
+				ActiveKeyID: "2024-06",
+			},
+			expectedError: ErrInvalidSigningKey,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := LoadKeySet(tt.cfg)
+			require.Equal(t, tt.expectedError, err)
+
+			var keyIDs []string
+			for _, key := range keys.JWKS().Keys {
+				keyIDs = append(keyIDs, key.KeyID)
+			}
+			require.Equal(t, tt.expectedKeyIDs, keyIDs)
+		})
+	}
+}
+
+func TestKeySet_JWKS(t *testing.T) {
+	key := newTestKey(t, "2024-06")
+	jwks := newTestKeySet(t, key).JWKS()
+	require.Len(t, jwks.Keys, 1)
+
+	jwk := jwks.Keys[0]
+	require.Equal(t, "RSA", jwk.KeyType)
+	require.Equal(t, "sig", jwk.Use)
+	require.Equal(t, "RS256", jwk.Algorithm)
+	require.Equal(t, "2024-06", jwk.KeyID)
+	require.Equal(t, "AQAB", jwk.Exponent)
+
+	modulus, err := base64.RawURLEncoding.DecodeString(jwk.Modulus)
+	require.NoError(t, err)
+	require.Equal(t, 0, new(big.Int).SetBytes(modulus).Cmp(key.Public.N))
+}
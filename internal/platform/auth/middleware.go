@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+)
+
+// TokenParser verifies access tokens.
+type TokenParser interface {
+	Parse(token string) (Principal, error)
+}
+
+// SessionValidator checks the session of access tokens is still active.
+type SessionValidator interface {
+	// ValidateSession returns ErrSessionRevoked if the session of principal was revoked.
+	ValidateSession(ctx context.Context, principal Principal) error
+}
+
+// APIKeyAuthenticator verifies the API keys of machine clients.
+type APIKeyAuthenticator interface {
+	// AuthenticateAPIKey returns the principal of key, or ErrInvalidAPIKey.
+	AuthenticateAPIKey(ctx context.Context, key string) (Principal, error)
+}
+
+// Middleware returns a middleware storing the principal of the request bearer token, or
+// "Authorization: ApiKey <key>" API key, in its context. Requests without an Authorization header
+// continue anonymously, handlers decide whether that's allowed. Invalid tokens or API keys, and
+// tokens of revoked sessions, are rejected with 401.
+func Middleware(tokens TokenParser, sessions SessionValidator, apiKeys APIKeyAuthenticator) func(next http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			if header == "" {
+				next(w, r)
+				return
+			}
+
+			if key, ok := credentials(header, "ApiKey"); ok {
+				principal, err := apiKeys.AuthenticateAPIKey(r.Context(), key)
+				if err != nil {
+					if err == ErrInvalidAPIKey {
+						gowebapp.RespondWithError(w, http.StatusUnauthorized, err.Error())
+						return
+					}
+					gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+					return
+				}
+
+				next(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+				return
+			}
+
+			token, ok := bearerToken(header)
+			if !ok {
+				gowebapp.RespondWithError(w, http.StatusUnauthorized, ErrInvalidToken.Error())
+				return
+			}
+
+			principal, err := tokens.Parse(token)
+			if err != nil {
+				gowebapp.RespondWithError(w, http.StatusUnauthorized, err.Error())
+				return
+			}
+
+			err = sessions.ValidateSession(r.Context(), principal)
+			if err != nil {
+				if err == ErrSessionRevoked {
+					gowebapp.RespondWithError(w, http.StatusUnauthorized, err.Error())
+					return
+				}
+				gowebapp.RespondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+				return
+			}
+
+			next(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/config"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+// SessionValidatorMock has every session revoked but the ones in active.
+type SessionValidatorMock struct {
+	active map[int]bool
+}
+
+func (v SessionValidatorMock) ValidateSession(_ context.Context, principal Principal) error {
+	if !v.active[principal.SessionID] {
+		return ErrSessionRevoked
+	}
+	return nil
+}
+
+// APIKeyAuthenticatorMock accepts the API key "gu_0011_some-secret", with id 3.
+type APIKeyAuthenticatorMock struct{}
+
+func (APIKeyAuthenticatorMock) AuthenticateAPIKey(_ context.Context, key string) (Principal, error) {
+	if key != "gu_0011_some-secret" {
+		return Principal{}, ErrInvalidAPIKey
+	}
+	return Principal{Subject: "apikey:3", APIKeyID: 3, Scopes: []string{"users:read"}}, nil
+}
+
+func TestMiddleware(t *testing.T) {
+	tokens := NewTokens(config.Auth{TokenSecret: "some-secret-of-at-least-32-bytes!", TokenIssuer: "go-users", TokenTTL: time.Minute}, newTestKeySet(t, newTestKey(t, "2024-06")))
+	token, _, err := tokens.Issue(7, 3, []string{"admin"})
+	require.NoError(t, err)
+	revoked, _, err := tokens.Issue(7, 4, []string{"admin"})
+	require.NoError(t, err)
+
+	var tests = []struct {
+		name               string
+		authorization      string
+		expectedStatusCode int
+		expectedResponse   string
+	}{
+		{
+			name:               "Ok - Authenticated",
+			authorization:      "Bearer " + token,
+			expectedStatusCode: http.StatusOK,
+			expectedResponse:   "user:7",
+		},
+		{
+			name:               "Ok - Anonymous",
+			expectedStatusCode: http.StatusOK,
+			expectedResponse:   AnonymousActor,
+		},
+		{
+			name:               "Ok - API key",
+			authorization:      "ApiKey gu_0011_some-secret",
+			expectedStatusCode: http.StatusOK,
+			expectedResponse:   "apikey:3",
+		},
+		{
+			name:               "Fail - Invalid API key",
+			authorization:      "ApiKey gu_0011_other-secret",
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedResponse:   `{"message":"invalid api key"}`,
+		},
+		{
+			name:               "Fail - Not a bearer token",
+			authorization:      "Basic dXNlcjpwYXNz",
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedResponse:   `{"message":"invalid token"}`,
+		},
+		{
+			name:               "Fail - Revoked session",
+			authorization:      "Bearer " + revoked,
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedResponse:   `{"message":"session revoked"}`,
+		},
+		{
+			name:               "Fail - Invalid token",
+			authorization:      "Bearer not-a-token",
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedResponse:   `{"message":"invalid token"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := gowebapp.NewWebApp("local")
+			app.Get("/ping", Middleware(tokens, SessionValidatorMock{active: map[int]bool{3: true}}, APIKeyAuthenticatorMock{})(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(Actor(r.Context())))
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/ping", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+
+			rr := httptest.NewRecorder()
+			app.Router.ServeHTTP(rr, r)
+
+			res := rr.Result()
+			resBody, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expectedStatusCode, res.StatusCode)
+			require.Equal(t, tt.expectedResponse, string(resBody))
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package auth
+
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	// ExpiresIn is the number of seconds the access token is valid for.
+	ExpiresIn int64 `json:"expires_in"`
+	// RefreshToken is exchanged on POST /auth/refresh for a new access token, and a new refresh
+	// token replacing it. It can only be used once.
+	RefreshToken string `json:"refresh_token"`
+	// SessionID is the id of the login session, to log out with DELETE /auth/sessions/{id}.
+	SessionID int `json:"session_id"`
+	// MFAEnrollmentRequired is set when roles requiring MFA were left out of the token because the
+	// user isn't enrolled.
+	MFAEnrollmentRequired bool `json:"mfa_enrollment_required,omitempty"`
+}
+
+// MFAChallengeResponse is the login response of users enrolled in MFA, who must exchange MFAToken
+// and a code for an access token.
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	// ExpiresIn is the number of seconds the MFA token is valid for.
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token"`
+	// Code is a TOTP code or a recovery code.
+	Code string `json:"code"`
+}
+
+// ErrorResponse is an error response with a machine readable code, for errors clients handle
+// differently.
+type ErrorResponse struct {
+	Message string `json:"message"`
+	Code    string `json:"code"`
+}
+
+// JWKS is a JSON Web Key Set (RFC 7517), the public keys verifying access tokens.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK is an RSA public key in JSON Web Key format. Modulus and Exponent are base64url encoded.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Modulus   string `json:"n"`
+	Exponent  string `json:"e"`
+}
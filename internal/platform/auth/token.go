@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/marcosstupnicki/go-users/internal/platform/config"
+)
+
+const (
+	_userSubjectPrefix = "user:"
+
+	// _useMFAChallenge marks the tokens proving the first login step, exchanged for an access
+	// token with a second factor.
+	_useMFAChallenge = "mfa_challenge"
+	// _useBrowserSession marks the tokens of the cookie keeping users logged in to the OAuth
+	// authorization endpoint.
+	_useBrowserSession = "browser_session"
+)
+
+// ErrInvalidToken token malformed, expired or not signed by us error
+var ErrInvalidToken = errors.New("invalid token")
+
+// Challenge is an MFA challenge token: its unique id (the jti claim), which lets the attempts made
+// with it be counted and the token be used only once, its user and when it expires.
+type Challenge struct {
+	ID        string
+	UserID    int
+	ExpiresAt time.Time
+}
+
+// claims are the JWT claims of an access token, or of another token if Use is set. The subject is
+// the user id.
+type claims struct {
+	jwt.RegisteredClaims
+	Roles     []string `json:"roles,omitempty"`
+	SessionID int      `json:"sid,omitempty"`
+	Use       string   `json:"use,omitempty"`
+}
+
+// Tokens issues and verifies access tokens, signed with RS256 by the active key of a KeySet, and
+// HS256 signed internal tokens.
+type Tokens struct {
+	keys         KeySet
+	secret       []byte
+	issuer       string
+	ttl          time.Duration
+	challengeTTL time.Duration
+	browserTTL   time.Duration
+	now          func() time.Time
+}
+
+func NewTokens(cfg config.Auth, keys KeySet) Tokens {
+	return Tokens{
+		keys:         keys,
+		secret:       []byte(cfg.TokenSecret),
+		issuer:       cfg.TokenIssuer,
+		ttl:          cfg.TokenTTL,
+		challengeTTL: cfg.MFAChallengeTTL,
+		browserTTL:   cfg.BrowserSessionTTL,
+		now:          time.Now,
+	}
+}
+
+// Issue returns an access token for the session with id sessionID of the user with id userID and
+// the given roles, and when it expires.
+func (t Tokens) Issue(userID int, sessionID int, roles []string) (string, time.Time, error) {
+	return t.issue(userID, t.ttl, claims{Roles: roles, SessionID: sessionID})
+}
+
+// IssueChallenge returns a token proving the user with id userID passed the password step of a
+// login requiring a second factor, and the challenge it carries. It's not an access token, and
+// it's signed with the internal secret so services verifying access tokens with the JWKS can't
+// accept it.
+func (t Tokens) IssueChallenge(userID int) (string, Challenge, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", Challenge{}, err
+	}
+	id := hex.EncodeToString(b)
+
+	c := claims{Use: _useMFAChallenge}
+	c.ID = id
+	token, expiresAt, err := t.issue(userID, t.challengeTTL, c)
+	if err != nil {
+		return "", Challenge{}, err
+	}
+
+	return token, Challenge{ID: id, UserID: userID, ExpiresAt: expiresAt}, nil
+}
+
+// IssueBrowserSession returns the token of the cookie keeping the user with id userID logged in
+// to the OAuth authorization endpoint with the session with id sessionID, and when it expires.
+// Like MFA challenges, it's signed with the internal secret and never accepted as access token.
+func (t Tokens) IssueBrowserSession(userID int, sessionID int) (string, time.Time, error) {
+	return t.issue(userID, t.browserTTL, claims{SessionID: sessionID, Use: _useBrowserSession})
+}
+
+// Parse verifies an access token and returns the principal it was issued for.
+func (t Tokens) Parse(token string) (Principal, error) {
+	c, userID, err := t.parse(token, "")
+	if err != nil {
+		return Principal{}, err
+	}
+
+	return Principal{
+		Subject:   _userSubjectPrefix + c.Subject,
+		UserID:    userID,
+		SessionID: c.SessionID,
+		Roles:     c.Roles,
+	}, nil
+}
+
+// ParseChallenge verifies a token issued by IssueChallenge and returns its challenge.
+func (t Tokens) ParseChallenge(token string) (Challenge, error) {
+	c, userID, err := t.parse(token, _useMFAChallenge)
+	if err != nil {
+		return Challenge{}, err
+	}
+	if c.ID == "" || c.ExpiresAt == nil {
+		return Challenge{}, ErrInvalidToken
+	}
+
+	return Challenge{ID: c.ID, UserID: userID, ExpiresAt: c.ExpiresAt.Time}, nil
+}
+
+// ParseBrowserSession verifies a token issued by IssueBrowserSession and returns the principal of
+// its session, without roles.
+func (t Tokens) ParseBrowserSession(token string) (Principal, error) {
+	c, userID, err := t.parse(token, _useBrowserSession)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	return Principal{
+		Subject:   _userSubjectPrefix + c.Subject,
+		UserID:    userID,
+		SessionID: c.SessionID,
+	}, nil
+}
+
+func (t Tokens) issue(userID int, ttl time.Duration, c claims) (string, time.Time, error) {
+	now := t.now()
+	expiresAt := now.Add(ttl)
+
+	c.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        c.ID,
+		Issuer:    t.issuer,
+		Subject:   strconv.Itoa(userID),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+	}
+
+	var signed string
+	var err error
+	if c.Use == "" {
+		signed, err = t.keys.Sign(c)
+	} else {
+		signed, err = jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString(t.secret)
+	}
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return signed, expiresAt, nil
+}
+
+// parse verifies token was issued by us for use, and returns its claims and user id.
+func (t Tokens) parse(token string, use string) (claims, int, error) {
+	var c claims
+	_, err := jwt.ParseWithClaims(token, &c, func(token *jwt.Token) (interface{}, error) {
+		// Only accept the algorithm we sign each kind of token with, so "none" or algorithm
+		// confusion can't pass.
+		if use != "" {
+			if token.Method != jwt.SigningMethodHS256 {
+				return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+			}
+			return t.secret, nil
+		}
+
+		if token.Method != jwt.SigningMethodRS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		key, ok := t.keys.publicKey(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		return key, nil
+	})
+	if err != nil {
+		return claims{}, 0, ErrInvalidToken
+	}
+	if !c.VerifyIssuer(t.issuer, true) || c.Use != use {
+		return claims{}, 0, ErrInvalidToken
+	}
+
+	userID, err := strconv.Atoi(c.Subject)
+	if err != nil {
+		return claims{}, 0, ErrInvalidToken
+	}
+
+	return c, userID, nil
+}
+
+// bearerToken returns the token of an "Authorization: Bearer <token>" header value.
+func bearerToken(header string) (string, bool) {
+	return credentials(header, "Bearer")
+}
+
+// credentials returns the credentials of an "Authorization: <scheme> <credentials>" header value.
+// The scheme is case insensitive.
+func credentials(header string, scheme string) (string, bool) {
+	prefix := scheme + " "
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+
+	return header[len(prefix):], true
+}
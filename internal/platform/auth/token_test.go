@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/marcosstupnicki/go-users/internal/platform/config"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestKey returns a random RSA key with id.
+func newTestKey(t *testing.T, id string) Key {
+	private, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	return Key{ID: id, Private: private, Public: &private.PublicKey}
+}
+
+// newTestKeySet returns a KeySet signing with active.
+func newTestKeySet(t *testing.T, active Key, others ...Key) KeySet {
+	keys, err := NewKeySet(active, others...)
+	require.NoError(t, err)
+	return keys
+}
+
+func TestTokens(t *testing.T) {
+	cfg := config.Auth{
+		TokenSecret:     "some-secret-of-at-least-32-bytes!",
+		TokenIssuer:     "go-users",
+		TokenTTL:        15 * time.Minute,
+		MFAChallengeTTL: 5 * time.Minute,
+	}
+	current := newTestKey(t, "2024-06")
+	previous := newTestKey(t, "2024-01")
+	tokens := NewTokens(cfg, newTestKeySet(t, current, Key{ID: previous.ID, Public: previous.Public}))
+
+	token, expiresAt, err := tokens.Issue(7, 3, []string{"admin"})
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(15*time.Minute), expiresAt, time.Second)
+
+	var tests = []struct {
+		name              string
+		token             string
+		expectedPrincipal Principal
+		expectedError     error
+	}{
+		{
+			name:              "Ok",
+			token:             token,
+			expectedPrincipal: Principal{Subject: "user:7", UserID: 7, SessionID: 3, Roles: []string{"admin"}},
+		},
+		{
+			name: "Fail - Expired",
+			token: func() string {
+				expired := NewTokens(cfg, newTestKeySet(t, current))
+				expired.now = func() time.Time { return time.Now().Add(-time.Hour) }
+				token, _, err := expired.Issue(7, 3, nil)
+				require.NoError(t, err)
+				return token
+			}(),
+			expectedError: ErrInvalidToken,
+		},
+		{
+			name: "Ok - Signed with the previous key",
+			token: func() string {
+				token, _, err := NewTokens(cfg, newTestKeySet(t, previous)).Issue(7, 3, []string{"admin"})
+				require.NoError(t, err)
+				return token
+			}(),
+			expectedPrincipal: Principal{Subject: "user:7", UserID: 7, SessionID: 3, Roles: []string{"admin"}},
+		},
+		{
+			name: "Fail - Signed with another key with a known id",
+			token: func() string {
+				token, _, err := NewTokens(cfg, newTestKeySet(t, newTestKey(t, "2024-06"))).Issue(7, 3, []string{"admin"})
+				require.NoError(t, err)
+				return token
+			}(),
+			expectedError: ErrInvalidToken,
+		},
+		{
+			name: "Fail - Unknown key id",
+			token: func() string {
+				token, _, err := NewTokens(cfg, newTestKeySet(t, newTestKey(t, "2023-01"))).Issue(7, 3, []string{"admin"})
+				require.NoError(t, err)
+				return token
+			}(),
+			expectedError: ErrInvalidToken,
+		},
+		{
+			name: "Fail - Signed with the internal secret",
+			token: func() string {
+				token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims{
+					RegisteredClaims: jwt.RegisteredClaims{Subject: "7", Issuer: "go-users"},
+					Roles:            []string{"admin"},
+				}).SignedString([]byte(cfg.TokenSecret))
+				require.NoError(t, err)
+				return token
+			}(),
+			expectedError: ErrInvalidToken,
+		},
+		{
+			name: "Fail - Another issuer",
+			token: func() string {
+				other := cfg
+				other.TokenIssuer = "someone-else"
+				token, _, err := NewTokens(other, newTestKeySet(t, current)).Issue(7, 3, []string{"admin"})
+				require.NoError(t, err)
+				return token
+			}(),
+			expectedError: ErrInvalidToken,
+		},
+		{
+			name: "Fail - MFA challenge",
+			token: func() string {
+				token, _, err := tokens.IssueChallenge(7)
+				require.NoError(t, err)
+				return token
+			}(),
+			expectedError: ErrInvalidToken,
+		},
+		{
+			name: "Fail - Browser session",
+			token: func() string {
+				token, _, err := tokens.IssueBrowserSession(7, 3)
+				require.NoError(t, err)
+				return token
+			}(),
+			expectedError: ErrInvalidToken,
+		},
+		{
+			name: "Fail - Unsigned",
+			token: func() string {
+				token, err := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.RegisteredClaims{Subject: "7", Issuer: "go-users"}).
+					SignedString(jwt.UnsafeAllowNoneSignatureType)
+				require.NoError(t, err)
+				return token
+			}(),
+			expectedError: ErrInvalidToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := tokens.Parse(tt.token)
+			require.Equal(t, tt.expectedError, err)
+			require.Equal(t, tt.expectedPrincipal, principal)
+		})
+	}
+}
+
+func TestTokens_ParseChallenge(t *testing.T) {
+	tokens := NewTokens(config.Auth{
+		TokenSecret:     "some-secret-of-at-least-32-bytes!",
+		TokenIssuer:     "go-users",
+		TokenTTL:        15 * time.Minute,
+		MFAChallengeTTL: 5 * time.Minute,
+	}, newTestKeySet(t, newTestKey(t, "2024-06")))
+
+	token, challenge, err := tokens.IssueChallenge(7)
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(5*time.Minute), challenge.ExpiresAt, time.Second)
+	require.Len(t, challenge.ID, 32)
+	_, other, err := tokens.IssueChallenge(7)
+	require.NoError(t, err)
+	require.NotEqual(t, challenge.ID, other.ID)
+	access, _, err := tokens.Issue(7, 3, []string{"admin"})
+	require.NoError(t, err)
+
+	var tests = []struct {
+		name              string
+		token             string
+		expectedChallenge Challenge
+		expectedError     error
+	}{
+		{
+			name:  "Ok",
+			token: token,
+			expectedChallenge: Challenge{
+				ID:        challenge.ID,
+				UserID:    7,
+				ExpiresAt: challenge.ExpiresAt.Truncate(time.Second),
+			},
+		},
+		{
+			name:          "Fail - Access token",
+			token:         access,
+			expectedError: ErrInvalidToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			challenge, err := tokens.ParseChallenge(tt.token)
+			require.Equal(t, tt.expectedError, err)
+			require.Equal(t, tt.expectedChallenge.ID, challenge.ID)
+			require.Equal(t, tt.expectedChallenge.UserID, challenge.UserID)
+			require.True(t, tt.expectedChallenge.ExpiresAt.Equal(challenge.ExpiresAt))
+		})
+	}
+}
+
+func TestTokens_ParseBrowserSession(t *testing.T) {
+	tokens := NewTokens(config.Auth{
+		TokenSecret:       "some-secret-of-at-least-32-bytes!",
+		TokenIssuer:       "go-users",
+		TokenTTL:          15 * time.Minute,
+		MFAChallengeTTL:   5 * time.Minute,
+		BrowserSessionTTL: 8 * time.Hour,
+	}, newTestKeySet(t, newTestKey(t, "2024-06")))
+
+	session, expiresAt, err := tokens.IssueBrowserSession(7, 3)
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(8*time.Hour), expiresAt, time.Second)
+	challenge, _, err := tokens.IssueChallenge(7)
+	require.NoError(t, err)
+
+	var tests = []struct {
+		name              string
+		token             string
+		expectedPrincipal Principal
+		expectedError     error
+	}{
+		{
+			name:              "Ok",
+			token:             session,
+			expectedPrincipal: Principal{Subject: "user:7", UserID: 7, SessionID: 3},
+		},
+		{
+			name:          "Fail - MFA challenge",
+			token:         challenge,
+			expectedError: ErrInvalidToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := tokens.ParseBrowserSession(tt.token)
+			require.Equal(t, tt.expectedError, err)
+			require.Equal(t, tt.expectedPrincipal, principal)
+		})
+	}
+}
@@ -2,13 +2,35 @@ package config
 
 import (
 	"errors"
+	"time"
 
 	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
 	"gorm.io/gorm/logger"
 )
 
+// _minTokenSecretLength is the shortest Auth.TokenSecret accepted, in bytes: an HS256 key shorter
+// than the SHA-256 output weakens the signatures it makes.
+const _minTokenSecretLength = 32
+
+// ErrTokenSecretTooShort Auth.TokenSecret shorter than 32 bytes error
+var ErrTokenSecretTooShort = errors.New("auth token secret must be at least 32 bytes")
+
+// _preferencesMetadataSchema validates the "preferences" user metadata namespace.
+const _preferencesMetadataSchema = `{
+	"type": "object",
+	"properties": {
+		"theme": {"type": "string", "enum": ["light", "dark"]},
+		"newsletter": {"type": "boolean"}
+	},
+	"additionalProperties": false
+}`
+
 var _configs = map[string]Config{
 	"local": {
+		Server: Server{
+			Address:         ":8080",
+			ShutdownTimeout: 15 * time.Second,
+		},
 		Database: Database{
 			User:     "root",
 			Password: "root",
@@ -17,6 +39,98 @@ var _configs = map[string]Config{
 			Name:     "users",
 			LogLevel: logger.Info,
 		},
+		Tracing: Tracing{
+			ServiceName: "go-users",
+			Exporter:    "stdout",
+		},
+		Health: Health{
+			ReadinessTimeout: 2 * time.Second,
+		},
+		Events: Events{
+			WebhookTimeout:      5 * time.Second,
+			RelayInterval:       time.Second,
+			RelayBatchSize:      100,
+			RelayMaxAttempts:    10,
+			RelayInitialBackoff: 5 * time.Second,
+			RelayMaxBackoff:     10 * time.Minute,
+			RelayLease:          5 * time.Minute,
+		},
+		Webhooks: Webhooks{
+			DispatchInterval:  time.Second,
+			DispatchBatchSize: 50,
+			Timeout:           10 * time.Second,
+			MaxAttempts:       8,
+			InitialBackoff:    30 * time.Second,
+			MaxBackoff:        6 * time.Hour,
+			Lease:             10 * time.Minute,
+		},
+		Metadata: Metadata{
+			Schemas: map[string]string{
+				"preferences": _preferencesMetadataSchema,
+			},
+			IndexedKeys: []string{"preferences.theme"},
+		},
+		Auth: Auth{
+			TokenSecret:       "local-development-secret-change-me!",
+			TokenIssuer:       "go-users",
+			TokenTTL:          15 * time.Minute,
+			RefreshTokenTTL:   30 * 24 * time.Hour,
+			MFAChallengeTTL:   5 * time.Minute,
+			BrowserSessionTTL: 8 * time.Hour,
+		},
+		Accounts: Accounts{
+			InitialStatus: "active",
+		},
+		Lockout: Lockout{
+			MaxFailures:   5,
+			IPMaxFailures: 20,
+			BaseDuration:  time.Minute,
+			MaxDuration:   time.Hour,
+			ResetAfter:    24 * time.Hour,
+		},
+		RateLimit: RateLimit{
+			// Create and password updates hash with bcrypt, login compares with it.
+			// Logins are also limited per account, so spreading guesses over IPs doesn't help.
+			Routes: map[string][]RouteLimit{
+				"POST /users":     {{Requests: 10, Period: time.Minute, KeyBy: "ip"}},
+				"PUT /users/{id}": {{Requests: 20, Period: time.Minute, KeyBy: "user"}},
+				"POST /auth/login": {
+					{Requests: 10, Period: time.Minute, KeyBy: "ip"},
+					{Requests: 10, Period: time.Minute, KeyBy: "account"},
+				},
+				"POST /auth/login/mfa": {{Requests: 10, Period: time.Minute, KeyBy: "ip"}},
+				"POST /auth/refresh":   {{Requests: 30, Period: time.Minute, KeyBy: "ip"}},
+				"POST /oauth/authorize": {
+					{Requests: 10, Period: time.Minute, KeyBy: "ip"},
+					{Requests: 10, Period: time.Minute, KeyBy: "account"},
+				},
+				"POST /oauth/token": {{Requests: 30, Period: time.Minute, KeyBy: "ip"}},
+				// Batches count their operations, which can create users or update passwords.
+				"POST /users:batch": {{Requests: 100, Period: time.Minute, KeyBy: "user", WeighBy: "operations"}},
+				// Confirming and disabling MFA take a code, each user only gets a few guesses.
+				"POST /auth/mfa/confirm": {{Requests: 5, Period: time.Minute, KeyBy: "user"}},
+				"POST /auth/mfa/disable": {{Requests: 5, Period: time.Minute, KeyBy: "user"}},
+			},
+		},
+		MFA: MFA{
+			EncryptionKey:        "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+			Issuer:               "go-users",
+			RequiredRoles:        []string{"admin"},
+			ChallengeMaxAttempts: 5,
+		},
+		OAuth: OAuth{
+			Issuer:               "http://localhost:8080",
+			AuthorizationCodeTTL: time.Minute,
+			IDTokenTTL:           15 * time.Minute,
+		},
+		Import: Import{
+			BatchSize: 500,
+		},
+		Idempotency: Idempotency{
+			TTL:           24 * time.Hour,
+			PurgeInterval: time.Hour,
+			MaxBodySize:   1 << 20,
+		},
 	},
 }
 
@@ -26,5 +140,19 @@ func GetConfigFromScope(scope gowebapp.Scope) (Config, error) {
 		return Config{}, errors.New("config not found for indicated scope")
 	}
 
+	err := validate(config)
+	if err != nil {
+		return Config{}, err
+	}
+
 	return config, nil
 }
+
+// validate rejects configs the service must not start with.
+func validate(config Config) error {
+	if len(config.Auth.TokenSecret) < _minTokenSecretLength {
+		return ErrTokenSecretTooShort
+	}
+
+	return nil
+}
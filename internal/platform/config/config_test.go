@@ -3,6 +3,7 @@ package config
 import (
 	"errors"
 	"testing"
+	"time"
 
 	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
 	"github.com/stretchr/testify/require"
@@ -22,6 +23,10 @@ func TestGetConfigFromEnvironment(t *testing.T) {
 				Environment: "local",
 			},
 			expectedConfig: Config{
+				Server: Server{
+					Address:         ":8080",
+					ShutdownTimeout: 15 * time.Second,
+				},
 				Database: Database{
 					User:     "root",
 					Password: "root",
@@ -30,6 +35,94 @@ func TestGetConfigFromEnvironment(t *testing.T) {
 					Name:     "users",
 					LogLevel: logger.Info,
 				},
+				Tracing: Tracing{
+					ServiceName: "go-users",
+					Exporter:    "stdout",
+				},
+				Health: Health{
+					ReadinessTimeout: 2 * time.Second,
+				},
+				Events: Events{
+					WebhookTimeout:      5 * time.Second,
+					RelayInterval:       time.Second,
+					RelayBatchSize:      100,
+					RelayMaxAttempts:    10,
+					RelayInitialBackoff: 5 * time.Second,
+					RelayMaxBackoff:     10 * time.Minute,
+					RelayLease:          5 * time.Minute,
+				},
+				Webhooks: Webhooks{
+					DispatchInterval:  time.Second,
+					DispatchBatchSize: 50,
+					Timeout:           10 * time.Second,
+					MaxAttempts:       8,
+					InitialBackoff:    30 * time.Second,
+					MaxBackoff:        6 * time.Hour,
+					Lease:             10 * time.Minute,
+				},
+				Metadata: Metadata{
+					Schemas: map[string]string{
+						"preferences": _preferencesMetadataSchema,
+					},
+					IndexedKeys: []string{"preferences.theme"},
+				},
+				Auth: Auth{
+					TokenSecret:       "local-development-secret-change-me!",
+					TokenIssuer:       "go-users",
+					TokenTTL:          15 * time.Minute,
+					RefreshTokenTTL:   30 * 24 * time.Hour,
+					MFAChallengeTTL:   5 * time.Minute,
+					BrowserSessionTTL: 8 * time.Hour,
+				},
+				Accounts: Accounts{
+					InitialStatus: "active",
+				},
+				Lockout: Lockout{
+					MaxFailures:   5,
+					IPMaxFailures: 20,
+					BaseDuration:  time.Minute,
+					MaxDuration:   time.Hour,
+					ResetAfter:    24 * time.Hour,
+				},
+				RateLimit: RateLimit{
+					Routes: map[string][]RouteLimit{
+						"POST /users":     {{Requests: 10, Period: time.Minute, KeyBy: "ip"}},
+						"PUT /users/{id}": {{Requests: 20, Period: time.Minute, KeyBy: "user"}},
+						"POST /auth/login": {
+							{Requests: 10, Period: time.Minute, KeyBy: "ip"},
+							{Requests: 10, Period: time.Minute, KeyBy: "account"},
+						},
+						"POST /auth/login/mfa": {{Requests: 10, Period: time.Minute, KeyBy: "ip"}},
+						"POST /auth/refresh":   {{Requests: 30, Period: time.Minute, KeyBy: "ip"}},
+						"POST /oauth/authorize": {
+							{Requests: 10, Period: time.Minute, KeyBy: "ip"},
+							{Requests: 10, Period: time.Minute, KeyBy: "account"},
+						},
+						"POST /oauth/token":      {{Requests: 30, Period: time.Minute, KeyBy: "ip"}},
+						"POST /users:batch":      {{Requests: 100, Period: time.Minute, KeyBy: "user", WeighBy: "operations"}},
+						"POST /auth/mfa/confirm": {{Requests: 5, Period: time.Minute, KeyBy: "user"}},
+						"POST /auth/mfa/disable": {{Requests: 5, Period: time.Minute, KeyBy: "user"}},
+					},
+				},
+				MFA: MFA{
+					EncryptionKey:        "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+					Issuer:               "go-users",
+					RequiredRoles:        []string{"admin"},
+					ChallengeMaxAttempts: 5,
+				},
+				OAuth: OAuth{
+					Issuer:               "http://localhost:8080",
+					AuthorizationCodeTTL: time.Minute,
+					IDTokenTTL:           15 * time.Minute,
+				},
+				Import: Import{
+					BatchSize: 500,
+				},
+				Idempotency: Idempotency{
+					TTL:           24 * time.Hour,
+					PurgeInterval: time.Hour,
+					MaxBodySize:   1 << 20,
+				},
 			},
 		},
 		{
@@ -39,8 +132,18 @@ func TestGetConfigFromEnvironment(t *testing.T) {
 			},
 			expectedError: errors.New("config not found for indicated scope"),
 		},
+		{
+			name: "Error - GetConfigFromScope with a short token secret ",
+			scope: gowebapp.Scope{
+				Environment: "short-secret",
+			},
+			expectedError: ErrTokenSecretTooShort,
+		},
 	}
 
+	_configs["short-secret"] = Config{Auth: Auth{TokenSecret: "shorter-than-32-bytes"}}
+	defer delete(_configs, "short-secret")
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			config, err := GetConfigFromScope(tt.scope)
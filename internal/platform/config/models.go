@@ -1,6 +1,10 @@
 package config
 
-import "gorm.io/gorm/logger"
+import (
+	"time"
+
+	"gorm.io/gorm/logger"
+)
 
 type Database struct {
 	User     string
@@ -11,8 +15,200 @@ type Database struct {
 	LogLevel logger.LogLevel
 }
 
+type Tracing struct {
+	ServiceName string
+	// Exporter selects where spans are sent: "otlp", "stdout", "file" or "" to disable tracing.
+	Exporter string
+	// Endpoint is the OTLP/HTTP collector host:port, used by the "otlp" exporter.
+	Endpoint string
+	// FilePath is the file spans are appended to, used by the "file" exporter.
+	FilePath string
+}
+
+type Health struct {
+	// ReadinessTimeout bounds each readiness check, eg: the database ping.
+	ReadinessTimeout time.Duration
+}
+
+type Server struct {
+	Address string
+	// ShutdownTimeout bounds how long in-flight requests are drained after SIGTERM/SIGINT.
+	ShutdownTimeout time.Duration
+	// TrustedProxies are the CIDR ranges of the reverse proxies whose X-Forwarded-For and
+	// X-Real-IP headers are trusted for the client IP. Empty uses the connection address.
+	TrustedProxies []string
+}
+
+type Events struct {
+	// WebhookURL receives every user event as a JSON POST. Empty disables the webhook sink.
+	WebhookURL     string
+	WebhookTimeout time.Duration
+	// FilePath is the file every user event is appended to as NDJSON. Empty disables the file sink.
+	FilePath string
+	// RelayInterval is how often the outbox is polled for undelivered events.
+	RelayInterval  time.Duration
+	RelayBatchSize int
+	// RelayMaxAttempts is the number of failed attempts after which an event is parked.
+	RelayMaxAttempts    int
+	RelayInitialBackoff time.Duration
+	RelayMaxBackoff     time.Duration
+	// RelayLease is how long a claimed batch is hidden from other relays while it is delivered.
+	RelayLease time.Duration
+}
+
+type Webhooks struct {
+	// DispatchInterval is how often due deliveries are sent.
+	DispatchInterval  time.Duration
+	DispatchBatchSize int
+	// Timeout bounds each delivery request.
+	Timeout time.Duration
+	// MaxAttempts is the number of failed attempts after which a delivery is dead-lettered.
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Lease is how long a claimed batch is hidden from other dispatchers while it is sent.
+	Lease time.Duration
+	// AllowPrivateNetworks lets subscriptions target loopback, link-local and private addresses.
+	// Only meant for local development.
+	AllowPrivateNetworks bool
+}
+
+type Metadata struct {
+	// Schemas maps each user metadata namespace to the JSON Schema its object must validate
+	// against. Namespaces without a schema are rejected.
+	Schemas map[string]string
+	// IndexedKeys are the "<namespace>.<key>" metadata keys users can be listed by.
+	IndexedKeys []string
+}
+
+// SigningKey is an RSA key signing or verifying access tokens.
+type SigningKey struct {
+	// ID is the key id, sent as the kid header of the tokens signed with the key.
+	ID string
+	// File is the path of the PEM encoded key. The active key must be a private key, the others
+	// can be public keys.
+	File string
+}
+
+type Auth struct {
+	// TokenSecret signs the HS256 internal tokens, like MFA challenges, that only this service
+	// verifies. Must be kept secret and be at least 32 bytes.
+	TokenSecret string
+	TokenIssuer string
+	// SigningKeys are the keys accepted when verifying access tokens, and published on the JWKS
+	// endpoint: the active key and the ones rotated out, until the tokens they signed expire.
+	SigningKeys []SigningKey
+	// ActiveKeyID is the id of the key in SigningKeys signing new RS256 access tokens. Empty
+	// signs with an ephemeral key generated on start, only meant for local development.
+	ActiveKeyID string
+	// TokenTTL is how long access tokens are valid after login.
+	TokenTTL time.Duration
+	// RefreshTokenTTL is how long refresh tokens are valid after they're issued or rotated.
+	RefreshTokenTTL time.Duration
+	// MFAChallengeTTL is how long users enrolled in MFA have to send their code after the
+	// password.
+	MFAChallengeTTL time.Duration
+	// BrowserSessionTTL is how long users stay logged in to the OAuth authorization endpoint
+	// after logging in with its form, unless their session is revoked first.
+	BrowserSessionTTL time.Duration
+}
+
+type MFA struct {
+	// EncryptionKey is the hex encoded 32 bytes AES-256 key encrypting the TOTP secrets. Must be
+	// kept secret.
+	EncryptionKey string
+	// Issuer is shown by authenticator apps next to the account.
+	Issuer string
+	// RequiredRoles can't be used without MFA: tokens of users not enrolled don't carry them.
+	RequiredRoles []string
+	// ChallengeMaxAttempts is how many codes can be sent for a single login challenge, after the
+	// password. Every wrong code also counts as a failed login towards the account lockout.
+	ChallengeMaxAttempts int
+}
+
+// OAuth configures the OAuth 2.0 and OpenID Connect provider endpoints.
+type OAuth struct {
+	// Issuer is the public base URL of the service, the iss claim of ID tokens. The endpoints of
+	// the discovery document are relative to it.
+	Issuer string
+	// AuthorizationCodeTTL is how long clients have to exchange authorization codes.
+	AuthorizationCodeTTL time.Duration
+	// IDTokenTTL is how long ID tokens are valid after they're issued.
+	IDTokenTTL time.Duration
+}
+
+// Import configures bulk imports of users.
+type Import struct {
+	// BatchSize is the number of rows imported per transaction.
+	BatchSize int
+}
+
+// Idempotency configures the idempotency keys of requests.
+type Idempotency struct {
+	// TTL is how long the response to a request with a key is replayed to its retries.
+	TTL time.Duration
+	// PurgeInterval is how often expired keys are dropped.
+	PurgeInterval time.Duration
+	// MaxBodySize is the max size in bytes of the bodies of requests with a key, buffered to be
+	// fingerprinted.
+	MaxBodySize int64
+}
+
+type Accounts struct {
+	// InitialStatus is the status of created users: "active", or "pending" to require an admin
+	// to activate them before they can log in.
+	InitialStatus string
+}
+
+// Lockout configures brute-force protection of logins.
+type Lockout struct {
+	// MaxFailures consecutive failed logins of a user lock it out. Zero disables it.
+	MaxFailures int
+	// IPMaxFailures consecutive failed logins from an IP, to any account, lock it out. Zero
+	// disables it.
+	IPMaxFailures int
+	// BaseDuration is the duration of the first lockout, doubled on each consecutive one up to
+	// MaxDuration, or without limit if it's zero.
+	BaseDuration time.Duration
+	MaxDuration  time.Duration
+	// ResetAfter the last failed login, failures and lockouts are forgotten.
+	ResetAfter time.Duration
+}
+
+type RateLimit struct {
+	// Routes maps "<METHOD> <route pattern>" (eg: "PUT /users/{id}") to its limits, every one of
+	// which must allow a request. Routes not listed are not limited.
+	Routes map[string][]RouteLimit
+}
+
+// RouteLimit allows bursts of up to Requests requests, refilled at Requests per Period, per key.
+type RouteLimit struct {
+	Requests int
+	Period   time.Duration
+	// KeyBy is "ip", "user" to limit authenticated requests by user and anonymous ones by IP, or
+	// "account" to limit requests by the "email" field of their JSON body, like logins.
+	KeyBy string
+	// WeighBy is empty for requests to count one each, or "operations" for them to count the
+	// elements of the "operations" array of their JSON body, like batches.
+	WeighBy string
+}
+
 type Config struct {
-	Database Database
+	Server      Server
+	Database    Database
+	Tracing     Tracing
+	Health      Health
+	Events      Events
+	Webhooks    Webhooks
+	Metadata    Metadata
+	Auth        Auth
+	Accounts    Accounts
+	Lockout     Lockout
+	RateLimit   RateLimit
+	MFA         MFA
+	OAuth       OAuth
+	Import      Import
+	Idempotency Idempotency
 }
 
 type Configs struct {
@@ -0,0 +1,79 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Check is a named dependency check run by the readiness endpoint.
+type Check struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+type CheckResult struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+type Response struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks"`
+}
+
+// Liveness reports that the process is up and able to serve requests. It doesn't check any
+// dependency, so a lost database doesn't get the instance restarted.
+func Liveness(w http.ResponseWriter, _ *http.Request) {
+	gowebapp.RespondWithJSON(w, http.StatusOK, Response{Status: StatusOK, Checks: []CheckResult{}})
+}
+
+// NewReadinessHandler returns a handler that runs every check with the given timeout and responds
+// 503 if any of them fails, so the instance is taken out of rotation.
+func NewReadinessHandler(timeout time.Duration, checks ...Check) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := Response{Status: StatusOK, Checks: make([]CheckResult, 0, len(checks))}
+
+		for _, check := range checks {
+			result := run(r.Context(), timeout, check)
+			if result.Status == StatusFail {
+				response.Status = StatusFail
+			}
+			response.Checks = append(response.Checks, result)
+		}
+
+		code := http.StatusOK
+		if response.Status == StatusFail {
+			code = http.StatusServiceUnavailable
+		}
+		gowebapp.RespondWithJSON(w, code, response)
+	}
+}
+
+func run(ctx context.Context, timeout time.Duration, check Check) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.Check(ctx)
+	result := CheckResult{
+		Name:      check.Name,
+		Status:    StatusOK,
+		LatencyMS: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+
+	return result
+}
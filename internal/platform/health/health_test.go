@@ -0,0 +1,75 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLiveness(t *testing.T) {
+	rr := httptest.NewRecorder()
+	Liveness(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	body, err := ioutil.ReadAll(rr.Result().Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rr.Result().StatusCode)
+	require.Equal(t, "{\"status\":\"ok\",\"checks\":[]}", string(body))
+}
+
+func TestNewReadinessHandler(t *testing.T) {
+	okCheck := Check{Name: "database", Check: func(ctx context.Context) error { return nil }}
+	failCheck := Check{Name: "migrations", Check: func(ctx context.Context) error { return errors.New("schema outdated") }}
+	slowCheck := Check{Name: "database", Check: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+
+	var tests = []struct {
+		name               string
+		checks             []Check
+		expectedResponse   string
+		expectedStatusCode int
+	}{
+		{
+			name:               "Ok - All checks pass",
+			checks:             []Check{okCheck},
+			expectedResponse:   `{"status":"ok","checks":[{"name":"database","status":"ok","latency_ms":0}]}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Fail - A check fails",
+			checks:             []Check{okCheck, failCheck},
+			expectedResponse:   `{"status":"fail","checks":[{"name":"database","status":"ok","latency_ms":0},{"name":"migrations","status":"fail","latency_ms":0,"error":"schema outdated"}]}`,
+			expectedStatusCode: http.StatusServiceUnavailable,
+		},
+		{
+			name:               "Fail - A check times out",
+			checks:             []Check{slowCheck},
+			expectedResponse:   `{"status":"fail","checks":[{"name":"database","status":"fail","latency_ms":0,"error":"context deadline exceeded"}]}`,
+			expectedStatusCode: http.StatusServiceUnavailable,
+		},
+	}
+
+	latency := regexp.MustCompile(`"latency_ms":\d+`)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewReadinessHandler(time.Millisecond, tt.checks...)
+
+			rr := httptest.NewRecorder()
+			handler(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			body, err := ioutil.ReadAll(rr.Result().Body)
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedStatusCode, rr.Result().StatusCode)
+			require.Equal(t, tt.expectedResponse, latency.ReplaceAllString(string(body), `"latency_ms":0`))
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	"github.com/marcosstupnicki/go-users/internal/platform/config"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+)
+
+const (
+	HeaderIdempotencyKey = "Idempotency-Key"
+	// HeaderReplayed is set on replayed responses.
+	HeaderReplayed = "Idempotent-Replayed"
+
+	// _maxKeyLength bounds the keys, usually UUIDs.
+	_maxKeyLength = 255
+	// _lockTimeout is how long a key is reserved by a request in progress, so keys of requests
+	// interrupted by a crash can be retried.
+	_lockTimeout = time.Minute
+)
+
+var (
+	// ErrInvalidKey idempotency key too long error
+	ErrInvalidKey = errors.New("invalid idempotency key. keys must be at most 255 characters")
+	// ErrKeyReused idempotency key used before for a different request error
+	ErrKeyReused = errors.New("idempotency key already used for a different request")
+	// ErrRequestInProgress request with the same idempotency key in progress error
+	ErrRequestInProgress = errors.New("a request with this idempotency key is in progress")
+	// ErrBodyTooLarge body of a request with an idempotency key over the max body size error
+	ErrBodyTooLarge = errors.New("request body too large")
+)
+
+// Replayer makes requests with an Idempotency-Key header safe to retry: the response to the
+// first request with a key is stored for config.Idempotency.TTL, and replayed to the retries.
+type Replayer struct {
+	store         Store
+	ttl           time.Duration
+	purgeInterval time.Duration
+	maxBodySize   int64
+	now           func() time.Time
+}
+
+func NewReplayer(store Store, cfg config.Idempotency) Replayer {
+	return Replayer{
+		store:         store,
+		ttl:           cfg.TTL,
+		purgeInterval: cfg.PurgeInterval,
+		maxBodySize:   cfg.MaxBodySize,
+		now:           time.Now,
+	}
+}
+
+// Middleware runs next once per idempotency key, and replays its response to requests with the
+// same key, method, path and principal. Reusing a key with a different body is rejected with 422,
+// and while the first request is in progress with 409. Server errors aren't stored, so those
+// requests can be retried. Bodies are buffered to be fingerprinted, and rejected with 413 over
+// config.Idempotency.MaxBodySize. It relies on auth.Middleware running first. Requests without the
+// header, anonymous ones, which would share their keys, or when the store fails, run as usual.
+func (p Replayer) Middleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(HeaderIdempotencyKey)
+		_, authenticated := auth.PrincipalFromContext(r.Context())
+		if key == "" || !authenticated {
+			next(w, r)
+			return
+		}
+		if len(key) > _maxKeyLength {
+			gowebapp.RespondWithError(w, http.StatusBadRequest, ErrInvalidKey.Error())
+			return
+		}
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, p.maxBodySize))
+		if err != nil {
+			// MaxBytesReader fails once it read the max size.
+			if int64(len(body)) >= p.maxBodySize {
+				gowebapp.RespondWithError(w, http.StatusRequestEntityTooLarge, ErrBodyTooLarge.Error())
+				return
+			}
+			gowebapp.RespondWithError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		now := p.now()
+		record, reserved, err := p.store.Reserve(r.Context(), Record{
+			KeyHash:     keyHash(r, key),
+			Fingerprint: hash(body),
+			CreatedAt:   now.Unix(),
+			ExpiresAt:   now.Add(_lockTimeout).Unix(),
+		}, now.Unix())
+		if err != nil {
+			log.Printf("error reserving idempotency key: %v", err)
+			next(w, r)
+			return
+		}
+
+		if !reserved {
+			switch {
+			case record.Fingerprint != hash(body):
+				gowebapp.RespondWithError(w, http.StatusUnprocessableEntity, ErrKeyReused.Error())
+			case record.StatusCode == 0:
+				gowebapp.RespondWithError(w, http.StatusConflict, ErrRequestInProgress.Error())
+			default:
+				replay(w, record)
+			}
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		defer func() {
+			// The request may have been canceled, the record is stored regardless.
+			ctx := context.Background()
+			if recorder.status == 0 || recorder.status >= http.StatusInternalServerError {
+				err := p.store.Delete(ctx, record.KeyHash)
+				if err != nil {
+					log.Printf("error deleting idempotency key: %v", err)
+				}
+				return
+			}
+
+			record.StatusCode = recorder.status
+			record.ContentType = recorder.Header().Get("Content-Type")
+			record.Body = recorder.body.Bytes()
+			record.ExpiresAt = p.now().Add(p.ttl).Unix()
+			err := p.store.Complete(ctx, record)
+			if err != nil {
+				log.Printf("error storing idempotent response: %v", err)
+			}
+		}()
+		next(recorder, r)
+	}
+}
+
+// Run purges the expired records every purge interval until ctx is done.
+func (p Replayer) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		_, err := p.store.Purge(ctx, p.now().Unix())
+		if err != nil && ctx.Err() == nil {
+			log.Printf("error purging idempotency keys: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// keyHash scopes key to the method, path and principal of r, so clients can't replay the
+// responses of other clients.
+func keyHash(r *http.Request, key string) string {
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	return hash([]byte(r.Method + " " + r.URL.Path + "\n" + principal.Subject + "\n" + key))
+}
+
+func hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func replay(w http.ResponseWriter, record Record) {
+	if record.ContentType != "" {
+		w.Header().Set("Content-Type", record.ContentType)
+	}
+	w.Header().Set(HeaderReplayed, "true")
+	w.WriteHeader(record.StatusCode)
+	w.Write(record.Body)
+}
+
+// responseRecorder copies the response written to ResponseWriter.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,260 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	"github.com/marcosstupnicki/go-users/internal/platform/config"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryStore is an in-process Store, failing with err if it's set.
+type memoryStore struct {
+	mu      sync.Mutex
+	records map[string]Record
+	err     error
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{records: map[string]Record{}}
+}
+
+func (s *memoryStore) Reserve(_ context.Context, record Record, now int64) (Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return Record{}, false, s.err
+	}
+
+	stored, ok := s.records[record.KeyHash]
+	if ok && stored.ExpiresAt > now {
+		return stored, false, nil
+	}
+	s.records[record.KeyHash] = record
+	return record, true, nil
+}
+
+func (s *memoryStore) Complete(_ context.Context, record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[record.KeyHash] = record
+	return nil
+}
+
+func (s *memoryStore) Delete(_ context.Context, keyHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, keyHash)
+	return nil
+}
+
+func (s *memoryStore) Purge(_ context.Context, now int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var purged int64
+	for keyHash, record := range s.records {
+		if record.ExpiresAt <= now {
+			delete(s.records, keyHash)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+type request struct {
+	key       string
+	body      string
+	principal *auth.Principal
+	// advance moves the clock forward before the request.
+	advance time.Duration
+}
+
+func TestReplayer_Middleware(t *testing.T) {
+	admin := &auth.Principal{Subject: "user:1", UserID: 1}
+	first := request{key: "some-key", body: `{"email":"some@email.com"}`, principal: admin}
+
+	var tests = []struct {
+		name               string
+		store              *memoryStore
+		status             int
+		requests           []request
+		expectedCalls      int
+		expectedStatusCode int
+		expectedResponse   string
+		expectedReplayed   bool
+	}{
+		{
+			name:               "Ok - Without key",
+			store:              newMemoryStore(),
+			requests:           []request{{body: first.body}, {body: first.body}},
+			expectedCalls:      2,
+			expectedStatusCode: http.StatusCreated,
+			expectedResponse:   `{"id":2}`,
+		},
+		{
+			name:               "Ok - Retry replayed",
+			store:              newMemoryStore(),
+			requests:           []request{first, first},
+			expectedCalls:      1,
+			expectedStatusCode: http.StatusCreated,
+			expectedResponse:   `{"id":1}`,
+			expectedReplayed:   true,
+		},
+		{
+			name:               "Ok - Client errors replayed",
+			store:              newMemoryStore(),
+			status:             http.StatusBadRequest,
+			requests:           []request{first, first},
+			expectedCalls:      1,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   `{"id":1}`,
+			expectedReplayed:   true,
+		},
+		{
+			name:               "Ok - Server errors not stored",
+			store:              newMemoryStore(),
+			status:             http.StatusInternalServerError,
+			requests:           []request{first, first},
+			expectedCalls:      2,
+			expectedStatusCode: http.StatusInternalServerError,
+			expectedResponse:   `{"id":2}`,
+		},
+		{
+			name:               "Ok - Key expired",
+			store:              newMemoryStore(),
+			requests:           []request{first, {key: first.key, body: first.body, principal: admin, advance: 25 * time.Hour}},
+			expectedCalls:      2,
+			expectedStatusCode: http.StatusCreated,
+			expectedResponse:   `{"id":2}`,
+		},
+		{
+			name:  "Ok - Keys scoped to the principal",
+			store: newMemoryStore(),
+			requests: []request{
+				first,
+				{key: first.key, body: first.body, principal: &auth.Principal{Subject: "user:7", UserID: 7}},
+			},
+			expectedCalls:      2,
+			expectedStatusCode: http.StatusCreated,
+			expectedResponse:   `{"id":2}`,
+		},
+		{
+			name:               "Ok - Anonymous key ignored",
+			store:              newMemoryStore(),
+			requests:           []request{{key: first.key, body: first.body}, {key: first.key, body: first.body}},
+			expectedCalls:      2,
+			expectedStatusCode: http.StatusCreated,
+			expectedResponse:   `{"id":2}`,
+		},
+		{
+			name:               "Ok - Store failing",
+			store:              &memoryStore{err: errors.New("store unavailable")},
+			requests:           []request{first, first},
+			expectedCalls:      2,
+			expectedStatusCode: http.StatusCreated,
+			expectedResponse:   `{"id":2}`,
+		},
+		{
+			name:               "Fail - Key reused with a different body",
+			store:              newMemoryStore(),
+			requests:           []request{first, {key: first.key, body: `{"email":"other@email.com"}`, principal: admin}},
+			expectedCalls:      1,
+			expectedStatusCode: http.StatusUnprocessableEntity,
+			expectedResponse:   `{"message":"idempotency key already used for a different request"}`,
+		},
+		{
+			name:               "Fail - Key too long",
+			store:              newMemoryStore(),
+			requests:           []request{{key: strings.Repeat("k", 256), body: first.body, principal: admin}},
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   `{"message":"invalid idempotency key. keys must be at most 255 characters"}`,
+		},
+		{
+			name:               "Fail - Body too large",
+			store:              newMemoryStore(),
+			requests:           []request{{key: first.key, body: strings.Repeat("b", 1025), principal: admin}},
+			expectedStatusCode: http.StatusRequestEntityTooLarge,
+			expectedResponse:   `{"message":"request body too large"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Unix(1700000000, 0)
+			replayer := NewReplayer(tt.store, config.Idempotency{TTL: 24 * time.Hour, MaxBodySize: 1024})
+			replayer.now = func() time.Time { return now }
+
+			status := tt.status
+			if status == 0 {
+				status = http.StatusCreated
+			}
+			calls := 0
+			handler := replayer.Middleware(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				gowebapp.RespondWithJSON(w, status, map[string]int{"id": calls})
+			})
+
+			var res *http.Response
+			for _, request := range tt.requests {
+				now = now.Add(request.advance)
+				r := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(request.body))
+				if request.key != "" {
+					r.Header.Set(HeaderIdempotencyKey, request.key)
+				}
+				if request.principal != nil {
+					r = r.WithContext(auth.WithPrincipal(r.Context(), *request.principal))
+				}
+				rr := httptest.NewRecorder()
+				handler(rr, r)
+				res = rr.Result()
+			}
+
+			resBody, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedCalls, calls)
+			require.Equal(t, tt.expectedStatusCode, res.StatusCode)
+			require.Equal(t, tt.expectedResponse, string(resBody))
+			require.Equal(t, tt.expectedReplayed, res.Header.Get(HeaderReplayed) == "true")
+			require.Equal(t, "application/json; charset=utf-8", res.Header.Get("Content-Type"))
+		})
+	}
+}
+
+func TestReplayer_Middleware_InProgress(t *testing.T) {
+	replayer := NewReplayer(newMemoryStore(), config.Idempotency{TTL: 24 * time.Hour, MaxBodySize: 1024})
+	principal := auth.Principal{Subject: "user:1", UserID: 1}
+
+	var res *http.Response
+	handler := replayer.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		// The retry arrives while the first request is still running.
+		retry := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader("{}"))
+		retry.Header.Set(HeaderIdempotencyKey, "some-key")
+		retry = retry.WithContext(auth.WithPrincipal(retry.Context(), principal))
+		rr := httptest.NewRecorder()
+		replayer.Middleware(func(w http.ResponseWriter, r *http.Request) {
+			t.Fatal("retry ran")
+		})(rr, retry)
+		res = rr.Result()
+
+		gowebapp.RespondWithJSON(w, http.StatusCreated, nil)
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader("{}"))
+	r.Header.Set(HeaderIdempotencyKey, "some-key")
+	r = r.WithContext(auth.WithPrincipal(r.Context(), principal))
+	handler(httptest.NewRecorder(), r)
+
+	resBody, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusConflict, res.StatusCode)
+	require.Equal(t, `{"message":"a request with this idempotency key is in progress"}`, string(resBody))
+}
@@ -0,0 +1,105 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrRecordNotFound record expired or deleted while reserving its key error
+	ErrRecordNotFound = errors.New("idempotency record not found")
+)
+
+// Record is the response to the first request with an idempotency key. KeyHash is the SHA-256
+// hash of the key and the request it's scoped to, Fingerprint the hash of the request body.
+// StatusCode is zero while the first request is in progress.
+type Record struct {
+	KeyHash     string `gorm:"column:key_hash;primaryKey;size:64"`
+	Fingerprint string `gorm:"column:fingerprint;size:64"`
+	StatusCode  int    `gorm:"column:status_code"`
+	ContentType string `gorm:"column:content_type;size:100"`
+	Body        []byte `gorm:"column:body"`
+	CreatedAt   int64  `gorm:"column:created_at"`
+	ExpiresAt   int64  `gorm:"column:expires_at;index"`
+}
+
+func (Record) TableName() string {
+	return "idempotency_keys"
+}
+
+// Store keeps the records of idempotency keys, shared by every instance of the API.
+type Store interface {
+	// Reserve stores record unless its key has an unexpired record, and returns the stored record
+	// and whether it's record.
+	Reserve(ctx context.Context, record Record, now int64) (Record, bool, error)
+	// Complete stores the response of a reserved record.
+	Complete(ctx context.Context, record Record) error
+	// Delete drops the record of keyHash, so its key can be used again.
+	Delete(ctx context.Context, keyHash string) error
+	// Purge drops the records expired at now and returns how many were dropped.
+	Purge(ctx context.Context, now int64) (int64, error)
+}
+
+type MySQLStore struct {
+	DB *gorm.DB
+}
+
+// NewMySQLStore returns the idempotency store over an existing connection, usually the one
+// opened by users.NewMySQL.
+func NewMySQLStore(db *gorm.DB) MySQLStore {
+	return MySQLStore{
+		DB: db,
+	}
+}
+
+func (s MySQLStore) Reserve(ctx context.Context, record Record, now int64) (Record, bool, error) {
+	db := s.DB.WithContext(ctx)
+	err := db.Where("key_hash = ? AND expires_at <= ?", record.KeyHash, now).Delete(&Record{}).Error
+	if err != nil {
+		return Record{}, false, err
+	}
+
+	tx := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+	if tx.Error != nil {
+		return Record{}, false, tx.Error
+	}
+	if tx.RowsAffected == 1 {
+		return record, true, nil
+	}
+
+	var stored Record
+	tx = db.Where("key_hash = ?", record.KeyHash).Limit(1).Find(&stored)
+	if tx.Error != nil {
+		return Record{}, false, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return Record{}, false, ErrRecordNotFound
+	}
+
+	return stored, false, nil
+}
+
+func (s MySQLStore) Complete(ctx context.Context, record Record) error {
+	return s.DB.WithContext(ctx).Model(&Record{}).Where("key_hash = ?", record.KeyHash).Updates(map[string]interface{}{
+		"status_code":  record.StatusCode,
+		"content_type": record.ContentType,
+		"body":         record.Body,
+		"expires_at":   record.ExpiresAt,
+	}).Error
+}
+
+func (s MySQLStore) Delete(ctx context.Context, keyHash string) error {
+	return s.DB.WithContext(ctx).Where("key_hash = ?", keyHash).Delete(&Record{}).Error
+}
+
+func (s MySQLStore) Purge(ctx context.Context, now int64) (int64, error) {
+	tx := s.DB.WithContext(ctx).Where("expires_at <= ?", now).Delete(&Record{})
+	return tx.RowsAffected, tx.Error
+}
+
+func (s MySQLStore) AutoMigrate() error {
+	return s.DB.AutoMigrate(&Record{})
+}
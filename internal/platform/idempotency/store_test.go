@@ -0,0 +1,87 @@
+package idempotency
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestMySQLStore_Reserve(t *testing.T) {
+	record := Record{KeyHash: "some-hash", Fingerprint: "some-fingerprint", CreatedAt: 1700000000, ExpiresAt: 1700000060}
+	stored := Record{KeyHash: "some-hash", Fingerprint: "some-fingerprint", StatusCode: 201, ContentType: "application/json", Body: []byte(`{"id":1}`), CreatedAt: 1699990000, ExpiresAt: 1700076400}
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	expire := regexp.QuoteMeta("DELETE FROM `idempotency_keys` WHERE key_hash = ? AND expires_at <= ?")
+	insert := regexp.QuoteMeta("INSERT INTO `idempotency_keys` (`key_hash`,`fingerprint`,`status_code`,`content_type`,`body`,`created_at`,`expires_at`) VALUES (?,?,?,?,?,?,?) ON DUPLICATE KEY UPDATE `key_hash`=`key_hash`")
+	mock.ExpectBegin()
+	mock.ExpectExec(expire).
+		WithArgs("some-hash", 1700000000).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(insert).
+		WithArgs("some-hash", "some-fingerprint", 0, "", sqlmock.AnyArg(), 1700000000, 1700000060).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(expire).
+		WithArgs("some-hash", 1700000000).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(insert).
+		WithArgs("some-hash", "some-fingerprint", 0, "", sqlmock.AnyArg(), 1700000000, 1700000060).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `idempotency_keys` WHERE key_hash = ? LIMIT 1")).
+		WithArgs("some-hash").
+		WillReturnRows(sqlmock.NewRows([]string{"key_hash", "fingerprint", "status_code", "content_type", "body", "created_at", "expires_at"}).
+			AddRow(stored.KeyHash, stored.Fingerprint, stored.StatusCode, stored.ContentType, stored.Body, stored.CreatedAt, stored.ExpiresAt))
+
+	gormDB, err := gorm.Open(
+		mysql.New(mysql.Config{
+			Conn:                      db,
+			SkipInitializeWithVersion: true}),
+		&gorm.Config{})
+	require.NoError(t, err)
+
+	store := NewMySQLStore(gormDB)
+	result, reserved, err := store.Reserve(context.Background(), record, 1700000000)
+	require.NoError(t, err)
+	require.True(t, reserved)
+	require.Equal(t, record, result)
+
+	result, reserved, err = store.Reserve(context.Background(), record, 1700000000)
+	require.NoError(t, err)
+	require.False(t, reserved)
+	require.Equal(t, stored, result)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMySQLStore_Complete(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `idempotency_keys` SET `body`=?,`content_type`=?,`expires_at`=?,`status_code`=? WHERE key_hash = ?")).
+		WithArgs([]byte(`{"id":1}`), "application/json", 1700086400, 201, "some-hash").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	gormDB, err := gorm.Open(
+		mysql.New(mysql.Config{
+			Conn:                      db,
+			SkipInitializeWithVersion: true}),
+		&gorm.Config{})
+	require.NoError(t, err)
+
+	store := NewMySQLStore(gormDB)
+	err = store.Complete(context.Background(), Record{KeyHash: "some-hash", StatusCode: 201, ContentType: "application/json", Body: []byte(`{"id":1}`), ExpiresAt: 1700086400})
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstrumentHandler(t *testing.T) {
+	var tests = []struct {
+		name          string
+		path          string
+		status        int
+		expectedRoute string
+		expectedCode  string
+	}{
+		{
+			name:          "Ok - Route pattern used as label",
+			path:          "/users/5",
+			status:        http.StatusOK,
+			expectedRoute: "/users/{id}",
+			expectedCode:  "200",
+		},
+		{
+			name:          "Ok - Status code written by handler",
+			path:          "/users/6",
+			status:        http.StatusNotFound,
+			expectedRoute: "/users/{id}",
+			expectedCode:  "404",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := gowebapp.NewWebApp("local")
+			app.Get("/users/{id}", InstrumentHandler(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+
+			before := testutil.ToFloat64(httpRequestsTotal.WithLabelValues(http.MethodGet, tt.expectedRoute, tt.expectedCode))
+
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rr := httptest.NewRecorder()
+			app.Router.ServeHTTP(rr, r)
+
+			after := testutil.ToFloat64(httpRequestsTotal.WithLabelValues(http.MethodGet, tt.expectedRoute, tt.expectedCode))
+			require.Equal(t, tt.status, rr.Result().StatusCode)
+			require.Equal(t, before+1, after)
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	app := gowebapp.NewWebApp("local")
+	app.Get("/users/{id}", InstrumentHandler(func(w http.ResponseWriter, r *http.Request) {}))
+	app.Get("/metrics", Handler().ServeHTTP)
+
+	app.Router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/1", nil))
+
+	rr := httptest.NewRecorder()
+	app.Router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body, err := ioutil.ReadAll(rr.Result().Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rr.Result().StatusCode)
+	require.True(t, strings.Contains(string(body), `http_requests_total{code="200",method="GET",route="/users/{id}"}`))
+}
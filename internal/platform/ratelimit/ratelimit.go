@@ -0,0 +1,281 @@
+package ratelimit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	"github.com/marcosstupnicki/go-users/internal/platform/config"
+	"github.com/marcosstupnicki/go-users/internal/platform/requestmeta"
+)
+
+const (
+	// KeyByIP limits requests by client IP.
+	KeyByIP = "ip"
+	// KeyByUser limits authenticated requests by user, and anonymous ones by client IP.
+	KeyByUser = "user"
+	// KeyByAccount limits requests by the account they name in the "email" field of their JSON or
+	// form body, like logins, and the ones without it by client IP.
+	KeyByAccount = "account"
+
+	// WeighByOperations makes requests cost the number of elements of the "operations" array of
+	// their JSON body, like batches, instead of one.
+	WeighByOperations = "operations"
+
+	// _maxAccountBodySize bounds how much of a body is read looking for its account.
+	_maxAccountBodySize = 64 << 10
+	// _maxOperationsBodySize bounds how much of a body is read counting its operations.
+	_maxOperationsBodySize = 1 << 20
+
+	HeaderLimit      = "RateLimit-Limit"
+	HeaderRemaining  = "RateLimit-Remaining"
+	HeaderReset      = "RateLimit-Reset"
+	HeaderRetryAfter = "Retry-After"
+)
+
+var (
+	// ErrInvalidRouteLimit route limit without requests, period or a known key error
+	ErrInvalidRouteLimit = errors.New("invalid route rate limit")
+)
+
+// Problem is an RFC 7807 problem details response.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail"`
+}
+
+type route struct {
+	limit   Limit
+	keyBy   string
+	weighBy string
+}
+
+// Limiter limits the requests to the routes configured in config.RateLimit.
+type Limiter struct {
+	store  Store
+	routes map[string][]route
+	now    func() time.Time
+}
+
+func NewLimiter(store Store, cfg config.RateLimit) (Limiter, error) {
+	routes := make(map[string][]route, len(cfg.Routes))
+	for name, routeLimits := range cfg.Routes {
+		for _, routeLimit := range routeLimits {
+			if routeLimit.Requests <= 0 || routeLimit.Period <= 0 || !knownKey(routeLimit.KeyBy) ||
+				(routeLimit.WeighBy != "" && routeLimit.WeighBy != WeighByOperations) {
+				return Limiter{}, fmt.Errorf("%w %q", ErrInvalidRouteLimit, name)
+			}
+			routes[name] = append(routes[name], route{
+				limit:   Limit{Requests: routeLimit.Requests, Period: routeLimit.Period},
+				keyBy:   routeLimit.KeyBy,
+				weighBy: routeLimit.WeighBy,
+			})
+		}
+	}
+
+	return Limiter{
+		store:  store,
+		routes: routes,
+		now:    time.Now,
+	}, nil
+}
+
+// Middleware limits the requests to next if its route is configured, identified by
+// "<METHOD> <route pattern>" (eg: "PUT /users/{id}"). It relies on requestmeta.Collector and
+// auth.Middleware running first. Requests are allowed if the store fails, so an outage of a shared
+// store doesn't take the API down. With several limits, the headers describe the tightest one.
+// Weighted limits count the operations of requests instead.
+func (l Limiter) Middleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.Method + " " + routePattern(r)
+		routes, ok := l.routes[name]
+		if !ok {
+			next(w, r)
+			return
+		}
+
+		var route route
+		var result Result
+		for i, candidate := range routes {
+			taken, err := l.store.Take(r.Context(), name+"|"+key(r, candidate.keyBy), candidate.limit, cost(r, candidate.weighBy), l.now())
+			if err != nil {
+				log.Printf("error taking rate limit token: %v", err)
+				next(w, r)
+				return
+			}
+			if i == 0 || tighter(taken, result) {
+				route, result = candidate, taken
+			}
+		}
+
+		w.Header().Set(HeaderLimit, strconv.Itoa(route.limit.Requests))
+		w.Header().Set(HeaderRemaining, strconv.Itoa(result.Remaining))
+		w.Header().Set(HeaderReset, ceilSeconds(result.Reset))
+		if !result.Allowed {
+			w.Header().Set(HeaderRetryAfter, ceilSeconds(result.RetryAfter))
+			respondWithProblem(w, Problem{
+				Type:   "about:blank",
+				Title:  http.StatusText(http.StatusTooManyRequests),
+				Status: http.StatusTooManyRequests,
+				Detail: fmt.Sprintf("rate limit of %d %s per %s exceeded", route.limit.Requests, unit(route.weighBy), route.limit.Period),
+			})
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// key returns the bucket key of r.
+func key(r *http.Request, keyBy string) string {
+	switch keyBy {
+	case KeyByUser:
+		principal, ok := auth.PrincipalFromContext(r.Context())
+		if ok {
+			return principal.Subject
+		}
+	case KeyByAccount:
+		account := accountFromBody(r)
+		if account != "" {
+			return "account:" + account
+		}
+	}
+
+	return "ip:" + requestmeta.FromContext(r.Context()).IP
+}
+
+// cost returns the tokens r takes from the buckets of a limit weighed by weighBy, at least one.
+// Bodies whose operations can't be counted cost the whole bucket, like any over the size read.
+func cost(r *http.Request, weighBy string) int {
+	if weighBy != WeighByOperations {
+		return 1
+	}
+
+	operations, ok := operationsFromBody(r)
+	if !ok {
+		return math.MaxInt32
+	}
+	if operations < 1 {
+		return 1
+	}
+
+	return operations
+}
+
+// operationsFromBody returns the number of elements of the "operations" array of the JSON body of
+// r, and whether it could be read. The body read is put back for the handler.
+func operationsFromBody(r *http.Request) (int, bool) {
+	if r.Body == nil {
+		return 0, true
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, _maxOperationsBodySize))
+	r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	if err != nil {
+		return 0, false
+	}
+
+	var fields struct {
+		Operations []json.RawMessage `json:"operations"`
+	}
+	if json.Unmarshal(body, &fields) != nil {
+		return 0, false
+	}
+
+	return len(fields.Operations), true
+}
+
+// accountFromBody returns the normalized "email" field of the JSON or form body of r, or empty. The
+// body read is put back for the handler.
+func accountFromBody(r *http.Request) string {
+	if r.Body == nil {
+		return ""
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, _maxAccountBodySize))
+	r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	if err != nil {
+		return ""
+	}
+
+	var email string
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/x-www-form-urlencoded" {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return ""
+		}
+		email = form.Get("email")
+	} else {
+		var fields struct {
+			Email string `json:"email"`
+		}
+		if json.Unmarshal(body, &fields) != nil {
+			return ""
+		}
+		email = fields.Email
+	}
+
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// unit names what weighBy counts, for the problem details of rejected requests.
+func unit(weighBy string) string {
+	if weighBy == WeighByOperations {
+		return "operations"
+	}
+
+	return "requests"
+}
+
+func knownKey(keyBy string) bool {
+	return keyBy == KeyByIP || keyBy == KeyByUser || keyBy == KeyByAccount
+}
+
+// tighter reports whether a leaves fewer requests than b: rejected before allowed, then the one
+// rejected for longer or with fewer remaining requests.
+func tighter(a Result, b Result) bool {
+	if a.Allowed != b.Allowed {
+		return !a.Allowed
+	}
+	if !a.Allowed {
+		return a.RetryAfter > b.RetryAfter
+	}
+
+	return a.Remaining < b.Remaining
+}
+
+func routePattern(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return ""
+	}
+
+	return rctx.RoutePattern()
+}
+
+func respondWithProblem(w http.ResponseWriter, problem Problem) {
+	body, _ := json.Marshal(problem)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.Status)
+	w.Write(body)
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
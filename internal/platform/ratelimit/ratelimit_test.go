@@ -0,0 +1,232 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	"github.com/marcosstupnicki/go-users/internal/platform/config"
+	"github.com/marcosstupnicki/go-users/internal/platform/requestmeta"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+type failingStore struct{}
+
+func (failingStore) Take(_ context.Context, _ string, _ Limit, _ int, _ time.Time) (Result, error) {
+	return Result{}, errors.New("store unavailable")
+}
+
+func TestNewLimiter(t *testing.T) {
+	_, err := NewLimiter(NewMemoryStore(time.Minute), config.RateLimit{Routes: map[string][]config.RouteLimit{
+		"POST /users": {{Requests: 1, Period: time.Minute, KeyBy: "session"}},
+	}})
+	require.True(t, errors.Is(err, ErrInvalidRouteLimit))
+
+	_, err = NewLimiter(NewMemoryStore(time.Minute), config.RateLimit{Routes: map[string][]config.RouteLimit{
+		"POST /users:batch": {{Requests: 1, Period: time.Minute, KeyBy: KeyByUser, WeighBy: "bytes"}},
+	}})
+	require.True(t, errors.Is(err, ErrInvalidRouteLimit))
+}
+
+func TestLimiter_Middleware(t *testing.T) {
+	cfg := config.RateLimit{Routes: map[string][]config.RouteLimit{
+		"POST /users":     {{Requests: 1, Period: time.Minute, KeyBy: KeyByIP}},
+		"PUT /users/{id}": {{Requests: 1, Period: time.Minute, KeyBy: KeyByUser}},
+		"POST /auth/login": {
+			{Requests: 5, Period: time.Minute, KeyBy: KeyByIP},
+			{Requests: 2, Period: time.Minute, KeyBy: KeyByAccount},
+		},
+		"POST /users:batch": {{Requests: 5, Period: time.Minute, KeyBy: KeyByUser, WeighBy: WeighByOperations}},
+	}}
+
+	var tests = []struct {
+		name               string
+		store              Store
+		method             string
+		path               string
+		body               func(i int) string
+		principal          *auth.Principal
+		requests           int
+		expectedStatusCode int
+		expectedHeaders    map[string]string
+		expectedResponse   string
+	}{
+		{
+			name:               "Ok - Within limit",
+			store:              NewMemoryStore(time.Minute),
+			method:             http.MethodPost,
+			path:               "/users",
+			requests:           1,
+			expectedStatusCode: http.StatusOK,
+			expectedHeaders:    map[string]string{HeaderLimit: "1", HeaderRemaining: "0", HeaderReset: "60"},
+		},
+		{
+			name:               "Fail - Limit exceeded",
+			store:              NewMemoryStore(time.Minute),
+			method:             http.MethodPost,
+			path:               "/users",
+			requests:           2,
+			expectedStatusCode: http.StatusTooManyRequests,
+			expectedHeaders:    map[string]string{HeaderLimit: "1", HeaderRemaining: "0", HeaderRetryAfter: "60", "Content-Type": "application/problem+json"},
+			expectedResponse:   `{"type":"about:blank","title":"Too Many Requests","status":429,"detail":"rate limit of 1 requests per 1m0s exceeded"}`,
+		},
+		{
+			name:               "Ok - Route not limited",
+			store:              NewMemoryStore(time.Minute),
+			method:             http.MethodGet,
+			path:               "/users/5",
+			requests:           3,
+			expectedStatusCode: http.StatusOK,
+			expectedHeaders:    map[string]string{HeaderLimit: ""},
+		},
+		{
+			name:               "Ok - Tightest limit in the headers",
+			store:              NewMemoryStore(time.Minute),
+			method:             http.MethodPost,
+			path:               "/auth/login",
+			body:               func(int) string { return `{"email":"some@email.com"}` },
+			requests:           1,
+			expectedStatusCode: http.StatusOK,
+			expectedHeaders:    map[string]string{HeaderLimit: "2", HeaderRemaining: "1"},
+		},
+		{
+			name:               "Fail - Account limit exceeded",
+			store:              NewMemoryStore(time.Minute),
+			method:             http.MethodPost,
+			path:               "/auth/login",
+			body:               func(int) string { return `{"email":" Some@Email.com"}` },
+			requests:           3,
+			expectedStatusCode: http.StatusTooManyRequests,
+			expectedHeaders:    map[string]string{HeaderLimit: "2", HeaderRemaining: "0"},
+			expectedResponse:   `{"type":"about:blank","title":"Too Many Requests","status":429,"detail":"rate limit of 2 requests per 1m0s exceeded"}`,
+		},
+		{
+			name:               "Ok - Account limits independent",
+			store:              NewMemoryStore(time.Minute),
+			method:             http.MethodPost,
+			path:               "/auth/login",
+			body:               func(i int) string { return fmt.Sprintf(`{"email":"user%d@email.com"}`, i) },
+			requests:           3,
+			expectedStatusCode: http.StatusOK,
+			expectedHeaders:    map[string]string{HeaderLimit: "2", HeaderRemaining: "1"},
+		},
+		{
+			name:               "Ok - Weighted by operations",
+			store:              NewMemoryStore(time.Minute),
+			method:             http.MethodPost,
+			path:               "/users:batch",
+			body:               func(int) string { return `{"operations":[{"method":"DELETE","id":1},{"method":"DELETE","id":2}]}` },
+			requests:           2,
+			expectedStatusCode: http.StatusOK,
+			expectedHeaders:    map[string]string{HeaderLimit: "5", HeaderRemaining: "1"},
+		},
+		{
+			name:               "Fail - Operations limit exceeded",
+			store:              NewMemoryStore(time.Minute),
+			method:             http.MethodPost,
+			path:               "/users:batch",
+			body:               func(int) string { return `{"operations":[{"method":"DELETE","id":1},{"method":"DELETE","id":2}]}` },
+			requests:           3,
+			expectedStatusCode: http.StatusTooManyRequests,
+			expectedHeaders:    map[string]string{HeaderLimit: "5", HeaderRemaining: "1", HeaderRetryAfter: "12"},
+			expectedResponse:   `{"type":"about:blank","title":"Too Many Requests","status":429,"detail":"rate limit of 5 operations per 1m0s exceeded"}`,
+		},
+		{
+			name:               "Fail - Unreadable operations take the whole bucket",
+			store:              NewMemoryStore(time.Minute),
+			method:             http.MethodPost,
+			path:               "/users:batch",
+			body:               func(int) string { return `{"operations":` },
+			requests:           2,
+			expectedStatusCode: http.StatusTooManyRequests,
+			expectedHeaders:    map[string]string{HeaderLimit: "5", HeaderRemaining: "0"},
+			expectedResponse:   `{"type":"about:blank","title":"Too Many Requests","status":429,"detail":"rate limit of 5 operations per 1m0s exceeded"}`,
+		},
+		{
+			name:               "Ok - Store failure allows requests",
+			store:              failingStore{},
+			method:             http.MethodPost,
+			path:               "/users",
+			requests:           2,
+			expectedStatusCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter, err := NewLimiter(tt.store, cfg)
+			require.NoError(t, err)
+
+			ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+			app := gowebapp.NewWebApp("local")
+			app.Post("/users", limiter.Middleware(ok))
+			app.Get("/users/{id}", limiter.Middleware(ok))
+			app.Post("/users:batch", limiter.Middleware(func(w http.ResponseWriter, r *http.Request) {
+				body, err := ioutil.ReadAll(r.Body)
+				require.NoError(t, err)
+				require.Contains(t, string(body), "operations")
+				w.WriteHeader(http.StatusOK)
+			}))
+			app.Post("/auth/login", limiter.Middleware(func(w http.ResponseWriter, r *http.Request) {
+				// The handler still reads the whole body.
+				body, err := ioutil.ReadAll(r.Body)
+				require.NoError(t, err)
+				require.Contains(t, string(body), "email")
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			var res *http.Response
+			for i := 0; i < tt.requests; i++ {
+				var body io.Reader
+				if tt.body != nil {
+					body = strings.NewReader(tt.body(i))
+				}
+				r := httptest.NewRequest(tt.method, tt.path, body)
+				rr := httptest.NewRecorder()
+				app.Router.ServeHTTP(rr, r)
+				res = rr.Result()
+			}
+
+			resBody, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedStatusCode, res.StatusCode)
+			require.Equal(t, tt.expectedResponse, string(resBody))
+			for header, value := range tt.expectedHeaders {
+				require.Equal(t, value, res.Header.Get(header), header)
+			}
+		})
+	}
+}
+
+func TestKey(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPut, "/users/5", nil)
+	r = r.WithContext(requestmeta.WithMetadata(r.Context(), requestmeta.Metadata{IP: "203.0.113.9"}))
+	require.Equal(t, "ip:203.0.113.9", key(r, KeyByIP))
+	require.Equal(t, "ip:203.0.113.9", key(r, KeyByUser))
+
+	r = r.WithContext(auth.WithPrincipal(r.Context(), auth.Principal{Subject: "user:5", UserID: 5}))
+	require.Equal(t, "ip:203.0.113.9", key(r, KeyByIP))
+	require.Equal(t, "user:5", key(r, KeyByUser))
+	require.Equal(t, "ip:203.0.113.9", key(r, KeyByAccount))
+
+	r = httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{"email":"Some@Email.com","password":"secret"}`))
+	require.Equal(t, "account:some@email.com", key(r, KeyByAccount))
+	body, err := ioutil.ReadAll(r.Body)
+	require.NoError(t, err)
+	require.Equal(t, `{"email":"Some@Email.com","password":"secret"}`, string(body))
+
+	r = httptest.NewRequest(http.MethodPost, "/oauth/authorize", strings.NewReader("email=Some%40Email.com&password=secret"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	require.Equal(t, "account:some@email.com", key(r, KeyByAccount))
+	require.NoError(t, r.ParseForm())
+	require.Equal(t, "secret", r.PostForm.Get("password"))
+}
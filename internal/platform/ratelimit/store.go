@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit allows bursts of up to Requests requests, refilled at Requests per Period.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// Result is the outcome of taking a token from a bucket.
+type Result struct {
+	Allowed bool
+	// Remaining is the number of requests allowed right after this one.
+	Remaining int
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed, zero if Allowed.
+	RetryAfter time.Duration
+}
+
+// Store keeps the token buckets. MemoryStore keeps them per process, so with several instances
+// each enforces its own limit; a shared Store, e.g. over Redis, makes the limit global.
+type Store interface {
+	// Take takes cost tokens at now from the bucket of key, refilled as limit. Costs over
+	// limit.Requests take the whole bucket, so they're allowed once it's full.
+	Take(ctx context.Context, key string, limit Limit, cost int, now time.Time) (Result, error)
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// MemoryStore is an in-process Store. Buckets are dropped once full, so idle keys don't
+// accumulate.
+type MemoryStore struct {
+	mu            sync.Mutex
+	buckets       map[string]*bucket
+	sweepInterval time.Duration
+	lastSweep     time.Time
+}
+
+func NewMemoryStore(sweepInterval time.Duration) *MemoryStore {
+	return &MemoryStore{
+		buckets:       map[string]*bucket{},
+		sweepInterval: sweepInterval,
+	}
+}
+
+func (s *MemoryStore) Take(_ context.Context, key string, limit Limit, cost int, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) >= s.sweepInterval {
+		s.sweep(limit, now)
+	}
+
+	capacity := float64(limit.Requests)
+	rate := capacity / limit.Period.Seconds()
+	tokens := math.Min(capacity, float64(cost))
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, updated: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+
+	result := Result{}
+	if b.tokens >= tokens {
+		b.tokens -= tokens
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((tokens - b.tokens) / rate)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = seconds((capacity - b.tokens) / rate)
+
+	return result, nil
+}
+
+// sweep drops the buckets refilled by now. Buckets are swept with the limit of the request that
+// triggered the sweep, which at worst drops a bucket early, allowing a few extra requests.
+func (s *MemoryStore) sweep(limit Limit, now time.Time) {
+	for key, b := range s.buckets {
+		if now.Sub(b.updated) >= limit.Period {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore_Take(t *testing.T) {
+	limit := Limit{Requests: 2, Period: 10 * time.Second}
+	now := time.Unix(1651422724, 0)
+
+	var tests = []struct {
+		name     string
+		at       time.Time
+		cost     int
+		expected Result
+	}{
+		{
+			name:     "Ok - First request",
+			at:       now,
+			cost:     1,
+			expected: Result{Allowed: true, Remaining: 1, Reset: 5 * time.Second},
+		},
+		{
+			name:     "Ok - Burst",
+			at:       now,
+			cost:     1,
+			expected: Result{Allowed: true, Remaining: 0, Reset: 10 * time.Second},
+		},
+		{
+			name:     "Fail - Bucket empty",
+			at:       now.Add(time.Second),
+			cost:     1,
+			expected: Result{Allowed: false, Remaining: 0, Reset: 9 * time.Second, RetryAfter: 4 * time.Second},
+		},
+		{
+			name:     "Ok - Refilled",
+			at:       now.Add(5 * time.Second),
+			cost:     1,
+			expected: Result{Allowed: true, Remaining: 0, Reset: 10 * time.Second},
+		},
+		{
+			name:     "Fail - Not enough tokens for the cost",
+			at:       now.Add(10 * time.Second),
+			cost:     2,
+			expected: Result{Allowed: false, Remaining: 1, Reset: 5 * time.Second, RetryAfter: 5 * time.Second},
+		},
+		{
+			name:     "Ok - Cost over the limit takes the whole bucket",
+			at:       now.Add(15 * time.Second),
+			cost:     5,
+			expected: Result{Allowed: true, Remaining: 0, Reset: 10 * time.Second},
+		},
+	}
+
+	store := NewMemoryStore(time.Hour)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := store.Take(context.Background(), "some-key", limit, tt.cost, tt.at)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestMemoryStore_Sweep(t *testing.T) {
+	limit := Limit{Requests: 2, Period: 10 * time.Second}
+	now := time.Unix(1651422724, 0)
+	store := NewMemoryStore(time.Minute)
+
+	_, err := store.Take(context.Background(), "idle-key", limit, 1, now)
+	require.NoError(t, err)
+	_, err = store.Take(context.Background(), "other-key", limit, 1, now.Add(time.Minute))
+	require.NoError(t, err)
+
+	require.Len(t, store.buckets, 1)
+	require.Contains(t, store.buckets, "other-key")
+}
@@ -0,0 +1,129 @@
+package requestmeta
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+// Metadata identifies the HTTP request that triggered an operation.
+type Metadata struct {
+	RequestID string
+	IP        string
+	UserAgent string
+}
+
+type metadataKey struct{}
+
+// WithMetadata returns a copy of ctx carrying metadata.
+func WithMetadata(ctx context.Context, metadata Metadata) context.Context {
+	return context.WithValue(ctx, metadataKey{}, metadata)
+}
+
+// FromContext returns the metadata carried by ctx, empty outside of a request.
+func FromContext(ctx context.Context) Metadata {
+	metadata, _ := ctx.Value(metadataKey{}).(Metadata)
+	return metadata
+}
+
+type peerAddressKey struct{}
+
+// PeerAddress keeps the address of the connection a request came from, before go-webapp's RealIP
+// middleware replaces it with the client supplied X-Forwarded-For or X-Real-IP header. It must wrap
+// the handler of the HTTP server.
+func PeerAddress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), peerAddressKey{}, r.RemoteAddr)))
+	})
+}
+
+// Collector stores the metadata of requests in their context. Forwarded headers are only trusted
+// from proxies, so clients can't choose the IP their requests are audited and rate limited by.
+type Collector struct {
+	trustedProxies []*net.IPNet
+}
+
+// NewCollector returns a Collector trusting the forwarded headers of the peers in the
+// trustedProxies CIDR ranges. Without trusted proxies, IP is always the peer address.
+func NewCollector(trustedProxies []string) (Collector, error) {
+	networks := make([]*net.IPNet, 0, len(trustedProxies))
+	for _, cidr := range trustedProxies {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return Collector{}, err
+		}
+		networks = append(networks, network)
+	}
+
+	return Collector{
+		trustedProxies: networks,
+	}, nil
+}
+
+// Middleware stores the request metadata in the request context. It relies on the RequestID
+// middleware installed by go-webapp, and on PeerAddress.
+func (c Collector) Middleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := WithMetadata(r.Context(), Metadata{
+			RequestID: middleware.GetReqID(r.Context()),
+			IP:        c.clientIP(r),
+			UserAgent: r.UserAgent(),
+		})
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// clientIP returns the peer address, unless it's a trusted proxy. Then it's the last
+// X-Forwarded-For address not added by a trusted proxy, or X-Real-IP without X-Forwarded-For.
+func (c Collector) clientIP(r *http.Request) string {
+	peer, ok := r.Context().Value(peerAddressKey{}).(string)
+	if !ok {
+		peer = r.RemoteAddr
+	}
+	ip, _, err := net.SplitHostPort(peer)
+	if err != nil {
+		ip = peer
+	}
+	if !c.trusted(ip) {
+		return ip
+	}
+
+	forwarded := r.Header.Values("X-Forwarded-For")
+	if len(forwarded) == 0 {
+		if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+			return realIP
+		}
+		return ip
+	}
+
+	addresses := strings.Split(strings.Join(forwarded, ","), ",")
+	for i := len(addresses) - 1; i >= 0; i-- {
+		address := strings.TrimSpace(addresses[i])
+		if net.ParseIP(address) == nil {
+			return ip
+		}
+		ip = address
+		if !c.trusted(address) {
+			return address
+		}
+	}
+
+	return ip
+}
+
+func (c Collector) trusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range c.trustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,76 @@
+package requestmeta
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollector_Middleware(t *testing.T) {
+	var tests = []struct {
+		name           string
+		trustedProxies []string
+		headers        map[string]string
+		expectedIP     string
+	}{
+		{
+			name:       "Ok - Remote address",
+			expectedIP: "192.0.2.1",
+		},
+		{
+			name:       "Ok - Forwarded address ignored from an untrusted peer",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.9", "X-Real-IP": "203.0.113.9"},
+			expectedIP: "192.0.2.1",
+		},
+		{
+			name:           "Ok - Forwarded address from a trusted proxy",
+			trustedProxies: []string{"192.0.2.0/24"},
+			headers:        map[string]string{"X-Forwarded-For": "203.0.113.9"},
+			expectedIP:     "203.0.113.9",
+		},
+		{
+			name:           "Ok - Address added by the first trusted proxy",
+			trustedProxies: []string{"192.0.2.0/24", "10.0.0.0/8"},
+			headers:        map[string]string{"X-Forwarded-For": "198.51.100.7, 203.0.113.9, 10.1.2.3"},
+			expectedIP:     "203.0.113.9",
+		},
+		{
+			name:           "Ok - Real IP from a trusted proxy",
+			trustedProxies: []string{"192.0.2.0/24"},
+			headers:        map[string]string{"X-Real-IP": "203.0.113.9"},
+			expectedIP:     "203.0.113.9",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector, err := NewCollector(tt.trustedProxies)
+			require.NoError(t, err)
+
+			var metadata Metadata
+			app := gowebapp.NewWebApp("local")
+			app.Get("/ping", collector.Middleware(func(w http.ResponseWriter, r *http.Request) {
+				metadata = FromContext(r.Context())
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/ping", nil)
+			r.Header.Set("User-Agent", "some-agent/1.0")
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			PeerAddress(app.Router).ServeHTTP(httptest.NewRecorder(), r)
+
+			require.NotEmpty(t, metadata.RequestID)
+			require.Equal(t, tt.expectedIP, metadata.IP)
+			require.Equal(t, "some-agent/1.0", metadata.UserAgent)
+		})
+	}
+}
+
+func TestNewCollector(t *testing.T) {
+	_, err := NewCollector([]string{"10.0.0.0/8", "not-a-cidr"})
+	require.Error(t, err)
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+var (
+	// ErrShutdownTimeout in-flight requests not drained before the shutdown deadline error
+	ErrShutdownTimeout = errors.New("shutdown deadline exceeded before draining in-flight requests")
+)
+
+// Serve accepts connections on listener until ctx is done. Then it stops accepting new
+// connections and waits up to shutdownTimeout for in-flight requests to finish, forcing the
+// remaining connections closed and returning ErrShutdownTimeout if the deadline is exceeded.
+func Serve(ctx context.Context, srv *http.Server, listener net.Listener, shutdownTimeout time.Duration) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(listener)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err := srv.Shutdown(shutdownCtx)
+	if err != nil {
+		srv.Close()
+		if errors.Is(err, context.DeadlineExceeded) {
+			return ErrShutdownTimeout
+		}
+		return err
+	}
+
+	return nil
+}
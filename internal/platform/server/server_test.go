@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServe(t *testing.T) {
+	var tests = []struct {
+		name             string
+		handlerDuration  time.Duration
+		shutdownTimeout  time.Duration
+		expectedError    error
+		expectedResponse string
+	}{
+		{
+			name:             "Ok - In-flight request drained",
+			handlerDuration:  50 * time.Millisecond,
+			shutdownTimeout:  time.Second,
+			expectedResponse: "done",
+		},
+		{
+			name:            "Fail - Shutdown deadline exceeded",
+			handlerDuration: time.Second,
+			shutdownTimeout: 50 * time.Millisecond,
+			expectedError:   ErrShutdownTimeout,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started := make(chan struct{})
+			srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				time.Sleep(tt.handlerDuration)
+				w.Write([]byte("done"))
+			})}
+
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			served := make(chan error, 1)
+			go func() {
+				served <- Serve(ctx, srv, listener, tt.shutdownTimeout)
+			}()
+
+			responses := make(chan string, 1)
+			go func() {
+				res, err := http.Get("http://" + listener.Addr().String())
+				if err != nil {
+					responses <- ""
+					return
+				}
+				defer res.Body.Close()
+				body, _ := ioutil.ReadAll(res.Body)
+				responses <- string(body)
+			}()
+
+			<-started
+			cancel()
+
+			require.Equal(t, tt.expectedError, <-served)
+			require.Equal(t, tt.expectedResponse, <-responses)
+		})
+	}
+}
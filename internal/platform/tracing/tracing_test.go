@@ -0,0 +1,92 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/config"
+	gowebapp "github.com/marcosstupnicki/go-webapp/pkg"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestInit(t *testing.T) {
+	var tests = []struct {
+		name          string
+		cfg           config.Tracing
+		expectedError error
+	}{
+		{
+			name: "Ok - Tracing disabled",
+			cfg:  config.Tracing{},
+		},
+		{
+			name: "Ok - Stdout exporter",
+			cfg:  config.Tracing{ServiceName: "go-users", Exporter: ExporterStdout},
+		},
+		{
+			name: "Ok - File exporter",
+			cfg: config.Tracing{
+				ServiceName: "go-users",
+				Exporter:    ExporterFile,
+				FilePath:    filepath.Join(t.TempDir(), "traces.json"),
+			},
+		},
+		{
+			name:          "Fail - Unknown exporter",
+			cfg:           config.Tracing{Exporter: "unknown"},
+			expectedError: ErrUnknownExporter,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shutdown, err := Init(tt.cfg)
+			require.Equal(t, tt.expectedError, err)
+			if err != nil {
+				return
+			}
+			require.NoError(t, shutdown(context.Background()))
+		})
+	}
+}
+
+func TestInit_FileExporterWritesSpans(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+	shutdown, err := Init(config.Tracing{ServiceName: "go-users", Exporter: ExporterFile, FilePath: path})
+	require.NoError(t, err)
+
+	_, span := otel.Tracer("test").Start(context.Background(), "some-span")
+	span.End()
+	require.NoError(t, shutdown(context.Background()))
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(content), "some-span")
+}
+
+func TestInstrumentHandler(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	_, err := Init(config.Tracing{})
+	require.NoError(t, err)
+
+	app := gowebapp.NewWebApp("local")
+	app.Get("/users/{id}", InstrumentHandler(func(w http.ResponseWriter, r *http.Request) {}))
+
+	r := httptest.NewRequest(http.MethodGet, "/users/5", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	app.Router.ServeHTTP(httptest.NewRecorder(), r)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	require.Equal(t, "GET /users/{id}", spans[0].Name())
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext().TraceID().String())
+	require.Equal(t, "00f067aa0ba902b7", spans[0].Parent().SpanID().String())
+}
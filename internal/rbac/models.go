@@ -0,0 +1,78 @@
+package rbac
+
+const (
+	PermissionUsersRead   = "users:read"
+	PermissionUsersWrite  = "users:write"
+	PermissionUsersDelete = "users:delete"
+	// PermissionUsersAdmin grants every other permission, and managing roles and webhooks.
+	PermissionUsersAdmin = "users:admin"
+
+	// RoleAdmin is the role seeded with PermissionUsersAdmin by the migrate tool.
+	RoleAdmin = "admin"
+)
+
+// Permissions are every permission that can be granted to a role.
+var Permissions = []string{PermissionUsersRead, PermissionUsersWrite, PermissionUsersDelete, PermissionUsersAdmin}
+
+type RoleRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type RoleResponse struct {
+	ID          int      `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Permissions []string `json:"permissions"`
+	CreatedAt   int64    `json:"created_at"`
+	UpdatedAt   int64    `json:"updated_at"`
+}
+
+type AssignRoleRequest struct {
+	Role string `json:"role"`
+}
+
+type Permission struct {
+	ID   int    `gorm:"column:id;primaryKey"`
+	Name string `gorm:"column:name;size:64;uniqueIndex"`
+}
+
+func (Permission) TableName() string {
+	return "permissions"
+}
+
+// Role is a named set of permissions assigned to users.
+type Role struct {
+	ID          int          `gorm:"column:id;primaryKey"`
+	Name        string       `gorm:"column:name;size:64;uniqueIndex"`
+	Description string       `gorm:"column:description;size:255"`
+	Permissions []Permission `gorm:"many2many:role_permissions"`
+	CreatedAt   int64        `gorm:"column:created_at"`
+	UpdatedAt   int64        `gorm:"column:updated_at"`
+}
+
+func (Role) TableName() string {
+	return "roles"
+}
+
+// PermissionNames returns the names of the permissions granted by the role.
+func (r Role) PermissionNames() []string {
+	names := make([]string, 0, len(r.Permissions))
+	for _, permission := range r.Permissions {
+		names = append(names, permission.Name)
+	}
+
+	return names
+}
+
+// UserRole assigns a role to a user.
+type UserRole struct {
+	UserID    int   `gorm:"column:user_id;primaryKey;autoIncrement:false"`
+	RoleID    int   `gorm:"column:role_id;primaryKey;autoIncrement:false;index"`
+	CreatedAt int64 `gorm:"column:created_at"`
+}
+
+func (UserRole) TableName() string {
+	return "user_roles"
+}
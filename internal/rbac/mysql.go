@@ -0,0 +1,274 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	gomysql "github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// _mysqlErrDuplicateEntry is the MySQL server error number for unique key violations.
+const _mysqlErrDuplicateEntry = 1062
+
+type MySQL struct {
+	DB *gorm.DB
+}
+
+// NewMySQL returns the RBAC repository over an existing connection, usually the one opened by
+// users.NewMySQL.
+func NewMySQL(db *gorm.DB) MySQL {
+	return MySQL{
+		DB: db,
+	}
+}
+
+func (repository MySQL) CreateRole(ctx context.Context, role Role) (Role, error) {
+	err := repository.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		permissions, err := permissionsByName(tx, role.Permissions)
+		if err != nil {
+			return err
+		}
+		role.Permissions = permissions
+
+		return tx.Create(&role).Error
+	})
+	if err != nil {
+		return Role{}, translateError(err)
+	}
+
+	return role, nil
+}
+
+func (repository MySQL) GetRole(ctx context.Context, id int) (Role, error) {
+	role := Role{ID: id}
+	tx := repository.DB.WithContext(ctx).Preload("Permissions").Limit(1).Find(&role)
+	if tx.Error != nil {
+		return Role{}, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return Role{}, ErrRoleNotFound
+	}
+
+	return role, nil
+}
+
+func (repository MySQL) ListRoles(ctx context.Context) ([]Role, error) {
+	var roles []Role
+	tx := repository.DB.WithContext(ctx).Preload("Permissions").Order("id").Find(&roles)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return roles, nil
+}
+
+func (repository MySQL) UpdateRole(ctx context.Context, role Role) (Role, error) {
+	err := repository.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		permissions, err := permissionsByName(tx, role.Permissions)
+		if err != nil {
+			return err
+		}
+		role.Permissions = permissions
+
+		result := tx.Model(&role).Updates(map[string]interface{}{
+			"description": role.Description,
+			"updated_at":  time.Now().Unix(),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrRoleNotFound
+		}
+
+		return tx.Model(&role).Association("Permissions").Replace(permissions)
+	})
+	if err != nil {
+		return Role{}, err
+	}
+
+	return repository.GetRole(ctx, role.ID)
+}
+
+func (repository MySQL) DeleteRole(ctx context.Context, id int) error {
+	return repository.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		role := Role{ID: id}
+		err := tx.Model(&role).Association("Permissions").Clear()
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("role_id = ?", id).Delete(&UserRole{}).Error
+		if err != nil {
+			return err
+		}
+
+		result := tx.Delete(&role)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrRoleNotFound
+		}
+
+		return nil
+	})
+}
+
+func (repository MySQL) UserRoles(ctx context.Context, userID int) ([]Role, error) {
+	var roles []Role
+	tx := repository.DB.WithContext(ctx).
+		Preload("Permissions").
+		Joins("JOIN user_roles ON user_roles.role_id = roles.id").
+		Where("user_roles.user_id = ?", userID).
+		Order("roles.name").
+		Find(&roles)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return roles, nil
+}
+
+// AssignRole assigns the role named roleName to a user. Assigning a role twice is a no-op.
+func (repository MySQL) AssignRole(ctx context.Context, userID int, roleName string) error {
+	role, err := repository.roleByName(ctx, roleName)
+	if err != nil {
+		return err
+	}
+
+	tx := repository.DB.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&UserRole{UserID: userID, RoleID: role.ID})
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	return nil
+}
+
+func (repository MySQL) UnassignRole(ctx context.Context, userID int, roleName string) error {
+	role, err := repository.roleByName(ctx, roleName)
+	if err != nil {
+		return err
+	}
+
+	tx := repository.DB.WithContext(ctx).Delete(&UserRole{UserID: userID, RoleID: role.ID})
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	return nil
+}
+
+func (repository MySQL) DeleteUserRoles(ctx context.Context, userID int) error {
+	tx := repository.DB.WithContext(ctx).Where("user_id = ?", userID).Delete(&UserRole{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	return nil
+}
+
+func (repository MySQL) RolePermissions(ctx context.Context, roleNames []string) ([]string, error) {
+	var names []string
+	tx := repository.DB.WithContext(ctx).
+		Model(&Permission{}).
+		Distinct("permissions.name").
+		Joins("JOIN role_permissions ON role_permissions.permission_id = permissions.id").
+		Joins("JOIN roles ON roles.id = role_permissions.role_id").
+		Where("roles.name IN ?", roleNames).
+		Pluck("permissions.name", &names)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return names, nil
+}
+
+// AutoMigrate creates the RBAC tables, and seeds the permissions and the admin role.
+func (repository MySQL) AutoMigrate() error {
+	err := repository.DB.AutoMigrate(&Permission{}, &Role{}, &UserRole{})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range Permissions {
+		err = repository.DB.FirstOrCreate(&Permission{}, Permission{Name: name}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	var admin Role
+	err = repository.DB.Where(Role{Name: RoleAdmin}).
+		Attrs(Role{Description: "Every permission."}).
+		FirstOrCreate(&admin).Error
+	if err != nil {
+		return err
+	}
+	permissions, err := permissionsByName(repository.DB, []Permission{{Name: PermissionUsersAdmin}})
+	if err != nil {
+		return err
+	}
+
+	return repository.DB.Model(&admin).Association("Permissions").Append(permissions)
+}
+
+func (repository MySQL) roleByName(ctx context.Context, name string) (Role, error) {
+	var role Role
+	tx := repository.DB.WithContext(ctx).Where("name = ?", name).Limit(1).Find(&role)
+	if tx.Error != nil {
+		return Role{}, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return Role{}, ErrRoleNotFound
+	}
+
+	return role, nil
+}
+
+// permissionsByName loads the stored permissions with the names of permissions.
+func permissionsByName(tx *gorm.DB, permissions []Permission) ([]Permission, error) {
+	if len(permissions) == 0 {
+		return []Permission{}, nil
+	}
+
+	names := make([]string, 0, len(permissions))
+	for _, permission := range permissions {
+		names = append(names, permission.Name)
+	}
+
+	var stored []Permission
+	err := tx.Where("name IN ?", names).Find(&stored).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(stored) != len(uniqueNames(names)) {
+		return nil, ErrUnknownPermission
+	}
+
+	return stored, nil
+}
+
+func uniqueNames(names []string) map[string]bool {
+	unique := make(map[string]bool, len(names))
+	for _, name := range names {
+		unique[name] = true
+	}
+
+	return unique
+}
+
+// translateError maps driver errors to the repository errors.
+func translateError(err error) error {
+	var mysqlErr *gomysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == _mysqlErrDuplicateEntry {
+		return ErrRoleAlreadyExists
+	}
+
+	return err
+}
@@ -0,0 +1,43 @@
+package rbac
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestMySQL_AssignRole(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `roles` WHERE name = ? LIMIT 1")).
+		WithArgs("support").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(2, "support"))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `user_roles` (`user_id`,`role_id`,`created_at`) VALUES (?,?,?) ON DUPLICATE KEY UPDATE `user_id`=`user_id`")).
+		WithArgs(5, 2, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `roles` WHERE name = ? LIMIT 1")).
+		WithArgs("pilot").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+
+	gormDB, err := gorm.Open(
+		mysql.New(mysql.Config{
+			Conn:                      db,
+			SkipInitializeWithVersion: true}),
+		&gorm.Config{})
+	require.NoError(t, err)
+
+	repo := NewMySQL(gormDB)
+	err = repo.AssignRole(context.Background(), 5, "support")
+	require.NoError(t, err)
+
+	err = repo.AssignRole(context.Background(), 5, "pilot")
+	require.Equal(t, ErrRoleNotFound, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,177 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"regexp"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	"github.com/marcosstupnicki/go-users/internal/users"
+)
+
+var (
+	// ErrRoleNotFound role not found error
+	ErrRoleNotFound = errors.New("role not found")
+	// ErrRoleAlreadyExists role with the same name already exists error
+	ErrRoleAlreadyExists = errors.New("role already exists")
+	// ErrInvalidRoleName role name not matching the allowed format error
+	ErrInvalidRoleName = errors.New("invalid role name. name must be lowercase letters, digits, '-' or '_', starting with a letter, up to 64 characters")
+	// ErrUnknownPermission permission that doesn't exist error
+	ErrUnknownPermission = errors.New("unknown permission")
+)
+
+var _roleName = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,63}$`)
+
+type Repository interface {
+	// CreateRole stores role, granting the permissions named in role.Permissions.
+	CreateRole(ctx context.Context, role Role) (Role, error)
+	GetRole(ctx context.Context, id int) (Role, error)
+	ListRoles(ctx context.Context) ([]Role, error)
+	// UpdateRole replaces the description and permissions of role.
+	UpdateRole(ctx context.Context, role Role) (Role, error)
+	// DeleteRole deletes a role and unassigns it from every user.
+	DeleteRole(ctx context.Context, id int) error
+	UserRoles(ctx context.Context, userID int) ([]Role, error)
+	AssignRole(ctx context.Context, userID int, roleName string) error
+	UnassignRole(ctx context.Context, userID int, roleName string) error
+	DeleteUserRoles(ctx context.Context, userID int) error
+	// RolePermissions returns the names of the permissions granted by the roles named roleNames.
+	RolePermissions(ctx context.Context, roleNames []string) ([]string, error)
+}
+
+type Service struct {
+	repository Repository
+}
+
+func NewService(repository Repository) Service {
+	return Service{
+		repository: repository,
+	}
+}
+
+func (s Service) CreateRole(ctx context.Context, role Role) (Role, error) {
+	err := validate(role)
+	if err != nil {
+		return Role{}, err
+	}
+
+	return s.repository.CreateRole(ctx, role)
+}
+
+func (s Service) GetRole(ctx context.Context, id int) (Role, error) {
+	return s.repository.GetRole(ctx, id)
+}
+
+func (s Service) ListRoles(ctx context.Context) ([]Role, error) {
+	return s.repository.ListRoles(ctx)
+}
+
+// UpdateRole replaces the description and permissions of the role with the given id. Roles can't
+// be renamed, since issued tokens carry role names.
+func (s Service) UpdateRole(ctx context.Context, id int, role Role) (Role, error) {
+	current, err := s.repository.GetRole(ctx, id)
+	if err != nil {
+		return Role{}, err
+	}
+
+	role.ID = id
+	role.Name = current.Name
+	err = validate(role)
+	if err != nil {
+		return Role{}, err
+	}
+
+	return s.repository.UpdateRole(ctx, role)
+}
+
+func (s Service) DeleteRole(ctx context.Context, id int) error {
+	return s.repository.DeleteRole(ctx, id)
+}
+
+func (s Service) UserRoles(ctx context.Context, userID int) ([]Role, error) {
+	return s.repository.UserRoles(ctx, userID)
+}
+
+// UserRoleNames returns the names of the roles assigned to a user, as embedded in its tokens.
+func (s Service) UserRoleNames(ctx context.Context, userID int) ([]string, error) {
+	roles, err := s.repository.UserRoles(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		names = append(names, role.Name)
+	}
+
+	return names, nil
+}
+
+func (s Service) AssignRole(ctx context.Context, userID int, roleName string) error {
+	return s.repository.AssignRole(ctx, userID, roleName)
+}
+
+func (s Service) UnassignRole(ctx context.Context, userID int, roleName string) error {
+	return s.repository.UnassignRole(ctx, userID, roleName)
+}
+
+// Authorize returns nil if the principal of ctx has permission, through any of its roles, or its
+// scopes for API keys. PermissionUsersAdmin grants every permission. Roles come from the principal
+// token, but their permissions are read on each call, so changes to a role apply immediately.
+func (s Service) Authorize(ctx context.Context, permission string) error {
+	principal, ok := auth.PrincipalFromContext(ctx)
+	if !ok {
+		return auth.ErrUnauthenticated
+	}
+
+	granted := principal.Scopes
+	if principal.APIKeyID == 0 {
+		if len(principal.Roles) == 0 {
+			return auth.ErrForbidden
+		}
+
+		var err error
+		granted, err = s.repository.RolePermissions(ctx, principal.Roles)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, name := range granted {
+		if name == permission || name == PermissionUsersAdmin {
+			return nil
+		}
+	}
+
+	return auth.ErrForbidden
+}
+
+// HandleUserDeleted unassigns every role of a deleted user. It's meant to be subscribed to the
+// users events bus for users.EventNameUserDeleted.
+func (s Service) HandleUserDeleted(ctx context.Context, event users.Event) error {
+	return s.repository.DeleteUserRoles(ctx, event.Metadata().UserID)
+}
+
+func validate(role Role) error {
+	if !_roleName.MatchString(role.Name) {
+		return ErrInvalidRoleName
+	}
+
+	for _, permission := range role.Permissions {
+		if !knownPermission(permission.Name) {
+			return ErrUnknownPermission
+		}
+	}
+
+	return nil
+}
+
+func knownPermission(name string) bool {
+	for _, permission := range Permissions {
+		if permission == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,186 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type RepositoryMock struct {
+	mock.Mock
+}
+
+func (r *RepositoryMock) CreateRole(_ context.Context, role Role) (Role, error) {
+	args := r.Called(role)
+	return args.Get(0).(Role), args.Error(1)
+}
+
+func (r *RepositoryMock) GetRole(_ context.Context, id int) (Role, error) {
+	args := r.Called(id)
+	return args.Get(0).(Role), args.Error(1)
+}
+
+func (r *RepositoryMock) ListRoles(_ context.Context) ([]Role, error) {
+	args := r.Called()
+	return args.Get(0).([]Role), args.Error(1)
+}
+
+func (r *RepositoryMock) UpdateRole(_ context.Context, role Role) (Role, error) {
+	args := r.Called(role)
+	return args.Get(0).(Role), args.Error(1)
+}
+
+func (r *RepositoryMock) DeleteRole(_ context.Context, id int) error {
+	args := r.Called(id)
+	return args.Error(0)
+}
+
+func (r *RepositoryMock) UserRoles(_ context.Context, userID int) ([]Role, error) {
+	args := r.Called(userID)
+	return args.Get(0).([]Role), args.Error(1)
+}
+
+func (r *RepositoryMock) AssignRole(_ context.Context, userID int, roleName string) error {
+	args := r.Called(userID, roleName)
+	return args.Error(0)
+}
+
+func (r *RepositoryMock) UnassignRole(_ context.Context, userID int, roleName string) error {
+	args := r.Called(userID, roleName)
+	return args.Error(0)
+}
+
+func (r *RepositoryMock) DeleteUserRoles(_ context.Context, userID int) error {
+	args := r.Called(userID)
+	return args.Error(0)
+}
+
+func (r *RepositoryMock) RolePermissions(_ context.Context, roleNames []string) ([]string, error) {
+	args := r.Called(roleNames)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func TestService_CreateRole(t *testing.T) {
+	var tests = []struct {
+		name          string
+		role          Role
+		expectedError error
+	}{
+		{
+			name: "Ok",
+			role: Role{Name: "support", Permissions: []Permission{{Name: PermissionUsersRead}}},
+		},
+		{
+			name:          "Fail - Invalid name",
+			role:          Role{Name: "Support Team"},
+			expectedError: ErrInvalidRoleName,
+		},
+		{
+			name:          "Fail - Unknown permission",
+			role:          Role{Name: "support", Permissions: []Permission{{Name: "users:fly"}}},
+			expectedError: ErrUnknownPermission,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := RepositoryMock{}
+			repo.On("CreateRole", tt.role).Return(tt.role, nil)
+
+			_, err := NewService(&repo).CreateRole(context.Background(), tt.role)
+			require.Equal(t, tt.expectedError, err)
+		})
+	}
+}
+
+func TestService_UpdateRole_KeepsName(t *testing.T) {
+	repo := RepositoryMock{}
+	repo.On("GetRole", 2).Return(Role{ID: 2, Name: "support"}, nil)
+	repo.On("UpdateRole", Role{ID: 2, Name: "support", Description: "Support team."}).
+		Return(Role{ID: 2, Name: "support", Description: "Support team."}, nil)
+
+	role, err := NewService(&repo).UpdateRole(context.Background(), 2, Role{Name: "renamed", Description: "Support team."})
+	require.NoError(t, err)
+	require.Equal(t, "support", role.Name)
+}
+
+func TestService_Authorize(t *testing.T) {
+	var tests = []struct {
+		name          string
+		principal     *auth.Principal
+		granted       []string
+		grantedErr    error
+		permission    string
+		expectedError error
+	}{
+		{
+			name:       "Ok - Permission granted",
+			principal:  &auth.Principal{UserID: 1, Roles: []string{"support"}},
+			granted:    []string{PermissionUsersRead},
+			permission: PermissionUsersRead,
+		},
+		{
+			name:       "Ok - Admin grants every permission",
+			principal:  &auth.Principal{UserID: 1, Roles: []string{RoleAdmin}},
+			granted:    []string{PermissionUsersAdmin},
+			permission: PermissionUsersDelete,
+		},
+		{
+			name:       "Ok - API key scope",
+			principal:  &auth.Principal{APIKeyID: 3, Scopes: []string{PermissionUsersRead}},
+			grantedErr: errors.New("roles not read for api keys"),
+			permission: PermissionUsersRead,
+		},
+		{
+			name:          "Fail - API key without the scope",
+			principal:     &auth.Principal{APIKeyID: 3, Scopes: []string{PermissionUsersRead}, Roles: []string{RoleAdmin}},
+			granted:       []string{PermissionUsersAdmin},
+			permission:    PermissionUsersWrite,
+			expectedError: auth.ErrForbidden,
+		},
+		{
+			name:          "Fail - Anonymous",
+			permission:    PermissionUsersRead,
+			expectedError: auth.ErrUnauthenticated,
+		},
+		{
+			name:          "Fail - No roles",
+			principal:     &auth.Principal{UserID: 1},
+			permission:    PermissionUsersRead,
+			expectedError: auth.ErrForbidden,
+		},
+		{
+			name:          "Fail - Permission not granted",
+			principal:     &auth.Principal{UserID: 1, Roles: []string{"support"}},
+			granted:       []string{PermissionUsersRead},
+			permission:    PermissionUsersDelete,
+			expectedError: auth.ErrForbidden,
+		},
+		{
+			name:          "Fail - Internal error",
+			principal:     &auth.Principal{UserID: 1, Roles: []string{"support"}},
+			grantedErr:    errors.New("internal error"),
+			permission:    PermissionUsersRead,
+			expectedError: errors.New("internal error"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := RepositoryMock{}
+			repo.On("RolePermissions", mock.Anything).Return(tt.granted, tt.grantedErr)
+
+			ctx := context.Background()
+			if tt.principal != nil {
+				ctx = auth.WithPrincipal(ctx, *tt.principal)
+			}
+
+			err := NewService(&repo).Authorize(ctx, tt.permission)
+			require.Equal(t, tt.expectedError, err)
+		})
+	}
+}
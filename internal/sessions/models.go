@@ -0,0 +1,54 @@
+package sessions
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type SessionResponse struct {
+	ID        int    `json:"id"`
+	UserAgent string `json:"user_agent,omitempty"`
+	IP        string `json:"ip,omitempty"`
+	// Current is set on the session of the token of the request.
+	Current    bool  `json:"current"`
+	CreatedAt  int64 `json:"created_at"`
+	LastSeenAt int64 `json:"last_seen_at"`
+}
+
+// Session is a login of a user, and the family of the refresh tokens rotated from it. Revoking it
+// invalidates every refresh token of the family.
+type Session struct {
+	ID     int `gorm:"column:id;primaryKey"`
+	UserID int `gorm:"column:user_id;index"`
+	// MFA is whether the login passed a second factor, so refreshed tokens keep the roles
+	// requiring it.
+	MFA bool `gorm:"column:mfa"`
+	// ClientID is the OAuth client the session was started for, the only one that can use its
+	// refresh tokens. It's empty for logins to this service.
+	ClientID string `gorm:"column:client_id;size:32"`
+	// UserAgent and IP are the device the user logged in from.
+	UserAgent string `gorm:"column:user_agent;size:255"`
+	IP        string `gorm:"column:ip;size:45"`
+	CreatedAt int64  `gorm:"column:created_at"`
+	// LastSeenAt is when the session was last used, by a request or a refresh.
+	LastSeenAt int64 `gorm:"column:last_seen_at"`
+	RevokedAt  int64 `gorm:"column:revoked_at"`
+}
+
+func (Session) TableName() string {
+	return "sessions"
+}
+
+// RefreshToken is a single use refresh token of a session. Only the SHA-256 hash of the token is
+// stored. Once used it's rotated: it's kept to detect reuse, and replaced by a new token.
+type RefreshToken struct {
+	ID        int    `gorm:"column:id;primaryKey"`
+	SessionID int    `gorm:"column:session_id;index"`
+	TokenHash string `gorm:"column:token_hash;size:64;uniqueIndex"`
+	ExpiresAt int64  `gorm:"column:expires_at"`
+	RotatedAt int64  `gorm:"column:rotated_at"`
+	CreatedAt int64  `gorm:"column:created_at"`
+}
+
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
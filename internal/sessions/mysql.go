@@ -0,0 +1,120 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+type MySQL struct {
+	DB *gorm.DB
+}
+
+// NewMySQL returns the sessions repository over an existing connection, usually the one opened by
+// users.NewMySQL.
+func NewMySQL(db *gorm.DB) MySQL {
+	return MySQL{
+		DB: db,
+	}
+}
+
+func (repository MySQL) CreateSession(ctx context.Context, session Session, token RefreshToken) (Session, error) {
+	err := repository.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&session).Error
+		if err != nil {
+			return err
+		}
+
+		token.SessionID = session.ID
+		return tx.Create(&token).Error
+	})
+	if err != nil {
+		return Session{}, err
+	}
+
+	return session, nil
+}
+
+func (repository MySQL) GetSession(ctx context.Context, id int) (Session, error) {
+	var session Session
+	err := repository.DB.WithContext(ctx).Where("id = ?", id).Take(&session).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return Session{}, ErrSessionNotFound
+		}
+		return Session{}, err
+	}
+
+	return session, nil
+}
+
+func (repository MySQL) GetRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	var token RefreshToken
+	err := repository.DB.WithContext(ctx).Where("token_hash = ?", tokenHash).Take(&token).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return RefreshToken{}, ErrInvalidRefreshToken
+		}
+		return RefreshToken{}, err
+	}
+
+	return token, nil
+}
+
+// RotateRefreshToken marks the refresh token with id as rotated at now and stores next in its
+// place, recording the session was seen at now. If it was already rotated, e.g. by a concurrent refresh, it returns
+// ErrRefreshTokenReused.
+func (repository MySQL) RotateRefreshToken(ctx context.Context, id int, next RefreshToken, now int64) error {
+	return repository.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&RefreshToken{}).Where("id = ? AND rotated_at = 0", id).UpdateColumn("rotated_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrRefreshTokenReused
+		}
+
+		err := tx.Model(&Session{}).Where("id = ?", next.SessionID).UpdateColumn("last_seen_at", now).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(&next).Error
+	})
+}
+
+// ListActiveSessions returns the sessions of the user not revoked and seen since, most recently
+// seen first.
+func (repository MySQL) ListActiveSessions(ctx context.Context, userID int, since int64) ([]Session, error) {
+	var sessions []Session
+	err := repository.DB.WithContext(ctx).
+		Where("user_id = ? AND revoked_at = 0 AND last_seen_at >= ?", userID, since).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+func (repository MySQL) TouchSession(ctx context.Context, id int, now int64) error {
+	return repository.DB.WithContext(ctx).Model(&Session{}).Where("id = ?", id).UpdateColumn("last_seen_at", now).Error
+}
+
+func (repository MySQL) RevokeSession(ctx context.Context, id int, now int64) error {
+	return repository.DB.WithContext(ctx).Model(&Session{}).
+		Where("id = ? AND revoked_at = 0", id).
+		UpdateColumn("revoked_at", now).Error
+}
+
+func (repository MySQL) RevokeUserSessions(ctx context.Context, userID int, now int64) error {
+	return repository.DB.WithContext(ctx).Model(&Session{}).
+		Where("user_id = ? AND revoked_at = 0", userID).
+		UpdateColumn("revoked_at", now).Error
+}
+
+func (repository MySQL) AutoMigrate() error {
+	return repository.DB.AutoMigrate(&Session{}, &RefreshToken{})
+}
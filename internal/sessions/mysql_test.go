@@ -0,0 +1,50 @@
+package sessions
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestMySQL_RotateRefreshToken(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	update := regexp.QuoteMeta("UPDATE `refresh_tokens` SET `rotated_at`=? WHERE id = ? AND rotated_at = 0")
+	mock.ExpectBegin()
+	mock.ExpectExec(update).
+		WithArgs(1700000000, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `sessions` SET `last_seen_at`=? WHERE id = ?")).
+		WithArgs(1700000000, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `refresh_tokens` (`session_id`,`token_hash`,`expires_at`,`rotated_at`,`created_at`) VALUES (?,?,?,?,?)")).
+		WithArgs(2, "some-hash", 1700003600, 0, 1700000000).
+		WillReturnResult(sqlmock.NewResult(4, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(update).
+		WithArgs(1700000000, 3).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	gormDB, err := gorm.Open(
+		mysql.New(mysql.Config{
+			Conn:                      db,
+			SkipInitializeWithVersion: true}),
+		&gorm.Config{})
+	require.NoError(t, err)
+
+	repo := NewMySQL(gormDB)
+	next := RefreshToken{SessionID: 2, TokenHash: "some-hash", ExpiresAt: 1700003600, CreatedAt: 1700000000}
+	err = repo.RotateRefreshToken(context.Background(), 3, next, 1700000000)
+	require.NoError(t, err)
+
+	err = repo.RotateRefreshToken(context.Background(), 3, next, 1700000000)
+	require.Equal(t, ErrRefreshTokenReused, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
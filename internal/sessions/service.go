@@ -0,0 +1,253 @@
+package sessions
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	"github.com/marcosstupnicki/go-users/internal/platform/requestmeta"
+	"github.com/marcosstupnicki/go-users/internal/users"
+)
+
+const (
+	// _refreshTokenSize is the size in bytes of the random refresh tokens.
+	_refreshTokenSize = 32
+	// _lastSeenResolution is how often the last seen time of a session in use is updated, so
+	// requests don't all write to the database.
+	_lastSeenResolution = time.Minute
+	// _userAgentMaxLength is the size of the user agent column.
+	_userAgentMaxLength = 255
+)
+
+var (
+	// ErrSessionNotFound session not found error
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrInvalidRefreshToken refresh token unknown, expired or of a revoked session error
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	// ErrRefreshTokenReused refresh token already rotated error. Its session is revoked.
+	ErrRefreshTokenReused = errors.New("refresh token reused, session revoked")
+)
+
+type Repository interface {
+	// CreateSession stores session with its first refresh token.
+	CreateSession(ctx context.Context, session Session, token RefreshToken) (Session, error)
+	GetSession(ctx context.Context, id int) (Session, error)
+	GetRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, id int, next RefreshToken, now int64) error
+	ListActiveSessions(ctx context.Context, userID int, since int64) ([]Session, error)
+	TouchSession(ctx context.Context, id int, now int64) error
+	RevokeSession(ctx context.Context, id int, now int64) error
+	RevokeUserSessions(ctx context.Context, userID int, now int64) error
+}
+
+// Service manages login sessions and their refresh tokens. Refresh tokens are rotated on each use,
+// and reusing a rotated token, which means it leaked, revokes the whole session.
+type Service struct {
+	repository Repository
+	ttl        time.Duration
+	now        func() time.Time
+}
+
+// NewService returns a Service issuing refresh tokens valid for ttl since their last rotation.
+func NewService(repository Repository, ttl time.Duration) Service {
+	return Service{
+		repository: repository,
+		ttl:        ttl,
+		now:        time.Now,
+	}
+}
+
+// Start starts a session for the user with id userID, from the device of the request of ctx, and
+// returns it with its first refresh token. mfa is whether the login passed a second factor.
+func (s Service) Start(ctx context.Context, userID int, mfa bool) (Session, string, error) {
+	return s.StartForClient(ctx, userID, mfa, "")
+}
+
+// StartForClient starts a session as Start for the OAuth client with id clientID, which is then
+// the only one that can refresh it.
+func (s Service) StartForClient(ctx context.Context, userID int, mfa bool, clientID string) (Session, string, error) {
+	plain, token, err := s.newRefreshToken()
+	if err != nil {
+		return Session{}, "", err
+	}
+
+	metadata := requestmeta.FromContext(ctx)
+	userAgent := metadata.UserAgent
+	if len(userAgent) > _userAgentMaxLength {
+		userAgent = userAgent[:_userAgentMaxLength]
+	}
+
+	now := s.now().Unix()
+	session, err := s.repository.CreateSession(ctx, Session{
+		UserID:     userID,
+		MFA:        mfa,
+		ClientID:   clientID,
+		UserAgent:  userAgent,
+		IP:         metadata.IP,
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}, token)
+	if err != nil {
+		return Session{}, "", err
+	}
+
+	return session, plain, nil
+}
+
+// Refresh rotates refreshToken for the OAuth client with id clientID, empty for logins to this
+// service, returning its session and the refresh token replacing it. Tokens of sessions started
+// for another client are rejected with ErrInvalidRefreshToken.
+func (s Service) Refresh(ctx context.Context, refreshToken string, clientID string) (Session, string, error) {
+	current, err := s.repository.GetRefreshToken(ctx, hashToken(refreshToken))
+	if err != nil {
+		return Session{}, "", err
+	}
+
+	session, err := s.repository.GetSession(ctx, current.SessionID)
+	if err != nil {
+		if err == ErrSessionNotFound {
+			return Session{}, "", ErrInvalidRefreshToken
+		}
+		return Session{}, "", err
+	}
+	if session.RevokedAt != 0 || session.ClientID != clientID {
+		return Session{}, "", ErrInvalidRefreshToken
+	}
+
+	now := s.now()
+	if current.RotatedAt != 0 {
+		return Session{}, "", s.revokeReused(ctx, session.ID, now)
+	}
+	if now.Unix() >= current.ExpiresAt {
+		return Session{}, "", ErrInvalidRefreshToken
+	}
+
+	plain, next, err := s.newRefreshToken()
+	if err != nil {
+		return Session{}, "", err
+	}
+	next.SessionID = session.ID
+
+	err = s.repository.RotateRefreshToken(ctx, current.ID, next, now.Unix())
+	if err != nil {
+		if err == ErrRefreshTokenReused {
+			return Session{}, "", s.revokeReused(ctx, session.ID, now)
+		}
+		return Session{}, "", err
+	}
+
+	return session, plain, nil
+}
+
+func (s Service) Get(ctx context.Context, id int) (Session, error) {
+	return s.repository.GetSession(ctx, id)
+}
+
+// List returns the active sessions of the user with id userID: not revoked, and used within the
+// refresh token TTL.
+func (s Service) List(ctx context.Context, userID int) ([]Session, error) {
+	since := s.now().Add(-s.ttl).Unix()
+	return s.repository.ListActiveSessions(ctx, userID, since)
+}
+
+// ValidateSession returns auth.ErrSessionRevoked unless the session of the access token of
+// principal is active, and records it was seen.
+func (s Service) ValidateSession(ctx context.Context, principal auth.Principal) error {
+	if principal.SessionID == 0 {
+		return auth.ErrSessionRevoked
+	}
+
+	session, err := s.repository.GetSession(ctx, principal.SessionID)
+	if err != nil {
+		if err == ErrSessionNotFound {
+			return auth.ErrSessionRevoked
+		}
+		return err
+	}
+	if session.UserID != principal.UserID || session.RevokedAt != 0 {
+		return auth.ErrSessionRevoked
+	}
+
+	now := s.now()
+	if now.Sub(time.Unix(session.LastSeenAt, 0)) < _lastSeenResolution {
+		return nil
+	}
+
+	return s.repository.TouchSession(ctx, session.ID, now.Unix())
+}
+
+// Revoke revokes the session with id sessionID of the user with id userID. Revoking a revoked
+// session does nothing.
+func (s Service) Revoke(ctx context.Context, userID int, sessionID int) error {
+	session, err := s.repository.GetSession(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	if session.UserID != userID {
+		return ErrSessionNotFound
+	}
+
+	return s.repository.RevokeSession(ctx, sessionID, s.now().Unix())
+}
+
+// RevokeAll revokes every session of the user with id userID.
+func (s Service) RevokeAll(ctx context.Context, userID int) error {
+	return s.repository.RevokeUserSessions(ctx, userID, s.now().Unix())
+}
+
+// HandleUserDeleted revokes every session of a deleted user. It's meant to be subscribed to the
+// users events bus for users.EventNameUserDeleted, as a backstop for the revocation done on delete.
+func (s Service) HandleUserDeleted(ctx context.Context, event users.Event) error {
+	return s.repository.RevokeUserSessions(ctx, event.Metadata().UserID, s.now().Unix())
+}
+
+// HandleStatusChanged revokes every session of a user whose account is no longer active. It's
+// meant to be subscribed to the users events bus for users.EventNameStatusChanged.
+func (s Service) HandleStatusChanged(ctx context.Context, event users.Event) error {
+	changed, ok := event.(users.StatusChanged)
+	if !ok || changed.To == users.StatusActive {
+		return nil
+	}
+
+	return s.repository.RevokeUserSessions(ctx, changed.UserID, s.now().Unix())
+}
+
+// revokeReused revokes the session with id sessionID after one of its rotated refresh tokens was
+// reused, and returns ErrRefreshTokenReused.
+func (s Service) revokeReused(ctx context.Context, sessionID int, now time.Time) error {
+	err := s.repository.RevokeSession(ctx, sessionID, now.Unix())
+	if err != nil {
+		return err
+	}
+
+	return ErrRefreshTokenReused
+}
+
+// newRefreshToken returns a new random refresh token, encoded for clients and hashed for storage.
+func (s Service) newRefreshToken() (string, RefreshToken, error) {
+	raw := make([]byte, _refreshTokenSize)
+	_, err := rand.Read(raw)
+	if err != nil {
+		return "", RefreshToken{}, err
+	}
+
+	plain := base64.RawURLEncoding.EncodeToString(raw)
+	now := s.now()
+	return plain, RefreshToken{
+		TokenHash: hashToken(plain),
+		ExpiresAt: now.Add(s.ttl).Unix(),
+		CreatedAt: now.Unix(),
+	}, nil
+}
+
+// hashToken returns the hex SHA-256 hash of token. Tokens are random, so a fast unsalted hash is
+// enough to make them useless if the table leaks.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
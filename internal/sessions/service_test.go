@@ -0,0 +1,342 @@
+package sessions
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	"github.com/marcosstupnicki/go-users/internal/platform/requestmeta"
+	"github.com/marcosstupnicki/go-users/internal/users"
+	"github.com/stretchr/testify/require"
+)
+
+// RepositoryMock is an in memory Repository.
+type RepositoryMock struct {
+	sessions map[int]Session
+	tokens   map[string]RefreshToken
+}
+
+func NewRepositoryMock() *RepositoryMock {
+	return &RepositoryMock{
+		sessions: map[int]Session{},
+		tokens:   map[string]RefreshToken{},
+	}
+}
+
+func (r *RepositoryMock) CreateSession(_ context.Context, session Session, token RefreshToken) (Session, error) {
+	session.ID = len(r.sessions) + 1
+	r.sessions[session.ID] = session
+	token.ID = len(r.tokens) + 1
+	token.SessionID = session.ID
+	r.tokens[token.TokenHash] = token
+	return session, nil
+}
+
+func (r *RepositoryMock) GetSession(_ context.Context, id int) (Session, error) {
+	session, ok := r.sessions[id]
+	if !ok {
+		return Session{}, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+func (r *RepositoryMock) GetRefreshToken(_ context.Context, tokenHash string) (RefreshToken, error) {
+	token, ok := r.tokens[tokenHash]
+	if !ok {
+		return RefreshToken{}, ErrInvalidRefreshToken
+	}
+	return token, nil
+}
+
+func (r *RepositoryMock) RotateRefreshToken(_ context.Context, id int, next RefreshToken, now int64) error {
+	for hash, token := range r.tokens {
+		if token.ID != id {
+			continue
+		}
+		if token.RotatedAt != 0 {
+			return ErrRefreshTokenReused
+		}
+		token.RotatedAt = now
+		r.tokens[hash] = token
+	}
+	next.ID = len(r.tokens) + 1
+	r.tokens[next.TokenHash] = next
+	return nil
+}
+
+func (r *RepositoryMock) ListActiveSessions(_ context.Context, userID int, since int64) ([]Session, error) {
+	var sessions []Session
+	for id := 1; id <= len(r.sessions); id++ {
+		session := r.sessions[id]
+		if session.UserID == userID && session.RevokedAt == 0 && session.LastSeenAt >= since {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+func (r *RepositoryMock) TouchSession(_ context.Context, id int, now int64) error {
+	session := r.sessions[id]
+	session.LastSeenAt = now
+	r.sessions[id] = session
+	return nil
+}
+
+func (r *RepositoryMock) RevokeSession(_ context.Context, id int, now int64) error {
+	session := r.sessions[id]
+	session.RevokedAt = now
+	r.sessions[id] = session
+	return nil
+}
+
+func (r *RepositoryMock) RevokeUserSessions(_ context.Context, userID int, now int64) error {
+	for id, session := range r.sessions {
+		if session.UserID == userID {
+			session.RevokedAt = now
+			r.sessions[id] = session
+		}
+	}
+	return nil
+}
+
+func TestService_Refresh(t *testing.T) {
+	repository := NewRepositoryMock()
+	s := NewService(repository, time.Hour)
+
+	ctx := requestmeta.WithMetadata(context.Background(), requestmeta.Metadata{IP: "203.0.113.9", UserAgent: "some-agent/1.0"})
+	session, first, err := s.Start(ctx, 5, true)
+	require.NoError(t, err)
+	require.Equal(t, Session{
+		ID:         1,
+		UserID:     5,
+		MFA:        true,
+		UserAgent:  "some-agent/1.0",
+		IP:         "203.0.113.9",
+		CreatedAt:  session.CreatedAt,
+		LastSeenAt: session.CreatedAt,
+	}, session)
+	require.NotContains(t, repository.tokens, first)
+
+	refreshed, second, err := s.Refresh(context.Background(), first, "")
+	require.NoError(t, err)
+	require.Equal(t, session, refreshed)
+	require.NotEqual(t, first, second)
+
+	_, third, err := s.Refresh(context.Background(), second, "")
+	require.NoError(t, err)
+
+	// Reusing a rotated token revokes the session, so its latest token is rejected too.
+	_, _, err = s.Refresh(context.Background(), first, "")
+	require.Equal(t, ErrRefreshTokenReused, err)
+	require.NotZero(t, repository.sessions[1].RevokedAt)
+
+	_, _, err = s.Refresh(context.Background(), third, "")
+	require.Equal(t, ErrInvalidRefreshToken, err)
+}
+
+func TestService_Refresh_Expired(t *testing.T) {
+	repository := NewRepositoryMock()
+	s := NewService(repository, time.Hour)
+	_, token, err := s.Start(context.Background(), 5, false)
+	require.NoError(t, err)
+
+	s.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	_, _, err = s.Refresh(context.Background(), token, "")
+	require.Equal(t, ErrInvalidRefreshToken, err)
+
+	_, _, err = s.Refresh(context.Background(), "unknown-token", "")
+	require.Equal(t, ErrInvalidRefreshToken, err)
+}
+
+func TestService_Refresh_Client(t *testing.T) {
+	repository := NewRepositoryMock()
+	s := NewService(repository, time.Hour)
+	session, token, err := s.StartForClient(context.Background(), 5, false, "some-client")
+	require.NoError(t, err)
+	require.Equal(t, "some-client", session.ClientID)
+
+	var tests = []struct {
+		name          string
+		clientID      string
+		expectedError error
+	}{
+		{
+			name:          "Fail - Another client",
+			clientID:      "other-client",
+			expectedError: ErrInvalidRefreshToken,
+		},
+		{
+			name:          "Fail - Login to this service",
+			clientID:      "",
+			expectedError: ErrInvalidRefreshToken,
+		},
+		{
+			name:     "Ok",
+			clientID: "some-client",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := s.Refresh(context.Background(), token, tt.clientID)
+			require.Equal(t, tt.expectedError, err)
+		})
+	}
+	require.Zero(t, repository.sessions[session.ID].RevokedAt)
+}
+
+func TestService_Revoke(t *testing.T) {
+	repository := NewRepositoryMock()
+	s := NewService(repository, time.Hour)
+	session, token, err := s.Start(context.Background(), 5, false)
+	require.NoError(t, err)
+
+	var tests = []struct {
+		name          string
+		userID        int
+		sessionID     int
+		expectedError error
+	}{
+		{
+			name:          "Fail - Session of another user",
+			userID:        6,
+			sessionID:     session.ID,
+			expectedError: ErrSessionNotFound,
+		},
+		{
+			name:          "Fail - Session not found",
+			userID:        5,
+			sessionID:     9,
+			expectedError: ErrSessionNotFound,
+		},
+		{
+			name:      "Ok",
+			userID:    5,
+			sessionID: session.ID,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.Revoke(context.Background(), tt.userID, tt.sessionID)
+			require.Equal(t, tt.expectedError, err)
+		})
+	}
+
+	_, _, err = s.Refresh(context.Background(), token, "")
+	require.Equal(t, ErrInvalidRefreshToken, err)
+}
+
+func TestService_HandleStatusChanged(t *testing.T) {
+	var tests = []struct {
+		name            string
+		event           users.Event
+		expectedRevoked bool
+	}{
+		{
+			name:            "Ok - Suspended",
+			event:           users.StatusChanged{EventMetadata: users.EventMetadata{UserID: 5}, From: users.StatusActive, To: users.StatusSuspended},
+			expectedRevoked: true,
+		},
+		{
+			name:  "Ok - Activated",
+			event: users.StatusChanged{EventMetadata: users.EventMetadata{UserID: 5}, From: users.StatusPending, To: users.StatusActive},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := NewRepositoryMock()
+			s := NewService(repository, time.Hour)
+			session, _, err := s.Start(context.Background(), 5, false)
+			require.NoError(t, err)
+
+			err = s.HandleStatusChanged(context.Background(), tt.event)
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedRevoked, repository.sessions[session.ID].RevokedAt != 0)
+		})
+	}
+}
+
+func TestService_ValidateSession(t *testing.T) {
+	repository := NewRepositoryMock()
+	s := NewService(repository, time.Hour)
+	active, _, err := s.Start(context.Background(), 5, false)
+	require.NoError(t, err)
+	revoked, _, err := s.Start(context.Background(), 5, false)
+	require.NoError(t, err)
+	require.NoError(t, s.Revoke(context.Background(), 5, revoked.ID))
+
+	var tests = []struct {
+		name          string
+		principal     auth.Principal
+		expectedError error
+	}{
+		{
+			name:      "Ok",
+			principal: auth.Principal{UserID: 5, SessionID: active.ID},
+		},
+		{
+			name:          "Fail - Revoked",
+			principal:     auth.Principal{UserID: 5, SessionID: revoked.ID},
+			expectedError: auth.ErrSessionRevoked,
+		},
+		{
+			name:          "Fail - Session of another user",
+			principal:     auth.Principal{UserID: 6, SessionID: active.ID},
+			expectedError: auth.ErrSessionRevoked,
+		},
+		{
+			name:          "Fail - Not found",
+			principal:     auth.Principal{UserID: 5, SessionID: 9},
+			expectedError: auth.ErrSessionRevoked,
+		},
+		{
+			name:          "Fail - Token without session",
+			principal:     auth.Principal{UserID: 5},
+			expectedError: auth.ErrSessionRevoked,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.ValidateSession(context.Background(), tt.principal)
+			require.Equal(t, tt.expectedError, err)
+		})
+	}
+
+	// The last seen time is only updated once it's older than the resolution.
+	s.now = func() time.Time { return time.Now().Add(30 * time.Second) }
+	require.NoError(t, s.ValidateSession(context.Background(), auth.Principal{UserID: 5, SessionID: active.ID}))
+	require.Equal(t, active.LastSeenAt, repository.sessions[active.ID].LastSeenAt)
+
+	later := time.Now().Add(2 * time.Minute)
+	s.now = func() time.Time { return later }
+	require.NoError(t, s.ValidateSession(context.Background(), auth.Principal{UserID: 5, SessionID: active.ID}))
+	require.Equal(t, later.Unix(), repository.sessions[active.ID].LastSeenAt)
+}
+
+func TestService_List(t *testing.T) {
+	repository := NewRepositoryMock()
+	s := NewService(repository, time.Hour)
+	first, _, err := s.Start(context.Background(), 5, false)
+	require.NoError(t, err)
+	_, _, err = s.Start(context.Background(), 6, false)
+	require.NoError(t, err)
+	revoked, _, err := s.Start(context.Background(), 5, false)
+	require.NoError(t, err)
+	require.NoError(t, s.Revoke(context.Background(), 5, revoked.ID))
+
+	sessions, err := s.List(context.Background(), 5)
+	require.NoError(t, err)
+	require.Equal(t, []Session{first}, sessions)
+
+	s.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	sessions, err = s.List(context.Background(), 5)
+	require.NoError(t, err)
+	require.Empty(t, sessions)
+
+	require.NoError(t, s.RevokeAll(context.Background(), 5))
+	require.NotZero(t, repository.sessions[first.ID].RevokedAt)
+}
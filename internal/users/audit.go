@@ -0,0 +1,167 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	"github.com/marcosstupnicki/go-users/internal/platform/requestmeta"
+)
+
+const (
+	AuditActionCreate = "create"
+	AuditActionUpdate = "update"
+	AuditActionDelete = "delete"
+
+	// _redacted replaces the value of secret fields in the audit log.
+	_redacted = "[REDACTED]"
+)
+
+// AuditEntry records one mutation of a user: who made it, from which request, and the before and
+// after value of every field it changed. Entries are append-only and outlive the user.
+type AuditEntry struct {
+	ID        int64  `gorm:"column:id;primaryKey"`
+	UserID    int    `gorm:"column:user_id;index"`
+	Actor     string `gorm:"column:actor;size:255"`
+	Action    string `gorm:"column:action;size:16"`
+	Changes   string `gorm:"column:changes;type:json"`
+	RequestID string `gorm:"column:request_id;size:128"`
+	IP        string `gorm:"column:ip;size:45"`
+	CreatedAt int64  `gorm:"column:created_at"`
+}
+
+func (AuditEntry) TableName() string {
+	return "user_audit_log"
+}
+
+// AuditChange is the value of a field before and after a mutation. Nil means the user didn't
+// exist.
+type AuditChange struct {
+	Before *string `json:"before"`
+	After  *string `json:"after"`
+}
+
+// newAuditEntry returns the entry of action on userID, taking the actor and request from ctx.
+func newAuditEntry(ctx context.Context, userID int, action string, changes map[string]AuditChange) (AuditEntry, error) {
+	payload, err := json.Marshal(changes)
+	if err != nil {
+		return AuditEntry{}, err
+	}
+
+	metadata := requestmeta.FromContext(ctx)
+	return AuditEntry{
+		UserID:    userID,
+		Actor:     auth.Actor(ctx),
+		Action:    action,
+		Changes:   string(payload),
+		RequestID: metadata.RequestID,
+		IP:        metadata.IP,
+		CreatedAt: time.Now().Unix(),
+	}, nil
+}
+
+// auditDiff returns the changes of the audited fields between before and after. before is nil on
+// create and after is nil on delete.
+func auditDiff(before *User, after *User) map[string]AuditChange {
+	var beforeFields, afterFields map[string]string
+	if before != nil {
+		beforeFields = auditedFields(*before)
+	}
+	if after != nil {
+		afterFields = auditedFields(*after)
+	}
+
+	diff := map[string]AuditChange{}
+	for _, field := range _auditedFieldNames {
+		beforeValue, hadBefore := beforeFields[field]
+		afterValue, hasAfter := afterFields[field]
+		// Unset fields are empty, so they're only recorded once set.
+		if (hadBefore && hasAfter && beforeValue == afterValue) || (beforeValue == "" && afterValue == "") {
+			continue
+		}
+		if _redactedFields[field] {
+			beforeValue, afterValue = _redacted, _redacted
+		}
+
+		change := AuditChange{}
+		if hadBefore {
+			change.Before = &beforeValue
+		}
+		if hasAfter {
+			change.After = &afterValue
+		}
+		diff[field] = change
+	}
+
+	return diff
+}
+
+// _auditedFieldNames are the user fields recorded in the audit log, in the order they are
+// reported.
+var _auditedFieldNames = []string{
+	"email", "first_name", "last_name", "display_name", "locale", "timezone", "phone", "avatar_url", "metadata",
+	"status", "status_reason", "password",
+}
+
+// _statusFields are audited fields only changed by Service.SetStatus.
+var _statusFields = map[string]bool{"status": true, "status_reason": true}
+
+// _redactedFields are audited fields whose values are never recorded.
+var _redactedFields = map[string]bool{"password": true}
+
+func auditedFields(user User) map[string]string {
+	return map[string]string{
+		"email":         user.Email,
+		"first_name":    user.FirstName,
+		"last_name":     user.LastName,
+		"display_name":  user.DisplayName,
+		"locale":        user.Locale,
+		"timezone":      user.Timezone,
+		"phone":         user.Phone,
+		"avatar_url":    user.AvatarURL,
+		"metadata":      user.Metadata.String(),
+		"status":        user.Status,
+		"status_reason": user.StatusReason,
+		"password":      user.Password,
+	}
+}
+
+// applyUpdate returns user with the non-zero fields of update applied, as stored by
+// Repository.Update.
+func applyUpdate(user User, update User) User {
+	for _, field := range []struct{ target, value *string }{
+		{&user.Email, &update.Email},
+		{&user.Password, &update.Password},
+		{&user.FirstName, &update.FirstName},
+		{&user.LastName, &update.LastName},
+		{&user.DisplayName, &update.DisplayName},
+		{&user.Locale, &update.Locale},
+		{&user.Timezone, &update.Timezone},
+		{&user.Phone, &update.Phone},
+		{&user.AvatarURL, &update.AvatarURL},
+		{&user.Status, &update.Status},
+		{&user.StatusReason, &update.StatusReason},
+	} {
+		if *field.value != "" {
+			*field.target = *field.value
+		}
+	}
+	if update.Metadata != nil {
+		user.Metadata = update.Metadata
+	}
+	if update.StatusChangedAt != 0 {
+		user.StatusChangedAt = update.StatusChangedAt
+	}
+	if update.CreatedAt != 0 {
+		user.CreatedAt = update.CreatedAt
+	}
+	if update.UpdatedAt != 0 {
+		user.UpdatedAt = update.UpdatedAt
+	}
+	if update.Version != 0 {
+		user.Version = update.Version
+	}
+
+	return user
+}
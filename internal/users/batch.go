@@ -0,0 +1,162 @@
+package users
+
+import (
+	"context"
+	"errors"
+)
+
+// Batch operation methods.
+const (
+	BatchMethodCreate = "create"
+	BatchMethodUpdate = "update"
+	BatchMethodDelete = "delete"
+)
+
+// Batch modes. Atomic batches apply all their operations or none, best effort ones apply those
+// that succeed.
+const (
+	BatchModeAtomic     = "atomic"
+	BatchModeBestEffort = "best_effort"
+)
+
+var (
+	// ErrInvalidBatchMethod batch operation method other than create, update or delete error
+	ErrInvalidBatchMethod = errors.New("invalid method. method must be one of create, update or delete")
+	// ErrBatchAborted operation of an atomic batch not applied, since another one failed, error
+	ErrBatchAborted = errors.New("operation not applied. another operation of the atomic batch failed")
+)
+
+// errBatchRollback rolls back the transaction of an atomic batch.
+var errBatchRollback = errors.New("batch rolled back")
+
+// BatchOperation creates User, updates the user with ID with the fields set on User, or deletes
+// the user with ID.
+type BatchOperation struct {
+	Method string
+	ID     int
+	User   User
+}
+
+// BatchResult is the result of a BatchOperation: the created or updated user, or its error.
+type BatchResult struct {
+	User User
+	Err  error
+}
+
+// Batch runs operations in order, with the Service methods. Atomic batches run them in a single
+// transaction: once one fails it's rolled back, and the other operations fail with
+// ErrBatchAborted. Their users are validated and their passwords hashed before the transaction,
+// so it isn't held open while bcrypt runs. Otherwise each operation runs on its own, and failures
+// don't stop the rest. The returned error is only set if the transaction of an atomic batch fails.
+func (s Service) Batch(ctx context.Context, operations []BatchOperation, atomic bool) (_ []BatchResult, err error) {
+	ctx, span := tracer.Start(ctx, "Service.Batch")
+	defer func() { endSpan(span, err) }()
+
+	results := make([]BatchResult, len(operations))
+	if !atomic {
+		for i, operation := range operations {
+			results[i] = s.runBatchOperation(ctx, operation)
+		}
+		return results, nil
+	}
+
+	failed := -1
+	prepared := make([]BatchOperation, len(operations))
+	for i, operation := range operations {
+		prepared[i], results[i].Err = s.prepareBatchOperation(ctx, operation)
+		if results[i].Err != nil {
+			failed = i
+			break
+		}
+	}
+	if failed < 0 {
+		err = s.repository.Transaction(ctx, func(repository Repository) error {
+			// The transactions of the Service methods are nested in this one, as savepoints.
+			tx := s
+			tx.repository = repository
+			for i, operation := range prepared {
+				results[i] = tx.storeBatchOperation(ctx, operation)
+				if results[i].Err != nil {
+					failed = i
+					return errBatchRollback
+				}
+			}
+			return nil
+		})
+	}
+	if failed >= 0 {
+		for i := range results {
+			if i != failed {
+				results[i] = BatchResult{Err: ErrBatchAborted}
+			}
+		}
+		return results, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (s Service) runBatchOperation(ctx context.Context, operation BatchOperation) BatchResult {
+	var user User
+	var err error
+	switch operation.Method {
+	case BatchMethodCreate:
+		user, err = s.Create(ctx, operation.User)
+	case BatchMethodUpdate:
+		user, err = s.Update(ctx, operation.ID, operation.User)
+	case BatchMethodDelete:
+		err = s.Delete(ctx, operation.ID)
+	default:
+		err = ErrInvalidBatchMethod
+	}
+
+	return BatchResult{User: user, Err: err}
+}
+
+// prepareBatchOperation validates the user of a create or update operation and hashes its
+// password, returning the operation to run with storeBatchOperation.
+func (s Service) prepareBatchOperation(ctx context.Context, operation BatchOperation) (BatchOperation, error) {
+	var err error
+	switch operation.Method {
+	case BatchMethodCreate:
+		operation.User, err = s.prepareCreate(ctx, operation.User)
+		observeBatchFailure(_operationCreate, err)
+	case BatchMethodUpdate:
+		operation.User, err = s.prepareUpdate(ctx, operation.User)
+		observeBatchFailure(_operationUpdate, err)
+	case BatchMethodDelete:
+	default:
+		err = ErrInvalidBatchMethod
+	}
+
+	return operation, err
+}
+
+// storeBatchOperation runs an operation prepared by prepareBatchOperation.
+func (s Service) storeBatchOperation(ctx context.Context, operation BatchOperation) BatchResult {
+	var user User
+	var err error
+	switch operation.Method {
+	case BatchMethodCreate:
+		user, err = s.create(ctx, operation.User)
+		observeOperation(_operationCreate, _outcomeCreated, err)
+	case BatchMethodUpdate:
+		user, err = s.update(ctx, operation.ID, operation.User)
+		observeOperation(_operationUpdate, _outcomeUpdated, err)
+	case BatchMethodDelete:
+		err = s.Delete(ctx, operation.ID)
+	}
+
+	return BatchResult{User: user, Err: err}
+}
+
+// observeBatchFailure counts operations of atomic batches failing before their transaction, like
+// the Service methods would.
+func observeBatchFailure(operation string, err error) {
+	if err != nil {
+		observeOperation(operation, "", err)
+	}
+}
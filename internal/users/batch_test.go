@@ -0,0 +1,122 @@
+package users
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_Batch(t *testing.T) {
+	created := User{ID: 1, Email: "some@email.com", Status: StatusActive}
+	existing := User{ID: 2, Email: "other@email.com", Status: StatusActive}
+
+	operations := []BatchOperation{
+		{Method: BatchMethodCreate, User: User{Email: "some@email.com", Password: "some-password"}},
+		{Method: BatchMethodDelete, ID: 2},
+		{Method: "upsert", ID: 2},
+	}
+
+	var tests = []struct {
+		name            string
+		repo            *RepositoryMock
+		operations      []BatchOperation
+		atomic          bool
+		expectedResults []BatchResult
+		expectedEvents  []string
+	}{
+		{
+			name: "Ok - Best effort applies the operations that succeed",
+			repo: func() *RepositoryMock {
+				m := RepositoryMock{}
+				m.On("Create", mock.Anything).Return(created, nil)
+				m.On("Get", mock.Anything).Return(existing, nil)
+				m.On("Delete", mock.Anything).Return(nil)
+				return &m
+			}(),
+			operations: operations,
+			expectedResults: []BatchResult{
+				{User: created},
+				{},
+				{Err: ErrInvalidBatchMethod},
+			},
+			expectedEvents: []string{EventNameUserCreated, EventNameUserDeleted},
+		},
+		{
+			name: "Ok - Atomic",
+			repo: func() *RepositoryMock {
+				m := RepositoryMock{}
+				m.On("Create", mock.Anything).Return(created, nil)
+				m.On("Get", mock.Anything).Return(existing, nil)
+				m.On("Delete", mock.Anything).Return(nil)
+				return &m
+			}(),
+			operations:      operations[:2],
+			atomic:          true,
+			expectedResults: []BatchResult{{User: created}, {}},
+			expectedEvents:  []string{EventNameUserCreated, EventNameUserDeleted},
+		},
+		{
+			name: "Fail - Atomic aborts every operation once one fails",
+			repo: func() *RepositoryMock {
+				m := RepositoryMock{}
+				m.On("Create", mock.Anything).Return(created, nil)
+				m.On("Get", mock.Anything).Return(User{}, ErrUserNotFound)
+				return &m
+			}(),
+			operations: []BatchOperation{
+				operations[0],
+				operations[1],
+				{Method: BatchMethodUpdate, ID: 1, User: User{FirstName: "Some"}},
+			},
+			atomic: true,
+			expectedResults: []BatchResult{
+				{Err: ErrBatchAborted},
+				{Err: ErrUserNotFound},
+				{Err: ErrBatchAborted},
+			},
+			// The mock doesn't roll back, the transaction of a repository would discard the event.
+			expectedEvents: []string{EventNameUserCreated},
+		},
+		{
+			name: "Fail - Atomic validates every operation before its transaction",
+			// No repository method is expected, nothing runs once a user is invalid.
+			repo: &RepositoryMock{},
+			operations: []BatchOperation{
+				operations[0],
+				{Method: BatchMethodUpdate, ID: 1, User: User{Locale: "not a locale", Password: "other-password"}},
+				operations[1],
+			},
+			atomic: true,
+			expectedResults: []BatchResult{
+				{Err: ErrBatchAborted},
+				{Err: ErrInvalidLocale},
+				{Err: ErrBatchAborted},
+			},
+			expectedEvents: []string{},
+		},
+		{
+			name:       "Fail - Atomic with an unknown method",
+			repo:       &RepositoryMock{},
+			operations: operations,
+			atomic:     true,
+			expectedResults: []BatchResult{
+				{Err: ErrBatchAborted},
+				{Err: ErrBatchAborted},
+				{Err: ErrInvalidBatchMethod},
+			},
+			expectedEvents: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewService(tt.repo)
+			results, err := service.Batch(context.Background(), tt.operations, tt.atomic)
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedResults, results)
+			require.Equal(t, tt.expectedEvents, tt.repo.eventNames())
+		})
+	}
+}
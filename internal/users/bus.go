@@ -0,0 +1,54 @@
+package users
+
+import (
+	"context"
+	"sync"
+)
+
+// EventHandler reacts to an event published on a Bus.
+type EventHandler func(ctx context.Context, event Event) error
+
+// Bus is an in-process Publisher that dispatches each event to the handlers subscribed to it.
+type Bus struct {
+	mu       sync.RWMutex
+	handlers map[string][]EventHandler
+	all      []EventHandler
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		handlers: map[string][]EventHandler{},
+	}
+}
+
+// Subscribe registers handler for the given event names, or for every event if none is given.
+func (b *Bus) Subscribe(handler EventHandler, eventNames ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(eventNames) == 0 {
+		b.all = append(b.all, handler)
+		return
+	}
+	for _, name := range eventNames {
+		b.handlers[name] = append(b.handlers[name], handler)
+	}
+}
+
+// Publish calls the subscribed handlers synchronously. Every handler is called even if a previous
+// one fails, and the first error is returned.
+func (b *Bus) Publish(ctx context.Context, event Event) error {
+	b.mu.RLock()
+	handlers := append(append([]EventHandler{}, b.handlers[event.EventName()]...), b.all...)
+	b.mu.RUnlock()
+
+	var firstErr error
+	for _, handler := range handlers {
+		err := handler(ctx, event)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
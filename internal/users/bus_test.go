@@ -0,0 +1,69 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBus_Publish(t *testing.T) {
+	var tests = []struct {
+		name          string
+		subscribe     func(b *Bus, received *[]string)
+		event         Event
+		expectedCalls []string
+		expectedError error
+	}{
+		{
+			name: "Ok - Handler subscribed to the event",
+			subscribe: func(b *Bus, received *[]string) {
+				b.Subscribe(recordingHandler("created", received), EventNameUserCreated)
+				b.Subscribe(recordingHandler("deleted", received), EventNameUserDeleted)
+			},
+			event:         UserCreated{EventMetadata: EventMetadata{UserID: 1}},
+			expectedCalls: []string{"created"},
+		},
+		{
+			name: "Ok - Handler subscribed to every event",
+			subscribe: func(b *Bus, received *[]string) {
+				b.Subscribe(recordingHandler("all", received))
+			},
+			event:         UserDeleted{EventMetadata: EventMetadata{UserID: 1}},
+			expectedCalls: []string{"all"},
+		},
+		{
+			name: "Fail - Every handler called and first error returned",
+			subscribe: func(b *Bus, received *[]string) {
+				b.Subscribe(func(ctx context.Context, event Event) error {
+					*received = append(*received, "failing")
+					return errors.New("handler error")
+				}, EventNameUserUpdated)
+				b.Subscribe(recordingHandler("all", received))
+			},
+			event:         UserUpdated{EventMetadata: EventMetadata{UserID: 1}},
+			expectedCalls: []string{"failing", "all"},
+			expectedError: errors.New("handler error"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := []string{}
+			bus := NewBus()
+			tt.subscribe(bus, &received)
+
+			err := bus.Publish(context.Background(), tt.event)
+			require.Equal(t, tt.expectedError, err)
+			require.Equal(t, tt.expectedCalls, received)
+		})
+	}
+}
+
+func recordingHandler(name string, received *[]string) EventHandler {
+	return func(ctx context.Context, event Event) error {
+		*received = append(*received, name)
+		return nil
+	}
+}
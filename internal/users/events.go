@@ -0,0 +1,146 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"time"
+)
+
+const (
+	EventNameUserCreated     = "user.created"
+	EventNameUserUpdated     = "user.updated"
+	EventNameUserDeleted     = "user.deleted"
+	EventNamePasswordChanged = "user.password_changed"
+	EventNameStatusChanged   = "user.status_changed"
+)
+
+var (
+	// ErrUnknownEvent event name without a registered type error
+	ErrUnknownEvent = errors.New("unknown event")
+)
+
+// Event is a change on a user emitted by the Service once the mutation is stored. Events never
+// carry password hashes.
+type Event interface {
+	EventName() string
+	Metadata() EventMetadata
+}
+
+// Publisher delivers the events emitted by the Service.
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// EventMetadata is common to every event. Delivery is at-least-once, so consumers should use ID
+// to discard duplicates.
+type EventMetadata struct {
+	ID         string    `json:"id"`
+	UserID     int       `json:"user_id"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+func (m EventMetadata) Metadata() EventMetadata { return m }
+
+type UserCreated struct {
+	EventMetadata
+	Email string `json:"email"`
+}
+
+func (UserCreated) EventName() string { return EventNameUserCreated }
+
+type UserUpdated struct {
+	EventMetadata
+	ChangedFields []string `json:"changed_fields"`
+}
+
+func (UserUpdated) EventName() string { return EventNameUserUpdated }
+
+type UserDeleted struct {
+	EventMetadata
+}
+
+func (UserDeleted) EventName() string { return EventNameUserDeleted }
+
+type PasswordChanged struct {
+	EventMetadata
+}
+
+func (PasswordChanged) EventName() string { return EventNamePasswordChanged }
+
+type StatusChanged struct {
+	EventMetadata
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Reason string `json:"reason"`
+}
+
+func (StatusChanged) EventName() string { return EventNameStatusChanged }
+
+// newEventMetadata returns the metadata for a new event on userID occurred at occurredAt.
+func newEventMetadata(userID int, occurredAt time.Time) EventMetadata {
+	return EventMetadata{
+		ID:         newEventID(),
+		UserID:     userID,
+		OccurredAt: occurredAt,
+	}
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	// crypto/rand.Read only fails if the OS entropy source is unavailable.
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// DecodeEvent rebuilds the typed event named name from its JSON payload.
+func DecodeEvent(name string, payload []byte) (Event, error) {
+	var target interface{}
+	switch name {
+	case EventNameUserCreated:
+		target = &UserCreated{}
+	case EventNameUserUpdated:
+		target = &UserUpdated{}
+	case EventNameUserDeleted:
+		target = &UserDeleted{}
+	case EventNamePasswordChanged:
+		target = &PasswordChanged{}
+	case EventNameStatusChanged:
+		target = &StatusChanged{}
+	default:
+		return nil, ErrUnknownEvent
+	}
+
+	err := json.Unmarshal(payload, target)
+	if err != nil {
+		return nil, err
+	}
+
+	// Events are handled as values, so dereference the decoded pointer.
+	return reflect.ValueOf(target).Elem().Interface().(Event), nil
+}
+
+// changedFields lists the fields an update changes on before. Only non-zero fields of update are
+// stored, so zero values are not considered changes. The password and status are reported by
+// their own events.
+func changedFields(before User, update User) []string {
+	beforeFields, updateFields := auditedFields(before), auditedFields(update)
+
+	changed := []string{}
+	for _, field := range _auditedFieldNames {
+		if field == "password" || _statusFields[field] {
+			continue
+		}
+		if updateFields[field] != "" && updateFields[field] != beforeFields[field] {
+			changed = append(changed, field)
+		}
+	}
+
+	return changed
+}
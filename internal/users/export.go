@@ -0,0 +1,154 @@
+package users
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Export formats.
+const (
+	ExportFormatCSV    = "csv"
+	ExportFormatNDJSON = "ndjson"
+)
+
+// ErrInvalidExportFormat export format neither csv nor ndjson error
+var ErrInvalidExportFormat = errors.New("invalid export format. format must be csv or ndjson")
+
+// _exportColumns are the CSV columns of exports, the json names of the ExportedUser fields.
+var _exportColumns = []string{
+	"id", "email", "status", "status_reason", "status_changed_at",
+	"first_name", "last_name", "display_name", "locale", "timezone", "phone", "avatar_url",
+	"metadata", "created_at", "updated_at",
+}
+
+// _formulaPrefixes are the first characters spreadsheets read a cell as a formula by.
+const _formulaPrefixes = "=+-@\t\r"
+
+// ExportWriter writes the users of an export as ExportedUser, without their password. Flush is
+// called once the last user is written.
+type ExportWriter interface {
+	Write(user User) error
+	Flush() error
+}
+
+// NewExportWriter returns the writer of an export in format. CSV exports start with a header
+// naming their columns, with metadata as a JSON object, and cells that spreadsheets would run as
+// formulas are escaped. NDJSON exports have an ExportedUser per line.
+func NewExportWriter(w io.Writer, format string) (ExportWriter, error) {
+	switch format {
+	case ExportFormatCSV:
+		return &csvExportWriter{writer: csv.NewWriter(w)}, nil
+	case ExportFormatNDJSON:
+		return ndjsonExportWriter{encoder: json.NewEncoder(w)}, nil
+	default:
+		return nil, ErrInvalidExportFormat
+	}
+}
+
+type csvExportWriter struct {
+	writer *csv.Writer
+	// header is whether the header was written, before the first user or on Flush.
+	header bool
+}
+
+func (w *csvExportWriter) Write(user User) error {
+	err := w.writeHeader()
+	if err != nil {
+		return err
+	}
+
+	record := []string{
+		strconv.Itoa(user.ID),
+		user.Email,
+		user.Status,
+		user.StatusReason,
+		formatTimestamp(user.StatusChangedAt),
+		user.FirstName,
+		user.LastName,
+		user.DisplayName,
+		user.Locale,
+		user.Timezone,
+		user.Phone,
+		user.AvatarURL,
+		user.Metadata.String(),
+		strconv.FormatInt(user.CreatedAt, 10),
+		strconv.FormatInt(user.UpdatedAt, 10),
+	}
+	for i, cell := range record {
+		record[i] = escapeFormula(cell)
+	}
+
+	return w.writer.Write(record)
+}
+
+func (w *csvExportWriter) Flush() error {
+	err := w.writeHeader()
+	if err != nil {
+		return err
+	}
+
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+func (w *csvExportWriter) writeHeader() error {
+	if w.header {
+		return nil
+	}
+	w.header = true
+
+	return w.writer.Write(_exportColumns)
+}
+
+type ndjsonExportWriter struct {
+	encoder *json.Encoder
+}
+
+func (w ndjsonExportWriter) Write(user User) error {
+	return w.encoder.Encode(ExportedUser{
+		ID:              user.ID,
+		Email:           user.Email,
+		Status:          user.Status,
+		StatusReason:    user.StatusReason,
+		StatusChangedAt: user.StatusChangedAt,
+		FirstName:       user.FirstName,
+		LastName:        user.LastName,
+		DisplayName:     user.DisplayName,
+		Locale:          user.Locale,
+		Timezone:        user.Timezone,
+		Phone:           user.Phone,
+		AvatarURL:       user.AvatarURL,
+		Metadata:        user.Metadata,
+		CreatedAt:       user.CreatedAt,
+		UpdatedAt:       user.UpdatedAt,
+	})
+}
+
+// Flush does nothing, NDJSON exports are written a user at a time.
+func (w ndjsonExportWriter) Flush() error {
+	return nil
+}
+
+// escapeFormula prefixes cell with a single quote if it starts like a formula, so spreadsheets
+// opening the export show it as text instead of running it (CSV injection). Values like phone
+// numbers starting with + are escaped too, and must be unescaped to be imported back.
+func escapeFormula(cell string) string {
+	if cell != "" && strings.IndexByte(_formulaPrefixes, cell[0]) >= 0 {
+		return "'" + cell
+	}
+
+	return cell
+}
+
+// formatTimestamp formats an optional unix timestamp for CSV exports, empty if it's zero.
+func formatTimestamp(timestamp int64) string {
+	if timestamp == 0 {
+		return ""
+	}
+
+	return strconv.FormatInt(timestamp, 10)
+}
@@ -0,0 +1,162 @@
+package users
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewExportWriter(t *testing.T) {
+	exported := []User{
+		{
+			ID:        1,
+			Email:     "some@email.com",
+			Password:  "$2a$10$i8u5FgiJXRui/p.ZDXnDO.kVq3H6rbqrQp6rInFX.IeEO0zN/2F5G",
+			Status:    StatusActive,
+			FirstName: "Some, Name",
+			Phone:     "+5491123456789",
+			Metadata:  Metadata{"preferences": {"theme": "dark"}},
+			CreatedAt: 1651422724,
+			UpdatedAt: 1651422725,
+		},
+		{
+			ID:              2,
+			Email:           "other@email.com",
+			Status:          StatusSuspended,
+			StatusReason:    "fraud",
+			StatusChangedAt: 1651422726,
+			CreatedAt:       1651422724,
+			UpdatedAt:       1651422726,
+		},
+		{
+			ID:          3,
+			Email:       "formula@email.com",
+			Status:      StatusActive,
+			FirstName:   "=HYPERLINK(\"http://evil.example\",\"click\")",
+			LastName:    "@SUM(A1:A2)",
+			DisplayName: "-2+3",
+			Locale:      "\tes",
+			Timezone:    "\rUTC",
+			AvatarURL:   "https://example.com/a=b",
+			CreatedAt:   1651422724,
+			UpdatedAt:   1651422724,
+		},
+	}
+
+	var tests = []struct {
+		name           string
+		format         string
+		users          []User
+		expectedOutput string
+		expectedError  error
+	}{
+		{
+			name:   "Ok - CSV",
+			format: ExportFormatCSV,
+			users:  exported,
+			expectedOutput: "id,email,status,status_reason,status_changed_at,first_name,last_name,display_name,locale,timezone,phone,avatar_url,metadata,created_at,updated_at\n" +
+				`1,some@email.com,active,,,"Some, Name",,,,,'+5491123456789,,"{""preferences"":{""theme"":""dark""}}",1651422724,1651422725` + "\n" +
+				"2,other@email.com,suspended,fraud,1651422726,,,,,,,,,1651422724,1651422726\n" +
+				`3,formula@email.com,active,,,"'=HYPERLINK(""http://evil.example"",""click"")",'@SUM(A1:A2),'-2+3,'` + "\tes,\"'\rUTC\",,https://example.com/a=b,,1651422724,1651422724\n",
+		},
+		{
+			name:           "Ok - CSV without users",
+			format:         ExportFormatCSV,
+			expectedOutput: "id,email,status,status_reason,status_changed_at,first_name,last_name,display_name,locale,timezone,phone,avatar_url,metadata,created_at,updated_at\n",
+		},
+		{
+			name:   "Ok - NDJSON",
+			format: ExportFormatNDJSON,
+			users:  exported,
+			expectedOutput: `{"id":1,"email":"some@email.com","status":"active","first_name":"Some, Name","phone":"+5491123456789","metadata":{"preferences":{"theme":"dark"}},"created_at":1651422724,"updated_at":1651422725}` + "\n" +
+				`{"id":2,"email":"other@email.com","status":"suspended","status_reason":"fraud","status_changed_at":1651422726,"created_at":1651422724,"updated_at":1651422726}` + "\n" +
+				`{"id":3,"email":"formula@email.com","status":"active","first_name":"=HYPERLINK(\"http://evil.example\",\"click\")","last_name":"@SUM(A1:A2)","display_name":"-2+3","locale":"\tes","timezone":"\rUTC","avatar_url":"https://example.com/a=b","created_at":1651422724,"updated_at":1651422724}` + "\n",
+		},
+		{
+			name:          "Fail - Unknown format",
+			format:        "xml",
+			expectedError: ErrInvalidExportFormat,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+			writer, err := NewExportWriter(&output, tt.format)
+			require.Equal(t, tt.expectedError, err)
+			if err != nil {
+				return
+			}
+
+			for _, user := range tt.users {
+				require.NoError(t, writer.Write(user))
+			}
+			require.NoError(t, writer.Flush())
+			require.Equal(t, tt.expectedOutput, output.String())
+		})
+	}
+}
+
+func TestService_Export(t *testing.T) {
+	stored := []User{
+		{ID: 1, Email: "some@email.com", Password: "$2a$10$i8u5FgiJXRui/p.ZDXnDO.kVq3H6rbqrQp6rInFX.IeEO0zN/2F5G"},
+		{ID: 2, Email: "other@email.com"},
+	}
+
+	var tests = []struct {
+		name          string
+		repo          *RepositoryMock
+		filter        ListFilter
+		fnErr         error
+		expectedUsers []User
+		expectedError error
+	}{
+		{
+			name: "Ok - Passwords removed",
+			repo: func() *RepositoryMock {
+				m := RepositoryMock{}
+				m.On("Export", ListFilter{}).Return(stored, nil)
+				return &m
+			}(),
+			expectedUsers: []User{{ID: 1, Email: "some@email.com"}, {ID: 2, Email: "other@email.com"}},
+		},
+		{
+			name: "Fail - Error writing a user stops the export",
+			repo: func() *RepositoryMock {
+				m := RepositoryMock{}
+				m.On("Export", ListFilter{}).Return(stored, nil)
+				return &m
+			}(),
+			fnErr:         errors.New("broken pipe"),
+			expectedUsers: []User{{ID: 1, Email: "some@email.com"}},
+			expectedError: errors.New("broken pipe"),
+		},
+		{
+			name:          "Fail - Filter by key not indexed",
+			repo:          &RepositoryMock{},
+			filter:        ListFilter{Metadata: map[string]string{"crm.owner": "sales"}},
+			expectedUsers: []User{},
+			expectedError: ErrMetadataKeyNotIndexed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewService(tt.repo)
+			exported := []User{}
+			err := service.Export(context.Background(), tt.filter, func(user User) error {
+				exported = append(exported, user)
+				return tt.fnErr
+			})
+			if tt.expectedError == ErrMetadataKeyNotIndexed {
+				require.True(t, errors.Is(err, ErrMetadataKeyNotIndexed))
+			} else {
+				require.Equal(t, tt.expectedError, err)
+			}
+			require.Equal(t, tt.expectedUsers, exported)
+		})
+	}
+}
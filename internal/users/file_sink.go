@@ -0,0 +1,36 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// FileSink is a Publisher that appends each event as a JSON line (NDJSON) to a writer, usually an
+// *os.File opened in append mode.
+type FileSink struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func NewFileSink(w io.Writer) FileSink {
+	return FileSink{
+		mu: &sync.Mutex{},
+		w:  w,
+	}
+}
+
+func (s FileSink) Publish(_ context.Context, event Event) error {
+	line, err := json.Marshal(EventEnvelope{Name: event.EventName(), Data: event})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.w.Write(line)
+	return err
+}
@@ -0,0 +1,25 @@
+package users
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSink_Publish(t *testing.T) {
+	occurredAt := time.Date(2022, 5, 1, 16, 32, 4, 0, time.UTC)
+
+	var buf bytes.Buffer
+	sink := NewFileSink(&buf)
+
+	require.NoError(t, sink.Publish(context.Background(), UserDeleted{EventMetadata: EventMetadata{ID: "a", UserID: 1, OccurredAt: occurredAt}}))
+	require.NoError(t, sink.Publish(context.Background(), PasswordChanged{EventMetadata: EventMetadata{ID: "b", UserID: 1, OccurredAt: occurredAt}}))
+
+	require.Equal(t,
+		`{"name":"user.deleted","data":{"id":"a","user_id":1,"occurred_at":"2022-05-01T16:32:04Z"}}`+"\n"+
+			`{"name":"user.password_changed","data":{"id":"b","user_id":1,"occurred_at":"2022-05-01T16:32:04Z"}}`+"\n",
+		buf.String())
+}
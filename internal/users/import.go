@@ -0,0 +1,475 @@
+package users
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/mail"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Import formats.
+const (
+	ImportFormatCSV    = "csv"
+	ImportFormatNDJSON = "ndjson"
+)
+
+// Import statuses: ImportStatusCreated and ImportStatusFailed of each row, ImportStatusCompleted
+// and ImportStatusAborted of the whole import.
+const (
+	ImportStatusCreated   = "created"
+	ImportStatusFailed    = "failed"
+	ImportStatusCompleted = "completed"
+	ImportStatusAborted   = "aborted"
+)
+
+const (
+	// _maxEmailLength is the size of the email column.
+	_maxEmailLength = 255
+	// _maxImportLineSize is the size of the longest NDJSON line read.
+	_maxImportLineSize = 1 << 20
+	// _utf8BOM starts CSV files exported by some spreadsheets.
+	_utf8BOM = "\ufeff"
+)
+
+var (
+	// ErrInvalidImportFormat import format neither csv nor ndjson error
+	ErrInvalidImportFormat = errors.New("invalid import format. format must be csv or ndjson")
+	// ErrInvalidImportHeader CSV header missing the email column, or with unknown or repeated columns, error
+	ErrInvalidImportHeader = errors.New("invalid import header. columns must be user fields, including email, without repeats")
+	// ErrInvalidImportRow import row that could not be decoded error
+	ErrInvalidImportRow = errors.New("invalid row. row could not be decoded")
+	// ErrInvalidEmail email empty, too long or not an address error
+	ErrInvalidEmail = errors.New("invalid email. email is required and must be an address of at most 255 characters")
+	// ErrInvalidPassword neither or both of password and password_hash error
+	ErrInvalidPassword = errors.New("invalid password. either a password or a password_hash is required")
+	// ErrInvalidPasswordHash password hash not a bcrypt hash error
+	ErrInvalidPasswordHash = errors.New("invalid password_hash. password_hash must be a bcrypt hash")
+	// ErrDuplicateImportEmail email on a previous row of the same import error
+	ErrDuplicateImportEmail = errors.New("duplicate email. the email is on a previous row of the import")
+)
+
+// _importRowErrors are the rejections of a single row, besides validation errors, which fail it
+// without aborting the import.
+var _importRowErrors = []error{ErrDuplicateImportEmail, ErrUserAlreadyExists}
+
+// ImportRow is a user read from an import, or the Err decoding it.
+type ImportRow struct {
+	Row  int
+	User ImportRequest
+	Err  error
+}
+
+// ImportReader reads the rows of an import in order, returning io.EOF after the last one. Other
+// errors abort the import.
+type ImportReader interface {
+	Read() (ImportRow, error)
+}
+
+// NewImportReader returns the reader of an import in format. CSV imports start with a header
+// naming their columns, the json names of ImportRequest fields, with metadata as a JSON object.
+// NDJSON imports have an ImportRequest per line.
+func NewImportReader(r io.Reader, format string) (ImportReader, error) {
+	switch format {
+	case ImportFormatCSV:
+		return newCSVImportReader(r)
+	case ImportFormatNDJSON:
+		return newNDJSONImportReader(r), nil
+	default:
+		return nil, ErrInvalidImportFormat
+	}
+}
+
+type csvImportReader struct {
+	reader  *csv.Reader
+	columns []string
+	row     int
+}
+
+func newCSVImportReader(r io.Reader) (*csvImportReader, error) {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, ErrInvalidImportHeader
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, ErrInvalidImportHeader
+		}
+		return nil, err
+	}
+
+	columns := make([]string, len(header))
+	seen := map[string]bool{}
+	for i, column := range header {
+		if i == 0 {
+			column = strings.TrimPrefix(column, _utf8BOM)
+		}
+		column = strings.TrimSpace(column)
+		_, known := importFields(&ImportRequest{})[column]
+		if (!known && column != "metadata") || seen[column] {
+			return nil, ErrInvalidImportHeader
+		}
+		seen[column] = true
+		columns[i] = column
+	}
+	if !seen["email"] {
+		return nil, ErrInvalidImportHeader
+	}
+
+	return &csvImportReader{reader: reader, columns: columns, row: 1}, nil
+}
+
+// Read returns the next record. Records with the wrong number of fields or bad quoting fail
+// their row only.
+func (r *csvImportReader) Read() (ImportRow, error) {
+	record, err := r.reader.Read()
+	if err == io.EOF {
+		return ImportRow{}, io.EOF
+	}
+	r.row++
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return ImportRow{Row: r.row, Err: ErrInvalidImportRow}, nil
+		}
+		return ImportRow{}, err
+	}
+
+	var user ImportRequest
+	fields := importFields(&user)
+	for i, column := range r.columns {
+		if column != "metadata" {
+			*fields[column] = record[i]
+			continue
+		}
+		if record[i] == "" {
+			continue
+		}
+		err = json.Unmarshal([]byte(record[i]), &user.Metadata)
+		if err != nil {
+			return ImportRow{Row: r.row, Err: ErrInvalidImportRow}, nil
+		}
+	}
+
+	return ImportRow{Row: r.row, User: user}, nil
+}
+
+// importFields maps the CSV columns of the text fields of user to them.
+func importFields(user *ImportRequest) map[string]*string {
+	return map[string]*string{
+		"email":         &user.Email,
+		"password":      &user.Password,
+		"password_hash": &user.PasswordHash,
+		"status":        &user.Status,
+		"first_name":    &user.FirstName,
+		"last_name":     &user.LastName,
+		"display_name":  &user.DisplayName,
+		"locale":        &user.Locale,
+		"timezone":      &user.Timezone,
+		"phone":         &user.Phone,
+		"avatar_url":    &user.AvatarURL,
+	}
+}
+
+type ndjsonImportReader struct {
+	scanner *bufio.Scanner
+	row     int
+}
+
+func newNDJSONImportReader(r io.Reader) *ndjsonImportReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), _maxImportLineSize)
+
+	return &ndjsonImportReader{scanner: scanner}
+}
+
+// Read returns the user of the next non-blank line. Lines that aren't an ImportRequest, including
+// those with unknown fields, fail their row only.
+func (r *ndjsonImportReader) Read() (ImportRow, error) {
+	for r.scanner.Scan() {
+		r.row++
+		line := bytes.TrimSpace(r.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var user ImportRequest
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&user)
+		if err != nil || decoder.More() {
+			return ImportRow{Row: r.row, Err: ErrInvalidImportRow}, nil
+		}
+
+		return ImportRow{Row: r.row, User: user}, nil
+	}
+
+	err := r.scanner.Err()
+	if err != nil {
+		return ImportRow{}, err
+	}
+
+	return ImportRow{}, io.EOF
+}
+
+// importEntry is a row of an import batch, with the user to create unless err failed it.
+type importEntry struct {
+	result ImportResult
+	user   User
+	err    error
+}
+
+// Import creates the users read from reader, in transactions of up to batchSize users, and calls
+// report with the result of each row, in order. Invalid rows, and users that already exist, fail
+// on their own. Reader errors, and errors storing users, abort the import: they're returned with
+// the summary of the rows reported until then, whose users were created.
+func (s Service) Import(ctx context.Context, reader ImportReader, batchSize int, report func(result ImportResult) error) (summary ImportSummary, err error) {
+	ctx, span := tracer.Start(ctx, "Service.Import")
+	defer func() {
+		span.SetAttributes(attribute.Int("import.created", summary.Created), attribute.Int("import.failed", summary.Failed))
+		if err != nil {
+			summary.Status = ImportStatusAborted
+		}
+		endSpan(span, err)
+	}()
+
+	seen := map[string]bool{}
+	batch := make([]importEntry, 0, batchSize)
+
+	flush := func() error {
+		err := s.importBatch(ctx, batch)
+		if err != nil {
+			return err
+		}
+		for _, entry := range batch {
+			observeOperation(_operationImport, _outcomeCreated, entry.err)
+			if entry.err == nil {
+				summary.Created++
+			} else {
+				summary.Failed++
+			}
+			err = report(entry.result)
+			if err != nil {
+				return err
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return summary, err
+		}
+
+		entry := importEntry{result: ImportResult{Row: row.Row, Email: row.User.Email}, err: row.Err}
+		if entry.err == nil {
+			entry.user, entry.err = s.prepareImport(ctx, row.User, seen)
+		}
+		if entry.err != nil {
+			if !isImportRowError(entry.err) {
+				return summary, entry.err
+			}
+			entry.result.Status = ImportStatusFailed
+			entry.result.Error = entry.err.Error()
+		}
+		batch = append(batch, entry)
+
+		if len(batch) >= batchSize {
+			err = flush()
+			if err != nil {
+				return summary, err
+			}
+		}
+	}
+
+	err = flush()
+	if err != nil {
+		return summary, err
+	}
+
+	summary.Status = ImportStatusCompleted
+	return summary, nil
+}
+
+// prepareImport validates the user of an import row and returns it ready to be created. seen
+// holds the emails of the previous rows.
+func (s Service) prepareImport(ctx context.Context, request ImportRequest, seen map[string]bool) (User, error) {
+	email := strings.TrimSpace(request.Email)
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || len(email) > _maxEmailLength {
+		return User{}, ErrInvalidEmail
+	}
+	// Emails are unique regardless of case, like the users email index.
+	key := strings.ToLower(email)
+	if seen[key] {
+		return User{}, ErrDuplicateImportEmail
+	}
+
+	user, err := normalizeProfile(User{
+		Email:       email,
+		FirstName:   request.FirstName,
+		LastName:    request.LastName,
+		DisplayName: request.DisplayName,
+		Locale:      request.Locale,
+		Timezone:    request.Timezone,
+		Phone:       request.Phone,
+		AvatarURL:   request.AvatarURL,
+		Metadata:    request.Metadata,
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	err = s.metadata.Validate(user.Metadata)
+	if err != nil {
+		return User{}, err
+	}
+
+	switch request.Status {
+	case "":
+		user.Status = s.initialStatus
+	case StatusActive, StatusPending:
+		user.Status = request.Status
+	default:
+		return User{}, ErrInvalidStatus
+	}
+
+	// Hashed last, since it's the slowest check.
+	switch {
+	case (request.Password == "") == (request.PasswordHash == ""):
+		return User{}, ErrInvalidPassword
+	case request.PasswordHash != "":
+		_, err = bcrypt.Cost([]byte(request.PasswordHash))
+		if err != nil {
+			return User{}, ErrInvalidPasswordHash
+		}
+		user.Password = request.PasswordHash
+	default:
+		user.Password, err = generatePassword(ctx, request.Password)
+		if err != nil {
+			return User{}, err
+		}
+	}
+
+	seen[key] = true
+	return user, nil
+}
+
+// importBatch creates the users of batch in a single transaction, setting the result of their
+// rows. If one of them already exists the transaction is rolled back, and they're created one by
+// one to fail only that row.
+func (s Service) importBatch(ctx context.Context, batch []importEntry) error {
+	entries := []*importEntry{}
+	batchUsers := []User{}
+	for i := range batch {
+		if batch[i].err == nil {
+			entries = append(entries, &batch[i])
+			batchUsers = append(batchUsers, batch[i].user)
+		}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	created, err := s.createBatch(ctx, batchUsers)
+	if err == nil {
+		for i, entry := range entries {
+			entry.result.Status = ImportStatusCreated
+			entry.result.ID = created[i].ID
+		}
+		return nil
+	}
+	if !isImportRowError(err) {
+		return err
+	}
+
+	for _, entry := range entries {
+		created, err := s.createBatch(ctx, []User{entry.user})
+		if err != nil {
+			if !isImportRowError(err) {
+				return err
+			}
+			entry.err = err
+			entry.result.Status = ImportStatusFailed
+			entry.result.Error = err.Error()
+			continue
+		}
+		entry.result.Status = ImportStatusCreated
+		entry.result.ID = created[0].ID
+	}
+
+	return nil
+}
+
+// createBatch creates batchUsers with their metadata index, audit entries and events, like Create
+// does, in a single transaction.
+func (s Service) createBatch(ctx context.Context, batchUsers []User) ([]User, error) {
+	var created []User
+	err := s.repository.Transaction(ctx, func(repository Repository) error {
+		var err error
+		created, err = repository.CreateBatch(ctx, batchUsers)
+		if err != nil {
+			return err
+		}
+
+		events := make([]Event, 0, len(created))
+		now := time.Now()
+		for i := range created {
+			user := created[i]
+			if len(user.Metadata) > 0 {
+				err = repository.SetMetadataIndex(ctx, user.ID, s.metadata.indexEntries(user.ID, user.Metadata))
+				if err != nil {
+					return err
+				}
+			}
+
+			err = audit(ctx, repository, user.ID, AuditActionCreate, nil, &user)
+			if err != nil {
+				return err
+			}
+
+			events = append(events, UserCreated{
+				EventMetadata: newEventMetadata(user.ID, now),
+				Email:         user.Email,
+			})
+		}
+
+		return repository.SaveEvents(ctx, events...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
+
+// isImportRowError reports whether err fails a single import row, instead of the whole import.
+func isImportRowError(err error) bool {
+	if IsValidationError(err) {
+		return true
+	}
+	for _, rowErr := range _importRowErrors {
+		if errors.Is(err, rowErr) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,236 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const _importPasswordHash = "$2a$10$i8u5FgiJXRui/p.ZDXnDO.kVq3H6rbqrQp6rInFX.IeEO0zN/2F5G"
+
+func TestNewImportReader(t *testing.T) {
+	var tests = []struct {
+		name          string
+		format        string
+		input         string
+		expectedRows  []ImportRow
+		expectedError error
+	}{
+		{
+			name:   "Ok - CSV",
+			format: ImportFormatCSV,
+			input: "\ufeffemail,password_hash,first_name,metadata\n" +
+				"some@email.com," + _importPasswordHash + ",Some,\"{\"\"preferences\"\":{\"\"theme\"\":\"\"dark\"\"}}\"\n" +
+				"other@email.com,,Other\n" +
+				"bad@email.com," + _importPasswordHash + ",Bad,not json\n",
+			expectedRows: []ImportRow{
+				{Row: 2, User: ImportRequest{
+					UserRequest:  UserRequest{Email: "some@email.com", FirstName: "Some", Metadata: Metadata{"preferences": {"theme": "dark"}}},
+					PasswordHash: _importPasswordHash,
+				}},
+				{Row: 3, Err: ErrInvalidImportRow},
+				{Row: 4, Err: ErrInvalidImportRow},
+			},
+		},
+		{
+			name:   "Ok - NDJSON",
+			format: ImportFormatNDJSON,
+			input: `{"email":"some@email.com","password":"some-password","status":"pending"}` + "\n" +
+				"\n" +
+				`{"email":"other@email.com","unknown":true}` + "\n" +
+				`{"email":"last@email.com","password":"some-password"}`,
+			expectedRows: []ImportRow{
+				{Row: 1, User: ImportRequest{UserRequest: UserRequest{Email: "some@email.com", Password: "some-password"}, Status: StatusPending}},
+				{Row: 3, Err: ErrInvalidImportRow},
+				{Row: 4, User: ImportRequest{UserRequest: UserRequest{Email: "last@email.com", Password: "some-password"}}},
+			},
+		},
+		{
+			name:          "Fail - CSV without an email column",
+			format:        ImportFormatCSV,
+			input:         "first_name,password\nSome,some-password\n",
+			expectedError: ErrInvalidImportHeader,
+		},
+		{
+			name:          "Fail - CSV with an unknown column",
+			format:        ImportFormatCSV,
+			input:         "email,password,role\n",
+			expectedError: ErrInvalidImportHeader,
+		},
+		{
+			name:          "Fail - Empty CSV",
+			format:        ImportFormatCSV,
+			expectedError: ErrInvalidImportHeader,
+		},
+		{
+			name:          "Fail - Unknown format",
+			format:        "xml",
+			expectedError: ErrInvalidImportFormat,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, err := NewImportReader(strings.NewReader(tt.input), tt.format)
+			require.Equal(t, tt.expectedError, err)
+			if err != nil {
+				return
+			}
+
+			rows := []ImportRow{}
+			for {
+				row, err := reader.Read()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+				rows = append(rows, row)
+			}
+			require.Equal(t, tt.expectedRows, rows)
+		})
+	}
+}
+
+// ImportReaderMock returns rows, then err, or io.EOF if it's nil.
+type ImportReaderMock struct {
+	rows []ImportRow
+	err  error
+}
+
+func (r *ImportReaderMock) Read() (ImportRow, error) {
+	if len(r.rows) == 0 {
+		if r.err != nil {
+			return ImportRow{}, r.err
+		}
+		return ImportRow{}, io.EOF
+	}
+	row := r.rows[0]
+	r.rows = r.rows[1:]
+	return row, nil
+}
+
+func TestService_Import(t *testing.T) {
+	importRow := func(row int, email string) ImportRow {
+		return ImportRow{Row: row, User: ImportRequest{UserRequest: UserRequest{Email: email}, PasswordHash: _importPasswordHash}}
+	}
+
+	var tests = []struct {
+		name            string
+		repo            *RepositoryMock
+		reader          *ImportReaderMock
+		batchSize       int
+		expectedResults []ImportResult
+		expectedSummary ImportSummary
+		expectedError   error
+		expectedCreated []string
+	}{
+		{
+			name: "Ok - Import in batches",
+			repo: &RepositoryMock{},
+			reader: &ImportReaderMock{rows: []ImportRow{
+				importRow(1, "one@email.com"),
+				importRow(2, "two@email.com"),
+				importRow(3, "three@email.com"),
+			}},
+			batchSize: 2,
+			expectedResults: []ImportResult{
+				{Row: 1, Email: "one@email.com", Status: ImportStatusCreated, ID: 1},
+				{Row: 2, Email: "two@email.com", Status: ImportStatusCreated, ID: 2},
+				{Row: 3, Email: "three@email.com", Status: ImportStatusCreated, ID: 3},
+			},
+			expectedSummary: ImportSummary{Status: ImportStatusCompleted, Created: 3},
+			expectedCreated: []string{"one@email.com", "two@email.com", "three@email.com"},
+		},
+		{
+			name: "Ok - Invalid rows fail alone",
+			repo: &RepositoryMock{existing: map[string]bool{"taken@email.com": true}},
+			reader: &ImportReaderMock{rows: []ImportRow{
+				importRow(1, "one@email.com"),
+				importRow(2, "not an email"),
+				importRow(3, "taken@email.com"),
+				importRow(4, "ONE@email.com"),
+				{Row: 5, User: ImportRequest{UserRequest: UserRequest{Email: "five@email.com"}, PasswordHash: "not a hash"}},
+				{Row: 6, Err: ErrInvalidImportRow},
+				importRow(7, "seven@email.com"),
+			}},
+			batchSize: 10,
+			expectedResults: []ImportResult{
+				{Row: 1, Email: "one@email.com", Status: ImportStatusCreated, ID: 1},
+				{Row: 2, Email: "not an email", Status: ImportStatusFailed, Error: ErrInvalidEmail.Error()},
+				{Row: 3, Email: "taken@email.com", Status: ImportStatusFailed, Error: ErrUserAlreadyExists.Error()},
+				{Row: 4, Email: "ONE@email.com", Status: ImportStatusFailed, Error: ErrDuplicateImportEmail.Error()},
+				{Row: 5, Email: "five@email.com", Status: ImportStatusFailed, Error: ErrInvalidPasswordHash.Error()},
+				{Row: 6, Status: ImportStatusFailed, Error: ErrInvalidImportRow.Error()},
+				{Row: 7, Email: "seven@email.com", Status: ImportStatusCreated, ID: 2},
+			},
+			expectedSummary: ImportSummary{Status: ImportStatusCompleted, Created: 2, Failed: 5},
+			expectedCreated: []string{"one@email.com", "seven@email.com"},
+		},
+		{
+			name: "Fail - Reader error aborts the import",
+			repo: &RepositoryMock{},
+			reader: &ImportReaderMock{
+				rows: []ImportRow{importRow(1, "one@email.com")},
+				err:  errors.New("connection reset"),
+			},
+			batchSize:       1,
+			expectedResults: []ImportResult{{Row: 1, Email: "one@email.com", Status: ImportStatusCreated, ID: 1}},
+			expectedSummary: ImportSummary{Status: ImportStatusAborted, Created: 1},
+			expectedError:   errors.New("connection reset"),
+			expectedCreated: []string{"one@email.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewService(tt.repo)
+			results := []ImportResult{}
+			summary, err := service.Import(context.Background(), tt.reader, tt.batchSize, func(result ImportResult) error {
+				results = append(results, result)
+				return nil
+			})
+			require.Equal(t, tt.expectedError, err)
+			require.Equal(t, tt.expectedSummary, summary)
+			require.Equal(t, tt.expectedResults, results)
+
+			created := []string{}
+			for _, user := range tt.repo.created {
+				require.Equal(t, _importPasswordHash, user.Password)
+				require.Equal(t, StatusActive, user.Status)
+				created = append(created, user.Email)
+			}
+			require.Equal(t, tt.expectedCreated, created)
+			require.Len(t, tt.repo.events, len(tt.expectedCreated))
+		})
+	}
+}
+
+func TestService_Import_Password(t *testing.T) {
+	repo := &RepositoryMock{}
+	service := NewService(repo, WithInitialStatus(StatusPending))
+	reader := &ImportReaderMock{rows: []ImportRow{
+		{Row: 1, User: ImportRequest{UserRequest: UserRequest{Email: "some@email.com", Password: "some-password"}, Status: StatusActive}},
+		{Row: 2, User: ImportRequest{UserRequest: UserRequest{Email: "other@email.com", Password: "some-password"}, PasswordHash: _importPasswordHash}},
+		{Row: 3, User: ImportRequest{UserRequest: UserRequest{Email: "last@email.com"}, PasswordHash: _importPasswordHash}},
+	}}
+
+	results := []ImportResult{}
+	summary, err := service.Import(context.Background(), reader, 10, func(result ImportResult) error {
+		results = append(results, result)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, ImportSummary{Status: ImportStatusCompleted, Created: 2, Failed: 1}, summary)
+	require.Equal(t, ErrInvalidPassword.Error(), results[1].Error)
+
+	// Plain passwords are hashed, and the initial status applies unless a row sets one.
+	require.Len(t, repo.created, 2)
+	require.NoError(t, bcrypt.CompareHashAndPassword([]byte(repo.created[0].Password), []byte("some-password")))
+	require.Equal(t, StatusActive, repo.created[0].Status)
+	require.Equal(t, StatusPending, repo.created[1].Status)
+}
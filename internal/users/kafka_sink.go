@@ -0,0 +1,37 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+)
+
+// KafkaProducer is the subset of a Kafka-compatible client (Kafka, Redpanda, ...) the KafkaSink
+// needs. Implementations must return only once the broker acknowledged the message.
+type KafkaProducer interface {
+	Produce(ctx context.Context, topic string, key []byte, value []byte) error
+}
+
+// KafkaSink is a Publisher that produces each event to a topic. Messages are keyed by user ID, so
+// the events of a user keep their order within a partition.
+type KafkaSink struct {
+	producer KafkaProducer
+	topic    string
+}
+
+func NewKafkaSink(producer KafkaProducer, topic string) KafkaSink {
+	return KafkaSink{
+		producer: producer,
+		topic:    topic,
+	}
+}
+
+func (s KafkaSink) Publish(ctx context.Context, event Event) error {
+	value, err := json.Marshal(EventEnvelope{Name: event.EventName(), Data: event})
+	if err != nil {
+		return err
+	}
+
+	key := []byte(strconv.Itoa(event.Metadata().UserID))
+	return s.producer.Produce(ctx, s.topic, key, value)
+}
@@ -0,0 +1,33 @@
+package users
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type KafkaProducerMock struct {
+	topic string
+	key   string
+	value string
+}
+
+func (p *KafkaProducerMock) Produce(_ context.Context, topic string, key []byte, value []byte) error {
+	p.topic, p.key, p.value = topic, string(key), string(value)
+	return nil
+}
+
+func TestKafkaSink_Publish(t *testing.T) {
+	occurredAt := time.Date(2022, 5, 1, 16, 32, 4, 0, time.UTC)
+
+	producer := &KafkaProducerMock{}
+	sink := NewKafkaSink(producer, "users.events")
+
+	err := sink.Publish(context.Background(), UserCreated{EventMetadata: EventMetadata{ID: "a", UserID: 7, OccurredAt: occurredAt}, Email: "some@email.com"})
+	require.NoError(t, err)
+	require.Equal(t, "users.events", producer.topic)
+	require.Equal(t, "7", producer.key)
+	require.Equal(t, `{"name":"user.created","data":{"id":"a","user_id":7,"occurred_at":"2022-05-01T16:32:04Z","email":"some@email.com"}}`, producer.value)
+}
@@ -0,0 +1,86 @@
+package users
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+var (
+	// ErrLoginThrottled login attempt while the account or client IP is locked out error
+	ErrLoginThrottled = errors.New("too many failed logins, try again later")
+)
+
+// LockoutPolicy configures brute-force protection. After MaxFailures consecutive failed logins of
+// a user, or IPMaxFailures from an IP, further logins are rejected for BaseDuration, doubling on
+// each consecutive lockout up to MaxDuration, uncapped if it's zero. Failures are forgotten ResetAfter the last one, and
+// a user's on a successful login. Zero MaxFailures or IPMaxFailures disables the respective
+// tracking.
+type LockoutPolicy struct {
+	MaxFailures   int
+	IPMaxFailures int
+	BaseDuration  time.Duration
+	MaxDuration   time.Duration
+	ResetAfter    time.Duration
+}
+
+// LoginFailures are the consecutive failed logins of a user or IP.
+type LoginFailures struct {
+	FailedLogins int `gorm:"column:failed_logins;not null;default:0"`
+	// Lockouts counts the consecutive lockouts, doubling the duration of the next one.
+	Lockouts      int   `gorm:"column:lockouts;not null;default:0"`
+	LastFailureAt int64 `gorm:"column:last_failure_at"`
+	LockedUntil   int64 `gorm:"column:locked_until"`
+}
+
+// IPLoginFailures are the failed logins from a client IP, whichever accounts they targeted.
+type IPLoginFailures struct {
+	IP            string        `gorm:"column:ip;size:45;primaryKey"`
+	LoginFailures LoginFailures `gorm:"embedded"`
+}
+
+func (IPLoginFailures) TableName() string {
+	return "ip_login_failures"
+}
+
+// Locked reports whether logins are rejected at now.
+func (f LoginFailures) Locked(now time.Time) bool {
+	return f.LockedUntil > now.Unix()
+}
+
+// lockDuration returns the duration of the lockouts-th consecutive lockout.
+func (p LockoutPolicy) lockDuration(lockouts int) time.Duration {
+	maxDuration := p.MaxDuration
+	if maxDuration <= 0 {
+		// Uncapped, but doubling stops before overflowing.
+		maxDuration = math.MaxInt64
+	}
+
+	duration := p.BaseDuration
+	for i := 1; i < lockouts; i++ {
+		if duration > maxDuration/2 {
+			return maxDuration
+		}
+		duration *= 2
+	}
+
+	return duration
+}
+
+// recordFailure returns failures after a failed login at now, locking them out once they reach
+// maxFailures.
+func (p LockoutPolicy) recordFailure(failures LoginFailures, maxFailures int, now time.Time) LoginFailures {
+	if p.ResetAfter > 0 && failures.LastFailureAt != 0 && now.Sub(time.Unix(failures.LastFailureAt, 0)) > p.ResetAfter {
+		failures = LoginFailures{}
+	}
+
+	failures.FailedLogins++
+	failures.LastFailureAt = now.Unix()
+	if failures.FailedLogins >= maxFailures {
+		failures.Lockouts++
+		failures.LockedUntil = now.Add(p.lockDuration(failures.Lockouts)).Unix()
+		failures.FailedLogins = 0
+	}
+
+	return failures
+}
@@ -0,0 +1,73 @@
+package users
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockoutPolicy_LockDuration(t *testing.T) {
+	policy := LockoutPolicy{BaseDuration: time.Minute, MaxDuration: 10 * time.Minute}
+
+	uncapped := LockoutPolicy{BaseDuration: time.Minute}
+
+	var tests = []struct {
+		policy   LockoutPolicy
+		lockouts int
+		expected time.Duration
+	}{
+		{policy: policy, lockouts: 1, expected: time.Minute},
+		{policy: policy, lockouts: 2, expected: 2 * time.Minute},
+		{policy: policy, lockouts: 4, expected: 8 * time.Minute},
+		{policy: policy, lockouts: 5, expected: 10 * time.Minute},
+		{policy: policy, lockouts: 50, expected: 10 * time.Minute},
+		{policy: uncapped, lockouts: 1, expected: time.Minute},
+		{policy: uncapped, lockouts: 2, expected: 2 * time.Minute},
+		{policy: uncapped, lockouts: 11, expected: 1024 * time.Minute},
+		{policy: uncapped, lockouts: 100, expected: math.MaxInt64},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.expected, tt.policy.lockDuration(tt.lockouts))
+	}
+}
+
+func TestLockoutPolicy_RecordFailure(t *testing.T) {
+	policy := LockoutPolicy{BaseDuration: time.Minute, MaxDuration: time.Hour, ResetAfter: time.Hour}
+	now := time.Unix(1651422724, 0)
+
+	var tests = []struct {
+		name     string
+		failures LoginFailures
+		expected LoginFailures
+	}{
+		{
+			name:     "Ok - Failure counted",
+			failures: LoginFailures{FailedLogins: 1, LastFailureAt: now.Add(-time.Minute).Unix()},
+			expected: LoginFailures{FailedLogins: 2, LastFailureAt: now.Unix()},
+		},
+		{
+			name:     "Ok - Locked at max failures",
+			failures: LoginFailures{FailedLogins: 2, LastFailureAt: now.Add(-time.Minute).Unix()},
+			expected: LoginFailures{Lockouts: 1, LastFailureAt: now.Unix(), LockedUntil: now.Add(time.Minute).Unix()},
+		},
+		{
+			name:     "Ok - Consecutive lockout doubles",
+			failures: LoginFailures{FailedLogins: 2, Lockouts: 1, LastFailureAt: now.Add(-time.Minute).Unix()},
+			expected: LoginFailures{Lockouts: 2, LastFailureAt: now.Unix(), LockedUntil: now.Add(2 * time.Minute).Unix()},
+		},
+		{
+			name:     "Ok - Reset after ResetAfter",
+			failures: LoginFailures{FailedLogins: 2, Lockouts: 3, LastFailureAt: now.Add(-2 * time.Hour).Unix()},
+			expected: LoginFailures{FailedLogins: 1, LastFailureAt: now.Unix()},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, policy.recordFailure(tt.failures, 3, now))
+		})
+	}
+}
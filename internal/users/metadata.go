@@ -0,0 +1,215 @@
+package users
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/config"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+var (
+	// ErrInvalidMetadata metadata namespace not matching its schema error
+	ErrInvalidMetadata = errors.New("invalid metadata")
+	// ErrUnknownMetadataNamespace metadata namespace without a configured schema error
+	ErrUnknownMetadataNamespace = errors.New("unknown metadata namespace")
+	// ErrMetadataKeyNotIndexed list filter on a metadata key that isn't indexed error
+	ErrMetadataKeyNotIndexed = errors.New("metadata key not indexed")
+)
+
+// _maxIndexedValueLength is the size of the metadata_value column. Longer values aren't indexed.
+const _maxIndexedValueLength = 255
+
+// Metadata holds custom attributes of a user, as one JSON object per namespace. Each namespace
+// is validated against its configured JSON Schema.
+type Metadata map[string]map[string]interface{}
+
+// Value stores the metadata as a JSON document.
+func (m Metadata) Value() (driver.Value, error) {
+	if m == nil {
+		return nil, nil
+	}
+
+	return json.Marshal(m)
+}
+
+// Scan reads the metadata from a JSON document.
+func (m *Metadata) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, m)
+	case string:
+		return json.Unmarshal([]byte(v), m)
+	default:
+		return fmt.Errorf("unsupported metadata type %T", value)
+	}
+}
+
+// String returns the metadata as JSON, empty if it's nil.
+func (m Metadata) String() string {
+	if m == nil {
+		return ""
+	}
+
+	// Marshaling decoded JSON values can't fail.
+	b, _ := json.Marshal(m)
+	return string(b)
+}
+
+// merge returns m with the namespaces of update replaced. A nil namespace in update removes it.
+func (m Metadata) merge(update Metadata) Metadata {
+	merged := Metadata{}
+	for namespace, attributes := range m {
+		merged[namespace] = attributes
+	}
+	for namespace, attributes := range update {
+		if attributes == nil {
+			delete(merged, namespace)
+			continue
+		}
+		merged[namespace] = attributes
+	}
+
+	return merged
+}
+
+// MetadataIndexEntry is the value of an indexed metadata key of a user, so users can be listed by
+// it without scanning the JSON column.
+type MetadataIndexEntry struct {
+	UserID int    `gorm:"column:user_id;primaryKey;autoIncrement:false"`
+	Key    string `gorm:"column:metadata_key;size:128;primaryKey;index:idx_user_metadata_index_key_value,priority:1"`
+	Value  string `gorm:"column:metadata_value;size:255;index:idx_user_metadata_index_key_value,priority:2"`
+}
+
+func (MetadataIndexEntry) TableName() string {
+	return "user_metadata_index"
+}
+
+// MetadataSchemas validates metadata namespaces and selects the keys indexed for listing.
+type MetadataSchemas struct {
+	schemas map[string]*jsonschema.Schema
+	indexed map[string]bool
+}
+
+// NewMetadataSchemas compiles the namespace schemas of cfg.
+func NewMetadataSchemas(cfg config.Metadata) (MetadataSchemas, error) {
+	schemas := make(map[string]*jsonschema.Schema, len(cfg.Schemas))
+	for namespace, document := range cfg.Schemas {
+		schema, err := jsonschema.CompileString(namespace+".json", document)
+		if err != nil {
+			return MetadataSchemas{}, fmt.Errorf("compiling metadata schema %q: %w", namespace, err)
+		}
+		schemas[namespace] = schema
+	}
+
+	indexed := make(map[string]bool, len(cfg.IndexedKeys))
+	for _, key := range cfg.IndexedKeys {
+		namespace := strings.SplitN(key, ".", 2)[0]
+		if _, ok := schemas[namespace]; !ok || !strings.Contains(key, ".") {
+			return MetadataSchemas{}, fmt.Errorf("indexed metadata key %q: %w", key, ErrUnknownMetadataNamespace)
+		}
+		indexed[key] = true
+	}
+
+	return MetadataSchemas{
+		schemas: schemas,
+		indexed: indexed,
+	}, nil
+}
+
+// Validate checks every namespace of metadata against its schema. Nil namespaces, which remove
+// the namespace on update, aren't validated.
+func (s MetadataSchemas) Validate(metadata Metadata) error {
+	for namespace, attributes := range metadata {
+		schema, ok := s.schemas[namespace]
+		if !ok {
+			return fmt.Errorf("%w %q", ErrUnknownMetadataNamespace, namespace)
+		}
+		if attributes == nil {
+			continue
+		}
+
+		err := schema.Validate(map[string]interface{}(attributes))
+		if err != nil {
+			return fmt.Errorf("%w. namespace %s: %s", ErrInvalidMetadata, namespace, validationMessage(err))
+		}
+	}
+
+	return nil
+}
+
+// CheckIndexed verifies every key can be used to list users.
+func (s MetadataSchemas) CheckIndexed(keys ...string) error {
+	for _, key := range keys {
+		if !s.indexed[key] {
+			return fmt.Errorf("%w %q", ErrMetadataKeyNotIndexed, key)
+		}
+	}
+
+	return nil
+}
+
+// indexEntries returns the index entries of the indexed keys of metadata holding a scalar value.
+func (s MetadataSchemas) indexEntries(userID int, metadata Metadata) []MetadataIndexEntry {
+	entries := []MetadataIndexEntry{}
+	for namespace, attributes := range metadata {
+		for key, value := range attributes {
+			name := namespace + "." + key
+			if !s.indexed[name] {
+				continue
+			}
+
+			indexValue, ok := indexValue(value)
+			if !ok {
+				continue
+			}
+			entries = append(entries, MetadataIndexEntry{UserID: userID, Key: name, Value: indexValue})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+
+	return entries
+}
+
+// indexValue returns value as stored in the index, as query params compare to it.
+func indexValue(value interface{}) (string, bool) {
+	var indexed string
+	switch v := value.(type) {
+	case string:
+		indexed = v
+	case float64:
+		indexed = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		indexed = strconv.FormatBool(v)
+	default:
+		return "", false
+	}
+
+	return indexed, len(indexed) <= _maxIndexedValueLength
+}
+
+// validationMessage returns the innermost cause of a schema validation error, the one pointing at
+// the offending attribute.
+func validationMessage(err error) string {
+	var validationErr *jsonschema.ValidationError
+	if !errors.As(err, &validationErr) {
+		return err.Error()
+	}
+	for len(validationErr.Causes) > 0 {
+		validationErr = validationErr.Causes[0]
+	}
+	location := validationErr.InstanceLocation
+	if location == "" {
+		location = "/"
+	}
+
+	return location + ": " + validationErr.Message
+}
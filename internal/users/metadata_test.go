@@ -0,0 +1,85 @@
+package users
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewMetadataSchemas(t *testing.T) {
+	var tests = []struct {
+		name          string
+		cfg           config.Metadata
+		expectedError error
+	}{
+		{
+			name: "Ok",
+			cfg: config.Metadata{
+				Schemas:     map[string]string{"billing": `{"type": "object"}`},
+				IndexedKeys: []string{"billing.plan"},
+			},
+		},
+		{
+			name: "Fail - Indexed key of unknown namespace",
+			cfg: config.Metadata{
+				Schemas:     map[string]string{"billing": `{"type": "object"}`},
+				IndexedKeys: []string{"crm.owner"},
+			},
+			expectedError: ErrUnknownMetadataNamespace,
+		},
+		{
+			name: "Fail - Indexed namespace without key",
+			cfg: config.Metadata{
+				Schemas:     map[string]string{"billing": `{"type": "object"}`},
+				IndexedKeys: []string{"billing"},
+			},
+			expectedError: ErrUnknownMetadataNamespace,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewMetadataSchemas(tt.cfg)
+			require.True(t, errors.Is(err, tt.expectedError))
+		})
+	}
+
+	t.Run("Fail - Invalid schema", func(t *testing.T) {
+		_, err := NewMetadataSchemas(config.Metadata{Schemas: map[string]string{"billing": `{"type": 1}`}})
+		require.Error(t, err)
+	})
+}
+
+func TestMetadataSchemas_indexEntries(t *testing.T) {
+	schemas, err := NewMetadataSchemas(config.Metadata{
+		Schemas:     map[string]string{"billing": `{"type": "object"}`},
+		IndexedKeys: []string{"billing.plan", "billing.seats", "billing.trial", "billing.tags"},
+	})
+	require.NoError(t, err)
+
+	entries := schemas.indexEntries(7, Metadata{
+		"billing": {"plan": "pro", "seats": float64(25), "trial": false, "tags": []interface{}{"a"}, "notes": "not indexed"},
+	})
+
+	require.Equal(t, []MetadataIndexEntry{
+		{UserID: 7, Key: "billing.plan", Value: "pro"},
+		{UserID: 7, Key: "billing.seats", Value: "25"},
+		{UserID: 7, Key: "billing.trial", Value: "false"},
+	}, entries)
+}
+
+func TestMetadata_Scan(t *testing.T) {
+	metadata := Metadata{"billing": {"plan": "pro"}}
+
+	value, err := metadata.Value()
+	require.NoError(t, err)
+
+	var scanned Metadata
+	require.NoError(t, scanned.Scan(value))
+	require.Equal(t, metadata, scanned)
+
+	require.NoError(t, scanned.Scan(nil))
+	require.Nil(t, scanned)
+}
@@ -0,0 +1,95 @@
+package users
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	_operationCreate       = "create"
+	_operationGet          = "get"
+	_operationUpdate       = "update"
+	_operationDelete       = "delete"
+	_operationList         = "list"
+	_operationAuthenticate = "authenticate"
+	_operationAuditLog     = "audit_log"
+	_operationSetStatus    = "set_status"
+	_operationImport       = "import"
+	_operationExport       = "export"
+	_operationSearch       = "search"
+
+	_outcomeCreated            = "created"
+	_outcomeFound              = "found"
+	_outcomeUpdated            = "updated"
+	_outcomeDeleted            = "deleted"
+	_outcomeAuthenticated      = "authenticated"
+	_outcomeInvalidCredentials = "invalid_credentials"
+	_outcomeAccountInactive    = "account_inactive"
+	_outcomeThrottled          = "throttled"
+	_outcomeNotFound           = "not_found"
+	_outcomeConflict           = "conflict"
+	_outcomeInvalid            = "invalid"
+	_outcomeError              = "error"
+
+	_lockoutScopeUser = "user"
+	_lockoutScopeIP   = "ip"
+)
+
+var (
+	serviceOperationsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "users_service_operations_total",
+			Help: "Total number of users service operations by operation and outcome.",
+		},
+		[]string{"operation", "outcome"},
+	)
+
+	loginLockoutsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "users_login_lockouts_total",
+			Help: "Total number of lockouts after consecutive failed logins, by scope: user or ip.",
+		},
+		[]string{"scope"},
+	)
+
+	passwordHashDuration = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "users_password_hash_duration_seconds",
+			Help:    "Time spent hashing user passwords with bcrypt.",
+			Buckets: []float64{.01, .025, .05, .1, .25, .5, 1, 2.5},
+		},
+	)
+)
+
+// observeOperation counts a service operation, mapping err to its outcome label. success is the
+// outcome used when err is nil.
+func observeOperation(operation string, success string, err error) {
+	serviceOperationsTotal.WithLabelValues(operation, operationOutcome(success, err)).Inc()
+}
+
+func operationOutcome(success string, err error) string {
+	switch err {
+	case nil:
+		return success
+	case ErrUserNotFound:
+		return _outcomeNotFound
+	case ErrUserAlreadyExists, ErrInvalidStatusTransition, ErrDuplicateImportEmail, ErrConcurrentUpdate:
+		return _outcomeConflict
+	case ErrInvalidCredentials:
+		return _outcomeInvalidCredentials
+	case ErrLoginThrottled:
+		return _outcomeThrottled
+	default:
+		if IsAccountStatusError(err) {
+			return _outcomeAccountInactive
+		}
+		if IsValidationError(err) {
+			return _outcomeInvalid
+		}
+		return _outcomeError
+	}
+}
+
+func observeLockout(scope string) {
+	loginLockoutsTotal.WithLabelValues(scope).Inc()
+}
@@ -1,19 +1,158 @@
 package users
 
+import "encoding/json"
+
 type UserRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email       string   `json:"email"`
+	Password    string   `json:"password"`
+	FirstName   string   `json:"first_name"`
+	LastName    string   `json:"last_name"`
+	DisplayName string   `json:"display_name"`
+	Locale      string   `json:"locale"`
+	Timezone    string   `json:"timezone"`
+	Phone       string   `json:"phone"`
+	AvatarURL   string   `json:"avatar_url"`
+	Metadata    Metadata `json:"metadata"`
 }
 
 type UserResponse struct {
-	ID    int    `json:"id"`
-	Email string `json:"email"`
+	ID              int      `json:"id"`
+	Email           string   `json:"email"`
+	Status          string   `json:"status,omitempty"`
+	StatusReason    string   `json:"status_reason,omitempty"`
+	StatusChangedAt int64    `json:"status_changed_at,omitempty"`
+	FailedLogins    int      `json:"failed_logins,omitempty"`
+	LockedUntil     int64    `json:"locked_until,omitempty"`
+	FirstName       string   `json:"first_name,omitempty"`
+	LastName        string   `json:"last_name,omitempty"`
+	DisplayName     string   `json:"display_name,omitempty"`
+	Locale          string   `json:"locale,omitempty"`
+	Timezone        string   `json:"timezone,omitempty"`
+	Phone           string   `json:"phone,omitempty"`
+	AvatarURL       string   `json:"avatar_url,omitempty"`
+	Metadata        Metadata `json:"metadata,omitempty"`
+}
+
+// BatchRequest runs Operations in Mode, "atomic" or "best_effort", the default.
+type BatchRequest struct {
+	Mode       string                  `json:"mode"`
+	Operations []BatchOperationRequest `json:"operations"`
+}
+
+// BatchOperationRequest is an operation of a batch: "create" of User, "update" of the user with
+// ID to User, or "delete" of the user with ID.
+type BatchOperationRequest struct {
+	Method string      `json:"method"`
+	ID     int         `json:"id"`
+	User   UserRequest `json:"user"`
+}
+
+// BatchResponse has the result of each operation of a batch, in order.
+type BatchResponse struct {
+	Results []BatchResultResponse `json:"results"`
+}
+
+// BatchResultResponse is the result of an operation: the status code and response body of its
+// endpoint, User or Error.
+type BatchResultResponse struct {
+	Status int           `json:"status"`
+	User   *UserResponse `json:"user,omitempty"`
+	Error  string        `json:"error,omitempty"`
 }
 
+// ExportedUser is a user of an export, with its timestamps and without its password or login
+// failures.
+type ExportedUser struct {
+	ID              int      `json:"id"`
+	Email           string   `json:"email"`
+	Status          string   `json:"status"`
+	StatusReason    string   `json:"status_reason,omitempty"`
+	StatusChangedAt int64    `json:"status_changed_at,omitempty"`
+	FirstName       string   `json:"first_name,omitempty"`
+	LastName        string   `json:"last_name,omitempty"`
+	DisplayName     string   `json:"display_name,omitempty"`
+	Locale          string   `json:"locale,omitempty"`
+	Timezone        string   `json:"timezone,omitempty"`
+	Phone           string   `json:"phone,omitempty"`
+	AvatarURL       string   `json:"avatar_url,omitempty"`
+	Metadata        Metadata `json:"metadata,omitempty"`
+	CreatedAt       int64    `json:"created_at"`
+	UpdatedAt       int64    `json:"updated_at"`
+}
+
+// ImportRequest is a user of a bulk import. Users migrated from systems that only have the hash
+// of their password have a bcrypt PasswordHash, stored as is, instead of a Password. Status is
+// "active" or "pending", the initial status of created users if empty.
+type ImportRequest struct {
+	UserRequest
+	PasswordHash string `json:"password_hash"`
+	Status       string `json:"status"`
+}
+
+// ImportResult is the result of one row of a bulk import. Row is the 1-based row of the input,
+// counting the header of CSV imports as row 1.
+type ImportResult struct {
+	Row    int    `json:"row"`
+	Email  string `json:"email,omitempty"`
+	Status string `json:"status"`
+	ID     int    `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ImportSummary closes the report of a bulk import, "completed" or "aborted" with the Error that
+// stopped it.
+type ImportSummary struct {
+	Status  string `json:"status"`
+	Created int    `json:"created"`
+	Failed  int    `json:"failed"`
+	Error   string `json:"error,omitempty"`
+}
+
+type StatusRequest struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+type AuditEntryResponse struct {
+	ID        int64           `json:"id"`
+	UserID    int             `json:"user_id"`
+	Actor     string          `json:"actor"`
+	Action    string          `json:"action"`
+	Changes   json.RawMessage `json:"changes"`
+	RequestID string          `json:"request_id,omitempty"`
+	IP        string          `json:"ip,omitempty"`
+	CreatedAt int64           `json:"created_at"`
+}
+
+// User is a user account. Locale is a BCP 47 language tag, Timezone an IANA timezone name and
+// Phone an E.164 number. Status is changed only through Service.SetStatus, with a reason.
 type User struct {
-	ID        int    `gorm:"column:id;primaryKey"`
-	Email     string `gorm:"column:email"`
-	Password  string `gorm:"column:password"`
-	CreatedAt int64  `gorm:"column:created_at"`
-	UpdatedAt int64  `gorm:"column:updated_at"`
+	ID          int      `gorm:"column:id;primaryKey"`
+	Email       string   `gorm:"column:email;size:255;uniqueIndex;index:idx_users_search,class:FULLTEXT"`
+	Password    string   `gorm:"column:password"`
+	FirstName   string   `gorm:"column:first_name;size:100;index:idx_users_search,class:FULLTEXT"`
+	LastName    string   `gorm:"column:last_name;size:100;index:idx_users_search,class:FULLTEXT"`
+	DisplayName string   `gorm:"column:display_name;size:100;index:idx_users_search,class:FULLTEXT"`
+	Locale      string   `gorm:"column:locale;size:35"`
+	Timezone    string   `gorm:"column:timezone;size:64"`
+	Phone       string   `gorm:"column:phone;size:16"`
+	AvatarURL   string   `gorm:"column:avatar_url;size:2048"`
+	Metadata    Metadata `gorm:"column:metadata;type:json"`
+	// Existing accounts are active.
+	Status          string `gorm:"column:status;size:16;not null;default:active"`
+	StatusReason    string `gorm:"column:status_reason;size:255"`
+	StatusChangedAt int64  `gorm:"column:status_changed_at"`
+	// LoginFailures are only changed by Service.Authenticate.
+	LoginFailures LoginFailures `gorm:"embedded"`
+	CreatedAt     int64         `gorm:"column:created_at"`
+	UpdatedAt     int64         `gorm:"column:updated_at"`
+	// Version is incremented by every update, which only applies to the version it was computed
+	// from.
+	Version int `gorm:"column:version;not null;default:1"`
+}
+
+// SchemaMigration records the schema version applied by the migrate tool.
+type SchemaMigration struct {
+	Version   int   `gorm:"column:version;primaryKey;autoIncrement:false"`
+	AppliedAt int64 `gorm:"column:applied_at;autoCreateTime"`
 }
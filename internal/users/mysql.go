@@ -1,22 +1,61 @@
 package users
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
 
+	gomysql "github.com/go-sql-driver/mysql"
 	"github.com/marcosstupnicki/go-users/internal/platform/config"
+	"go.opentelemetry.io/otel/attribute"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 )
 
 var (
 	// ErrUserNotFound users not found error
 	ErrUserNotFound = errors.New("user not found")
+	// ErrUserAlreadyExists users with the same email already exists error
+	ErrUserAlreadyExists = errors.New("user already exists")
+	// ErrInvalidCredentials email and password not matching a user error
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	// ErrConcurrentUpdate user updated by another request since it was read error
+	ErrConcurrentUpdate = errors.New("user was modified concurrently")
+	// ErrSchemaOutdated database schema behind the expected version error
+	ErrSchemaOutdated = errors.New("database schema is not at the expected version")
 )
 
+// SchemaVersion is the schema version this code expects. Bump it whenever a model change, of this
+// or any other package migrated by the migrate tool, requires running it.
+const SchemaVersion = 20
+
+// _mysqlErrDuplicateEntry is the MySQL server error number for unique key violations.
+const _mysqlErrDuplicateEntry = 1062
+
+// _searchMatch matches the columns of the idx_users_search FULLTEXT index.
+const _searchMatch = "MATCH (email, first_name, last_name, display_name)"
+
+// _minFullTextTermLength is the default innodb_ft_min_token_size. Shorter words aren't indexed.
+const _minFullTextTermLength = 3
+
+// _fullTextStopwords are the default InnoDB FULLTEXT stopwords, which aren't indexed either, so
+// required terms like "+com*" match no row.
+var _fullTextStopwords = map[string]bool{
+	"a": true, "about": true, "an": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+	"com": true, "de": true, "en": true, "for": true, "from": true, "how": true, "i": true, "in": true,
+	"is": true, "it": true, "la": true, "of": true, "on": true, "or": true, "that": true, "the": true,
+	"this": true, "to": true, "was": true, "what": true, "when": true, "where": true, "who": true,
+	"will": true, "with": true, "und": true, "www": true,
+}
+
 type MySQL struct {
 	DB *gorm.DB
 }
@@ -32,18 +71,38 @@ func NewMySQL(cfg config.Database) (MySQL, error) {
 	}, nil
 }
 
-func (repository MySQL) Create(user User) (User, error) {
-	tx := repository.DB.Create(&user)
+func (repository MySQL) Create(ctx context.Context, user User) (_ User, err error) {
+	ctx, span := tracer.Start(ctx, "MySQL.Create")
+	defer func() { endSpan(span, err) }()
+
+	tx := repository.DB.WithContext(ctx).Create(&user)
 	if tx.Error != nil {
-		return User{}, tx.Error
+		return User{}, translateError(tx.Error)
 	}
 
 	return user, nil
 }
 
-func (repository MySQL) Get(id int) (User, error) {
+func (repository MySQL) CreateBatch(ctx context.Context, users []User) (_ []User, err error) {
+	ctx, span := tracer.Start(ctx, "MySQL.CreateBatch")
+	span.SetAttributes(attribute.Int("users.count", len(users)))
+	defer func() { endSpan(span, err) }()
+
+	tx := repository.DB.WithContext(ctx).Create(&users)
+	if tx.Error != nil {
+		return nil, translateError(tx.Error)
+	}
+
+	return users, nil
+}
+
+func (repository MySQL) Get(ctx context.Context, id int) (_ User, err error) {
+	ctx, span := tracer.Start(ctx, "MySQL.Get")
+	span.SetAttributes(attribute.Int("user.id", id))
+	defer func() { endSpan(span, err) }()
+
 	user := User{ID: id}
-	tx := repository.DB.First(&user)
+	tx := repository.DB.WithContext(ctx).First(&user)
 
 	if tx.RowsAffected == 0 {
 		return User{}, ErrUserNotFound
@@ -55,22 +114,58 @@ func (repository MySQL) Get(id int) (User, error) {
 	return user, nil
 }
 
-func (repository MySQL) Update(user User) (User, error) {
-	tx := repository.DB.Model(&user).Updates(user)
+func (repository MySQL) GetByEmail(ctx context.Context, email string) (_ User, err error) {
+	ctx, span := tracer.Start(ctx, "MySQL.GetByEmail")
+	defer func() { endSpan(span, err) }()
+
+	var user User
+	tx := repository.DB.WithContext(ctx).Where("email = ?", email).Limit(1).Find(&user)
+	if tx.Error != nil {
+		return User{}, tx.Error
+	}
 	if tx.RowsAffected == 0 {
 		return User{}, ErrUserNotFound
 	}
+
+	return user, nil
+}
+
+func (repository MySQL) Update(ctx context.Context, user User) (_ User, err error) {
+	ctx, span := tracer.Start(ctx, "MySQL.Update")
+	span.SetAttributes(attribute.Int("user.id", user.ID))
+	defer func() { endSpan(span, err) }()
+
+	// The update only applies to the version of the user it was computed from, so concurrent
+	// updates don't overwrite each other.
+	version := user.Version
+	user.Version = version + 1
+	tx := repository.DB.WithContext(ctx).Model(&user).Where("version = ?", version).Updates(user)
 	if tx.Error != nil {
-		return User{}, tx.Error
+		return User{}, translateError(tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		var count int64
+		err = repository.DB.WithContext(ctx).Model(&User{}).Where("id = ?", user.ID).Count(&count).Error
+		if err != nil {
+			return User{}, err
+		}
+		if count == 0 {
+			return User{}, ErrUserNotFound
+		}
+		return User{}, ErrConcurrentUpdate
 	}
 
 	return user, nil
 }
 
-func (repository MySQL) Delete(id int) error {
+func (repository MySQL) Delete(ctx context.Context, id int) (err error) {
+	ctx, span := tracer.Start(ctx, "MySQL.Delete")
+	span.SetAttributes(attribute.Int("user.id", id))
+	defer func() { endSpan(span, err) }()
+
 	user := User{ID: id}
 
-	tx := repository.DB.Delete(&user)
+	tx := repository.DB.WithContext(ctx).Delete(&user)
 	if tx.Error != nil {
 		return tx.Error
 	}
@@ -83,15 +178,337 @@ func (repository MySQL) Delete(id int) error {
 	return nil
 }
 
+func (repository MySQL) List(ctx context.Context, filter ListFilter, limit int, offset int) (_ []User, err error) {
+	ctx, span := tracer.Start(ctx, "MySQL.List")
+	defer func() { endSpan(span, err) }()
+
+	var users []User
+	tx := repository.filter(repository.DB.WithContext(ctx), filter).Order("id").Limit(limit).Offset(offset).Find(&users)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return users, nil
+}
+
+// Search matches the terms against the idx_users_search FULLTEXT index, in boolean mode as
+// prefixes of words, ranked by relevance. Terms InnoDB doesn't index, shorter than its minimum
+// token size or stopwords, are matched anywhere in the columns with LIKE instead. Without any
+// indexed term, and on databases other than MySQL, users are ranked by whether the first term
+// starts the email or a name.
+func (repository MySQL) Search(ctx context.Context, terms []string, limit int, offset int) (_ []User, err error) {
+	ctx, span := tracer.Start(ctx, "MySQL.Search")
+	defer func() { endSpan(span, err) }()
+
+	// Ties are ordered by id in the rank expression, since gorm can't order by an expression and
+	// then by a column.
+	tx := repository.DB.WithContext(ctx)
+	var rank clause.Expr
+	indexed, unindexed := repository.fullTextTerms(terms)
+	if len(indexed) > 0 {
+		against := "+" + strings.Join(indexed, "* +") + "*"
+		tx = tx.Where(_searchMatch+" AGAINST (? IN BOOLEAN MODE)", against)
+		rank = clause.Expr{SQL: _searchMatch + " AGAINST (? IN BOOLEAN MODE) DESC, id", Vars: []interface{}{against}}
+	}
+	for _, term := range unindexed {
+		pattern := "%" + term + "%"
+		tx = tx.Where("email LIKE ? OR first_name LIKE ? OR last_name LIKE ? OR display_name LIKE ?", pattern, pattern, pattern, pattern)
+	}
+	if len(indexed) == 0 {
+		prefix := terms[0] + "%"
+		rank = clause.Expr{
+			SQL:  "CASE WHEN email LIKE ? THEN 0 WHEN first_name LIKE ? OR last_name LIKE ? OR display_name LIKE ? THEN 1 ELSE 2 END, id",
+			Vars: []interface{}{prefix, prefix, prefix, prefix},
+		}
+	}
+
+	var users []User
+	tx = tx.Clauses(clause.OrderBy{Expression: rank}).Limit(limit).Offset(offset).Find(&users)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return users, nil
+}
+
+// fullTextTerms splits terms into the ones matched against the FULLTEXT index and the ones it
+// doesn't index, all of them on databases other than MySQL.
+func (repository MySQL) fullTextTerms(terms []string) ([]string, []string) {
+	if repository.DB.Dialector.Name() != "mysql" {
+		return nil, terms
+	}
+
+	var indexed, unindexed []string
+	for _, term := range terms {
+		if utf8.RuneCountInString(term) < _minFullTextTermLength || _fullTextStopwords[term] {
+			unindexed = append(unindexed, term)
+			continue
+		}
+		indexed = append(indexed, term)
+	}
+
+	return indexed, unindexed
+}
+
+// Export iterates the users matching filter with a cursor, ordered by id, so memory use doesn't
+// grow with their number. Passwords are not read.
+func (repository MySQL) Export(ctx context.Context, filter ListFilter, fn func(user User) error) (err error) {
+	ctx, span := tracer.Start(ctx, "MySQL.Export")
+	defer func() { endSpan(span, err) }()
+
+	tx := repository.filter(repository.DB.WithContext(ctx).Model(&User{}), filter)
+	rows, err := tx.Omit("password").Order("id").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user User
+		err = tx.ScanRows(rows, &user)
+		if err != nil {
+			return err
+		}
+
+		err = fn(user)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// filter adds the conditions of filter to the users query tx.
+func (repository MySQL) filter(tx *gorm.DB, filter ListFilter) *gorm.DB {
+	for _, key := range sortedKeys(filter.Metadata) {
+		tx = tx.Where("id IN (?)", repository.DB.
+			Model(&MetadataIndexEntry{}).
+			Select("user_id").
+			Where("metadata_key = ? AND metadata_value = ?", key, filter.Metadata[key]))
+	}
+
+	return tx
+}
+
+func (repository MySQL) SetMetadataIndex(ctx context.Context, userID int, entries []MetadataIndexEntry) error {
+	tx := repository.DB.WithContext(ctx).Where("user_id = ?", userID).Delete(&MetadataIndexEntry{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	tx = repository.DB.WithContext(ctx).Create(&entries)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	return nil
+}
+
+// SetLoginFailures replaces the login failures of the user with id userID. Zero values are stored
+// too, and updated_at is left unchanged, since it's not a change on the user.
+func (repository MySQL) SetLoginFailures(ctx context.Context, userID int, failures LoginFailures) error {
+	tx := repository.DB.WithContext(ctx).Model(&User{}).Where("id = ?", userID).UpdateColumns(map[string]interface{}{
+		"failed_logins":   failures.FailedLogins,
+		"lockouts":        failures.Lockouts,
+		"last_failure_at": failures.LastFailureAt,
+		"locked_until":    failures.LockedUntil,
+	})
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	return nil
+}
+
+// GetIPLoginFailures returns the login failures from ip, zero if there are none.
+func (repository MySQL) GetIPLoginFailures(ctx context.Context, ip string) (LoginFailures, error) {
+	var failures IPLoginFailures
+	tx := repository.DB.WithContext(ctx).Where("ip = ?", ip).Limit(1).Find(&failures)
+	if tx.Error != nil {
+		return LoginFailures{}, tx.Error
+	}
+
+	return failures.LoginFailures, nil
+}
+
+func (repository MySQL) SetIPLoginFailures(ctx context.Context, ip string, failures LoginFailures) error {
+	tx := repository.DB.WithContext(ctx).Save(&IPLoginFailures{IP: ip, LoginFailures: failures})
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	return nil
+}
+
+func (repository MySQL) SaveEvents(ctx context.Context, events ...Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	messages := make([]OutboxMessage, 0, len(events))
+	for _, event := range events {
+		message, err := newOutboxMessage(event)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, message)
+	}
+
+	tx := repository.DB.WithContext(ctx).Create(&messages)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	return nil
+}
+
+func (repository MySQL) SaveAuditEntry(ctx context.Context, entry AuditEntry) error {
+	tx := repository.DB.WithContext(ctx).Create(&entry)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	return nil
+}
+
+func (repository MySQL) ListAuditEntries(ctx context.Context, userID int, limit int, offset int) (_ []AuditEntry, err error) {
+	ctx, span := tracer.Start(ctx, "MySQL.ListAuditEntries")
+	span.SetAttributes(attribute.Int("user.id", userID))
+	defer func() { endSpan(span, err) }()
+
+	var entries []AuditEntry
+	tx := repository.DB.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("id DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&entries)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return entries, nil
+}
+
+func (repository MySQL) Transaction(ctx context.Context, fn func(repository Repository) error) error {
+	return repository.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(MySQL{DB: tx})
+	})
+}
+
+// ClaimPending locks the due messages with SKIP LOCKED and moves their next attempt past the
+// lease before committing, so concurrent relays skip them and no lock is held during delivery.
+func (repository MySQL) ClaimPending(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]OutboxMessage, error) {
+	var messages []OutboxMessage
+	err := repository.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("delivered_at IS NULL AND parked_at IS NULL AND next_attempt_at <= ?", now.Unix()).
+			Order("id").
+			Limit(limit).
+			Find(&messages).Error
+		if err != nil {
+			return err
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+
+		ids := make([]int64, 0, len(messages))
+		for _, message := range messages {
+			ids = append(ids, message.ID)
+		}
+
+		return tx.Model(&OutboxMessage{}).Where("id IN ?", ids).Update("next_attempt_at", now.Add(lease).Unix()).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+func (repository MySQL) SaveAttempt(ctx context.Context, message OutboxMessage) error {
+	return repository.DB.WithContext(ctx).
+		Model(&message).
+		Select("attempts", "last_error", "next_attempt_at", "delivered_at", "parked_at").
+		Updates(&message).Error
+}
+
 func (repository MySQL) AutoMigrate() error {
-	err := repository.DB.AutoMigrate(&User{})
+	err := repository.DB.AutoMigrate(&User{}, &OutboxMessage{}, &AuditEntry{}, &MetadataIndexEntry{}, &IPLoginFailures{}, &SchemaMigration{})
 	if err != nil {
 		return err
 	}
 
+	tx := repository.DB.FirstOrCreate(&SchemaMigration{Version: SchemaVersion})
+	if tx.Error != nil {
+		return tx.Error
+	}
+
 	return nil
 }
 
+// Ping verifies the database connection is alive.
+func (repository MySQL) Ping(ctx context.Context) error {
+	sqlDB, err := repository.DB.DB()
+	if err != nil {
+		return err
+	}
+
+	return sqlDB.PingContext(ctx)
+}
+
+// Close closes the underlying database connection pool.
+func (repository MySQL) Close() error {
+	sqlDB, err := repository.DB.DB()
+	if err != nil {
+		return err
+	}
+
+	return sqlDB.Close()
+}
+
+// CheckSchemaVersion verifies the migrate tool has applied SchemaVersion.
+func (repository MySQL) CheckSchemaVersion(ctx context.Context) error {
+	var version int
+	row := repository.DB.WithContext(ctx).Model(&SchemaMigration{}).Select("COALESCE(MAX(version), 0)").Row()
+	err := row.Scan(&version)
+	if err != nil {
+		return err
+	}
+
+	if version < SchemaVersion {
+		return ErrSchemaOutdated
+	}
+
+	return nil
+}
+
+// sortedKeys returns the keys of m sorted, so queries built from it are deterministic.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// translateError maps driver errors to the repository errors.
+func translateError(err error) error {
+	var mysqlErr *gomysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == _mysqlErrDuplicateEntry {
+		return ErrUserAlreadyExists
+	}
+
+	return err
+}
+
 func connect(cfg config.Database) (*gorm.DB, error) {
 	newLogger := logger.New(
 		log.New(os.Stdout, "\r\n", log.LstdFlags),
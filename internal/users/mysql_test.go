@@ -1,21 +1,32 @@
 package users
 
 import (
+	"context"
+	"database/sql/driver"
 	"errors"
 	"regexp"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	gomysql "github.com/go-sql-driver/mysql"
 	"github.com/stretchr/testify/require"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 )
 
 func TestMySQL_Create(t *testing.T) {
+	// Every column is inserted: email, password, the profile fields, metadata, the timestamps and
+	// the version.
+	insertArgs := make([]driver.Value, 20)
+	for i := range insertArgs {
+		insertArgs[i] = sqlmock.AnyArg()
+	}
+
 	user := User{
 		Email:    "some@email.com",
 		Password: "$2a$10$i8u5FgiJXRui/p.ZDXnDO.kVq3H6rbqrQp6rInFX.IeEO0zN/2F5G",
+		Status:   StatusActive,
 	}
 
 	var tests = []struct {
@@ -35,7 +46,7 @@ func TestMySQL_Create(t *testing.T) {
 				mock.MatchExpectationsInOrder(false)
 				mock.ExpectBegin()
 				mock.ExpectExec("INSERT INTO `users`").
-					WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WithArgs(insertArgs...).
 					WillReturnResult(sqlmock.NewResult(0, 1))
 				mock.ExpectCommit()
 
@@ -52,8 +63,10 @@ func TestMySQL_Create(t *testing.T) {
 				ID:        0,
 				Email:     "some@email.com",
 				Password:  "$2a$10$i8u5FgiJXRui/p.ZDXnDO.kVq3H6rbqrQp6rInFX.IeEO0zN/2F5G",
+				Status:    StatusActive,
 				CreatedAt: time.Now().Unix(),
 				UpdatedAt: time.Now().Unix(),
+				Version:   1,
 			},
 		},
 		{
@@ -66,7 +79,7 @@ func TestMySQL_Create(t *testing.T) {
 				mock.MatchExpectationsInOrder(false)
 				mock.ExpectBegin()
 				mock.ExpectExec("INSERT INTO `users`").
-					WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WithArgs(insertArgs...).
 					WillReturnError(errors.New("internal error"))
 				mock.ExpectCommit()
 				mock.ExpectRollback()
@@ -82,6 +95,31 @@ func TestMySQL_Create(t *testing.T) {
 			}(),
 			expectedError: errors.New("internal error"),
 		},
+		{
+			name: "Fail - Duplicate email",
+			user: user,
+			db: func() *gorm.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+
+				mock.MatchExpectationsInOrder(false)
+				mock.ExpectBegin()
+				mock.ExpectExec("INSERT INTO `users`").
+					WithArgs(insertArgs...).
+					WillReturnError(&gomysql.MySQLError{Number: 1062, Message: "Duplicate entry"})
+				mock.ExpectRollback()
+
+				gormDB, err := gorm.Open(
+					mysql.New(mysql.Config{
+						Conn:                      db,
+						SkipInitializeWithVersion: true}),
+					&gorm.Config{})
+				require.NoError(t, err)
+
+				return gormDB
+			}(),
+			expectedError: ErrUserAlreadyExists,
+		},
 	}
 
 	for _, tt := range tests {
@@ -90,7 +128,7 @@ func TestMySQL_Create(t *testing.T) {
 			repo := MySQL{
 				DB: tt.db,
 			}
-			result, err := repo.Create(tt.user)
+			result, err := repo.Create(context.Background(), tt.user)
 
 			require.Equal(t, tt.expectedError, err)
 			require.Equal(t, tt.expectedResult, result)
@@ -196,7 +234,7 @@ func TestMySQL_Get(t *testing.T) {
 			repo := MySQL{
 				DB: tt.db,
 			}
-			result, err := repo.Get(tt.id)
+			result, err := repo.Get(context.Background(), tt.id)
 
 			require.Equal(t, tt.expectedError, err)
 			require.Equal(t, tt.expectedResult, result)
@@ -293,9 +331,521 @@ func TestMySQL_Delete(t *testing.T) {
 			repo := MySQL{
 				DB: tt.db,
 			}
-			err := repo.Delete(tt.id)
+			err := repo.Delete(context.Background(), tt.id)
+
+			require.Equal(t, tt.expectedError, err)
+		})
+	}
+}
+
+func TestMySQL_CheckSchemaVersion(t *testing.T) {
+	var tests = []struct {
+		name          string
+		db            *gorm.DB
+		expectedError error
+	}{
+		{
+			name: "Ok - Schema at expected version",
+			db: func() *gorm.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0) FROM `schema_migrations`")).
+					WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(SchemaVersion))
+
+				gormDB, err := gorm.Open(
+					mysql.New(mysql.Config{
+						Conn:                      db,
+						SkipInitializeWithVersion: true}),
+					&gorm.Config{})
+				require.NoError(t, err)
+
+				return gormDB
+			}(),
+		},
+		{
+			name: "Fail - Schema outdated",
+			db: func() *gorm.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0) FROM `schema_migrations`")).
+					WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(SchemaVersion - 1))
+
+				gormDB, err := gorm.Open(
+					mysql.New(mysql.Config{
+						Conn:                      db,
+						SkipInitializeWithVersion: true}),
+					&gorm.Config{})
+				require.NoError(t, err)
+
+				return gormDB
+			}(),
+			expectedError: ErrSchemaOutdated,
+		},
+		{
+			name: "Fail - Internal error",
+			db: func() *gorm.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0) FROM `schema_migrations`")).
+					WillReturnError(errors.New("internal error"))
+
+				gormDB, err := gorm.Open(
+					mysql.New(mysql.Config{
+						Conn:                      db,
+						SkipInitializeWithVersion: true}),
+					&gorm.Config{})
+				require.NoError(t, err)
+
+				return gormDB
+			}(),
+			expectedError: errors.New("internal error"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			repo := MySQL{
+				DB: tt.db,
+			}
+			err := repo.CheckSchemaVersion(context.Background())
 
 			require.Equal(t, tt.expectedError, err)
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestMySQL_ClaimPending(t *testing.T) {
+	payload := `{"id":"a","user_id":1,"occurred_at":"2022-05-01T16:32:04Z"}`
+	now := time.Unix(1651422724, 0)
+
+	var tests = []struct {
+		name             string
+		db               *gorm.DB
+		expectedMessages []OutboxMessage
+		expectedError    error
+	}{
+		{
+			name: "Ok - Messages claimed",
+			db: func() *gorm.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+
+				mock.ExpectBegin()
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `outbox` WHERE delivered_at IS NULL AND parked_at IS NULL AND next_attempt_at <= ? ORDER BY id LIMIT 10 FOR UPDATE SKIP LOCKED")).
+					WithArgs(now.Unix()).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "event_id", "event_name", "payload"}).
+						AddRow(1, "a", EventNameUserDeleted, payload))
+				mock.ExpectExec(regexp.QuoteMeta("UPDATE `outbox` SET `next_attempt_at`=? WHERE id IN (?)")).
+					WithArgs(now.Add(time.Minute).Unix(), 1).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+
+				gormDB, err := gorm.Open(
+					mysql.New(mysql.Config{
+						Conn:                      db,
+						SkipInitializeWithVersion: true}),
+					&gorm.Config{})
+				require.NoError(t, err)
+
+				return gormDB
+			}(),
+			expectedMessages: []OutboxMessage{{ID: 1, EventID: "a", EventName: EventNameUserDeleted, Payload: payload}},
+		},
+		{
+			name: "Ok - Nothing due",
+			db: func() *gorm.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+
+				mock.ExpectBegin()
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `outbox` WHERE delivered_at IS NULL AND parked_at IS NULL AND next_attempt_at <= ? ORDER BY id LIMIT 10 FOR UPDATE SKIP LOCKED")).
+					WithArgs(now.Unix()).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "event_id", "event_name", "payload"}))
+				mock.ExpectCommit()
+
+				gormDB, err := gorm.Open(
+					mysql.New(mysql.Config{
+						Conn:                      db,
+						SkipInitializeWithVersion: true}),
+					&gorm.Config{})
+				require.NoError(t, err)
+
+				return gormDB
+			}(),
+			expectedMessages: []OutboxMessage{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			repo := MySQL{
+				DB: tt.db,
+			}
+			messages, err := repo.ClaimPending(context.Background(), now, 10, time.Minute)
+
+			require.Equal(t, tt.expectedError, err)
+			require.Equal(t, tt.expectedMessages, messages)
+		})
+	}
+}
+
+func TestMySQL_SaveAttempt(t *testing.T) {
+	parkedAt := int64(1651422724)
+
+	var tests = []struct {
+		name          string
+		db            *gorm.DB
+		message       OutboxMessage
+		expectedError error
+	}{
+		{
+			name: "Ok - Failure recorded",
+			db: func() *gorm.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+
+				mock.ExpectBegin()
+				mock.ExpectExec(regexp.QuoteMeta("UPDATE `outbox` SET `attempts`=?,`last_error`=?,`next_attempt_at`=?,`delivered_at`=?,`parked_at`=? WHERE `id` = ?")).
+					WithArgs(3, "sink unavailable", 0, nil, parkedAt, 1).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+
+				gormDB, err := gorm.Open(
+					mysql.New(mysql.Config{
+						Conn:                      db,
+						SkipInitializeWithVersion: true}),
+					&gorm.Config{})
+				require.NoError(t, err)
+
+				return gormDB
+			}(),
+			message: OutboxMessage{ID: 1, Attempts: 3, LastError: "sink unavailable", ParkedAt: &parkedAt},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			repo := MySQL{
+				DB: tt.db,
+			}
+			err := repo.SaveAttempt(context.Background(), tt.message)
+
+			require.Equal(t, tt.expectedError, err)
+		})
+	}
+}
+
+func TestMySQL_ListAuditEntries(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	rows := sqlmock.NewRows([]string{"id", "user_id", "actor", "action", "changes", "request_id", "ip", "created_at"}).
+		AddRow(2, 1, "anonymous", AuditActionDelete, `{"email":{"before":"some@email.com","after":null}}`, "", "", 123457).
+		AddRow(1, 1, "anonymous", AuditActionCreate, `{"email":{"before":null,"after":"some@email.com"}}`, "", "", 123456)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user_audit_log` WHERE user_id = ? ORDER BY id DESC LIMIT 50")).
+		WithArgs(1).
+		WillReturnRows(rows)
+
+	gormDB, err := gorm.Open(
+		mysql.New(mysql.Config{
+			Conn:                      db,
+			SkipInitializeWithVersion: true}),
+		&gorm.Config{})
+	require.NoError(t, err)
+
+	repo := MySQL{
+		DB: gormDB,
+	}
+	entries, err := repo.ListAuditEntries(context.Background(), 1, 50, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, AuditActionDelete, entries[0].Action)
+	require.Equal(t, AuditActionCreate, entries[1].Action)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMySQL_List(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	rows := sqlmock.NewRows([]string{"id", "email", "metadata"}).
+		AddRow(3, "some@email.com", []byte(`{"billing":{"plan":"pro"}}`))
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `users` WHERE id IN (SELECT `user_id` FROM `user_metadata_index` WHERE metadata_key = ? AND metadata_value = ?) ORDER BY id LIMIT 50 OFFSET 50")).
+		WithArgs("billing.plan", "pro").
+		WillReturnRows(rows)
+
+	gormDB, err := gorm.Open(
+		mysql.New(mysql.Config{
+			Conn:                      db,
+			SkipInitializeWithVersion: true}),
+		&gorm.Config{})
+	require.NoError(t, err)
+
+	repo := MySQL{
+		DB: gormDB,
+	}
+	result, err := repo.List(context.Background(), ListFilter{Metadata: map[string]string{"billing.plan": "pro"}}, 50, 50)
+	require.NoError(t, err)
+	require.Equal(t, []User{{ID: 3, Email: "some@email.com", Metadata: Metadata{"billing": {"plan": "pro"}}}}, result)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMySQL_GetByEmail(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `users` WHERE email = ? LIMIT 1")).
+		WithArgs("some@email.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(1, "some@email.com"))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `users` WHERE email = ? LIMIT 1")).
+		WithArgs("other@email.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}))
+
+	gormDB, err := gorm.Open(
+		mysql.New(mysql.Config{
+			Conn:                      db,
+			SkipInitializeWithVersion: true}),
+		&gorm.Config{})
+	require.NoError(t, err)
+
+	repo := MySQL{
+		DB: gormDB,
+	}
+	user, err := repo.GetByEmail(context.Background(), "some@email.com")
+	require.NoError(t, err)
+	require.Equal(t, 1, user.ID)
+
+	_, err = repo.GetByEmail(context.Background(), "other@email.com")
+	require.Equal(t, ErrUserNotFound, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMySQL_SetLoginFailures(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `users` SET `failed_logins`=?,`last_failure_at`=?,`locked_until`=?,`lockouts`=? WHERE id = ?")).
+		WithArgs(0, 0, 0, 0, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	gormDB, err := gorm.Open(
+		mysql.New(mysql.Config{
+			Conn:                      db,
+			SkipInitializeWithVersion: true}),
+		&gorm.Config{})
+	require.NoError(t, err)
+
+	repo := MySQL{
+		DB: gormDB,
+	}
+	err = repo.SetLoginFailures(context.Background(), 1, LoginFailures{})
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMySQL_CreateBatch(t *testing.T) {
+	// Both users are inserted with a single statement, and get consecutive ids from the first one.
+	insertArgs := make([]driver.Value, 40)
+	for i := range insertArgs {
+		insertArgs[i] = sqlmock.AnyArg()
+	}
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO `users`").
+		WithArgs(insertArgs...).
+		WillReturnResult(sqlmock.NewResult(10, 2))
+	mock.ExpectCommit()
+
+	gormDB, err := gorm.Open(
+		mysql.New(mysql.Config{
+			Conn:                      db,
+			SkipInitializeWithVersion: true}),
+		&gorm.Config{})
+	require.NoError(t, err)
+
+	repo := MySQL{
+		DB: gormDB,
+	}
+	users, err := repo.CreateBatch(context.Background(), []User{
+		{Email: "some@email.com", Status: StatusActive},
+		{Email: "other@email.com", Status: StatusActive},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 10, users[0].ID)
+	require.Equal(t, 11, users[1].ID)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMySQL_Export(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	rows := sqlmock.NewRows([]string{"id", "email", "metadata"}).
+		AddRow(3, "some@email.com", []byte(`{"billing":{"plan":"pro"}}`)).
+		AddRow(5, "other@email.com", nil)
+
+	// The password column, between email and first_name, is not selected.
+	mock.ExpectQuery("SELECT `users`.`id`,`users`.`email`,`users`.`first_name`,.* FROM `users` WHERE id IN \\(SELECT `user_id` FROM `user_metadata_index` WHERE metadata_key = \\? AND metadata_value = \\?\\) ORDER BY id").
+		WithArgs("billing.plan", "pro").
+		WillReturnRows(rows)
+
+	gormDB, err := gorm.Open(
+		mysql.New(mysql.Config{
+			Conn:                      db,
+			SkipInitializeWithVersion: true}),
+		&gorm.Config{})
+	require.NoError(t, err)
+
+	repo := MySQL{
+		DB: gormDB,
+	}
+	result := []User{}
+	err = repo.Export(context.Background(), ListFilter{Metadata: map[string]string{"billing.plan": "pro"}}, func(user User) error {
+		result = append(result, user)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []User{
+		{ID: 3, Email: "some@email.com", Metadata: Metadata{"billing": {"plan": "pro"}}},
+		{ID: 5, Email: "other@email.com"},
+	}, result)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMySQL_Search(t *testing.T) {
+	var tests = []struct {
+		name          string
+		terms         []string
+		expectedQuery string
+		expectedArgs  []driver.Value
+	}{
+		{
+			name:          "Ok - FULLTEXT",
+			terms:         []string{"john", "example"},
+			expectedQuery: "SELECT * FROM `users` WHERE MATCH (email, first_name, last_name, display_name) AGAINST (? IN BOOLEAN MODE) ORDER BY MATCH (email, first_name, last_name, display_name) AGAINST (? IN BOOLEAN MODE) DESC, id LIMIT 20 OFFSET 40",
+			expectedArgs:  []driver.Value{"+john* +example*", "+john* +example*"},
+		},
+		{
+			name:          "Ok - Full email, LIKE for stopwords",
+			terms:         []string{"john", "example", "com"},
+			expectedQuery: "SELECT * FROM `users` WHERE MATCH (email, first_name, last_name, display_name) AGAINST (? IN BOOLEAN MODE) AND (email LIKE ? OR first_name LIKE ? OR last_name LIKE ? OR display_name LIKE ?) ORDER BY MATCH (email, first_name, last_name, display_name) AGAINST (? IN BOOLEAN MODE) DESC, id LIMIT 20 OFFSET 40",
+			expectedArgs:  []driver.Value{"+john* +example*", "%com%", "%com%", "%com%", "%com%", "+john* +example*"},
+		},
+		{
+			name:          "Ok - LIKE for short terms",
+			terms:         []string{"jo", "example"},
+			expectedQuery: "SELECT * FROM `users` WHERE MATCH (email, first_name, last_name, display_name) AGAINST (? IN BOOLEAN MODE) AND (email LIKE ? OR first_name LIKE ? OR last_name LIKE ? OR display_name LIKE ?) ORDER BY MATCH (email, first_name, last_name, display_name) AGAINST (? IN BOOLEAN MODE) DESC, id LIMIT 20 OFFSET 40",
+			expectedArgs:  []driver.Value{"+example*", "%jo%", "%jo%", "%jo%", "%jo%", "+example*"},
+		},
+		{
+			name:          "Ok - LIKE without indexed terms",
+			terms:         []string{"jo", "com"},
+			expectedQuery: "SELECT * FROM `users` WHERE (email LIKE ? OR first_name LIKE ? OR last_name LIKE ? OR display_name LIKE ?) AND (email LIKE ? OR first_name LIKE ? OR last_name LIKE ? OR display_name LIKE ?) ORDER BY CASE WHEN email LIKE ? THEN 0 WHEN first_name LIKE ? OR last_name LIKE ? OR display_name LIKE ? THEN 1 ELSE 2 END, id LIMIT 20 OFFSET 40",
+			expectedArgs:  []driver.Value{"%jo%", "%jo%", "%jo%", "%jo%", "%com%", "%com%", "%com%", "%com%", "jo%", "jo%", "jo%", "jo%"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			mock.ExpectQuery(regexp.QuoteMeta(tt.expectedQuery)).
+				WithArgs(tt.expectedArgs...).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "email", "first_name"}).AddRow(3, "john@example.com", "John"))
+
+			gormDB, err := gorm.Open(
+				mysql.New(mysql.Config{
+					Conn:                      db,
+					SkipInitializeWithVersion: true}),
+				&gorm.Config{})
+			require.NoError(t, err)
+
+			repo := MySQL{
+				DB: gormDB,
+			}
+			result, err := repo.Search(context.Background(), tt.terms, 20, 40)
+			require.NoError(t, err)
+			require.Equal(t, []User{{ID: 3, Email: "john@example.com", FirstName: "John"}}, result)
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestMySQL_Update(t *testing.T) {
+	update := regexp.QuoteMeta("UPDATE `users` SET `id`=?,`first_name`=?,`updated_at`=?,`version`=? WHERE version = ? AND `id` = ?")
+	count := regexp.QuoteMeta("SELECT count(*) FROM `users` WHERE id = ?")
+
+	var tests = []struct {
+		name          string
+		mock          func(mock sqlmock.Sqlmock)
+		expectedError error
+	}{
+		{
+			name: "Ok - Update",
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(update).
+					WithArgs(1, "Some", sqlmock.AnyArg(), 4, 3, 1).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "Fail - Updated concurrently",
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(update).
+					WithArgs(1, "Some", sqlmock.AnyArg(), 4, 3, 1).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectCommit()
+				mock.ExpectQuery(count).
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"count(*)"}).AddRow(1))
+			},
+			expectedError: ErrConcurrentUpdate,
+		},
+		{
+			name: "Fail - User not found",
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(update).
+					WithArgs(1, "Some", sqlmock.AnyArg(), 4, 3, 1).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectCommit()
+				mock.ExpectQuery(count).
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"count(*)"}).AddRow(0))
+			},
+			expectedError: ErrUserNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			tt.mock(mock)
+
+			gormDB, err := gorm.Open(
+				mysql.New(mysql.Config{
+					Conn:                      db,
+					SkipInitializeWithVersion: true}),
+				&gorm.Config{})
+			require.NoError(t, err)
+
+			repo := MySQL{
+				DB: gormDB,
+			}
+			_, err = repo.Update(context.Background(), User{ID: 1, FirstName: "Some", Version: 3})
+			require.Equal(t, tt.expectedError, err)
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// OutboxMessage is an event stored in the same transaction as the user mutation that emitted it,
+// waiting to be published by the OutboxRelay. A message is pending until it is either delivered or
+// parked after failing every attempt.
+type OutboxMessage struct {
+	ID            int64  `gorm:"column:id;primaryKey"`
+	EventID       string `gorm:"column:event_id;size:32;uniqueIndex"`
+	EventName     string `gorm:"column:event_name;size:64"`
+	Payload       string `gorm:"column:payload;type:json"`
+	Attempts      int    `gorm:"column:attempts"`
+	LastError     string `gorm:"column:last_error;type:text"`
+	NextAttemptAt int64  `gorm:"column:next_attempt_at;index"`
+	CreatedAt     int64  `gorm:"column:created_at"`
+	DeliveredAt   *int64 `gorm:"column:delivered_at;index"`
+	// ParkedAt is the dead-letter mark of a message that failed RelayPolicy.MaxAttempts times.
+	ParkedAt *int64 `gorm:"column:parked_at"`
+}
+
+func (OutboxMessage) TableName() string {
+	return "outbox"
+}
+
+// Outbox gives access to the pending outbox messages.
+type Outbox interface {
+	// ClaimPending returns up to limit pending messages due at now, oldest first, and hides them
+	// from other relays until now+lease, so they are delivered by a single relay at a time.
+	ClaimPending(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]OutboxMessage, error)
+	// SaveAttempt records the outcome of delivering a claimed message.
+	SaveAttempt(ctx context.Context, message OutboxMessage) error
+}
+
+// RelayPolicy configures how the OutboxRelay retries failed messages. The n-th retry waits
+// InitialBackoff * 2^(n-1), capped at MaxBackoff. A message that failed MaxAttempts times is
+// parked. Lease bounds how long a claimed message stays hidden from other relays, so it must
+// outlast the delivery of a whole batch.
+type RelayPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Lease          time.Duration
+}
+
+// Backoff returns the wait before the attempt following the attempts-th failed one.
+func (p RelayPolicy) Backoff(attempts int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+
+	return backoff
+}
+
+// OutboxRelay polls the outbox and publishes its messages to a sink. Messages are marked delivered
+// only after the sink accepts them, so delivery is at-least-once. A failed message is retried
+// after a backoff without holding back the messages behind it, so events keep their order only
+// while deliveries succeed.
+type OutboxRelay struct {
+	outbox    Outbox
+	sink      Publisher
+	policy    RelayPolicy
+	interval  time.Duration
+	batchSize int
+}
+
+func NewOutboxRelay(outbox Outbox, sink Publisher, policy RelayPolicy, interval time.Duration, batchSize int) OutboxRelay {
+	return OutboxRelay{
+		outbox:    outbox,
+		sink:      sink,
+		policy:    policy,
+		interval:  interval,
+		batchSize: batchSize,
+	}
+}
+
+// Run relays pending messages every interval until ctx is done.
+func (r OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := r.RelayPending(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("error relaying outbox messages: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayPending publishes one batch of pending messages and returns how many were delivered. The
+// batch is claimed first, so no database lock is held while the sink is called.
+func (r OutboxRelay) RelayPending(ctx context.Context) (int, error) {
+	messages, err := r.outbox.ClaimPending(ctx, time.Now(), r.batchSize, r.policy.Lease)
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, message := range messages {
+		message = r.attempt(ctx, message)
+		err = r.outbox.SaveAttempt(ctx, message)
+		if err != nil {
+			return delivered, err
+		}
+		if message.DeliveredAt != nil {
+			delivered++
+		}
+	}
+
+	return delivered, nil
+}
+
+// attempt publishes message and returns it updated with the outcome.
+func (r OutboxRelay) attempt(ctx context.Context, message OutboxMessage) OutboxMessage {
+	now := time.Now()
+	message.Attempts++
+
+	err := r.publish(ctx, message)
+	if err == nil {
+		deliveredAt := now.Unix()
+		message.DeliveredAt = &deliveredAt
+		message.LastError = ""
+		return message
+	}
+
+	message.LastError = err.Error()
+	if message.Attempts >= r.policy.MaxAttempts {
+		parkedAt := now.Unix()
+		message.ParkedAt = &parkedAt
+		log.Printf("outbox message %s parked after %d attempts: %v", message.EventID, message.Attempts, err)
+		return message
+	}
+	message.NextAttemptAt = now.Add(r.policy.Backoff(message.Attempts)).Unix()
+
+	return message
+}
+
+func (r OutboxRelay) publish(ctx context.Context, message OutboxMessage) error {
+	event, err := DecodeEvent(message.EventName, []byte(message.Payload))
+	if err != nil {
+		return err
+	}
+
+	return r.sink.Publish(ctx, event)
+}
+
+// newOutboxMessage serializes event to be stored in the outbox.
+func newOutboxMessage(event Event) (OutboxMessage, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return OutboxMessage{}, err
+	}
+
+	now := time.Now().Unix()
+	return OutboxMessage{
+		EventID:       event.Metadata().ID,
+		EventName:     event.EventName(),
+		Payload:       string(payload),
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}, nil
+}
@@ -0,0 +1,199 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// OutboxMock holds messages in memory, claiming them like the MySQL outbox does.
+type OutboxMock struct {
+	messages  []OutboxMessage
+	delivered []int64
+	parked    []int64
+}
+
+func (o *OutboxMock) ClaimPending(_ context.Context, now time.Time, limit int, lease time.Duration) ([]OutboxMessage, error) {
+	claimed := []OutboxMessage{}
+	for i, message := range o.messages {
+		if len(claimed) == limit {
+			break
+		}
+		if message.DeliveredAt != nil || message.ParkedAt != nil || message.NextAttemptAt > now.Unix() {
+			continue
+		}
+		claimed = append(claimed, message)
+		o.messages[i].NextAttemptAt = now.Add(lease).Unix()
+	}
+	return claimed, nil
+}
+
+func (o *OutboxMock) SaveAttempt(_ context.Context, message OutboxMessage) error {
+	for i := range o.messages {
+		if o.messages[i].ID == message.ID {
+			o.messages[i] = message
+		}
+	}
+	if message.DeliveredAt != nil {
+		o.delivered = append(o.delivered, message.ID)
+	}
+	if message.ParkedAt != nil {
+		o.parked = append(o.parked, message.ID)
+	}
+	return nil
+}
+
+// SinkMock records published events and fails those of the users in failFor.
+type SinkMock struct {
+	events  []Event
+	failFor map[int]bool
+}
+
+func (s *SinkMock) Publish(_ context.Context, event Event) error {
+	if s.failFor[event.Metadata().UserID] {
+		return errors.New("sink unavailable")
+	}
+	s.events = append(s.events, event)
+	return nil
+}
+
+func TestOutboxRelay_RelayPending(t *testing.T) {
+	occurredAt := time.Date(2022, 5, 1, 16, 32, 4, 0, time.UTC)
+	created := UserCreated{EventMetadata: EventMetadata{ID: "a", UserID: 1, OccurredAt: occurredAt}, Email: "some@email.com"}
+	updated := UserUpdated{EventMetadata: EventMetadata{ID: "b", UserID: 2, OccurredAt: occurredAt}, ChangedFields: []string{"email"}}
+	deleted := UserDeleted{EventMetadata: EventMetadata{ID: "c", UserID: 3, OccurredAt: occurredAt}}
+
+	messages := func(attempts int) []OutboxMessage {
+		result := []OutboxMessage{}
+		for i, event := range []Event{created, updated, deleted} {
+			message, err := newOutboxMessage(event)
+			require.NoError(t, err)
+			message.ID = int64(i + 1)
+			message.Attempts = attempts
+			result = append(result, message)
+		}
+		return result
+	}
+	policy := RelayPolicy{MaxAttempts: 3, InitialBackoff: time.Minute, MaxBackoff: time.Hour, Lease: time.Minute}
+
+	var tests = []struct {
+		name              string
+		batchSize         int
+		attempts          int
+		sink              *SinkMock
+		expectedEvents    []Event
+		expectedDelivered []int64
+		expectedParked    []int64
+	}{
+		{
+			name:              "Ok - Every message delivered in order",
+			batchSize:         10,
+			sink:              &SinkMock{},
+			expectedEvents:    []Event{created, updated, deleted},
+			expectedDelivered: []int64{1, 2, 3},
+		},
+		{
+			name:              "Ok - Batch size respected",
+			batchSize:         2,
+			sink:              &SinkMock{},
+			expectedEvents:    []Event{created, updated},
+			expectedDelivered: []int64{1, 2},
+		},
+		{
+			name:              "Fail - Failed message doesn't hold back the others",
+			batchSize:         10,
+			sink:              &SinkMock{failFor: map[int]bool{2: true}},
+			expectedEvents:    []Event{created, deleted},
+			expectedDelivered: []int64{1, 3},
+		},
+		{
+			name:              "Fail - Message parked at max attempts",
+			batchSize:         10,
+			attempts:          2,
+			sink:              &SinkMock{failFor: map[int]bool{2: true}},
+			expectedEvents:    []Event{created, deleted},
+			expectedDelivered: []int64{1, 3},
+			expectedParked:    []int64{2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outbox := &OutboxMock{messages: messages(tt.attempts)}
+			relay := NewOutboxRelay(outbox, tt.sink, policy, time.Second, tt.batchSize)
+
+			delivered, err := relay.RelayPending(context.Background())
+			require.NoError(t, err)
+			require.Equal(t, len(tt.expectedDelivered), delivered)
+			require.Equal(t, tt.expectedEvents, tt.sink.events)
+			require.Equal(t, tt.expectedDelivered, outbox.delivered)
+			require.Equal(t, tt.expectedParked, outbox.parked)
+		})
+	}
+}
+
+func TestRelayPolicy_Backoff(t *testing.T) {
+	policy := RelayPolicy{InitialBackoff: 5 * time.Second, MaxBackoff: time.Minute}
+
+	var tests = []struct {
+		name            string
+		attempts        int
+		expectedBackoff time.Duration
+	}{
+		{
+			name:            "Ok - First retry",
+			attempts:        1,
+			expectedBackoff: 5 * time.Second,
+		},
+		{
+			name:            "Ok - Doubled per attempt",
+			attempts:        3,
+			expectedBackoff: 20 * time.Second,
+		},
+		{
+			name:            "Ok - Capped",
+			attempts:        10,
+			expectedBackoff: time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expectedBackoff, policy.Backoff(tt.attempts))
+		})
+	}
+}
+
+func TestDecodeEvent(t *testing.T) {
+	var tests = []struct {
+		name          string
+		eventName     string
+		payload       string
+		expectedEvent Event
+		expectedError error
+	}{
+		{
+			name:          "Ok - Password changed",
+			eventName:     EventNamePasswordChanged,
+			payload:       `{"id":"a","user_id":1,"occurred_at":"2022-05-01T16:32:04Z"}`,
+			expectedEvent: PasswordChanged{EventMetadata: EventMetadata{ID: "a", UserID: 1, OccurredAt: time.Date(2022, 5, 1, 16, 32, 4, 0, time.UTC)}},
+		},
+		{
+			name:          "Fail - Unknown event",
+			eventName:     "user.unknown",
+			payload:       `{}`,
+			expectedError: ErrUnknownEvent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := DecodeEvent(tt.eventName, []byte(tt.payload))
+			require.Equal(t, tt.expectedError, err)
+			require.Equal(t, tt.expectedEvent, event)
+		})
+	}
+}
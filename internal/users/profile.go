@@ -0,0 +1,108 @@
+package users
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	// Embedded so timezones validate on hosts without a zoneinfo database.
+	_ "time/tzdata"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/language"
+)
+
+var (
+	// ErrInvalidName name too long or with control characters error
+	ErrInvalidName = errors.New("invalid name. names must be at most 100 characters without control characters")
+	// ErrInvalidLocale locale not a BCP 47 language tag error
+	ErrInvalidLocale = errors.New("invalid locale. locale must be a BCP 47 language tag, e.g. en-US")
+	// ErrInvalidTimezone timezone not in the IANA database error
+	ErrInvalidTimezone = errors.New("invalid timezone. timezone must be an IANA timezone name, e.g. America/Argentina/Buenos_Aires")
+	// ErrInvalidPhone phone not in E.164 format error
+	ErrInvalidPhone = errors.New("invalid phone. phone must be in E.164 format, e.g. +5491123456789")
+	// ErrInvalidAvatarURL avatar URL not an absolute https URL error
+	ErrInvalidAvatarURL = errors.New("invalid avatar url. avatar url must be an absolute https url")
+)
+
+const (
+	_maxNameLength      = 100
+	_maxAvatarURLLength = 2048
+)
+
+var _e164 = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+// _validationErrors are the rejections of invalid user fields or list filters.
+var _validationErrors = []error{
+	ErrInvalidName, ErrInvalidLocale, ErrInvalidTimezone, ErrInvalidPhone, ErrInvalidAvatarURL,
+	ErrInvalidMetadata, ErrUnknownMetadataNamespace, ErrMetadataKeyNotIndexed,
+	ErrInvalidStatus, ErrInvalidStatusReason,
+	ErrInvalidEmail, ErrInvalidPassword, ErrInvalidPasswordHash, ErrInvalidImportRow,
+	ErrInvalidBatchMethod, ErrInvalidSearchQuery,
+}
+
+// IsValidationError reports whether err is the rejection of an invalid user field or list filter.
+func IsValidationError(err error) bool {
+	for _, validationErr := range _validationErrors {
+		if errors.Is(err, validationErr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizeProfile validates the profile fields of user and returns it with them in canonical
+// form. Empty fields are not validated, since updates leave them unchanged.
+func normalizeProfile(user User) (User, error) {
+	for _, name := range []*string{&user.FirstName, &user.LastName, &user.DisplayName} {
+		*name = strings.TrimSpace(*name)
+		if !validName(*name) {
+			return User{}, ErrInvalidName
+		}
+	}
+
+	if user.Locale != "" {
+		tag, err := language.Parse(user.Locale)
+		if err != nil {
+			return User{}, ErrInvalidLocale
+		}
+		user.Locale = tag.String()
+	}
+
+	// LoadLocation also accepts "Local", the host timezone, which is not an IANA name.
+	if user.Timezone != "" {
+		_, err := time.LoadLocation(user.Timezone)
+		if err != nil || user.Timezone == "Local" {
+			return User{}, ErrInvalidTimezone
+		}
+	}
+
+	if user.Phone != "" && !_e164.MatchString(user.Phone) {
+		return User{}, ErrInvalidPhone
+	}
+
+	if user.AvatarURL != "" {
+		u, err := url.Parse(user.AvatarURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" || len(user.AvatarURL) > _maxAvatarURLLength {
+			return User{}, ErrInvalidAvatarURL
+		}
+	}
+
+	return user, nil
+}
+
+func validName(name string) bool {
+	if utf8.RuneCountInString(name) > _maxNameLength {
+		return false
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return false
+		}
+	}
+
+	return true
+}
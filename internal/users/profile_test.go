@@ -0,0 +1,92 @@
+package users
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeProfile(t *testing.T) {
+	var tests = []struct {
+		name           string
+		user           User
+		expectedResult User
+		expectedError  error
+	}{
+		{
+			name: "Ok - Full profile",
+			user: User{
+				FirstName:   "  Ada ",
+				LastName:    "Lovelace",
+				DisplayName: "Ada L.",
+				Locale:      "es-419",
+				Timezone:    "America/Argentina/Buenos_Aires",
+				Phone:       "+5491123456789",
+				AvatarURL:   "https://cdn.example.com/avatars/7.png",
+			},
+			expectedResult: User{
+				FirstName:   "Ada",
+				LastName:    "Lovelace",
+				DisplayName: "Ada L.",
+				Locale:      "es-419",
+				Timezone:    "America/Argentina/Buenos_Aires",
+				Phone:       "+5491123456789",
+				AvatarURL:   "https://cdn.example.com/avatars/7.png",
+			},
+		},
+		{
+			name:           "Ok - Locale canonicalized",
+			user:           User{Locale: "EN_us"},
+			expectedResult: User{Locale: "en-US"},
+		},
+		{
+			name:           "Ok - Empty profile",
+			user:           User{Email: "some@email.com"},
+			expectedResult: User{Email: "some@email.com"},
+		},
+		{
+			name:          "Fail - Name too long",
+			user:          User{LastName: strings.Repeat("a", 101)},
+			expectedError: ErrInvalidName,
+		},
+		{
+			name:          "Fail - Name with control characters",
+			user:          User{DisplayName: "Ada\nLovelace"},
+			expectedError: ErrInvalidName,
+		},
+		{
+			name:          "Fail - Invalid locale",
+			user:          User{Locale: "not a locale"},
+			expectedError: ErrInvalidLocale,
+		},
+		{
+			name:          "Fail - Unknown timezone",
+			user:          User{Timezone: "Mars/Olympus_Mons"},
+			expectedError: ErrInvalidTimezone,
+		},
+		{
+			name:          "Fail - Host timezone",
+			user:          User{Timezone: "Local"},
+			expectedError: ErrInvalidTimezone,
+		},
+		{
+			name:          "Fail - Phone not in E.164",
+			user:          User{Phone: "011 2345-6789"},
+			expectedError: ErrInvalidPhone,
+		},
+		{
+			name:          "Fail - Avatar URL not https",
+			user:          User{AvatarURL: "http://cdn.example.com/avatars/7.png"},
+			expectedError: ErrInvalidAvatarURL,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := normalizeProfile(tt.user)
+			require.Equal(t, tt.expectedError, err)
+			require.Equal(t, tt.expectedResult, result)
+		})
+	}
+}
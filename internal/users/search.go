@@ -0,0 +1,58 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// _maxSearchQueryLength bounds search queries, which are matched against every user.
+	_maxSearchQueryLength = 100
+	// _maxSearchTerms bounds the terms of a search query.
+	_maxSearchTerms = 5
+)
+
+var (
+	// ErrInvalidSearchQuery search query without letters or digits, or too long, error
+	ErrInvalidSearchQuery = errors.New("invalid search query. q must have letters or digits and be at most 100 characters")
+)
+
+// Search returns the users whose email or names match every term of query, most relevant first.
+// Terms are the runs of letters and digits of query, so "john@exam" finds "john@example.com".
+func (s Service) Search(ctx context.Context, query string, limit int, offset int) (_ []User, err error) {
+	ctx, span := tracer.Start(ctx, "Service.Search")
+	defer func() {
+		observeOperation(_operationSearch, _outcomeFound, err)
+		endSpan(span, err)
+	}()
+
+	terms := searchTerms(query)
+	if len(terms) == 0 || utf8.RuneCountInString(query) > _maxSearchQueryLength {
+		return nil, ErrInvalidSearchQuery
+	}
+
+	return s.repository.Search(ctx, terms, limit, offset)
+}
+
+// searchTerms returns the lowercased runs of letters and digits of query, without duplicates and
+// up to _maxSearchTerms. They have no operators or wildcards of the repository queries.
+func searchTerms(query string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	seen := make(map[string]bool, len(fields))
+	terms := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if seen[field] || len(terms) == _maxSearchTerms {
+			continue
+		}
+		seen[field] = true
+		terms = append(terms, field)
+	}
+
+	return terms
+}
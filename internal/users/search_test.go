@@ -0,0 +1,73 @@
+package users
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_Search(t *testing.T) {
+	found := []User{{ID: 1, Email: "john@example.com", FirstName: "John"}}
+
+	var tests = []struct {
+		name           string
+		repo           *RepositoryMock
+		query          string
+		expectedResult []User
+		expectedErr    error
+	}{
+		{
+			name: "Ok - Terms of the query",
+			repo: func() *RepositoryMock {
+				m := RepositoryMock{}
+				m.On("Search", []string{"john", "exam"}, 20, 40).Return(found, nil)
+				return &m
+			}(),
+			query:          " John@Exam ",
+			expectedResult: found,
+		},
+		{
+			name: "Ok - Full email",
+			repo: func() *RepositoryMock {
+				m := RepositoryMock{}
+				m.On("Search", []string{"john", "example", "com"}, 20, 40).Return(found, nil)
+				return &m
+			}(),
+			query:          "john@example.com",
+			expectedResult: found,
+		},
+		{
+			name: "Ok - Duplicated terms dropped, up to 5",
+			repo: func() *RepositoryMock {
+				m := RepositoryMock{}
+				m.On("Search", []string{"a", "b", "c", "d", "e"}, 20, 40).Return(found, nil)
+				return &m
+			}(),
+			query:          "a a b c d e f",
+			expectedResult: found,
+		},
+		{
+			name:        "Fail - Without letters or digits",
+			repo:        &RepositoryMock{},
+			query:       "%_*",
+			expectedErr: ErrInvalidSearchQuery,
+		},
+		{
+			name:        "Fail - Too long",
+			repo:        &RepositoryMock{},
+			query:       strings.Repeat("a", 101),
+			expectedErr: ErrInvalidSearchQuery,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewService(tt.repo)
+			result, err := service.Search(context.Background(), tt.query, 20, 40)
+			require.Equal(t, tt.expectedErr, err)
+			require.Equal(t, tt.expectedResult, result)
+		})
+	}
+}
@@ -1,35 +1,182 @@
 package users
 
 import (
+	"context"
+	"time"
+
+	"github.com/marcosstupnicki/go-users/internal/platform/requestmeta"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type Repository interface {
-	Create(user User) (User, error)
-	Get(id int) (User, error)
-	Update(user User) (User, error)
-	Delete(id int) error
+	Create(ctx context.Context, user User) (User, error)
+	// CreateBatch creates users with a single statement, returning them with their ids.
+	CreateBatch(ctx context.Context, users []User) ([]User, error)
+	Get(ctx context.Context, id int) (User, error)
+	GetByEmail(ctx context.Context, email string) (User, error)
+	Update(ctx context.Context, user User) (User, error)
+	Delete(ctx context.Context, id int) error
+	// List returns the users matching filter, ordered by id.
+	List(ctx context.Context, filter ListFilter, limit int, offset int) ([]User, error)
+	// Export calls fn with each user matching filter, ordered by id, without their password. It
+	// stops at the first error of fn, and returns it.
+	Export(ctx context.Context, filter ListFilter, fn func(user User) error) error
+	// Search returns the users whose email or names have words starting with every term, most
+	// relevant first.
+	Search(ctx context.Context, terms []string, limit int, offset int) ([]User, error)
+	// SetMetadataIndex replaces the metadata index entries of the user with id userID.
+	SetMetadataIndex(ctx context.Context, userID int, entries []MetadataIndexEntry) error
+	// SaveEvents stores events in the outbox, to be published by the OutboxRelay.
+	SaveEvents(ctx context.Context, events ...Event) error
+	// SaveAuditEntry appends entry to the audit log.
+	SaveAuditEntry(ctx context.Context, entry AuditEntry) error
+	// SetLoginFailures replaces the login failures of the user with id userID.
+	SetLoginFailures(ctx context.Context, userID int, failures LoginFailures) error
+	// GetIPLoginFailures returns the login failures from ip, zero if there are none.
+	GetIPLoginFailures(ctx context.Context, ip string) (LoginFailures, error)
+	SetIPLoginFailures(ctx context.Context, ip string, failures LoginFailures) error
+	// ListAuditEntries returns the audit log of the user with id userID, newest first.
+	ListAuditEntries(ctx context.Context, userID int, limit int, offset int) ([]AuditEntry, error)
+	// Transaction calls fn with a Repository whose operations run in a single transaction, which
+	// is committed if fn returns nil and rolled back otherwise.
+	Transaction(ctx context.Context, fn func(repository Repository) error) error
+}
+
+// ListFilter selects the users returned by List.
+type ListFilter struct {
+	// Metadata maps indexed "<namespace>.<key>" metadata keys to the value users must have.
+	Metadata map[string]string
+}
+
+// SessionRevoker revokes the login sessions of a user. sessions.Service implements it.
+type SessionRevoker interface {
+	RevokeAll(ctx context.Context, userID int) error
 }
 
 type Service struct {
-	repository Repository
+	repository    Repository
+	metadata      MetadataSchemas
+	initialStatus string
+	lockout       LockoutPolicy
+	sessions      SessionRevoker
+}
+
+// Option configures optional Service dependencies.
+type Option func(*Service)
+
+// WithMetadataSchemas sets the schemas user metadata is validated against. Without it, users
+// can't have metadata.
+func WithMetadataSchemas(schemas MetadataSchemas) Option {
+	return func(s *Service) {
+		s.metadata = schemas
+	}
+}
+
+// WithInitialStatus sets the status of created users, StatusPending or StatusActive. Without it,
+// created users are active.
+func WithInitialStatus(status string) Option {
+	return func(s *Service) {
+		s.initialStatus = status
+	}
+}
+
+// WithLockoutPolicy enables brute-force protection of Authenticate. Without it, failed logins are
+// not tracked.
+func WithLockoutPolicy(policy LockoutPolicy) Option {
+	return func(s *Service) {
+		s.lockout = policy
+	}
 }
 
-func NewService(repository Repository) Service {
-	return Service{
-		repository: repository,
+// WithSessionRevoker makes SetStatus revoke every session of a user leaving the active status
+// before returning, so its access tokens are rejected right away rather than once the status
+// change event is relayed.
+func WithSessionRevoker(revoker SessionRevoker) Option {
+	return func(s *Service) {
+		s.sessions = revoker
 	}
 }
 
-func (s Service) Create(user User) (User, error) {
+func NewService(repository Repository, opts ...Option) Service {
+	s := Service{
+		repository:    repository,
+		initialStatus: StatusActive,
+	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	return s
+}
+
+func (s Service) Create(ctx context.Context, user User) (_ User, err error) {
+	ctx, span := tracer.Start(ctx, "Service.Create")
+	defer func() {
+		observeOperation(_operationCreate, _outcomeCreated, err)
+		endSpan(span, err)
+	}()
+
+	user, err = s.prepareCreate(ctx, user)
+	if err != nil {
+		return User{}, err
+	}
+
+	return s.create(ctx, user)
+}
+
+// prepareCreate validates user and hashes its password, the slow part of creating it, which
+// atomic batches run before opening their transaction.
+func (s Service) prepareCreate(ctx context.Context, user User) (User, error) {
+	user, err := normalizeProfile(user)
+	if err != nil {
+		return User{}, err
+	}
+
+	err = s.metadata.Validate(user.Metadata)
+	if err != nil {
+		return User{}, err
+	}
+
 	// Generate and set the new user password.
-	hash, err := generatePassword(user.Password)
+	hash, err := generatePassword(ctx, user.Password)
 	if err != nil {
 		return User{}, err
 	}
 	user.Password = hash
+	user.Status = s.initialStatus
+	user.StatusReason = ""
+
+	return user, nil
+}
 
-	user, err = s.repository.Create(user)
+// create stores user, prepared by prepareCreate.
+func (s Service) create(ctx context.Context, user User) (User, error) {
+	// The user, its event and its audit entry are stored together, so none can be lost.
+	err := s.repository.Transaction(ctx, func(repository Repository) error {
+		var err error
+		user, err = repository.Create(ctx, user)
+		if err != nil {
+			return err
+		}
+
+		if len(user.Metadata) > 0 {
+			err = repository.SetMetadataIndex(ctx, user.ID, s.metadata.indexEntries(user.ID, user.Metadata))
+			if err != nil {
+				return err
+			}
+		}
+
+		err = audit(ctx, repository, user.ID, AuditActionCreate, nil, &user)
+		if err != nil {
+			return err
+		}
+
+		return repository.SaveEvents(ctx, UserCreated{
+			EventMetadata: newEventMetadata(user.ID, time.Now()),
+			Email:         user.Email,
+		})
+	})
 	if err != nil {
 		return User{}, err
 	}
@@ -37,8 +184,15 @@ func (s Service) Create(user User) (User, error) {
 	return user, nil
 }
 
-func (s Service) Get(id int) (User, error) {
-	user, err := s.repository.Get(id)
+func (s Service) Get(ctx context.Context, id int) (_ User, err error) {
+	ctx, span := tracer.Start(ctx, "Service.Get")
+	span.SetAttributes(attribute.Int("user.id", id))
+	defer func() {
+		observeOperation(_operationGet, _outcomeFound, err)
+		endSpan(span, err)
+	}()
+
+	user, err := s.repository.Get(ctx, id)
 	if err != nil {
 		if err == ErrUserNotFound {
 			return User{}, ErrUserNotFound
@@ -49,19 +203,98 @@ func (s Service) Get(id int) (User, error) {
 	return user, nil
 }
 
-func (s Service) Update(id int, user User) (User, error) {
+func (s Service) Update(ctx context.Context, id int, user User) (_ User, err error) {
+	ctx, span := tracer.Start(ctx, "Service.Update")
+	span.SetAttributes(attribute.Int("user.id", id))
+	defer func() {
+		observeOperation(_operationUpdate, _outcomeUpdated, err)
+		endSpan(span, err)
+	}()
+
+	user, err = s.prepareUpdate(ctx, user)
+	if err != nil {
+		return User{}, err
+	}
+
+	return s.update(ctx, id, user)
+}
+
+// prepareUpdate validates the fields set on user and hashes its password, if set, the slow part of
+// updating a user, which atomic batches run before opening their transaction.
+func (s Service) prepareUpdate(ctx context.Context, user User) (User, error) {
+	user, err := normalizeProfile(user)
+	if err != nil {
+		return User{}, err
+	}
+
+	err = s.metadata.Validate(user.Metadata)
+	if err != nil {
+		return User{}, err
+	}
+
 	// If needed, generate and set the new user password.
 	if user.Password != "" {
-		hash, err := generatePassword(user.Password)
+		hash, err := generatePassword(ctx, user.Password)
 		if err != nil {
 			return User{}, err
 		}
 		user.Password = hash
 	}
 
+	return user, nil
+}
+
+// update stores the fields set on user, prepared by prepareUpdate, on the user with id.
+func (s Service) update(ctx context.Context, id int, user User) (User, error) {
+	passwordChanged := user.Password != ""
 	user.ID = id
 
-	user, err := s.repository.Update(user)
+	var updated User
+	err := s.updateTransaction(ctx, func(repository Repository) error {
+		before, err := repository.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		update := user
+		update.Version = before.Version
+		// Metadata namespaces are updated one by one, so the stored ones not in user are kept.
+		if update.Metadata != nil {
+			update.Metadata = before.Metadata.merge(update.Metadata)
+		}
+		changed := changedFields(before, update)
+		after := applyUpdate(before, update)
+
+		stored, err := repository.Update(ctx, update)
+		if err != nil {
+			return err
+		}
+		// Update only stores the fields set on update, return the whole updated user.
+		updated = applyUpdate(before, stored)
+
+		if stored.Metadata != nil {
+			err = repository.SetMetadataIndex(ctx, id, s.metadata.indexEntries(id, updated.Metadata))
+			if err != nil {
+				return err
+			}
+		}
+
+		err = audit(ctx, repository, id, AuditActionUpdate, &before, &after)
+		if err != nil {
+			return err
+		}
+
+		events := []Event{}
+		metadata := newEventMetadata(id, time.Now())
+		if len(changed) > 0 {
+			events = append(events, UserUpdated{EventMetadata: metadata, ChangedFields: changed})
+		}
+		if passwordChanged {
+			metadata.ID = newEventID()
+			events = append(events, PasswordChanged{EventMetadata: metadata})
+		}
+
+		return repository.SaveEvents(ctx, events...)
+	})
 	if err != nil {
 		if err == ErrUserNotFound {
 			return User{}, ErrUserNotFound
@@ -69,11 +302,59 @@ func (s Service) Update(id int, user User) (User, error) {
 		return User{}, err
 	}
 
-	return user, nil
+	return updated, nil
 }
 
-func (s Service) Delete(id int) error {
-	err := s.repository.Delete(id)
+// _maxUpdateAttempts bounds the runs of an update conflicting with concurrent ones.
+const _maxUpdateAttempts = 3
+
+// updateTransaction runs fn in a transaction, again if its update conflicted with a concurrent
+// one, up to _maxUpdateAttempts times. fn must read the user again on each run.
+func (s Service) updateTransaction(ctx context.Context, fn func(repository Repository) error) error {
+	var err error
+	for attempt := 0; attempt < _maxUpdateAttempts; attempt++ {
+		err = s.repository.Transaction(ctx, fn)
+		if err != ErrConcurrentUpdate {
+			return err
+		}
+	}
+
+	return err
+}
+
+func (s Service) Delete(ctx context.Context, id int) (err error) {
+	ctx, span := tracer.Start(ctx, "Service.Delete")
+	span.SetAttributes(attribute.Int("user.id", id))
+	defer func() {
+		observeOperation(_operationDelete, _outcomeDeleted, err)
+		endSpan(span, err)
+	}()
+
+	err = s.repository.Transaction(ctx, func(repository Repository) error {
+		before, err := repository.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		err = repository.Delete(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		if len(before.Metadata) > 0 {
+			err = repository.SetMetadataIndex(ctx, id, nil)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = audit(ctx, repository, id, AuditActionDelete, &before, nil)
+		if err != nil {
+			return err
+		}
+
+		return repository.SaveEvents(ctx, UserDeleted{EventMetadata: newEventMetadata(id, time.Now())})
+	})
 	if err != nil {
 		if err == ErrUserNotFound {
 			return ErrUserNotFound
@@ -81,10 +362,340 @@ func (s Service) Delete(id int) error {
 		return err
 	}
 
+	// Sessions are revoked right away, not when the event is relayed, so tokens stop working once
+	// the user is deleted.
+	if s.sessions != nil {
+		err = s.sessions.RevokeAll(ctx, id)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func generatePassword(plainPassword string) (string, error) {
+// Authenticate returns the user with the given email if password is its password. Unknown emails
+// and wrong passwords both fail with ErrInvalidCredentials, so callers can't tell them apart.
+// Failures are tracked per user and client IP, and once either is locked out by the lockout
+// policy logins fail with ErrLoginThrottled, even with the right password. A matching password
+// doesn't reset the failures of the user, CompleteLogin does once every step of the login passed.
+func (s Service) Authenticate(ctx context.Context, email string, password string) (_ User, err error) {
+	ctx, span := tracer.Start(ctx, "Service.Authenticate")
+	defer func() {
+		observeOperation(_operationAuthenticate, _outcomeAuthenticated, err)
+		endSpan(span, err)
+	}()
+
+	now := time.Now()
+	ip := requestmeta.FromContext(ctx).IP
+	ipFailures, err := s.ipLoginFailures(ctx, ip)
+	if err != nil {
+		return User{}, err
+	}
+	if ipFailures.Locked(now) {
+		return User{}, ErrLoginThrottled
+	}
+
+	user, err := s.repository.GetByEmail(ctx, email)
+	if err != nil && err != ErrUserNotFound {
+		return User{}, err
+	}
+	found := err == nil
+	if found && user.LoginFailures.Locked(now) {
+		return User{}, ErrLoginThrottled
+	}
+
+	// Compare against a dummy hash for unknown emails too, so response times don't reveal which
+	// emails are registered.
+	hash := _dummyPasswordHash
+	if found {
+		hash = user.Password
+	}
+	if !comparePassword(ctx, hash, password) || !found {
+		err = s.countLoginFailure(ctx, ip, ipFailures, user, found, now)
+		if err != nil {
+			return User{}, err
+		}
+		return User{}, ErrInvalidCredentials
+	}
+
+	// Checked once the password matched, so the status of an account isn't revealed to callers
+	// without its password.
+	err = statusError(user.Status)
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// CheckLogin returns the user with the given id after the checks Authenticate makes once the
+// password matched: ErrLoginThrottled while the user or client IP is locked out, or the error of
+// its status if it's not active. It's meant for the later steps of a login, like the second
+// factor, since the user may have been locked out or suspended after the password step.
+func (s Service) CheckLogin(ctx context.Context, id int) (_ User, err error) {
+	ctx, span := tracer.Start(ctx, "Service.CheckLogin")
+	span.SetAttributes(attribute.Int("user.id", id))
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	now := time.Now()
+	ipFailures, err := s.ipLoginFailures(ctx, requestmeta.FromContext(ctx).IP)
+	if err != nil {
+		return User{}, err
+	}
+	if ipFailures.Locked(now) {
+		return User{}, ErrLoginThrottled
+	}
+
+	user, err := s.repository.Get(ctx, id)
+	if err != nil {
+		return User{}, err
+	}
+	if user.LoginFailures.Locked(now) {
+		return User{}, ErrLoginThrottled
+	}
+
+	err = statusError(user.Status)
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// RecordLoginFailure counts a failed later step of a login of user, like a wrong second factor,
+// towards the lockout of the user and the client IP, as Authenticate does with a wrong password.
+func (s Service) RecordLoginFailure(ctx context.Context, user User) (err error) {
+	ctx, span := tracer.Start(ctx, "Service.RecordLoginFailure")
+	span.SetAttributes(attribute.Int("user.id", user.ID))
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	ip := requestmeta.FromContext(ctx).IP
+	ipFailures, err := s.ipLoginFailures(ctx, ip)
+	if err != nil {
+		return err
+	}
+
+	return s.countLoginFailure(ctx, ip, ipFailures, user, true, time.Now())
+}
+
+// CompleteLogin resets the failed logins of user once every step of a login passed. Authenticate
+// doesn't reset them, so logging in again with a known password doesn't clear the failures of a
+// second factor being guessed.
+func (s Service) CompleteLogin(ctx context.Context, user User) (err error) {
+	ctx, span := tracer.Start(ctx, "Service.CompleteLogin")
+	span.SetAttributes(attribute.Int("user.id", user.ID))
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	if user.LoginFailures == (LoginFailures{}) {
+		return nil
+	}
+
+	return s.repository.SetLoginFailures(ctx, user.ID, LoginFailures{})
+}
+
+// ipLoginFailures returns the login failures from ip, zero if IP lockout is disabled or ip unknown.
+func (s Service) ipLoginFailures(ctx context.Context, ip string) (LoginFailures, error) {
+	if s.lockout.IPMaxFailures <= 0 || ip == "" {
+		return LoginFailures{}, nil
+	}
+
+	return s.repository.GetIPLoginFailures(ctx, ip)
+}
+
+// countLoginFailure counts a failed login from ip, and of user if found. Concurrent failures may
+// be undercounted, which only delays the lockout by a few attempts.
+func (s Service) countLoginFailure(ctx context.Context, ip string, ipFailures LoginFailures, user User, found bool, now time.Time) error {
+	if s.lockout.IPMaxFailures > 0 && ip != "" {
+		failures := s.lockout.recordFailure(ipFailures, s.lockout.IPMaxFailures, now)
+		if failures.Lockouts > ipFailures.Lockouts {
+			observeLockout(_lockoutScopeIP)
+		}
+		err := s.repository.SetIPLoginFailures(ctx, ip, failures)
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.lockout.MaxFailures > 0 && found {
+		failures := s.lockout.recordFailure(user.LoginFailures, s.lockout.MaxFailures, now)
+		if failures.Lockouts > user.LoginFailures.Lockouts {
+			observeLockout(_lockoutScopeUser)
+		}
+		err := s.repository.SetLoginFailures(ctx, user.ID, failures)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetStatus transitions the status of the user with the given id, recording reason. Only the
+// transitions in _statusTransitions are allowed.
+func (s Service) SetStatus(ctx context.Context, id int, status string, reason string) (_ User, err error) {
+	ctx, span := tracer.Start(ctx, "Service.SetStatus")
+	span.SetAttributes(attribute.Int("user.id", id), attribute.String("user.status", status))
+	defer func() {
+		observeOperation(_operationSetStatus, _outcomeUpdated, err)
+		endSpan(span, err)
+	}()
+
+	reason, err = normalizeStatusChange(status, reason)
+	if err != nil {
+		return User{}, err
+	}
+
+	var user User
+	err = s.updateTransaction(ctx, func(repository Repository) error {
+		before, err := repository.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !canTransition(before.Status, status) {
+			return ErrInvalidStatusTransition
+		}
+
+		now := time.Now()
+		stored, err := repository.Update(ctx, User{
+			ID:              id,
+			Status:          status,
+			StatusReason:    reason,
+			StatusChangedAt: now.Unix(),
+			Version:         before.Version,
+		})
+		if err != nil {
+			return err
+		}
+		user = applyUpdate(before, stored)
+
+		err = audit(ctx, repository, id, AuditActionUpdate, &before, &user)
+		if err != nil {
+			return err
+		}
+
+		return repository.SaveEvents(ctx, StatusChanged{
+			EventMetadata: newEventMetadata(id, now),
+			From:          before.Status,
+			To:            status,
+			Reason:        reason,
+		})
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	if status != StatusActive && s.sessions != nil {
+		err = s.sessions.RevokeAll(ctx, id)
+		if err != nil {
+			return User{}, err
+		}
+	}
+
+	return user, nil
+}
+
+// List returns the users matching filter, ordered by id. Only indexed metadata keys can be
+// filtered on.
+func (s Service) List(ctx context.Context, filter ListFilter, limit int, offset int) (_ []User, err error) {
+	ctx, span := tracer.Start(ctx, "Service.List")
+	defer func() {
+		observeOperation(_operationList, _outcomeFound, err)
+		endSpan(span, err)
+	}()
+
+	err = s.checkFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.repository.List(ctx, filter, limit, offset)
+}
+
+// Export calls fn with each user matching filter, ordered by id, stopping at the first error of
+// fn. Users are iterated with a cursor instead of loaded at once, and never have their password.
+// Only indexed metadata keys can be filtered on.
+func (s Service) Export(ctx context.Context, filter ListFilter, fn func(user User) error) (err error) {
+	ctx, span := tracer.Start(ctx, "Service.Export")
+	defer func() {
+		observeOperation(_operationExport, _outcomeFound, err)
+		endSpan(span, err)
+	}()
+
+	err = s.checkFilter(filter)
+	if err != nil {
+		return err
+	}
+
+	return s.repository.Export(ctx, filter, func(user User) error {
+		user.Password = ""
+		return fn(user)
+	})
+}
+
+// checkFilter fails with ErrMetadataKeyNotIndexed if filter has a metadata key that isn't indexed.
+func (s Service) checkFilter(filter ListFilter) error {
+	keys := make([]string, 0, len(filter.Metadata))
+	for key := range filter.Metadata {
+		keys = append(keys, key)
+	}
+
+	return s.metadata.CheckIndexed(keys...)
+}
+
+// AuditLog returns the audit log of the user with the given id, newest first. The log is kept
+// after the user is deleted.
+func (s Service) AuditLog(ctx context.Context, id int, limit int, offset int) (_ []AuditEntry, err error) {
+	ctx, span := tracer.Start(ctx, "Service.AuditLog")
+	span.SetAttributes(attribute.Int("user.id", id))
+	defer func() {
+		observeOperation(_operationAuditLog, _outcomeFound, err)
+		endSpan(span, err)
+	}()
+
+	return s.repository.ListAuditEntries(ctx, id, limit, offset)
+}
+
+// audit appends the audit entry of action on the user with id userID, if it changed any field.
+func audit(ctx context.Context, repository Repository, userID int, action string, before *User, after *User) error {
+	changes := auditDiff(before, after)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	entry, err := newAuditEntry(ctx, userID, action, changes)
+	if err != nil {
+		return err
+	}
+
+	return repository.SaveAuditEntry(ctx, entry)
+}
+
+// _dummyPasswordHash is a bcrypt hash, at the default cost, compared when authenticating unknown
+// emails.
+const _dummyPasswordHash = "$2a$10$i8u5FgiJXRui/p.ZDXnDO.kVq3H6rbqrQp6rInFX.IeEO0zN/2F5G"
+
+func comparePassword(ctx context.Context, hash string, plainPassword string) bool {
+	_, span := tracer.Start(ctx, "bcrypt.CompareHashAndPassword")
+	defer span.End()
+
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(plainPassword)) == nil
+}
+
+func generatePassword(ctx context.Context, plainPassword string) (_ string, err error) {
+	_, span := tracer.Start(ctx, "bcrypt.GenerateFromPassword")
+	start := time.Now()
+	defer func() {
+		passwordHashDuration.Observe(time.Since(start).Seconds())
+		endSpan(span, err)
+	}()
+
 	// Generate "hash" to mysql from user password.
 	hash, err := bcrypt.GenerateFromPassword([]byte(plainPassword), bcrypt.DefaultCost)
 	if err != nil {
@@ -1,37 +1,169 @@
 package users
 
 import (
+	"context"
 	"errors"
 	"testing"
+	"time"
 
+	"github.com/marcosstupnicki/go-users/internal/platform/auth"
+	"github.com/marcosstupnicki/go-users/internal/platform/config"
+	"github.com/marcosstupnicki/go-users/internal/platform/requestmeta"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type RepositoryMock struct {
 	mock.Mock
+	events        []Event
+	auditEntries  []AuditEntry
+	metadataIndex map[int][]MetadataIndexEntry
+	loginFailures map[int]LoginFailures
+	ipFailures    map[string]LoginFailures
+	// created are the users of CreateBatch, which fails with ErrUserAlreadyExists for the emails in
+	// existing.
+	created  []User
+	existing map[string]bool
 }
 
-func (s *RepositoryMock) Create(user User) (User, error) {
+func (s *RepositoryMock) Create(_ context.Context, user User) (User, error) {
 	args := s.Called()
 	return args.Get(0).(User), args.Error(1)
 }
 
-func (s *RepositoryMock) Get(id int) (User, error) {
+func (s *RepositoryMock) CreateBatch(_ context.Context, users []User) ([]User, error) {
+	for _, user := range users {
+		if s.existing[user.Email] {
+			return nil, ErrUserAlreadyExists
+		}
+	}
+	created := []User{}
+	for _, user := range users {
+		user.ID = len(s.created) + 1
+		s.created = append(s.created, user)
+		created = append(created, user)
+	}
+	return created, nil
+}
+
+func (s *RepositoryMock) Get(_ context.Context, id int) (User, error) {
+	// Tests of the methods reading the user before changing it may not set Get, the user with id
+	// is found then.
+	if !s.expects("Get") {
+		return User{ID: id}, nil
+	}
 	args := s.Called()
 	return args.Get(0).(User), args.Error(1)
 }
 
-func (s *RepositoryMock) Update(user User) (User, error) {
+// expects reports whether the method has an expected call set.
+func (s *RepositoryMock) expects(method string) bool {
+	for _, call := range s.ExpectedCalls {
+		if call.Method == method {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *RepositoryMock) GetByEmail(_ context.Context, email string) (User, error) {
+	args := s.Called(email)
+	user := args.Get(0).(User)
+	// Return the login failures stored by SetLoginFailures, as the database would.
+	if failures, ok := s.loginFailures[user.ID]; ok {
+		user.LoginFailures = failures
+	}
+	return user, args.Error(1)
+}
+
+func (s *RepositoryMock) Update(_ context.Context, user User) (User, error) {
 	args := s.Called()
 	return args.Get(0).(User), args.Error(1)
 }
 
-func (s *RepositoryMock) Delete(id int) error {
+func (s *RepositoryMock) Delete(_ context.Context, id int) error {
 	args := s.Called()
 	return args.Error(0)
 }
 
+func (s *RepositoryMock) List(_ context.Context, filter ListFilter, limit int, offset int) ([]User, error) {
+	args := s.Called(filter)
+	return args.Get(0).([]User), args.Error(1)
+}
+
+func (s *RepositoryMock) Search(_ context.Context, terms []string, limit int, offset int) ([]User, error) {
+	args := s.Called(terms, limit, offset)
+	return args.Get(0).([]User), args.Error(1)
+}
+
+func (s *RepositoryMock) Export(_ context.Context, filter ListFilter, fn func(user User) error) error {
+	args := s.Called(filter)
+	for _, user := range args.Get(0).([]User) {
+		err := fn(user)
+		if err != nil {
+			return err
+		}
+	}
+	return args.Error(1)
+}
+
+func (s *RepositoryMock) SetMetadataIndex(_ context.Context, userID int, entries []MetadataIndexEntry) error {
+	if s.metadataIndex == nil {
+		s.metadataIndex = map[int][]MetadataIndexEntry{}
+	}
+	s.metadataIndex[userID] = entries
+	return nil
+}
+
+func (s *RepositoryMock) SetLoginFailures(_ context.Context, userID int, failures LoginFailures) error {
+	if s.loginFailures == nil {
+		s.loginFailures = map[int]LoginFailures{}
+	}
+	s.loginFailures[userID] = failures
+	return nil
+}
+
+func (s *RepositoryMock) GetIPLoginFailures(_ context.Context, ip string) (LoginFailures, error) {
+	return s.ipFailures[ip], nil
+}
+
+func (s *RepositoryMock) SetIPLoginFailures(_ context.Context, ip string, failures LoginFailures) error {
+	if s.ipFailures == nil {
+		s.ipFailures = map[string]LoginFailures{}
+	}
+	s.ipFailures[ip] = failures
+	return nil
+}
+
+func (s *RepositoryMock) SaveEvents(_ context.Context, events ...Event) error {
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func (s *RepositoryMock) SaveAuditEntry(_ context.Context, entry AuditEntry) error {
+	s.auditEntries = append(s.auditEntries, entry)
+	return nil
+}
+
+func (s *RepositoryMock) ListAuditEntries(_ context.Context, userID int, limit int, offset int) ([]AuditEntry, error) {
+	args := s.Called(limit, offset)
+	return args.Get(0).([]AuditEntry), args.Error(1)
+}
+
+func (s *RepositoryMock) Transaction(_ context.Context, fn func(repository Repository) error) error {
+	return fn(s)
+}
+
+// eventNames returns the names of the saved events, in order.
+func (s *RepositoryMock) eventNames() []string {
+	names := []string{}
+	for _, event := range s.events {
+		names = append(names, event.EventName())
+	}
+	return names
+}
+
 func TestService_Create(t *testing.T) {
 	user := User{
 		ID:        1,
@@ -74,12 +206,22 @@ func TestService_Create(t *testing.T) {
 			},
 			expectedError: errors.New("internal error"),
 		},
+		{
+			name: "Fail - Invalid profile",
+			repo: &RepositoryMock{},
+			user: User{
+				Email:    "some@email.com",
+				Password: "some-password",
+				Locale:   "not a locale",
+			},
+			expectedError: ErrInvalidLocale,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			service := NewService(tt.repo)
-			result, err := service.Create(tt.user)
+			result, err := service.Create(context.Background(), tt.user)
 			require.Equal(t, tt.expectedError, err)
 			require.Equal(t, tt.expectedResult, result)
 		})
@@ -135,7 +277,7 @@ func TestService_Get(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			service := NewService(tt.repo)
-			result, err := service.Get(tt.id)
+			result, err := service.Get(context.Background(), tt.id)
 			require.Equal(t, tt.expectedError, err)
 			require.Equal(t, tt.expectedResult, result)
 		})
@@ -173,6 +315,22 @@ func TestService_Update(t *testing.T) {
 			},
 			expectedResult: user,
 		},
+		{
+			name: "Ok - Retried after a concurrent update",
+			repo: func() *RepositoryMock {
+				m := RepositoryMock{}
+				m.On("Get", mock.Anything).Return(user, nil)
+				m.On("Update", mock.Anything).Return(User{}, ErrConcurrentUpdate).Once()
+				m.On("Update", mock.Anything).Return(user, nil)
+				return &m
+			}(),
+			id: 1,
+			user: User{
+				Email:    "some2@email.com",
+				Password: "some2-password",
+			},
+			expectedResult: user,
+		},
 		{
 			name: "Fail - User not found",
 			id:   1,
@@ -183,6 +341,28 @@ func TestService_Update(t *testing.T) {
 			}(),
 			expectedError: ErrUserNotFound,
 		},
+		{
+			name: "Fail - User not found reading it",
+			id:   1,
+			repo: func() *RepositoryMock {
+				m := RepositoryMock{}
+				m.On("Get", mock.Anything).Return(User{}, ErrUserNotFound)
+				return &m
+			}(),
+			expectedError: ErrUserNotFound,
+		},
+		{
+			name: "Fail - Concurrent updates",
+			id:   1,
+			repo: func() *RepositoryMock {
+				m := RepositoryMock{}
+				m.On("Get", mock.Anything).Return(user, nil)
+				m.On("Update", mock.Anything).Return(User{}, ErrConcurrentUpdate)
+				return &m
+			}(),
+			user:          User{FirstName: "Some"},
+			expectedError: ErrConcurrentUpdate,
+		},
 		{
 			name: "Fail - Internal error",
 			repo: func() *RepositoryMock {
@@ -202,7 +382,7 @@ func TestService_Update(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			service := NewService(tt.repo)
-			result, err := service.Update(tt.id, tt.user)
+			result, err := service.Update(context.Background(), tt.id, tt.user)
 			require.Equal(t, tt.expectedError, err)
 			require.Equal(t, tt.expectedResult, result)
 		})
@@ -211,19 +391,21 @@ func TestService_Update(t *testing.T) {
 
 func TestService_Delete(t *testing.T) {
 	var tests = []struct {
-		name          string
-		repo          *RepositoryMock
-		id            int
-		expectedError error
+		name            string
+		repo            *RepositoryMock
+		id              int
+		expectedRevoked []int
+		expectedError   error
 	}{
 		{
-			name: "Ok",
+			name: "Ok - Sessions revoked",
 			repo: func() *RepositoryMock {
 				m := RepositoryMock{}
 				m.On("Delete", mock.Anything).Return(nil)
 				return &m
 			}(),
-			id: 1,
+			id:              1,
+			expectedRevoked: []int{1},
 		},
 		{
 			name: "Fail - User not found",
@@ -234,6 +416,15 @@ func TestService_Delete(t *testing.T) {
 			}(),
 			expectedError: ErrUserNotFound,
 		},
+		{
+			name: "Fail - User not found reading it",
+			repo: func() *RepositoryMock {
+				m := RepositoryMock{}
+				m.On("Get", mock.Anything).Return(User{}, ErrUserNotFound)
+				return &m
+			}(),
+			expectedError: ErrUserNotFound,
+		},
 		{
 			name: "Fail - Internal error",
 			repo: func() *RepositoryMock {
@@ -245,11 +436,551 @@ func TestService_Delete(t *testing.T) {
 		},
 	}
 
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessions := &SessionRevokerMock{}
+			service := NewService(tt.repo, WithSessionRevoker(sessions))
+			err := service.Delete(context.Background(), tt.id)
+			require.Equal(t, tt.expectedError, err)
+			require.Equal(t, tt.expectedRevoked, sessions.revoked)
+		})
+	}
+}
+
+func TestService_Authenticate(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("some-password"), bcrypt.MinCost)
+	require.NoError(t, err)
+	user := User{ID: 1, Email: "some@email.com", Password: string(hash), Status: StatusActive}
+	suspended := user
+	suspended.Status = StatusSuspended
+
+	var tests = []struct {
+		name           string
+		repo           *RepositoryMock
+		password       string
+		expectedResult User
+		expectedError  error
+	}{
+		{
+			name: "Ok",
+			repo: func() *RepositoryMock {
+				m := RepositoryMock{}
+				m.On("GetByEmail", "some@email.com").Return(user, nil)
+				return &m
+			}(),
+			password:       "some-password",
+			expectedResult: user,
+		},
+		{
+			name: "Fail - Wrong password",
+			repo: func() *RepositoryMock {
+				m := RepositoryMock{}
+				m.On("GetByEmail", "some@email.com").Return(user, nil)
+				return &m
+			}(),
+			password:      "other-password",
+			expectedError: ErrInvalidCredentials,
+		},
+		{
+			name: "Fail - Suspended account",
+			repo: func() *RepositoryMock {
+				m := RepositoryMock{}
+				m.On("GetByEmail", "some@email.com").Return(suspended, nil)
+				return &m
+			}(),
+			password:      "some-password",
+			expectedError: ErrAccountSuspended,
+		},
+		{
+			name: "Fail - Unknown email",
+			repo: func() *RepositoryMock {
+				m := RepositoryMock{}
+				m.On("GetByEmail", "some@email.com").Return(User{}, ErrUserNotFound)
+				return &m
+			}(),
+			password:      "some-password",
+			expectedError: ErrInvalidCredentials,
+		},
+		{
+			name: "Fail - Internal error",
+			repo: func() *RepositoryMock {
+				m := RepositoryMock{}
+				m.On("GetByEmail", "some@email.com").Return(User{}, errors.New("internal error"))
+				return &m
+			}(),
+			password:      "some-password",
+			expectedError: errors.New("internal error"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewService(tt.repo)
+			result, err := service.Authenticate(context.Background(), "some@email.com", tt.password)
+			require.Equal(t, tt.expectedError, err)
+			require.Equal(t, tt.expectedResult, result)
+		})
+	}
+}
+
+func TestService_Authenticate_Lockout(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("some-password"), bcrypt.MinCost)
+	require.NoError(t, err)
+	user := User{ID: 1, Email: "some@email.com", Password: string(hash), Status: StatusActive}
+	policy := LockoutPolicy{MaxFailures: 3, IPMaxFailures: 5, BaseDuration: time.Minute, MaxDuration: time.Hour}
+	ctx := requestmeta.WithMetadata(context.Background(), requestmeta.Metadata{IP: "203.0.113.9"})
+
+	t.Run("User locked out after MaxFailures", func(t *testing.T) {
+		repo := &RepositoryMock{}
+		repo.On("GetByEmail", "some@email.com").Return(user, nil)
+		service := NewService(repo, WithLockoutPolicy(policy))
+
+		for i := 0; i < 3; i++ {
+			_, err := service.Authenticate(ctx, "some@email.com", "other-password")
+			require.Equal(t, ErrInvalidCredentials, err)
+		}
+		failures := repo.loginFailures[1]
+		require.Equal(t, 1, failures.Lockouts)
+		require.True(t, failures.Locked(time.Now()))
+
+		_, err := service.Authenticate(ctx, "some@email.com", "some-password")
+		require.Equal(t, ErrLoginThrottled, err)
+	})
+
+	t.Run("IP locked out after IPMaxFailures", func(t *testing.T) {
+		repo := &RepositoryMock{}
+		repo.On("GetByEmail", mock.Anything).Return(User{}, ErrUserNotFound)
+		service := NewService(repo, WithLockoutPolicy(policy))
+
+		for i := 0; i < 5; i++ {
+			_, err := service.Authenticate(ctx, "unknown@email.com", "some-password")
+			require.Equal(t, ErrInvalidCredentials, err)
+		}
+		_, err := service.Authenticate(ctx, "unknown@email.com", "some-password")
+		require.Equal(t, ErrLoginThrottled, err)
+	})
+
+	t.Run("Completed login resets user failures", func(t *testing.T) {
+		failing := user
+		failing.LoginFailures = LoginFailures{FailedLogins: 2, LastFailureAt: time.Now().Unix()}
+		repo := &RepositoryMock{}
+		repo.On("GetByEmail", "some@email.com").Return(failing, nil)
+		service := NewService(repo, WithLockoutPolicy(policy))
+
+		result, err := service.Authenticate(ctx, "some@email.com", "some-password")
+		require.NoError(t, err)
+		require.Equal(t, failing.LoginFailures, result.LoginFailures)
+		require.Nil(t, repo.loginFailures)
+
+		err = service.CompleteLogin(ctx, result)
+		require.NoError(t, err)
+		require.Equal(t, LoginFailures{}, repo.loginFailures[1])
+	})
+
+	t.Run("Second factor failures lock the user out", func(t *testing.T) {
+		repo := &RepositoryMock{}
+		service := NewService(repo, WithLockoutPolicy(policy))
+
+		current := user
+		for i := 0; i < 3; i++ {
+			err := service.RecordLoginFailure(ctx, current)
+			require.NoError(t, err)
+			current.LoginFailures = repo.loginFailures[1]
+		}
+		require.Equal(t, 1, current.LoginFailures.Lockouts)
+		require.Equal(t, 3, repo.ipFailures["203.0.113.9"].FailedLogins)
+
+		repo.On("Get", mock.Anything).Return(current, nil)
+		_, err := service.CheckLogin(ctx, 1)
+		require.Equal(t, ErrLoginThrottled, err)
+	})
+}
+
+func TestService_CheckLogin(t *testing.T) {
+	user := User{ID: 1, Email: "some@email.com", Status: StatusActive}
+	suspended := user
+	suspended.Status = StatusSuspended
+
+	var tests = []struct {
+		name           string
+		repo           *RepositoryMock
+		expectedResult User
+		expectedError  error
+	}{
+		{
+			name: "Ok",
+			repo: func() *RepositoryMock {
+				m := RepositoryMock{}
+				m.On("Get", mock.Anything).Return(user, nil)
+				return &m
+			}(),
+			expectedResult: user,
+		},
+		{
+			name: "Fail - Suspended account",
+			repo: func() *RepositoryMock {
+				m := RepositoryMock{}
+				m.On("Get", mock.Anything).Return(suspended, nil)
+				return &m
+			}(),
+			expectedError: ErrAccountSuspended,
+		},
+		{
+			name: "Fail - User not found",
+			repo: func() *RepositoryMock {
+				m := RepositoryMock{}
+				m.On("Get", mock.Anything).Return(User{}, ErrUserNotFound)
+				return &m
+			}(),
+			expectedError: ErrUserNotFound,
+		},
+	}
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			service := NewService(tt.repo)
-			err := service.Delete(tt.id)
+			result, err := service.CheckLogin(context.Background(), 1)
 			require.Equal(t, tt.expectedError, err)
+			require.Equal(t, tt.expectedResult, result)
+		})
+	}
+}
+
+// SessionRevokerMock records the users whose sessions were revoked.
+type SessionRevokerMock struct {
+	revoked []int
+}
+
+func (m *SessionRevokerMock) RevokeAll(_ context.Context, userID int) error {
+	m.revoked = append(m.revoked, userID)
+	return nil
+}
+
+func TestService_SetStatus(t *testing.T) {
+	active := User{ID: 1, Email: "some@email.com", Status: StatusActive}
+
+	var tests = []struct {
+		name            string
+		repo            *RepositoryMock
+		status          string
+		expectedStatus  string
+		expectedRevoked []int
+		expectedError   error
+	}{
+		{
+			name: "Ok - Suspend",
+			repo: func() *RepositoryMock {
+				m := RepositoryMock{}
+				m.On("Get", mock.Anything).Return(active, nil)
+				m.On("Update", mock.Anything).Return(User{ID: 1, Status: StatusSuspended, StatusReason: "spam"}, nil)
+				return &m
+			}(),
+			status:          StatusSuspended,
+			expectedStatus:  StatusSuspended,
+			expectedRevoked: []int{1},
+		},
+		{
+			name: "Fail - Invalid transition",
+			repo: func() *RepositoryMock {
+				m := RepositoryMock{}
+				m.On("Get", mock.Anything).Return(active, nil)
+				return &m
+			}(),
+			status:        StatusPending,
+			expectedError: ErrInvalidStatusTransition,
+		},
+		{
+			name: "Fail - User not found",
+			repo: func() *RepositoryMock {
+				m := RepositoryMock{}
+				m.On("Get", mock.Anything).Return(User{}, ErrUserNotFound)
+				return &m
+			}(),
+			status:        StatusSuspended,
+			expectedError: ErrUserNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessions := &SessionRevokerMock{}
+			service := NewService(tt.repo, WithSessionRevoker(sessions))
+			user, err := service.SetStatus(context.Background(), 1, tt.status, "spam")
+			require.Equal(t, tt.expectedError, err)
+			require.Equal(t, tt.expectedStatus, user.Status)
+			require.Equal(t, tt.expectedRevoked, sessions.revoked)
+			if tt.expectedError != nil {
+				require.Empty(t, tt.repo.events)
+				return
+			}
+
+			require.Equal(t, []string{EventNameStatusChanged}, tt.repo.eventNames())
+			event := tt.repo.events[0].(StatusChanged)
+			require.Equal(t, StatusActive, event.From)
+			require.Equal(t, StatusSuspended, event.To)
+			require.Equal(t, "spam", event.Reason)
+			require.Len(t, tt.repo.auditEntries, 1)
+			require.JSONEq(t, `{"status":{"before":"active","after":"suspended"},"status_reason":{"before":"","after":"spam"}}`, tt.repo.auditEntries[0].Changes)
+		})
+	}
+}
+
+func TestService_SaveEvents(t *testing.T) {
+	user := User{
+		ID:        1,
+		Email:     "some@email.com",
+		Password:  "$2a$10$i8u5FgiJXRui/p.ZDXnDO.kVq3H6rbqrQp6rInFX.IeEO0zN/2F5G",
+		CreatedAt: 1651422724,
+		UpdatedAt: 1651422724,
+	}
+
+	var tests = []struct {
+		name           string
+		call           func(s Service) error
+		expectedEvents []string
+	}{
+		{
+			name: "Create",
+			call: func(s Service) error {
+				_, err := s.Create(context.Background(), User{Email: "some@email.com", Password: "some-password"})
+				return err
+			},
+			expectedEvents: []string{EventNameUserCreated},
+		},
+		{
+			name: "Update email",
+			call: func(s Service) error {
+				_, err := s.Update(context.Background(), 1, User{Email: "other@email.com"})
+				return err
+			},
+			expectedEvents: []string{EventNameUserUpdated},
+		},
+		{
+			name: "Update password",
+			call: func(s Service) error {
+				_, err := s.Update(context.Background(), 1, User{Password: "other-password"})
+				return err
+			},
+			expectedEvents: []string{EventNamePasswordChanged},
+		},
+		{
+			name: "Update without changes",
+			call: func(s Service) error {
+				_, err := s.Update(context.Background(), 1, User{Email: "some@email.com"})
+				return err
+			},
+			expectedEvents: []string{},
+		},
+		{
+			name: "Delete",
+			call: func(s Service) error {
+				return s.Delete(context.Background(), 1)
+			},
+			expectedEvents: []string{EventNameUserDeleted},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &RepositoryMock{}
+			repo.On("Create", mock.Anything).Return(user, nil)
+			repo.On("Get", mock.Anything).Return(user, nil)
+			repo.On("Update", mock.Anything).Return(user, nil)
+			repo.On("Delete", mock.Anything).Return(nil)
+
+			service := NewService(repo)
+			require.NoError(t, tt.call(service))
+			require.Equal(t, tt.expectedEvents, repo.eventNames())
 		})
 	}
+
+	t.Run("Update changed fields", func(t *testing.T) {
+		repo := &RepositoryMock{}
+		repo.On("Get", mock.Anything).Return(user, nil)
+		repo.On("Update", mock.Anything).Return(User{ID: 1, Email: "other@email.com", Password: "hash"}, nil)
+
+		service := NewService(repo)
+		_, err := service.Update(context.Background(), 1, User{Email: "other@email.com", Password: "other-password"})
+		require.NoError(t, err)
+		require.Len(t, repo.events, 2)
+		require.Equal(t, []string{"email"}, repo.events[0].(UserUpdated).ChangedFields)
+		require.NotEqual(t, repo.events[0].Metadata().ID, repo.events[1].Metadata().ID)
+	})
+}
+
+func TestService_Audit(t *testing.T) {
+	user := User{
+		ID:        1,
+		Email:     "some@email.com",
+		Password:  "$2a$10$i8u5FgiJXRui/p.ZDXnDO.kVq3H6rbqrQp6rInFX.IeEO0zN/2F5G",
+		CreatedAt: 1651422724,
+		UpdatedAt: 1651422724,
+	}
+
+	ctx := auth.WithPrincipal(context.Background(), auth.Principal{Subject: "user:9"})
+	ctx = requestmeta.WithMetadata(ctx, requestmeta.Metadata{RequestID: "host/abc-000001", IP: "203.0.113.9"})
+
+	var tests = []struct {
+		name            string
+		call            func(s Service) error
+		expectedAction  string
+		expectedChanges string
+	}{
+		{
+			name: "Create",
+			call: func(s Service) error {
+				_, err := s.Create(ctx, User{Email: "some@email.com", Password: "some-password"})
+				return err
+			},
+			expectedAction:  AuditActionCreate,
+			expectedChanges: `{"email":{"before":null,"after":"some@email.com"},"password":{"before":null,"after":"[REDACTED]"}}`,
+		},
+		{
+			name: "Update email",
+			call: func(s Service) error {
+				_, err := s.Update(ctx, 1, User{Email: "other@email.com"})
+				return err
+			},
+			expectedAction:  AuditActionUpdate,
+			expectedChanges: `{"email":{"before":"some@email.com","after":"other@email.com"}}`,
+		},
+		{
+			name: "Update password",
+			call: func(s Service) error {
+				_, err := s.Update(ctx, 1, User{Password: "other-password"})
+				return err
+			},
+			expectedAction:  AuditActionUpdate,
+			expectedChanges: `{"password":{"before":"[REDACTED]","after":"[REDACTED]"}}`,
+		},
+		{
+			name: "Delete",
+			call: func(s Service) error {
+				return s.Delete(ctx, 1)
+			},
+			expectedAction:  AuditActionDelete,
+			expectedChanges: `{"email":{"before":"some@email.com","after":null},"password":{"before":"[REDACTED]","after":null}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &RepositoryMock{}
+			repo.On("Create", mock.Anything).Return(user, nil)
+			repo.On("Get", mock.Anything).Return(user, nil)
+			repo.On("Update", mock.Anything).Return(user, nil)
+			repo.On("Delete", mock.Anything).Return(nil)
+
+			service := NewService(repo)
+			require.NoError(t, tt.call(service))
+			require.Len(t, repo.auditEntries, 1)
+
+			entry := repo.auditEntries[0]
+			require.Equal(t, 1, entry.UserID)
+			require.Equal(t, "user:9", entry.Actor)
+			require.Equal(t, tt.expectedAction, entry.Action)
+			require.Equal(t, tt.expectedChanges, entry.Changes)
+			require.Equal(t, "host/abc-000001", entry.RequestID)
+			require.Equal(t, "203.0.113.9", entry.IP)
+		})
+	}
+
+	t.Run("Update without changes", func(t *testing.T) {
+		repo := &RepositoryMock{}
+		repo.On("Get", mock.Anything).Return(user, nil)
+		repo.On("Update", mock.Anything).Return(user, nil)
+
+		service := NewService(repo)
+		_, err := service.Update(context.Background(), 1, User{Email: "some@email.com"})
+		require.NoError(t, err)
+		require.Empty(t, repo.auditEntries)
+	})
+}
+
+func TestService_Metadata(t *testing.T) {
+	schemas, err := NewMetadataSchemas(config.Metadata{
+		Schemas: map[string]string{
+			"billing": `{"type": "object", "properties": {"plan": {"type": "string", "enum": ["free", "pro"]}, "seats": {"type": "integer"}}}`,
+			"crm":     `{"type": "object"}`,
+		},
+		IndexedKeys: []string{"billing.plan"},
+	})
+	require.NoError(t, err)
+
+	user := User{
+		ID:       1,
+		Email:    "some@email.com",
+		Password: "$2a$10$i8u5FgiJXRui/p.ZDXnDO.kVq3H6rbqrQp6rInFX.IeEO0zN/2F5G",
+		Metadata: Metadata{
+			"billing": {"plan": "free", "seats": float64(1)},
+			"crm":     {"owner": "sales"},
+		},
+	}
+
+	t.Run("Create indexes metadata", func(t *testing.T) {
+		repo := &RepositoryMock{}
+		repo.On("Create", mock.Anything).Return(user, nil)
+
+		service := NewService(repo, WithMetadataSchemas(schemas))
+		_, err := service.Create(context.Background(), User{Email: "some@email.com", Password: "some-password", Metadata: user.Metadata})
+		require.NoError(t, err)
+		require.Equal(t, []MetadataIndexEntry{{UserID: 1, Key: "billing.plan", Value: "free"}}, repo.metadataIndex[1])
+	})
+
+	t.Run("Update merges namespaces", func(t *testing.T) {
+		repo := &RepositoryMock{}
+		repo.On("Get", mock.Anything).Return(user, nil)
+		repo.On("Update", mock.Anything).Return(User{ID: 1, Metadata: Metadata{"billing": {"plan": "pro"}, "crm": {"owner": "sales"}}}, nil)
+
+		service := NewService(repo, WithMetadataSchemas(schemas))
+		result, err := service.Update(context.Background(), 1, User{Metadata: Metadata{"billing": {"plan": "pro"}}})
+		require.NoError(t, err)
+		require.Equal(t, Metadata{"billing": {"plan": "pro"}, "crm": {"owner": "sales"}}, result.Metadata)
+		require.Equal(t, []MetadataIndexEntry{{UserID: 1, Key: "billing.plan", Value: "pro"}}, repo.metadataIndex[1])
+		require.Equal(t, []string{"metadata"}, repo.events[0].(UserUpdated).ChangedFields)
+		require.Equal(t, `{"metadata":{"before":"{\"billing\":{\"plan\":\"free\",\"seats\":1},\"crm\":{\"owner\":\"sales\"}}","after":"{\"billing\":{\"plan\":\"pro\"},\"crm\":{\"owner\":\"sales\"}}"}}`, repo.auditEntries[0].Changes)
+	})
+
+	var tests = []struct {
+		name          string
+		metadata      Metadata
+		expectedError string
+	}{
+		{
+			name:          "Fail - Unknown namespace",
+			metadata:      Metadata{"shipping": {"address": "somewhere"}},
+			expectedError: `unknown metadata namespace "shipping"`,
+		},
+		{
+			name:          "Fail - Schema not satisfied",
+			metadata:      Metadata{"billing": {"plan": "enterprise"}},
+			expectedError: `invalid metadata. namespace billing: /plan: value must be one of "free", "pro"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewService(&RepositoryMock{}, WithMetadataSchemas(schemas))
+			_, err := service.Create(context.Background(), User{Email: "some@email.com", Password: "some-password", Metadata: tt.metadata})
+			require.EqualError(t, err, tt.expectedError)
+			require.True(t, IsValidationError(err))
+		})
+	}
+
+	t.Run("List by indexed key", func(t *testing.T) {
+		filter := ListFilter{Metadata: map[string]string{"billing.plan": "pro"}}
+		repo := &RepositoryMock{}
+		repo.On("List", filter).Return([]User{user}, nil)
+
+		service := NewService(repo, WithMetadataSchemas(schemas))
+		result, err := service.List(context.Background(), filter, 50, 0)
+		require.NoError(t, err)
+		require.Equal(t, []User{user}, result)
+	})
+
+	t.Run("Fail - List by key not indexed", func(t *testing.T) {
+		service := NewService(&RepositoryMock{}, WithMetadataSchemas(schemas))
+		_, err := service.List(context.Background(), ListFilter{Metadata: map[string]string{"crm.owner": "sales"}}, 50, 0)
+		require.True(t, errors.Is(err, ErrMetadataKeyNotIndexed))
+	})
 }
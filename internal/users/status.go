@@ -0,0 +1,86 @@
+package users
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// Account statuses. Only active accounts can authenticate. Deleting a user is the terminal
+// transition: the account is removed, so it can't be transitioned again.
+const (
+	StatusPending   = "pending"
+	StatusActive    = "active"
+	StatusSuspended = "suspended"
+	StatusLocked    = "locked"
+
+	_maxStatusReasonLength = 255
+)
+
+var (
+	// ErrInvalidStatus unknown account status error
+	ErrInvalidStatus = errors.New("invalid status. status must be one of pending, active, suspended or locked")
+	// ErrInvalidStatusReason missing or too long status change reason error
+	ErrInvalidStatusReason = errors.New("invalid reason. reason is required, up to 255 characters")
+	// ErrInvalidStatusTransition status change not allowed from the current status error
+	ErrInvalidStatusTransition = errors.New("invalid status transition")
+	// ErrAccountPending authentication of an account pending activation error
+	ErrAccountPending = errors.New("account pending activation")
+	// ErrAccountSuspended authentication of a suspended account error
+	ErrAccountSuspended = errors.New("account suspended")
+	// ErrAccountLocked authentication of a locked account error
+	ErrAccountLocked = errors.New("account locked")
+)
+
+// _statusTransitions maps each status to the statuses it can transition to.
+var _statusTransitions = map[string][]string{
+	StatusPending:   {StatusActive},
+	StatusActive:    {StatusSuspended, StatusLocked},
+	StatusSuspended: {StatusActive},
+	StatusLocked:    {StatusActive},
+}
+
+// IsAccountStatusError reports whether err is the rejection of an authentication because the
+// account isn't active.
+func IsAccountStatusError(err error) bool {
+	return err == ErrAccountPending || err == ErrAccountSuspended || err == ErrAccountLocked
+}
+
+func canTransition(from string, to string) bool {
+	for _, status := range _statusTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// statusError returns the error rejecting the authentication of an account with status, or nil if
+// it's active.
+func statusError(status string) error {
+	switch status {
+	case StatusActive:
+		return nil
+	case StatusPending:
+		return ErrAccountPending
+	case StatusSuspended:
+		return ErrAccountSuspended
+	default:
+		return ErrAccountLocked
+	}
+}
+
+// normalizeStatusChange validates a change to status, and returns reason trimmed.
+func normalizeStatusChange(status string, reason string) (string, error) {
+	if _, ok := _statusTransitions[status]; !ok {
+		return "", ErrInvalidStatus
+	}
+
+	reason = strings.TrimSpace(reason)
+	if reason == "" || utf8.RuneCountInString(reason) > _maxStatusReasonLength {
+		return "", ErrInvalidStatusReason
+	}
+
+	return reason, nil
+}
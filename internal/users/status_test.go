@@ -0,0 +1,75 @@
+package users
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanTransition(t *testing.T) {
+	var tests = []struct {
+		from     string
+		to       string
+		expected bool
+	}{
+		{from: StatusPending, to: StatusActive, expected: true},
+		{from: StatusActive, to: StatusSuspended, expected: true},
+		{from: StatusActive, to: StatusLocked, expected: true},
+		{from: StatusSuspended, to: StatusActive, expected: true},
+		{from: StatusLocked, to: StatusActive, expected: true},
+		{from: StatusPending, to: StatusSuspended},
+		{from: StatusSuspended, to: StatusLocked},
+		{from: StatusActive, to: StatusActive},
+		{from: StatusActive, to: StatusPending},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from+" to "+tt.to, func(t *testing.T) {
+			require.Equal(t, tt.expected, canTransition(tt.from, tt.to))
+		})
+	}
+}
+
+func TestNormalizeStatusChange(t *testing.T) {
+	var tests = []struct {
+		name           string
+		status         string
+		reason         string
+		expectedReason string
+		expectedError  error
+	}{
+		{
+			name:           "Ok - Reason trimmed",
+			status:         StatusSuspended,
+			reason:         "  spam  ",
+			expectedReason: "spam",
+		},
+		{
+			name:          "Fail - Unknown status",
+			status:        "banned",
+			reason:        "spam",
+			expectedError: ErrInvalidStatus,
+		},
+		{
+			name:          "Fail - Missing reason",
+			status:        StatusSuspended,
+			reason:        " ",
+			expectedError: ErrInvalidStatusReason,
+		},
+		{
+			name:          "Fail - Reason too long",
+			status:        StatusSuspended,
+			reason:        strings.Repeat("a", 256),
+			expectedError: ErrInvalidStatusReason,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, err := normalizeStatusChange(tt.status, tt.reason)
+			require.Equal(t, tt.expectedError, err)
+			require.Equal(t, tt.expectedReason, reason)
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package users
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/marcosstupnicki/go-users/internal/users")
+
+// endSpan ends span recording err. Not found, conflict, invalid credentials, throttled login,
+// inactive account and validation errors are expected outcomes, so they don't flag the span as
+// failed.
+func endSpan(span trace.Span, err error) {
+	if err != nil && !expectedError(err) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func expectedError(err error) bool {
+	switch err {
+	case ErrUserNotFound, ErrUserAlreadyExists, ErrInvalidStatusTransition, ErrInvalidCredentials, ErrLoginThrottled:
+		return true
+	default:
+		return IsAccountStatusError(err) || IsValidationError(err)
+	}
+}
@@ -0,0 +1,53 @@
+package users
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// EventEnvelope is the wire format of an event delivered outside the service.
+type EventEnvelope struct {
+	Name string `json:"name"`
+	Data Event  `json:"data"`
+}
+
+// WebhookSink is a Publisher that POSTs each event as JSON to a fixed URL.
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewWebhookSink(url string, client *http.Client) WebhookSink {
+	return WebhookSink{
+		URL:    url,
+		Client: client,
+	}
+}
+
+func (s WebhookSink) Publish(ctx context.Context, event Event) error {
+	body, err := json.Marshal(EventEnvelope{Name: event.EventName(), Data: event})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook %s responded with status code %d", s.URL, res.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookSink_Publish(t *testing.T) {
+	occurredAt := time.Date(2022, 5, 1, 16, 32, 4, 0, time.UTC)
+
+	var tests = []struct {
+		name          string
+		status        int
+		event         Event
+		expectedBody  string
+		expectedError error
+	}{
+		{
+			name:         "Ok - Event delivered",
+			status:       http.StatusNoContent,
+			event:        UserUpdated{EventMetadata: EventMetadata{ID: "some-id", UserID: 1, OccurredAt: occurredAt}, ChangedFields: []string{"email"}},
+			expectedBody: `{"name":"user.updated","data":{"id":"some-id","user_id":1,"occurred_at":"2022-05-01T16:32:04Z","changed_fields":["email"]}}`,
+		},
+		{
+			name:          "Fail - Non 2xx response",
+			status:        http.StatusInternalServerError,
+			event:         UserDeleted{EventMetadata: EventMetadata{ID: "some-id", UserID: 1, OccurredAt: occurredAt}},
+			expectedBody:  `{"name":"user.deleted","data":{"id":"some-id","user_id":1,"occurred_at":"2022-05-01T16:32:04Z"}}`,
+			expectedError: errors.New("responded with status code 500"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, err := ioutil.ReadAll(r.Body)
+				require.NoError(t, err)
+				require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+				body = string(b)
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			sink := NewWebhookSink(server.URL, server.Client())
+			err := sink.Publish(context.Background(), tt.event)
+			if tt.expectedError != nil {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tt.expectedError.Error())
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tt.expectedBody, body)
+		})
+	}
+}
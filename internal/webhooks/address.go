@@ -0,0 +1,130 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress webhook URL resolving to a non-public address error
+var ErrForbiddenAddress = errors.New("invalid webhook url. url must resolve to a public address")
+
+// _nonPublicNetworks are the ranges, besides loopback, link-local, multicast and unspecified
+// addresses, a webhook must not reach: private, shared (CGNAT) and reserved ones.
+var _nonPublicNetworks = parseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"240.0.0.0/4",
+	"fc00::/7",
+)
+
+// Resolver looks up the addresses of a host. net.DefaultResolver implements it.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// AddressPolicy keeps webhooks from reaching internal services (SSRF): URLs are checked when a
+// subscription is stored, and every connection is checked again when the delivery is sent, since
+// DNS answers can change in between. AllowPrivate disables both checks, for local development.
+type AddressPolicy struct {
+	Resolver     Resolver
+	AllowPrivate bool
+}
+
+// ValidateURL returns ErrForbiddenAddress if the host of rawURL resolves to any non-public address.
+func (p AddressPolicy) ValidateURL(ctx context.Context, rawURL string) error {
+	if p.AllowPrivate {
+		return nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ErrInvalidURL
+	}
+
+	if ip := net.ParseIP(u.Hostname()); ip != nil {
+		if !publicIP(ip) {
+			return ErrForbiddenAddress
+		}
+		return nil
+	}
+
+	addresses, err := p.Resolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil || len(addresses) == 0 {
+		return ErrInvalidURL
+	}
+	for _, address := range addresses {
+		if !publicIP(address.IP) {
+			return ErrForbiddenAddress
+		}
+	}
+
+	return nil
+}
+
+// NewClient returns the HTTP client deliveries are sent with, refusing to connect to non-public
+// addresses unless policy allows them. Proxies from the environment are ignored, so the check
+// applies to the partner endpoint itself.
+func NewClient(timeout time.Duration, policy AddressPolicy) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			if policy.AllowPrivate {
+				return nil
+			}
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if !publicIP(net.ParseIP(host)) {
+				return ErrForbiddenAddress
+			}
+
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+		},
+	}
+}
+
+func publicIP(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range _nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks
+}
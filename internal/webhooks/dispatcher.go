@@ -0,0 +1,166 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy configures how failed deliveries are retried. The n-th retry waits
+// InitialBackoff * 2^(n-1), capped at MaxBackoff. A delivery that failed MaxAttempts times is moved
+// to the dead-letter state. Lease bounds how long a claimed delivery stays hidden from other
+// dispatchers, so it must outlast the attempts of a whole batch.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Lease          time.Duration
+}
+
+// Backoff returns the wait before the attempt following the attempts-th failed one.
+func (p RetryPolicy) Backoff(attempts int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+
+	return backoff
+}
+
+// Dispatcher polls the due deliveries and POSTs them, signed, to their subscriptions.
+type Dispatcher struct {
+	repository Repository
+	client     *http.Client
+	policy     RetryPolicy
+	interval   time.Duration
+	batchSize  int
+}
+
+func NewDispatcher(repository Repository, client *http.Client, policy RetryPolicy, interval time.Duration, batchSize int) Dispatcher {
+	return Dispatcher{
+		repository: repository,
+		client:     client,
+		policy:     policy,
+		interval:   interval,
+		batchSize:  batchSize,
+	}
+}
+
+// Run dispatches due deliveries every interval until ctx is done.
+func (d Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := d.DispatchDue(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("error dispatching webhook deliveries: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DispatchDue attempts one batch of due deliveries and returns how many were attempted. The batch
+// is claimed first, so no database lock is held while partners are called. Deliveries whose
+// subscription was deactivated or deleted are skipped.
+func (d Dispatcher) DispatchDue(ctx context.Context) (int, error) {
+	deliveries, err := d.repository.ClaimDue(ctx, time.Now(), d.batchSize, d.policy.Lease)
+	if err != nil {
+		return 0, err
+	}
+
+	attempted := 0
+	for _, delivery := range deliveries {
+		subscription, err := d.repository.GetSubscription(ctx, delivery.SubscriptionID)
+		switch {
+		case err == ErrSubscriptionNotFound:
+			delivery = skip(delivery, "subscription was deleted")
+		case err != nil:
+			return attempted, err
+		case !subscription.Active:
+			delivery = skip(delivery, "subscription is inactive")
+		default:
+			delivery = d.attempt(ctx, delivery, subscription)
+			attempted++
+		}
+
+		err = d.repository.SaveDelivery(ctx, delivery)
+		if err != nil {
+			return attempted, err
+		}
+	}
+
+	return attempted, nil
+}
+
+func skip(delivery Delivery, reason string) Delivery {
+	delivery.Status = DeliveryStatusSkipped
+	delivery.LastError = reason
+	delivery.NextAttemptAt = 0
+
+	return delivery
+}
+
+// attempt sends delivery to subscription and returns the delivery updated with the outcome.
+func (d Dispatcher) attempt(ctx context.Context, delivery Delivery, subscription Subscription) Delivery {
+	now := time.Now()
+	delivery.Attempts++
+
+	statusCode, err := d.send(ctx, delivery, subscription, now)
+	delivery.LastStatusCode = statusCode
+	if err == nil {
+		delivery.Status = DeliveryStatusSucceeded
+		delivery.LastError = ""
+		delivery.NextAttemptAt = 0
+		return delivery
+	}
+
+	delivery.LastError = err.Error()
+	if delivery.Attempts >= d.policy.MaxAttempts {
+		delivery.Status = DeliveryStatusDead
+		delivery.NextAttemptAt = 0
+		return delivery
+	}
+	delivery.NextAttemptAt = now.Add(d.policy.Backoff(delivery.Attempts)).Unix()
+
+	return delivery
+}
+
+func (d Dispatcher) send(ctx context.Context, delivery Delivery, subscription Subscription, now time.Time) (int, error) {
+	payload := []byte(delivery.Payload)
+	timestamp := now.Unix()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, delivery.EventName)
+	req.Header.Set(HeaderEventID, delivery.EventID)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(subscription.Secret, timestamp, payload))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("responded with status code %d", res.StatusCode)
+	}
+
+	return res.StatusCode, nil
+}
@@ -0,0 +1,192 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 8, InitialBackoff: 30 * time.Second, MaxBackoff: 5 * time.Minute}
+
+	require.Equal(t, 30*time.Second, policy.Backoff(1))
+	require.Equal(t, time.Minute, policy.Backoff(2))
+	require.Equal(t, 2*time.Minute, policy.Backoff(3))
+	require.Equal(t, 4*time.Minute, policy.Backoff(4))
+	require.Equal(t, 5*time.Minute, policy.Backoff(5))
+	require.Equal(t, 5*time.Minute, policy.Backoff(20))
+}
+
+func TestDispatcher_attempt(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Minute, MaxBackoff: time.Hour}
+	payload := `{"name":"user.deleted","data":{"id":"a","user_id":1,"occurred_at":"2022-05-01T16:32:04Z"}}`
+
+	var tests = []struct {
+		name                 string
+		status               int
+		attempts             int
+		expectedStatus       string
+		expectedAttempts     int
+		expectedNextAttempt  bool
+		expectedLastError    string
+		expectedLastHTTPCode int
+	}{
+		{
+			name:                 "Ok - Delivery succeeded",
+			status:               http.StatusOK,
+			expectedStatus:       DeliveryStatusSucceeded,
+			expectedAttempts:     1,
+			expectedLastHTTPCode: http.StatusOK,
+		},
+		{
+			name:                 "Fail - Delivery retried",
+			status:               http.StatusServiceUnavailable,
+			attempts:             1,
+			expectedStatus:       DeliveryStatusPending,
+			expectedAttempts:     2,
+			expectedNextAttempt:  true,
+			expectedLastError:    "responded with status code 503",
+			expectedLastHTTPCode: http.StatusServiceUnavailable,
+		},
+		{
+			name:                 "Fail - Delivery dead-lettered after max attempts",
+			status:               http.StatusInternalServerError,
+			attempts:             2,
+			expectedStatus:       DeliveryStatusDead,
+			expectedAttempts:     3,
+			expectedLastError:    "responded with status code 500",
+			expectedLastHTTPCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := ioutil.ReadAll(r.Body)
+				require.NoError(t, err)
+
+				timestamp, err := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+				require.NoError(t, err)
+				require.True(t, Verify("some-secret", timestamp, body, r.Header.Get(HeaderSignature)))
+				require.Equal(t, "user.deleted", r.Header.Get(HeaderEvent))
+				require.Equal(t, "a", r.Header.Get(HeaderEventID))
+
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			dispatcher := NewDispatcher(&RepositoryMock{}, server.Client(), policy, time.Second, 10)
+			delivery := dispatcher.attempt(context.Background(),
+				Delivery{ID: 1, EventID: "a", EventName: "user.deleted", Payload: payload, Status: DeliveryStatusPending, Attempts: tt.attempts},
+				Subscription{ID: 1, URL: server.URL, Secret: "some-secret"},
+			)
+
+			require.Equal(t, tt.expectedStatus, delivery.Status)
+			require.Equal(t, tt.expectedAttempts, delivery.Attempts)
+			require.Equal(t, tt.expectedLastError, delivery.LastError)
+			require.Equal(t, tt.expectedLastHTTPCode, delivery.LastStatusCode)
+			require.Equal(t, tt.expectedNextAttempt, delivery.NextAttemptAt > time.Now().Unix())
+		})
+	}
+}
+
+func TestDispatcher_DispatchDue(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Minute, MaxBackoff: time.Hour, Lease: time.Minute}
+	payload := `{"name":"user.deleted","data":{"id":"a","user_id":1,"occurred_at":"2022-05-01T16:32:04Z"}}`
+
+	var tests = []struct {
+		name              string
+		subscription      Subscription
+		subscriptionErr   error
+		expectedAttempted int
+		expectedStatus    string
+		expectedLastError string
+	}{
+		{
+			name:              "Ok - Delivery sent",
+			subscription:      Subscription{ID: 1, Secret: "some-secret", Active: true},
+			expectedAttempted: 1,
+			expectedStatus:    DeliveryStatusSucceeded,
+		},
+		{
+			name:              "Ok - Inactive subscription skipped",
+			subscription:      Subscription{ID: 1, Secret: "some-secret"},
+			expectedStatus:    DeliveryStatusSkipped,
+			expectedLastError: "subscription is inactive",
+		},
+		{
+			name:              "Ok - Deleted subscription skipped",
+			subscriptionErr:   ErrSubscriptionNotFound,
+			expectedStatus:    DeliveryStatusSkipped,
+			expectedLastError: "subscription was deleted",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			tt.subscription.URL = server.URL
+			repo := &RepositoryMock{claimed: []Delivery{
+				{ID: 1, SubscriptionID: 1, EventID: "a", EventName: "user.deleted", Payload: payload, Status: DeliveryStatusPending},
+			}}
+			repo.On("GetSubscription").Return(tt.subscription, tt.subscriptionErr)
+
+			dispatcher := NewDispatcher(repo, server.Client(), policy, time.Second, 10)
+			attempted, err := dispatcher.DispatchDue(context.Background())
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedAttempted, attempted)
+			require.Equal(t, tt.expectedAttempted, requests)
+			require.Len(t, repo.saved, 1)
+			require.Equal(t, tt.expectedStatus, repo.saved[0].Status)
+			require.Equal(t, tt.expectedLastError, repo.saved[0].LastError)
+		})
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	var tests = []struct {
+		name          string
+		policy        AddressPolicy
+		expectedError error
+	}{
+		{
+			name:   "Ok - Private addresses allowed",
+			policy: AddressPolicy{AllowPrivate: true},
+		},
+		{
+			name:          "Fail - Loopback address refused",
+			policy:        AddressPolicy{},
+			expectedError: ErrForbiddenAddress,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := NewClient(time.Second, tt.policy).Get(server.URL)
+			if tt.expectedError != nil {
+				require.True(t, errors.Is(err, tt.expectedError))
+				return
+			}
+			require.NoError(t, err)
+			res.Body.Close()
+		})
+	}
+}
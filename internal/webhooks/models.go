@@ -0,0 +1,76 @@
+package webhooks
+
+const (
+	DeliveryStatusPending   = "pending"
+	DeliveryStatusSucceeded = "succeeded"
+	// DeliveryStatusDead is the dead-letter state of a delivery that failed every attempt.
+	DeliveryStatusDead = "dead"
+	// DeliveryStatusSkipped is the state of a delivery that came due after its subscription was
+	// deactivated or deleted. It is never sent.
+	DeliveryStatusSkipped = "skipped"
+)
+
+type SubscriptionRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
+}
+
+type SubscriptionResponse struct {
+	ID        int      `json:"id"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	Active    bool     `json:"active"`
+	Secret    string   `json:"secret,omitempty"`
+	CreatedAt int64    `json:"created_at"`
+	UpdatedAt int64    `json:"updated_at"`
+}
+
+type DeliveryResponse struct {
+	ID             int64  `json:"id"`
+	EventID        string `json:"event_id"`
+	EventName      string `json:"event_name"`
+	Status         string `json:"status"`
+	Attempts       int    `json:"attempts"`
+	LastStatusCode int    `json:"last_status_code,omitempty"`
+	LastError      string `json:"last_error,omitempty"`
+	NextAttemptAt  int64  `json:"next_attempt_at,omitempty"`
+	CreatedAt      int64  `json:"created_at"`
+	UpdatedAt      int64  `json:"updated_at"`
+}
+
+// Subscription is a partner endpoint notified of user events. Events holds the subscribed event
+// names separated by commas; empty means every event.
+type Subscription struct {
+	ID        int    `gorm:"column:id;primaryKey"`
+	URL       string `gorm:"column:url;size:2048"`
+	Secret    string `gorm:"column:secret;size:64"`
+	Events    string `gorm:"column:events;size:512"`
+	Active    bool   `gorm:"column:active"`
+	CreatedAt int64  `gorm:"column:created_at"`
+	UpdatedAt int64  `gorm:"column:updated_at"`
+}
+
+func (Subscription) TableName() string {
+	return "webhook_subscriptions"
+}
+
+// Delivery is one event to be sent to one subscription, and the log of its attempts.
+type Delivery struct {
+	ID             int64  `gorm:"column:id;primaryKey"`
+	SubscriptionID int    `gorm:"column:subscription_id;uniqueIndex:idx_webhook_deliveries_subscription_event"`
+	EventID        string `gorm:"column:event_id;size:32;uniqueIndex:idx_webhook_deliveries_subscription_event"`
+	EventName      string `gorm:"column:event_name;size:64"`
+	Payload        string `gorm:"column:payload;type:json"`
+	Status         string `gorm:"column:status;size:16;index:idx_webhook_deliveries_due"`
+	Attempts       int    `gorm:"column:attempts"`
+	LastStatusCode int    `gorm:"column:last_status_code"`
+	LastError      string `gorm:"column:last_error;type:text"`
+	NextAttemptAt  int64  `gorm:"column:next_attempt_at;index:idx_webhook_deliveries_due"`
+	CreatedAt      int64  `gorm:"column:created_at"`
+	UpdatedAt      int64  `gorm:"column:updated_at"`
+}
+
+func (Delivery) TableName() string {
+	return "webhook_deliveries"
+}
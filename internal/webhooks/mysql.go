@@ -0,0 +1,145 @@
+package webhooks
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type MySQL struct {
+	DB *gorm.DB
+}
+
+// NewMySQL returns the webhooks repository over an existing connection, usually the one opened by
+// users.NewMySQL.
+func NewMySQL(db *gorm.DB) MySQL {
+	return MySQL{
+		DB: db,
+	}
+}
+
+func (repository MySQL) CreateSubscription(ctx context.Context, subscription Subscription) (Subscription, error) {
+	tx := repository.DB.WithContext(ctx).Create(&subscription)
+	if tx.Error != nil {
+		return Subscription{}, tx.Error
+	}
+
+	return subscription, nil
+}
+
+func (repository MySQL) GetSubscription(ctx context.Context, id int) (Subscription, error) {
+	subscription := Subscription{ID: id}
+	tx := repository.DB.WithContext(ctx).Limit(1).Find(&subscription)
+	if tx.Error != nil {
+		return Subscription{}, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return Subscription{}, ErrSubscriptionNotFound
+	}
+
+	return subscription, nil
+}
+
+func (repository MySQL) ListSubscriptions(ctx context.Context) ([]Subscription, error) {
+	var subscriptions []Subscription
+	tx := repository.DB.WithContext(ctx).Order("id").Find(&subscriptions)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return subscriptions, nil
+}
+
+func (repository MySQL) UpdateSubscription(ctx context.Context, subscription Subscription) (Subscription, error) {
+	tx := repository.DB.WithContext(ctx).Save(&subscription)
+	if tx.Error != nil {
+		return Subscription{}, tx.Error
+	}
+
+	return subscription, nil
+}
+
+// DeleteSubscription deletes a subscription along with its delivery log.
+func (repository MySQL) DeleteSubscription(ctx context.Context, id int) error {
+	return repository.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&Subscription{ID: id})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrSubscriptionNotFound
+		}
+
+		return tx.Where("subscription_id = ?", id).Delete(&Delivery{}).Error
+	})
+}
+
+func (repository MySQL) CreateDeliveries(ctx context.Context, deliveries []Delivery) error {
+	tx := repository.DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&deliveries)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	return nil
+}
+
+func (repository MySQL) ListDeliveries(ctx context.Context, subscriptionID int, limit int, offset int) ([]Delivery, error) {
+	var deliveries []Delivery
+	tx := repository.DB.WithContext(ctx).
+		Where("subscription_id = ?", subscriptionID).
+		Order("id DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&deliveries)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return deliveries, nil
+}
+
+// ClaimDue locks the due deliveries with SKIP LOCKED and moves their next attempt past the lease
+// before committing, so concurrent dispatchers skip them and no lock is held while they are sent.
+func (repository MySQL) ClaimDue(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]Delivery, error) {
+	var deliveries []Delivery
+	err := repository.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", DeliveryStatusPending, now.Unix()).
+			Order("next_attempt_at").
+			Limit(limit).
+			Find(&deliveries).Error
+		if err != nil {
+			return err
+		}
+		if len(deliveries) == 0 {
+			return nil
+		}
+
+		ids := make([]int64, 0, len(deliveries))
+		for _, delivery := range deliveries {
+			ids = append(ids, delivery.ID)
+		}
+
+		return tx.Model(&Delivery{}).Where("id IN ?", ids).Update("next_attempt_at", now.Add(lease).Unix()).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// SaveDelivery updates the attempt columns only, so a delivery whose subscription was deleted
+// meanwhile is not stored again.
+func (repository MySQL) SaveDelivery(ctx context.Context, delivery Delivery) error {
+	return repository.DB.WithContext(ctx).
+		Model(&delivery).
+		Select("status", "attempts", "last_status_code", "last_error", "next_attempt_at", "updated_at").
+		Updates(&delivery).Error
+}
+
+func (repository MySQL) AutoMigrate() error {
+	return repository.DB.AutoMigrate(&Subscription{}, &Delivery{})
+}
@@ -0,0 +1,184 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestMySQL_GetSubscription(t *testing.T) {
+	var tests = []struct {
+		name           string
+		db             *gorm.DB
+		expectedResult Subscription
+		expectedError  error
+	}{
+		{
+			name: "Ok - Get subscription",
+			db: func() *gorm.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				rows := sqlmock.NewRows([]string{"id", "url", "secret", "events", "active", "created_at", "updated_at"}).
+					AddRow(1, "https://partner.com/hooks", "some-secret", "user.created", true, 123456, 123456)
+
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `webhook_subscriptions` WHERE `webhook_subscriptions`.`id` = ? LIMIT 1")).
+					WithArgs(1).
+					WillReturnRows(rows)
+
+				gormDB, err := gorm.Open(
+					mysql.New(mysql.Config{
+						Conn:                      db,
+						SkipInitializeWithVersion: true}),
+					&gorm.Config{})
+				require.NoError(t, err)
+
+				return gormDB
+			}(),
+			expectedResult: Subscription{
+				ID:        1,
+				URL:       "https://partner.com/hooks",
+				Secret:    "some-secret",
+				Events:    "user.created",
+				Active:    true,
+				CreatedAt: 123456,
+				UpdatedAt: 123456,
+			},
+		},
+		{
+			name: "Fail - Subscription not found",
+			db: func() *gorm.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `webhook_subscriptions` WHERE `webhook_subscriptions`.`id` = ? LIMIT 1")).
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+				gormDB, err := gorm.Open(
+					mysql.New(mysql.Config{
+						Conn:                      db,
+						SkipInitializeWithVersion: true}),
+					&gorm.Config{})
+				require.NoError(t, err)
+
+				return gormDB
+			}(),
+			expectedError: ErrSubscriptionNotFound,
+		},
+		{
+			name: "Fail - Internal error",
+			db: func() *gorm.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `webhook_subscriptions` WHERE `webhook_subscriptions`.`id` = ? LIMIT 1")).
+					WithArgs(1).
+					WillReturnError(errors.New("internal error"))
+
+				gormDB, err := gorm.Open(
+					mysql.New(mysql.Config{
+						Conn:                      db,
+						SkipInitializeWithVersion: true}),
+					&gorm.Config{})
+				require.NoError(t, err)
+
+				return gormDB
+			}(),
+			expectedError: errors.New("internal error"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := NewMySQL(tt.db)
+			result, err := repo.GetSubscription(context.Background(), 1)
+
+			require.Equal(t, tt.expectedError, err)
+			require.Equal(t, tt.expectedResult, result)
+		})
+	}
+}
+
+func TestMySQL_ListDeliveries(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	rows := sqlmock.NewRows([]string{"id", "subscription_id", "event_id", "event_name", "status", "attempts"}).
+		AddRow(2, 1, "b", "user.deleted", DeliveryStatusPending, 1).
+		AddRow(1, 1, "a", "user.created", DeliveryStatusSucceeded, 1)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `webhook_deliveries` WHERE subscription_id = ? ORDER BY id DESC LIMIT 50 OFFSET 10")).
+		WithArgs(1).
+		WillReturnRows(rows)
+
+	gormDB, err := gorm.Open(
+		mysql.New(mysql.Config{
+			Conn:                      db,
+			SkipInitializeWithVersion: true}),
+		&gorm.Config{})
+	require.NoError(t, err)
+
+	deliveries, err := NewMySQL(gormDB).ListDeliveries(context.Background(), 1, 50, 10)
+	require.NoError(t, err)
+	require.Equal(t, []Delivery{
+		{ID: 2, SubscriptionID: 1, EventID: "b", EventName: "user.deleted", Status: DeliveryStatusPending, Attempts: 1},
+		{ID: 1, SubscriptionID: 1, EventID: "a", EventName: "user.created", Status: DeliveryStatusSucceeded, Attempts: 1},
+	}, deliveries)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMySQL_ClaimDue(t *testing.T) {
+	now := time.Unix(1651422724, 0)
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `webhook_deliveries` WHERE status = ? AND next_attempt_at <= ? ORDER BY next_attempt_at LIMIT 50 FOR UPDATE SKIP LOCKED")).
+		WithArgs(DeliveryStatusPending, now.Unix()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "subscription_id", "event_id", "status"}).
+			AddRow(1, 1, "a", DeliveryStatusPending))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `webhook_deliveries` SET `next_attempt_at`=?,`updated_at`=? WHERE id IN (?)")).
+		WithArgs(now.Add(time.Minute).Unix(), sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	gormDB, err := gorm.Open(
+		mysql.New(mysql.Config{
+			Conn:                      db,
+			SkipInitializeWithVersion: true}),
+		&gorm.Config{})
+	require.NoError(t, err)
+
+	deliveries, err := NewMySQL(gormDB).ClaimDue(context.Background(), now, 50, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, []Delivery{{ID: 1, SubscriptionID: 1, EventID: "a", Status: DeliveryStatusPending}}, deliveries)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMySQL_SaveDelivery(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `webhook_deliveries` SET `status`=?,`attempts`=?,`last_status_code`=?,`last_error`=?,`next_attempt_at`=?,`updated_at`=? WHERE `id` = ?")).
+		WithArgs(DeliveryStatusSkipped, 0, 0, "subscription is inactive", 0, sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	gormDB, err := gorm.Open(
+		mysql.New(mysql.Config{
+			Conn:                      db,
+			SkipInitializeWithVersion: true}),
+		&gorm.Config{})
+	require.NoError(t, err)
+
+	err = NewMySQL(gormDB).SaveDelivery(context.Background(), Delivery{ID: 1, Status: DeliveryStatusSkipped, LastError: "subscription is inactive"})
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,200 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/marcosstupnicki/go-users/internal/users"
+)
+
+var (
+	// ErrSubscriptionNotFound webhook subscription not found error
+	ErrSubscriptionNotFound = errors.New("webhook subscription not found")
+	// ErrInvalidURL webhook URL not an absolute http(s) URL error
+	ErrInvalidURL = errors.New("invalid webhook url. url must be an absolute http or https url")
+	// ErrUnknownEvent subscription to an event that doesn't exist error
+	ErrUnknownEvent = errors.New("unknown event name")
+)
+
+// _eventNames are the events a subscription can subscribe to.
+var _eventNames = map[string]bool{
+	users.EventNameUserCreated:     true,
+	users.EventNameUserUpdated:     true,
+	users.EventNameUserDeleted:     true,
+	users.EventNamePasswordChanged: true,
+	users.EventNameStatusChanged:   true,
+}
+
+type Repository interface {
+	CreateSubscription(ctx context.Context, subscription Subscription) (Subscription, error)
+	GetSubscription(ctx context.Context, id int) (Subscription, error)
+	ListSubscriptions(ctx context.Context) ([]Subscription, error)
+	UpdateSubscription(ctx context.Context, subscription Subscription) (Subscription, error)
+	DeleteSubscription(ctx context.Context, id int) error
+	// CreateDeliveries stores deliveries, ignoring the ones already stored for the same
+	// subscription and event, since events are relayed at-least-once.
+	CreateDeliveries(ctx context.Context, deliveries []Delivery) error
+	ListDeliveries(ctx context.Context, subscriptionID int, limit int, offset int) ([]Delivery, error)
+	// ClaimDue returns up to limit pending deliveries due at now and hides them from other
+	// dispatchers until now+lease, so they are attempted by a single dispatcher at a time.
+	ClaimDue(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]Delivery, error)
+	// SaveDelivery records the outcome of attempting a claimed delivery.
+	SaveDelivery(ctx context.Context, delivery Delivery) error
+}
+
+type Service struct {
+	repository Repository
+	addresses  AddressPolicy
+}
+
+func NewService(repository Repository, addresses AddressPolicy) Service {
+	return Service{
+		repository: repository,
+		addresses:  addresses,
+	}
+}
+
+// Create stores a new subscription with a generated signing secret.
+func (s Service) Create(ctx context.Context, subscription Subscription) (Subscription, error) {
+	err := s.validate(ctx, subscription)
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	subscription.Secret, err = generateSecret()
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	return s.repository.CreateSubscription(ctx, subscription)
+}
+
+func (s Service) Get(ctx context.Context, id int) (Subscription, error) {
+	return s.repository.GetSubscription(ctx, id)
+}
+
+func (s Service) List(ctx context.Context) ([]Subscription, error) {
+	return s.repository.ListSubscriptions(ctx)
+}
+
+// Update replaces the URL, events and active flag of a subscription. The secret is kept.
+func (s Service) Update(ctx context.Context, id int, subscription Subscription) (Subscription, error) {
+	err := s.validate(ctx, subscription)
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	current, err := s.repository.GetSubscription(ctx, id)
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	current.URL = subscription.URL
+	current.Events = subscription.Events
+	current.Active = subscription.Active
+
+	return s.repository.UpdateSubscription(ctx, current)
+}
+
+func (s Service) Delete(ctx context.Context, id int) error {
+	return s.repository.DeleteSubscription(ctx, id)
+}
+
+// Deliveries returns the delivery log of a subscription, newest first.
+func (s Service) Deliveries(ctx context.Context, subscriptionID int, limit int, offset int) ([]Delivery, error) {
+	_, err := s.repository.GetSubscription(ctx, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.repository.ListDeliveries(ctx, subscriptionID, limit, offset)
+}
+
+// Enqueue schedules the delivery of event to every active subscription subscribed to it. It's
+// meant to be subscribed to the users events bus.
+func (s Service) Enqueue(ctx context.Context, event users.Event) error {
+	subscriptions, err := s.repository.ListSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(users.EventEnvelope{Name: event.EventName(), Data: event})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	deliveries := []Delivery{}
+	for _, subscription := range subscriptions {
+		if !subscription.Active || !subscription.SubscribedTo(event.EventName()) {
+			continue
+		}
+		deliveries = append(deliveries, Delivery{
+			SubscriptionID: subscription.ID,
+			EventID:        event.Metadata().ID,
+			EventName:      event.EventName(),
+			Payload:        string(payload),
+			Status:         DeliveryStatusPending,
+			NextAttemptAt:  now,
+		})
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	return s.repository.CreateDeliveries(ctx, deliveries)
+}
+
+// EventList returns the subscribed event names. Empty means every event.
+func (s Subscription) EventList() []string {
+	if s.Events == "" {
+		return []string{}
+	}
+
+	return strings.Split(s.Events, ",")
+}
+
+// SubscribedTo reports whether the subscription must be notified of the event named name.
+func (s Subscription) SubscribedTo(name string) bool {
+	if s.Events == "" {
+		return true
+	}
+	for _, event := range s.EventList() {
+		if event == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s Service) validate(ctx context.Context, subscription Subscription) error {
+	u, err := url.Parse(subscription.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidURL
+	}
+
+	for _, event := range subscription.EventList() {
+		if !_eventNames[event] {
+			return ErrUnknownEvent
+		}
+	}
+
+	return s.addresses.ValidateURL(ctx, subscription.URL)
+}
+
+func generateSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
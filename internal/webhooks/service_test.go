@@ -0,0 +1,233 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/marcosstupnicki/go-users/internal/users"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type RepositoryMock struct {
+	mock.Mock
+	deliveries []Delivery
+	claimed    []Delivery
+	saved      []Delivery
+}
+
+func (m *RepositoryMock) CreateSubscription(_ context.Context, subscription Subscription) (Subscription, error) {
+	args := m.Called(subscription)
+	return args.Get(0).(Subscription), args.Error(1)
+}
+
+func (m *RepositoryMock) GetSubscription(_ context.Context, id int) (Subscription, error) {
+	args := m.Called()
+	return args.Get(0).(Subscription), args.Error(1)
+}
+
+func (m *RepositoryMock) ListSubscriptions(_ context.Context) ([]Subscription, error) {
+	args := m.Called()
+	return args.Get(0).([]Subscription), args.Error(1)
+}
+
+func (m *RepositoryMock) UpdateSubscription(_ context.Context, subscription Subscription) (Subscription, error) {
+	args := m.Called(subscription)
+	return args.Get(0).(Subscription), args.Error(1)
+}
+
+func (m *RepositoryMock) DeleteSubscription(_ context.Context, id int) error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *RepositoryMock) CreateDeliveries(_ context.Context, deliveries []Delivery) error {
+	m.deliveries = append(m.deliveries, deliveries...)
+	return nil
+}
+
+func (m *RepositoryMock) ListDeliveries(_ context.Context, subscriptionID int, limit int, offset int) ([]Delivery, error) {
+	args := m.Called()
+	return args.Get(0).([]Delivery), args.Error(1)
+}
+
+func (m *RepositoryMock) ClaimDue(_ context.Context, now time.Time, limit int, lease time.Duration) ([]Delivery, error) {
+	claimed := m.claimed
+	m.claimed = nil
+	return claimed, nil
+}
+
+func (m *RepositoryMock) SaveDelivery(_ context.Context, delivery Delivery) error {
+	m.saved = append(m.saved, delivery)
+	return nil
+}
+
+// ResolverMock resolves the hosts in addresses, and fails for any other.
+type ResolverMock struct {
+	addresses map[string]string
+}
+
+func (r ResolverMock) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	address, ok := r.addresses[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return []net.IPAddr{{IP: net.ParseIP(address)}}, nil
+}
+
+var _resolver = ResolverMock{addresses: map[string]string{
+	"partner.com":  "93.184.216.34",
+	"internal.com": "10.0.0.7",
+}}
+
+func TestService_Create(t *testing.T) {
+	var tests = []struct {
+		name          string
+		repo          *RepositoryMock
+		subscription  Subscription
+		expectedError error
+	}{
+		{
+			name: "Ok",
+			repo: func() *RepositoryMock {
+				m := RepositoryMock{}
+				m.On("CreateSubscription", mock.MatchedBy(func(s Subscription) bool { return len(s.Secret) == 64 })).
+					Return(Subscription{ID: 1}, nil)
+				return &m
+			}(),
+			subscription: Subscription{URL: "https://partner.com/hooks", Events: "user.created,user.deleted", Active: true},
+		},
+		{
+			name:          "Fail - Invalid URL",
+			repo:          &RepositoryMock{},
+			subscription:  Subscription{URL: "partner.com/hooks"},
+			expectedError: ErrInvalidURL,
+		},
+		{
+			name:          "Fail - Unknown event",
+			repo:          &RepositoryMock{},
+			subscription:  Subscription{URL: "https://partner.com/hooks", Events: "user.created,user.unknown"},
+			expectedError: ErrUnknownEvent,
+		},
+		{
+			name:          "Fail - URL resolving to a private address",
+			repo:          &RepositoryMock{},
+			subscription:  Subscription{URL: "https://internal.com/hooks"},
+			expectedError: ErrForbiddenAddress,
+		},
+		{
+			name:          "Fail - Loopback URL",
+			repo:          &RepositoryMock{},
+			subscription:  Subscription{URL: "http://127.0.0.1:8080/hooks"},
+			expectedError: ErrForbiddenAddress,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewService(tt.repo, AddressPolicy{Resolver: _resolver})
+			_, err := service.Create(context.Background(), tt.subscription)
+			require.Equal(t, tt.expectedError, err)
+			tt.repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestService_Update(t *testing.T) {
+	current := Subscription{ID: 1, URL: "https://partner.com/hooks", Secret: "some-secret", Active: true}
+
+	var tests = []struct {
+		name           string
+		repo           *RepositoryMock
+		subscription   Subscription
+		expectedResult Subscription
+		expectedError  error
+	}{
+		{
+			name: "Ok - Secret kept",
+			repo: func() *RepositoryMock {
+				m := RepositoryMock{}
+				updated := Subscription{ID: 1, URL: "https://partner.com/v2/hooks", Secret: "some-secret", Events: "user.created"}
+				m.On("GetSubscription").Return(current, nil)
+				m.On("UpdateSubscription", updated).Return(updated, nil)
+				return &m
+			}(),
+			subscription:   Subscription{URL: "https://partner.com/v2/hooks", Events: "user.created"},
+			expectedResult: Subscription{ID: 1, URL: "https://partner.com/v2/hooks", Secret: "some-secret", Events: "user.created"},
+		},
+		{
+			name: "Fail - Subscription not found",
+			repo: func() *RepositoryMock {
+				m := RepositoryMock{}
+				m.On("GetSubscription").Return(Subscription{}, ErrSubscriptionNotFound)
+				return &m
+			}(),
+			subscription:  Subscription{URL: "https://partner.com/v2/hooks"},
+			expectedError: ErrSubscriptionNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewService(tt.repo, AddressPolicy{Resolver: _resolver})
+			result, err := service.Update(context.Background(), 1, tt.subscription)
+			require.Equal(t, tt.expectedError, err)
+			require.Equal(t, tt.expectedResult, result)
+		})
+	}
+}
+
+func TestService_Enqueue(t *testing.T) {
+	event := users.UserDeleted{EventMetadata: users.EventMetadata{ID: "a", UserID: 1, OccurredAt: time.Date(2022, 5, 1, 16, 32, 4, 0, time.UTC)}}
+
+	var tests = []struct {
+		name                    string
+		subscriptions           []Subscription
+		listErr                 error
+		expectedSubscriptionIDs []int
+		expectedError           error
+	}{
+		{
+			name: "Ok - Delivered to matching active subscriptions",
+			subscriptions: []Subscription{
+				{ID: 1, Active: true},
+				{ID: 2, Active: true, Events: "user.created,user.deleted"},
+				{ID: 3, Active: true, Events: "user.created"},
+				{ID: 4, Active: false},
+			},
+			expectedSubscriptionIDs: []int{1, 2},
+		},
+		{
+			name:          "Fail - Internal error",
+			subscriptions: []Subscription{},
+			listErr:       errors.New("internal error"),
+			expectedError: errors.New("internal error"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &RepositoryMock{}
+			repo.On("ListSubscriptions").Return(tt.subscriptions, tt.listErr)
+
+			service := NewService(repo, AddressPolicy{Resolver: _resolver})
+			err := service.Enqueue(context.Background(), event)
+			require.Equal(t, tt.expectedError, err)
+
+			ids := []int{}
+			for _, delivery := range repo.deliveries {
+				require.Equal(t, "a", delivery.EventID)
+				require.Equal(t, DeliveryStatusPending, delivery.Status)
+				require.Equal(t, `{"name":"user.deleted","data":{"id":"a","user_id":1,"occurred_at":"2022-05-01T16:32:04Z"}}`, delivery.Payload)
+				ids = append(ids, delivery.SubscriptionID)
+			}
+			if tt.expectedSubscriptionIDs == nil {
+				tt.expectedSubscriptionIDs = []int{}
+			}
+			require.Equal(t, tt.expectedSubscriptionIDs, ids)
+		})
+	}
+}
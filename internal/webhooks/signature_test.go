@@ -0,0 +1,49 @@
+package webhooks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSign(t *testing.T) {
+	payload := []byte(`{"name":"user.deleted","data":{"id":"a","user_id":1,"occurred_at":"2022-05-01T16:32:04Z"}}`)
+
+	var tests = []struct {
+		name      string
+		secret    string
+		timestamp int64
+		signature string
+		expected  bool
+	}{
+		{
+			name:      "Ok - Valid signature",
+			secret:    "some-secret",
+			timestamp: 1651422724,
+			signature: Sign("some-secret", 1651422724, payload),
+			expected:  true,
+		},
+		{
+			name:      "Fail - Different secret",
+			secret:    "other-secret",
+			timestamp: 1651422724,
+			signature: Sign("some-secret", 1651422724, payload),
+		},
+		{
+			name:      "Fail - Different timestamp",
+			secret:    "some-secret",
+			timestamp: 1651422725,
+			signature: Sign("some-secret", 1651422724, payload),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, Verify(tt.secret, tt.timestamp, payload, tt.signature))
+		})
+	}
+
+	t.Run("Ok - Known signature", func(t *testing.T) {
+		require.Equal(t, "sha256=afbbce601f0724a586fba11829099a3092439a21654c129f4ba7730061d5c592", Sign("some-secret", 1651422724, []byte("{}")))
+	})
+}